@@ -35,6 +35,8 @@ import (
 type Client struct {
 	base *url.URL
 	http *http.Client
+	// apiKey is sent as a bearer token if set
+	apiKey string
 }
 
 func checkError(resp *http.Response, body []byte) error {
@@ -62,10 +64,13 @@ func checkError(resp *http.Response, body []byte) error {
 //
 // If the variable is not specified, a default ollama host and port will be
 // used.
+//
+// If OLLAMA_API_KEY is set, the client sends it as a bearer token.
 func ClientFromEnvironment() (*Client, error) {
 	return &Client{
-		base: envconfig.Host(),
-		http: http.DefaultClient,
+		base:   envconfig.Host(),
+		http:   http.DefaultClient,
+		apiKey: envconfig.APIKey(),
 	}, nil
 }
 
@@ -105,6 +110,9 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	respObj, err := c.http.Do(request)
 	if err != nil {
@@ -151,6 +159,9 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/x-ndjson")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	response, err := c.http.Do(request)
 	if err != nil {
@@ -333,6 +344,128 @@ func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, err
 	return &resp, nil
 }
 
+// Catalog lists the models that can be downloaded from the model catalog.
+func (c *Client) Catalog(ctx context.Context) (*CatalogResponse, error) {
+	var resp CatalogResponse
+	if err := c.do(ctx, http.MethodGet, "/api/catalog", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CatalogDownload queues a catalog model for download in the background. Use
+// [Client.CatalogStatus] or [Client.CatalogProgress] to follow its progress.
+// Queueing a paused download resumes it.
+func (c *Client) CatalogDownload(ctx context.Context, req *CatalogRequest) (*CatalogStatusResponse, error) {
+	var resp CatalogStatusResponse
+	if err := c.do(ctx, http.MethodPost, "/api/catalog/download", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CatalogCancel cancels a queued, paused or in-progress catalog download. A
+// later [Client.CatalogDownload] resumes it.
+func (c *Client) CatalogCancel(ctx context.Context, req *CatalogRequest) error {
+	if err := c.do(ctx, http.MethodPost, "/api/catalog/cancel", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CatalogPause stops a catalog download but keeps it queued, so
+// [Client.CatalogResume] continues where it stopped.
+func (c *Client) CatalogPause(ctx context.Context, req *CatalogRequest) error {
+	if err := c.do(ctx, http.MethodPost, "/api/catalog/pause", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CatalogResume queues a paused catalog download again.
+func (c *Client) CatalogResume(ctx context.Context, req *CatalogRequest) error {
+	if err := c.do(ctx, http.MethodPost, "/api/catalog/resume", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CatalogStatus obtains the download status of a catalog model.
+func (c *Client) CatalogStatus(ctx context.Context, req *CatalogRequest) (*CatalogStatusResponse, error) {
+	var resp CatalogStatusResponse
+	if err := c.do(ctx, http.MethodPost, "/api/catalog/status", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CatalogCheck checks whether a catalog model fits on disk and in memory
+// before it is downloaded.
+func (c *Client) CatalogCheck(ctx context.Context, req *CatalogRequest) (*CatalogCheckResponse, error) {
+	var resp CatalogCheckResponse
+	if err := c.do(ctx, http.MethodPost, "/api/catalog/check", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CatalogProgressFunc is a function that [Client.CatalogProgress] invokes
+// every time a catalog download makes progress or changes state. If this
+// function returns an error, [Client.CatalogProgress] will stop and return
+// this error.
+type CatalogProgressFunc func(CatalogStatusResponse) error
+
+// CatalogProgress follows catalog downloads. With a model set it returns
+// once that download has completed, been cancelled or failed; a failed
+// download is returned as an error. Without a model it follows every
+// download until ctx is done.
+func (c *Client) CatalogProgress(ctx context.Context, req *CatalogRequest, fn CatalogProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/catalog/progress", req, func(bts []byte) error {
+		var resp CatalogStatusResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
+// CatalogRemove deletes a downloaded catalog model.
+func (c *Client) CatalogRemove(ctx context.Context, req *CatalogRequest) error {
+	if err := c.do(ctx, http.MethodDelete, "/api/catalog", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Update returns the result of the last update check.
+func (c *Client) Update(ctx context.Context) (*UpdateResponse, error) {
+	var resp UpdateResponse
+	if err := c.do(ctx, http.MethodGet, "/api/update", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CheckUpdate checks the release channel for a newer version.
+func (c *Client) CheckUpdate(ctx context.Context) (*UpdateResponse, error) {
+	var resp UpdateResponse
+	if err := c.do(ctx, http.MethodPost, "/api/update/check", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ApplyUpdate installs the update found by the last check. The new version
+// is used once the server is restarted.
+func (c *Client) ApplyUpdate(ctx context.Context) (*UpdateResponse, error) {
+	var resp UpdateResponse
+	if err := c.do(ctx, http.MethodPost, "/api/update/apply", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Heartbeat checks if the server has started and is responsive; if yes, it
 // returns nil, otherwise an error.
 func (c *Client) Heartbeat(ctx context.Context) error {
@@ -366,6 +499,24 @@ func (c *Client) CreateBlob(ctx context.Context, digest string, r io.Reader) err
 	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/blobs/%s", digest), r, nil)
 }
 
+// Tokenize converts text to the tokens of a model.
+func (c *Client) Tokenize(ctx context.Context, req *TokenizeRequest) (*TokenizeResponse, error) {
+	var resp TokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/tokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Detokenize converts the tokens of a model back to text.
+func (c *Client) Detokenize(ctx context.Context, req *DetokenizeRequest) (*DetokenizeResponse, error) {
+	var resp DetokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/detokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Version returns the Ollama server version as a string.
 func (c *Client) Version(ctx context.Context) (string, error) {
 	var version struct {
@@ -83,6 +83,18 @@ type GenerateRequest struct {
 	// Options lists model-specific options. For example, temperature can be
 	// set through this field, if the model supports it.
 	Options map[string]any `json:"options"`
+
+	// Logprobs returns the log probability of each generated token.
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of most likely alternatives, up to 20, to
+	// return for each generated token. It requires Logprobs.
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
+	// Priority is the class the request is queued in while it waits for
+	// the model: "interactive" (the default), "batch" or "background". The
+	// X-Ollama-Priority header takes precedence over it.
+	Priority string `json:"priority,omitempty"`
 }
 
 // ChatRequest describes a request sent by [Client.Chat].
@@ -106,8 +118,36 @@ type ChatRequest struct {
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
+	// ToolChoice controls whether the model calls tools; it calls them as
+	// it sees fit if nil.
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+
+	// MaxToolCalls limits the tool calls returned for this turn; 0 is no
+	// limit.
+	MaxToolCalls int `json:"max_tool_calls,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]any `json:"options"`
+
+	// Logprobs and TopLogprobs are as in [GenerateRequest].
+	Logprobs    bool `json:"logprobs,omitempty"`
+	TopLogprobs int  `json:"top_logprobs,omitempty"`
+
+	// Priority is as in [GenerateRequest].
+	Priority string `json:"priority,omitempty"`
+}
+
+// TokenLogprob is the log probability of a token.
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
+// Logprob is the log probability of a generated token and the most likely
+// alternatives to it, in descending order of probability.
+type Logprob struct {
+	TokenLogprob
+	TopLogprobs []TokenLogprob `json:"top_logprobs,omitempty"`
 }
 
 type Tools []Tool
@@ -209,6 +249,59 @@ func (pt PropertyType) String() string {
 	return fmt.Sprintf("%v", []string(pt))
 }
 
+// ToolChoice controls whether the model calls tools. In JSON it is one of
+// the strings "auto", "none" or "required", or {"type": "function",
+// "function": {"name": "..."}} to call a specific function.
+type ToolChoice struct {
+	// Mode is "auto", "none", "required" or "function"
+	Mode string
+	// Name is the function to call when Mode is "function"
+	Name string
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (tc *ToolChoice) UnmarshalJSON(b []byte) error {
+	var mode string
+	if err := json.Unmarshal(b, &mode); err == nil {
+		switch mode {
+		case "auto", "none", "required":
+			*tc = ToolChoice{Mode: mode}
+			return nil
+		default:
+			return fmt.Errorf("invalid tool_choice %q; expected \"auto\", \"none\", \"required\" or a function", mode)
+		}
+	}
+
+	var fn struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(b, &fn); err != nil {
+		return err
+	}
+
+	if fn.Type != "function" || fn.Function.Name == "" {
+		return fmt.Errorf("invalid tool_choice %s; expected a function with a name", b)
+	}
+
+	*tc = ToolChoice{Mode: "function", Name: fn.Function.Name}
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (tc ToolChoice) MarshalJSON() ([]byte, error) {
+	if tc.Mode != "function" {
+		return json.Marshal(tc.Mode)
+	}
+
+	return json.Marshal(map[string]any{
+		"type":     "function",
+		"function": map[string]string{"name": tc.Name},
+	})
+}
+
 type ToolFunction struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
@@ -241,6 +334,10 @@ type ChatResponse struct {
 
 	Done bool `json:"done"`
 
+	// Logprobs holds a log probability for each token of the message, when
+	// requested.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
 	Metrics
 }
 
@@ -308,6 +405,9 @@ type EmbedRequest struct {
 
 	// Options lists model-specific options.
 	Options map[string]any `json:"options"`
+
+	// Priority is as in [GenerateRequest].
+	Priority string `json:"priority,omitempty"`
 }
 
 // EmbedResponse is the response from [Client.Embed].
@@ -320,6 +420,57 @@ type EmbedResponse struct {
 	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
 }
 
+// TokenizeRequest is the request passed to [Client.Tokenize].
+type TokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Content is the text to tokenize.
+	Content string `json:"content"`
+
+	// VocabOnly tokenizes with the model's vocabulary alone, without
+	// loading the model or waiting for a loaded one.
+	VocabOnly bool `json:"vocab_only,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+}
+
+// TokenizeResponse is the response from [Client.Tokenize].
+type TokenizeResponse struct {
+	Model  string `json:"model"`
+	Tokens []int  `json:"tokens"`
+}
+
+// DetokenizeRequest is the request passed to [Client.Detokenize].
+type DetokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Tokens are the tokens to convert back to text.
+	Tokens []int `json:"tokens"`
+
+	// VocabOnly is as in [TokenizeRequest].
+	VocabOnly bool `json:"vocab_only,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+}
+
+// DetokenizeResponse is the response from [Client.Detokenize].
+type DetokenizeResponse struct {
+	Model   string `json:"model"`
+	Content string `json:"content"`
+}
+
 // EmbeddingRequest is the request passed to [Client.Embeddings].
 type EmbeddingRequest struct {
 	// Model is the model name.
@@ -471,6 +622,107 @@ type ProcessModelResponse struct {
 	SizeVRAM  int64        `json:"size_vram"`
 }
 
+// CatalogModel is a single model in [CatalogResponse].
+type CatalogModel struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Size        int64    `json:"size"`
+	Parameters  int64    `json:"parameters"`
+	Category    string   `json:"category"`
+	Tags        []string `json:"tags"`
+	Downloaded  bool     `json:"downloaded"`
+	Recommended bool     `json:"kc_recommended"`
+}
+
+// CatalogResponse is the response from [Client.Catalog].
+type CatalogResponse struct {
+	Models []CatalogModel `json:"models"`
+}
+
+// AuditEntry is a model management operation in [AuditResponse].
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	// KeyID is the API key the operation was made with, if any
+	KeyID string `json:"key_id,omitempty"`
+	// Operation is pull, push, delete, copy or create
+	Operation string `json:"operation"`
+	Model     string `json:"model"`
+	// Source is the model copied, or the model or file created from
+	Source string `json:"source,omitempty"`
+	// Digest is the manifest digest of the model after the operation, or
+	// before it was deleted
+	Digest string `json:"digest,omitempty"`
+	// Outcome is success or error
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AuditResponse is the response of the audit log endpoint.
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// CatalogRequest is the request passed to [Client.CatalogDownload],
+// [Client.CatalogCancel], [Client.CatalogPause], [Client.CatalogResume],
+// [Client.CatalogStatus], [Client.CatalogRemove] and [Client.CatalogProgress].
+type CatalogRequest struct {
+	Model string `json:"model"`
+
+	// Priority orders queued downloads; higher priorities start first. It
+	// is only used by [Client.CatalogDownload].
+	Priority int `json:"priority,omitempty"`
+}
+
+// CatalogStatusResponse is the download status of a catalog model returned
+// from [Client.CatalogDownload] and [Client.CatalogStatus], and passed to
+// [CatalogProgressFunc].
+type CatalogStatusResponse struct {
+	Model     string  `json:"model"`
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	Completed bool    `json:"completed"`
+	Progress  float64 `json:"progress"`
+	// Downloaded and Total are in bytes
+	Downloaded int64 `json:"downloaded_bytes"`
+	Total      int64 `json:"total_bytes"`
+	// Rate is in bytes per second and ETA in seconds
+	Rate float64 `json:"bytes_per_second,omitempty"`
+	ETA  float64 `json:"eta_seconds,omitempty"`
+}
+
+// CatalogCheckResponse is the response from [Client.CatalogCheck]. Verdict
+// is "ok", "cpu_only", "insufficient_disk" or "insufficient_ram".
+type CatalogCheckResponse struct {
+	Model   string `json:"model"`
+	Verdict string `json:"verdict"`
+	Reason  string `json:"reason,omitempty"`
+	// Sizes are in bytes
+	DiskRequired    uint64 `json:"disk_required"`
+	DiskAvailable   uint64 `json:"disk_available"`
+	MemoryRequired  uint64 `json:"memory_required"`
+	MemoryAvailable uint64 `json:"memory_available"`
+	VRAMRequired    uint64 `json:"vram_required,omitempty"`
+	// GPULayers of Layers fit on the GPU. Layers is zero when the model's
+	// metadata could not be read.
+	GPULayers int `json:"gpu_layers"`
+	Layers    int `json:"layers,omitempty"`
+}
+
+// UpdateResponse is the response from [Client.Update] and
+// [Client.CheckUpdate].
+type UpdateResponse struct {
+	Available       bool      `json:"available"`
+	Required        bool      `json:"required,omitempty"`
+	CurrentVersion  string    `json:"current_version"`
+	NewVersion      string    `json:"new_version,omitempty"`
+	PreviousVersion string    `json:"previous_version,omitempty"`
+	Channel         string    `json:"channel,omitempty"`
+	Reason          string    `json:"reason,omitempty"`
+	ReleaseDate     time.Time `json:"release_date"`
+	ReleaseNotes    string    `json:"release_notes,omitempty"`
+}
+
 type RetrieveModelResponse struct {
 	Id      string `json:"id"`
 	Object  string `json:"object"`
@@ -503,6 +755,10 @@ type GenerateResponse struct {
 	// can be sent in the next request to keep a conversational memory.
 	Context []int `json:"context,omitempty"`
 
+	// Logprobs holds a log probability for each token of the response, when
+	// requested.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
 	Metrics
 }
 
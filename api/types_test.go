@@ -372,3 +372,46 @@ func TestPropertyType_MarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestToolChoice_JSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected ToolChoice
+	}{
+		{
+			name:     "auto",
+			input:    `"auto"`,
+			expected: ToolChoice{Mode: "auto"},
+		},
+		{
+			name:     "required",
+			input:    `"required"`,
+			expected: ToolChoice{Mode: "required"},
+		},
+		{
+			name:     "function",
+			input:    `{"type":"function","function":{"name":"get_weather"}}`,
+			expected: ToolChoice{Mode: "function", Name: "get_weather"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var tc ToolChoice
+			require.NoError(t, json.Unmarshal([]byte(test.input), &tc))
+			assert.Equal(t, test.expected, tc)
+
+			data, err := json.Marshal(tc)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.input, string(data))
+		})
+	}
+
+	for _, input := range []string{`"any"`, `{"type":"function"}`, `{"type":"tool","function":{"name":"x"}}`, `1`} {
+		var tc ToolChoice
+		if err := json.Unmarshal([]byte(input), &tc); err == nil {
+			t.Errorf("expected %s to be invalid", input)
+		}
+	}
+}
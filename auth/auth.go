@@ -18,6 +18,12 @@ import (
 
 const defaultPrivateKey = "id_ed25519"
 
+var (
+	ErrUnsigned         = errors.New("data is not signed")
+	ErrUntrustedKey     = errors.New("signed by an untrusted key")
+	ErrInvalidSignature = errors.New("signature is invalid")
+)
+
 func keyPath() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -90,3 +96,75 @@ func Sign(ctx context.Context, bts []byte) (string, error) {
 	// signature is <pubkey>:<signature>
 	return fmt.Sprintf("%s:%s", bytes.TrimSpace(parts[1]), base64.StdEncoding.EncodeToString(signedData.Blob)), nil
 }
+
+// ParsePublicKey parses an ed25519 public key in authorized_keys format or
+// as the bare base64 key that prefixes signatures from Sign
+func ParsePublicKey(s string) (ssh.PublicKey, error) {
+	s = strings.TrimSpace(s)
+
+	var key ssh.PublicKey
+	if strings.Contains(s, " ") {
+		k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	} else {
+		bts, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = ssh.ParsePublicKey(bts); err != nil {
+			return nil, err
+		}
+	}
+
+	if key.Type() != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("unsupported key type %s", key.Type())
+	}
+	return key, nil
+}
+
+// Verify checks a "<pubkey>:<signature>" string produced by Sign against bts,
+// accepting only signatures from one of trustedKeys
+func Verify(signature string, bts []byte, trustedKeys []string) error {
+	if signature == "" {
+		return ErrUnsigned
+	}
+
+	signer, sig, ok := strings.Cut(signature, ":")
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	signerKey, err := ParsePublicKey(signer)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	var trusted bool
+	for _, k := range trustedKeys {
+		key, err := ParsePublicKey(k)
+		if err != nil {
+			return fmt.Errorf("invalid trusted key: %w", err)
+		}
+		if bytes.Equal(key.Marshal(), signerKey.Marshal()) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return ErrUntrustedKey
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	if err := signerKey.Verify(bts, &ssh.Signature{Format: signerKey.Type(), Blob: blob}); err != nil {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -6,19 +6,19 @@ mkdir -p build
 
 # Build the shared library
 echo "Building shared library..."
-go build -buildmode=c-shared -o build/libkcriff.so kcriff_shared_lib.go
+go build -buildmode=c-shared -o build/libkcriff.so ./kcrifflib
 
 if [ $? -ne 0 ]; then
     echo "Failed to build shared library"
     exit 1
 fi
 
-# Build the minimal server
-echo "Building minimal server..."
-go build -o build/kcriff-server minimal_server.go
+# Build the server
+echo "Building server..."
+go build -o build/kcriff-server main.go
 
 if [ $? -ne 0 ]; then
-    echo "Failed to build minimal server"
+    echo "Failed to build server"
     exit 1
 fi
 
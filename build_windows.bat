@@ -6,19 +6,19 @@ if not exist build mkdir build
 
 REM Build the shared library DLL
 echo Building shared library...
-go build -buildmode=c-shared -o build/kcriff.dll kcriff_shared_lib.go
+go build -buildmode=c-shared -o build/kcriff.dll ./kcrifflib
 
 if %ERRORLEVEL% NEQ 0 (
     echo Failed to build shared library
     exit /b %ERRORLEVEL%
 )
 
-REM Build the minimal server
-echo Building minimal server...
-go build -o build/kcriff-server.exe minimal_server.go
+REM Build the server
+echo Building server...
+go build -o build/kcriff-server.exe main.go
 
 if %ERRORLEVEL% NEQ 0 (
-    echo Failed to build minimal server
+    echo Failed to build server
     exit /b %ERRORLEVEL%
 )
 
@@ -1397,10 +1397,16 @@ func NewCLI() *cobra.Command {
 				envVars["OLLAMA_KEEP_ALIVE"],
 				envVars["OLLAMA_MAX_LOADED_MODELS"],
 				envVars["OLLAMA_MAX_QUEUE"],
+				envVars["OLLAMA_MAX_QUEUE_BATCH"],
+				envVars["OLLAMA_MAX_QUEUE_BACKGROUND"],
 				envVars["OLLAMA_MODELS"],
 				envVars["OLLAMA_NUM_PARALLEL"],
 				envVars["OLLAMA_NOPRUNE"],
 				envVars["OLLAMA_ORIGINS"],
+				envVars["OLLAMA_API_KEYS"],
+				envVars["OLLAMA_RATE_LIMIT"],
+				envVars["OLLAMA_TOKEN_QUOTA"],
+				envVars["OLLAMA_AUDIT_LOG"],
 				envVars["OLLAMA_SCHED_SPREAD"],
 				envVars["OLLAMA_FLASH_ATTENTION"],
 				envVars["OLLAMA_KV_CACHE_TYPE"],
@@ -94,6 +94,17 @@ func Models() string {
 	return filepath.Join(home, ".ollama", "models")
 }
 
+// TrustedKeys returns the public keys trusted to sign the model catalog and updates. TrustedKeys can be configured via the OLLAMA_TRUSTED_KEYS environment variable as a comma separated list.
+func TrustedKeys() (keys []string) {
+	for _, key := range strings.Split(Var("OLLAMA_TRUSTED_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
 // KeepAlive returns the duration that models stay loaded in memory. KeepAlive can be configured via the OLLAMA_KEEP_ALIVE environment variable.
 // Negative values are treated as infinite. Zero is treated as no keep alive.
 // Default is 5 minutes.
@@ -181,6 +192,19 @@ func String(s string) func() string {
 var (
 	LLMLibrary = String("OLLAMA_LLM_LIBRARY")
 
+	// CatalogURL is the signed model catalog, an http(s) URL or local file.
+	CatalogURL = String("OLLAMA_CATALOG_URL")
+	// UpdateURL is the release manifest checked for updates.
+	UpdateURL = String("OLLAMA_UPDATE_URL")
+	// UpdateChannel is the release channel checked for updates.
+	UpdateChannel = String("OLLAMA_UPDATE_CHANNEL")
+	// APIKeys is the file of API keys the server requires requests to use.
+	APIKeys = String("OLLAMA_API_KEYS")
+	// APIKey is the API key the client sends to the server.
+	APIKey = String("OLLAMA_API_KEY")
+	// AuditLog is the file model management operations are recorded in.
+	AuditLog = String("OLLAMA_AUDIT_LOG")
+
 	CudaVisibleDevices    = String("CUDA_VISIBLE_DEVICES")
 	HipVisibleDevices     = String("HIP_VISIBLE_DEVICES")
 	RocrVisibleDevices    = String("ROCR_VISIBLE_DEVICES")
@@ -209,8 +233,18 @@ var (
 	MaxRunners = Uint("OLLAMA_MAX_LOADED_MODELS", 0)
 	// MaxQueue sets the maximum number of queued requests. MaxQueue can be configured via the OLLAMA_MAX_QUEUE environment variable.
 	MaxQueue = Uint("OLLAMA_MAX_QUEUE", 512)
+	// MaxQueueBatch sets the maximum number of queued batch requests. MaxQueueBatch can be configured via the OLLAMA_MAX_QUEUE_BATCH environment variable.
+	MaxQueueBatch = Uint("OLLAMA_MAX_QUEUE_BATCH", 512)
+	// MaxQueueBackground sets the maximum number of queued background requests. MaxQueueBackground can be configured via the OLLAMA_MAX_QUEUE_BACKGROUND environment variable.
+	MaxQueueBackground = Uint("OLLAMA_MAX_QUEUE_BACKGROUND", 128)
 	// MaxVRAM sets a maximum VRAM override in bytes. MaxVRAM can be configured via the OLLAMA_MAX_VRAM environment variable.
 	MaxVRAM = Uint("OLLAMA_MAX_VRAM", 0)
+	// MaxDownloads sets the number of catalog downloads that run at once. MaxDownloads can be configured via the OLLAMA_MAX_DOWNLOADS environment variable.
+	MaxDownloads = Uint("OLLAMA_MAX_DOWNLOADS", 2)
+	// RateLimit sets the requests per minute of each client, or 0 for no limit. RateLimit can be configured via the OLLAMA_RATE_LIMIT environment variable.
+	RateLimit = Uint("OLLAMA_RATE_LIMIT", 0)
+	// TokenQuota sets the prompt and completion tokens per day of each client, or 0 for no limit. TokenQuota can be configured via the OLLAMA_TOKEN_QUOTA environment variable.
+	TokenQuota = Uint("OLLAMA_TOKEN_QUOTA", 0)
 )
 
 func Uint64(key string, defaultValue uint64) func() uint64 {
@@ -238,25 +272,36 @@ type EnvVar struct {
 
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
-		"OLLAMA_KV_CACHE_TYPE":     {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
-		"OLLAMA_GPU_OVERHEAD":      {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
-		"OLLAMA_LOAD_TIMEOUT":      {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", AllowedOrigins(), "A comma separated list of allowed origins"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"OLLAMA_MULTIUSER_CACHE":   {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
-		"OLLAMA_CONTEXT_LENGTH":    {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 2048)"},
-		"OLLAMA_NEW_ENGINE":        {"OLLAMA_NEW_ENGINE", NewEngine(), "Enable the new Ollama engine"},
+		"OLLAMA_DEBUG":                {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_FLASH_ATTENTION":      {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
+		"OLLAMA_KV_CACHE_TYPE":        {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
+		"OLLAMA_GPU_OVERHEAD":         {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
+		"OLLAMA_HOST":                 {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_KEEP_ALIVE":           {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_LLM_LIBRARY":          {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
+		"OLLAMA_LOAD_TIMEOUT":         {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
+		"OLLAMA_MAX_LOADED_MODELS":    {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
+		"OLLAMA_MAX_QUEUE":            {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued interactive requests"},
+		"OLLAMA_MAX_QUEUE_BATCH":      {"OLLAMA_MAX_QUEUE_BATCH", MaxQueueBatch(), "Maximum number of queued batch requests"},
+		"OLLAMA_MAX_QUEUE_BACKGROUND": {"OLLAMA_MAX_QUEUE_BACKGROUND", MaxQueueBackground(), "Maximum number of queued background requests"},
+		"OLLAMA_MODELS":               {"OLLAMA_MODELS", Models(), "The path to the models directory"},
+		"OLLAMA_NOHISTORY":            {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"OLLAMA_NOPRUNE":              {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":         {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"OLLAMA_ORIGINS":              {"OLLAMA_ORIGINS", AllowedOrigins(), "A comma separated list of allowed origins"},
+		"OLLAMA_SCHED_SPREAD":         {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"OLLAMA_MULTIUSER_CACHE":      {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
+		"OLLAMA_CONTEXT_LENGTH":       {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 2048)"},
+		"OLLAMA_NEW_ENGINE":           {"OLLAMA_NEW_ENGINE", NewEngine(), "Enable the new Ollama engine"},
+		"OLLAMA_CATALOG_URL":          {"OLLAMA_CATALOG_URL", CatalogURL(), "Signed model catalog URL or file"},
+		"OLLAMA_MAX_DOWNLOADS":        {"OLLAMA_MAX_DOWNLOADS", MaxDownloads(), "Maximum number of catalog downloads that run at once (default: 2)"},
+		"OLLAMA_UPDATE_URL":           {"OLLAMA_UPDATE_URL", UpdateURL(), "Release manifest URL checked for updates"},
+		"OLLAMA_UPDATE_CHANNEL":       {"OLLAMA_UPDATE_CHANNEL", UpdateChannel(), "Release channel checked for updates (default: stable)"},
+		"OLLAMA_TRUSTED_KEYS":         {"OLLAMA_TRUSTED_KEYS", TrustedKeys(), "A comma separated list of keys trusted to sign the catalog and updates"},
+		"OLLAMA_API_KEYS":             {"OLLAMA_API_KEYS", APIKeys(), "Path to a file of API keys that requests must use"},
+		"OLLAMA_RATE_LIMIT":           {"OLLAMA_RATE_LIMIT", RateLimit(), "Maximum requests per minute of each client (default: no limit)"},
+		"OLLAMA_TOKEN_QUOTA":          {"OLLAMA_TOKEN_QUOTA", TokenQuota(), "Maximum prompt and completion tokens per day of each client (default: no limit)"},
+		"OLLAMA_AUDIT_LOG":            {"OLLAMA_AUDIT_LOG", AuditLog(), "Path to a log of model pulls, pushes, deletes, copies and creates"},
 
 		// Informational
 		"HTTP_PROXY":  {"HTTP_PROXY", String("HTTP_PROXY")(), "HTTP proxy"},
@@ -292,3 +292,22 @@ func TestContextLength(t *testing.T) {
 		})
 	}
 }
+
+func TestTrustedKeys(t *testing.T) {
+	cases := map[string][]string{
+		"":                   nil,
+		"key1":               {"key1"},
+		"key1,key2":          {"key1", "key2"},
+		" key1 , ,key2 , ":   {"key1", "key2"},
+		"ssh-ed25519 AAAA x": {"ssh-ed25519 AAAA x"},
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_TRUSTED_KEYS", k)
+			if diff := cmp.Diff(TrustedKeys(), v); diff != "" {
+				t.Errorf("%s: mismatch (-got +want):\n%s", k, diff)
+			}
+		})
+	}
+}
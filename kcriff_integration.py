@@ -11,7 +11,8 @@ import time
 import ctypes
 import platform
 import requests
-from ctypes import c_char_p, c_int, c_float, c_bool, Structure, POINTER, byref
+import threading
+from ctypes import c_char_p, c_void_p, c_ulonglong, CFUNCTYPE
 from typing import List, Dict, Optional, Callable, Any
 
 # Determine the correct library extension based on the platform
@@ -24,6 +25,9 @@ else:
 
 LIB_PREFIX = "libkcriff" if platform.system() != "Windows" else "kcriff"
 
+# Callback for streaming results: the JSON result and the caller's user data
+STREAM_CALLBACK = CFUNCTYPE(None, c_char_p, c_void_p)
+
 class KCRiffLibrary:
     """Wrapper for the KC-Riff shared library"""
     
@@ -58,38 +62,90 @@ class KCRiffLibrary:
             # Will fall back to HTTP API mode
             return
         
-        # Define function signatures
+        # Define function signatures. Strings returned by the library are
+        # owned by the caller, so they are kept as raw pointers until they are
+        # copied and freed.
         try:
-            # GetModels returns a JSON string of available models
-            self.lib.GetModels.restype = c_char_p
-            self.lib.GetModels.argtypes = []
-            
-            # StartModelDownload initiates a model download
-            self.lib.StartModelDownload.restype = c_char_p
-            self.lib.StartModelDownload.argtypes = [c_char_p]
-            
-            # GetDownloadStatus returns the status of a model download
-            self.lib.GetDownloadStatus.restype = c_char_p
-            self.lib.GetDownloadStatus.argtypes = [c_char_p]
-            
-            # RemoveModel deletes a downloaded model
-            self.lib.RemoveModel.restype = c_char_p
-            self.lib.RemoveModel.argtypes = [c_char_p]
-            
-            # CheckForUpdates checks if updates are available
-            self.lib.CheckForUpdates.restype = c_char_p
-            self.lib.CheckForUpdates.argtypes = []
-            
-            # ApplyUpdate applies available updates
-            self.lib.ApplyUpdate.restype = c_char_p
-            self.lib.ApplyUpdate.argtypes = []
-            
-            # HealthCheck checks if the library is functional
-            self.lib.HealthCheck.restype = c_char_p
-            self.lib.HealthCheck.argtypes = []
+            for name in ("GetModels", "CheckForUpdatesC", "ApplyUpdate", "GetHealthCheck", "Shutdown"):
+                getattr(self.lib, name).restype = c_void_p
+                getattr(self.lib, name).argtypes = []
+
+            for name in ("Init", "DownloadModel", "GetDownloadStatus", "CancelDownload", "RemoveModel"):
+                getattr(self.lib, name).restype = c_void_p
+                getattr(self.lib, name).argtypes = [c_char_p]
+
+            # Streaming functions call back with each result and return a
+            # handle for Cancel
+            for name in ("WatchDownloads", "Generate", "Chat"):
+                getattr(self.lib, name).restype = c_void_p
+                getattr(self.lib, name).argtypes = [c_char_p, STREAM_CALLBACK, c_void_p]
+
+            self.lib.Cancel.restype = c_void_p
+            self.lib.Cancel.argtypes = [c_ulonglong]
+
+            self.lib.FreeString.restype = None
+            self.lib.FreeString.argtypes = [c_void_p]
         except AttributeError as e:
             self.error_message = f"Failed to setup KC-Riff library functions: {str(e)}"
             self.lib = None
+            return
+
+        # Callbacks of running streams, kept alive until their last result
+        self._streams = {}
+        self._streams_lock = threading.Lock()
+        self._next_stream = 0
+
+    def _result(self, ptr) -> Dict[str, Any]:
+        """Decode and free a result envelope"""
+        try:
+            return json.loads(ctypes.string_at(ptr).decode('utf-8'))
+        finally:
+            self.lib.FreeString(ptr)
+
+    def call(self, name: str, *args) -> Any:
+        """Call a library function and return its data, or a dict with an
+        error and its code"""
+        result = self._result(getattr(self.lib, name)(*args))
+        if not result.get("ok"):
+            error = result.get("error") or {}
+            return {"error": error.get("message", "unknown error"), "code": error.get("code", "internal")}
+        return result.get("data")
+
+    def stream(self, name: str, arg: bytes, callback: Callable[[Dict[str, Any]], None]) -> Any:
+        """Start a streaming library function. callback is called from a
+        library thread with each result, or with a dict with an error and its
+        code. Returns the stream handle or an error dict."""
+        with self._streams_lock:
+            self._next_stream += 1
+            key = self._next_stream
+
+        def on_result(data, _):
+            result = json.loads(data.decode('utf-8'))
+            if result.get("ok"):
+                value = result.get("data") or {}
+                last = value.get("done", False) or value.get("completed", False)
+            else:
+                error = result.get("error") or {}
+                value = {"error": error.get("message", "unknown error"), "code": error.get("code", "internal")}
+                last = True
+
+            try:
+                callback(value)
+            finally:
+                if last:
+                    with self._streams_lock:
+                        self._streams.pop(key, None)
+
+        c_callback = STREAM_CALLBACK(on_result)
+        with self._streams_lock:
+            self._streams[key] = c_callback
+
+        result = self.call(name, arg, c_callback, None)
+        if isinstance(result, dict) and "error" in result:
+            with self._streams_lock:
+                self._streams.pop(key, None)
+            return result
+        return result["handle"]
 
 
 class KCRiff:
@@ -97,18 +153,23 @@ class KCRiff:
     
     _instance = None
     
-    def __new__(cls):
+    def __new__(cls, config: Optional[Dict[str, Any]] = None):
         if cls._instance is None:
             cls._instance = super(KCRiff, cls).__new__(cls)
-            cls._instance._initialize()
+            cls._instance._initialize(config or {})
         return cls._instance
     
-    def _initialize(self):
+    def _initialize(self, config: Dict[str, Any]):
         """Initialize the KC-Riff integration"""
         self.lib_wrapper = KCRiffLibrary()
         
         # Check if we're using the library or HTTP API
         self.using_library = self.lib_wrapper.lib is not None
+        if self.using_library:
+            result = self.lib_wrapper.call("Init", json.dumps(config).encode('utf-8'))
+            if isinstance(result, dict) and "error" in result:
+                self.lib_wrapper.error_message = f"Failed to initialize KC-Riff library: {result['error']}"
+                self.using_library = False
         
         if not self.using_library:
             print(f"Warning: {self.lib_wrapper.error_message}")
@@ -119,19 +180,20 @@ class KCRiff:
         """Get available models"""
         if self.using_library:
             try:
-                result = self.lib_wrapper.lib.GetModels()
-                if result:
-                    return json.loads(result.decode('utf-8'))
-                return []
+                result = self.lib_wrapper.call("GetModels")
+                if isinstance(result, dict):
+                    print(f"Error calling GetModels: {result['error']}")
+                    return []
+                return result
             except Exception as e:
                 print(f"Error calling GetModels: {str(e)}")
                 return []
         else:
             # Fallback to HTTP API
             try:
-                response = requests.get(f"{self.api_url}/api/models")
+                response = requests.get(f"{self.api_url}/api/catalog")
                 response.raise_for_status()
-                return response.json()
+                return response.json()["models"]
             except requests.RequestException as e:
                 print(f"Error fetching models from API: {str(e)}")
                 return []
@@ -140,18 +202,14 @@ class KCRiff:
         """Start downloading a model"""
         if self.using_library:
             try:
-                encoded_name = model_name.encode('utf-8')
-                result = self.lib_wrapper.lib.StartModelDownload(encoded_name)
-                if result:
-                    return json.loads(result.decode('utf-8'))
-                return {"error": "Failed to start download"}
+                return self.lib_wrapper.call("DownloadModel", model_name.encode('utf-8'))
             except Exception as e:
-                print(f"Error calling StartModelDownload: {str(e)}")
+                print(f"Error calling DownloadModel: {str(e)}")
                 return {"error": str(e)}
         else:
             # Fallback to HTTP API
             try:
-                response = requests.get(f"{self.api_url}/api/models/download?name={model_name}")
+                response = requests.post(f"{self.api_url}/api/catalog/download", json={"model": model_name})
                 response.raise_for_status()
                 return response.json()
             except requests.RequestException as e:
@@ -162,18 +220,14 @@ class KCRiff:
         """Get download status for a model"""
         if self.using_library:
             try:
-                encoded_name = model_name.encode('utf-8')
-                result = self.lib_wrapper.lib.GetDownloadStatus(encoded_name)
-                if result:
-                    return json.loads(result.decode('utf-8'))
-                return {"error": "Failed to get download status"}
+                return self.lib_wrapper.call("GetDownloadStatus", model_name.encode('utf-8'))
             except Exception as e:
                 print(f"Error calling GetDownloadStatus: {str(e)}")
                 return {"error": str(e)}
         else:
             # Fallback to HTTP API
             try:
-                response = requests.get(f"{self.api_url}/api/models/status?name={model_name}")
+                response = requests.post(f"{self.api_url}/api/catalog/status", json={"model": model_name})
                 response.raise_for_status()
                 return response.json()
             except requests.RequestException as e:
@@ -184,20 +238,17 @@ class KCRiff:
         """Remove a downloaded model"""
         if self.using_library:
             try:
-                encoded_name = model_name.encode('utf-8')
-                result = self.lib_wrapper.lib.RemoveModel(encoded_name)
-                if result:
-                    return json.loads(result.decode('utf-8'))
-                return {"error": "Failed to remove model"}
+                result = self.lib_wrapper.call("RemoveModel", model_name.encode('utf-8'))
+                return result if result else {"status": "removed"}
             except Exception as e:
                 print(f"Error calling RemoveModel: {str(e)}")
                 return {"error": str(e)}
         else:
             # Fallback to HTTP API
             try:
-                response = requests.get(f"{self.api_url}/api/models/remove?name={model_name}")
+                response = requests.delete(f"{self.api_url}/api/catalog", json={"model": model_name})
                 response.raise_for_status()
-                return response.json()
+                return {"status": "removed"}
             except requests.RequestException as e:
                 print(f"Error removing model from API: {str(e)}")
                 return {"error": str(e)}
@@ -206,17 +257,17 @@ class KCRiff:
         """Check if updates are available"""
         if self.using_library:
             try:
-                result = self.lib_wrapper.lib.CheckForUpdates()
-                if result:
-                    return json.loads(result.decode('utf-8'))
-                return {"available": False, "error": "Failed to check for updates"}
+                result = self.lib_wrapper.call("CheckForUpdatesC")
+                if "error" in result:
+                    result["available"] = False
+                return result
             except Exception as e:
-                print(f"Error calling CheckForUpdates: {str(e)}")
+                print(f"Error calling CheckForUpdatesC: {str(e)}")
                 return {"available": False, "error": str(e)}
         else:
             # Fallback to HTTP API
             try:
-                response = requests.get(f"{self.api_url}/api/updates")
+                response = requests.post(f"{self.api_url}/api/update/check")
                 response.raise_for_status()
                 return response.json()
             except requests.RequestException as e:
@@ -227,17 +278,17 @@ class KCRiff:
         """Apply available updates"""
         if self.using_library:
             try:
-                result = self.lib_wrapper.lib.ApplyUpdate()
-                if result:
-                    return json.loads(result.decode('utf-8'))
-                return {"status": "failed", "error": "Failed to apply update"}
+                result = self.lib_wrapper.call("ApplyUpdate")
+                if "error" in result:
+                    result["status"] = "failed"
+                return result
             except Exception as e:
                 print(f"Error calling ApplyUpdate: {str(e)}")
                 return {"status": "failed", "error": str(e)}
         else:
             # Fallback to HTTP API
             try:
-                response = requests.get(f"{self.api_url}/api/updates/apply")
+                response = requests.post(f"{self.api_url}/api/update/apply")
                 response.raise_for_status()
                 return response.json()
             except requests.RequestException as e:
@@ -248,25 +299,46 @@ class KCRiff:
         """Check if KC-Riff is functional"""
         if self.using_library:
             try:
-                result = self.lib_wrapper.lib.HealthCheck()
-                if result:
-                    return json.loads(result.decode('utf-8'))
-                return {"status": "unhealthy", "error": "Failed to perform health check"}
+                result = self.lib_wrapper.call("GetHealthCheck")
+                if "error" in result or not result.get("initialized"):
+                    return {"status": "unhealthy", "error": result.get("error", "library is not initialized")}
+                return {"status": "healthy", "version": result["version"]}
             except Exception as e:
-                print(f"Error calling HealthCheck: {str(e)}")
+                print(f"Error calling GetHealthCheck: {str(e)}")
                 return {"status": "unhealthy", "error": str(e)}
         else:
             # Fallback to HTTP API
             try:
-                response = requests.get(f"{self.api_url}/api/health")
+                response = requests.get(f"{self.api_url}/api/version")
                 response.raise_for_status()
-                return response.json()
+                return {"status": "healthy", "version": response.json()["version"]}
             except requests.RequestException as e:
                 print(f"Error performing health check from API: {str(e)}")
                 return {"status": "unhealthy", "error": str(e)}
     
     def wait_for_download_completion(self, model_name: str, progress_callback: Optional[Callable[[float], None]] = None, timeout: int = 300) -> Dict[str, Any]:
         """Wait for a model download to complete, with optional progress callback"""
+        if self.using_library:
+            finished = threading.Event()
+            last = {}
+
+            def on_status(status):
+                last.clear()
+                last.update(status)
+                if progress_callback and "error" not in status:
+                    progress_callback(status.get("progress", 0))
+                if "error" in status or status.get("completed", False) or status.get("status") == "not_started":
+                    finished.set()
+
+            handle = self.watch_download(model_name, on_status)
+            if isinstance(handle, dict):
+                return handle
+
+            if not finished.wait(timeout):
+                self.cancel(handle)
+                return {"error": "Download timed out", "completed": False, "status": "timeout"}
+            return dict(last)
+
         start_time = time.time()
         last_progress = -1
         
@@ -288,6 +360,40 @@ class KCRiff:
         
         return {"error": "Download timed out", "completed": False, "status": "timeout"}
 
+    def watch_download(self, model_name: str, callback: Callable[[Dict[str, Any]], None]) -> Any:
+        """Stream download status for a model, or for every model when
+        model_name is empty. Returns a handle for cancel."""
+        if not self.using_library:
+            return {"error": "Streaming requires the KC-Riff library"}
+        return self.lib_wrapper.stream("WatchDownloads", model_name.encode('utf-8'), callback)
+
+    def generate(self, request: Dict[str, Any], callback: Callable[[Dict[str, Any]], None]) -> Any:
+        """Stream a generate request, calling back with each response as
+        tokens arrive. Returns a handle for cancel."""
+        if not self.using_library:
+            return {"error": "Streaming requires the KC-Riff library"}
+        return self.lib_wrapper.stream("Generate", json.dumps(request).encode('utf-8'), callback)
+
+    def chat(self, request: Dict[str, Any], callback: Callable[[Dict[str, Any]], None]) -> Any:
+        """Stream a chat request, calling back with each response as tokens
+        arrive. Returns a handle for cancel."""
+        if not self.using_library:
+            return {"error": "Streaming requires the KC-Riff library"}
+        return self.lib_wrapper.stream("Chat", json.dumps(request).encode('utf-8'), callback)
+
+    def cancel(self, handle: int) -> Dict[str, Any]:
+        """Cancel a stream. Its callback is called once more with a
+        "cancelled" error."""
+        if not self.using_library:
+            return {"error": "Streaming requires the KC-Riff library"}
+        return self.lib_wrapper.call("Cancel", handle) or {"status": "cancelled"}
+
+    def shutdown(self):
+        """Stop streams and downloads and release the library"""
+        if self.using_library:
+            self.lib_wrapper.call("Shutdown")
+        KCRiff._instance = None
+
 
 class KillChaosModelManager:
     """Integration class for KillChaos"""
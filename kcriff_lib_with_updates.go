@@ -1,221 +0,0 @@
-package main
-
-import "C"
-import (
-	"encoding/json"
-	"fmt"
-	"os"
-	"path/filepath"
-	"sync"
-	"time"
-)
-
-// Model represents an AI model with its metadata
-type Model struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	Tags        []string `json:"tags"`
-	Size        int64    `json:"size"`
-	Downloaded  bool     `json:"downloaded"`
-	Recommended bool     `json:"recommended"`
-	Version     string   `json:"version"`
-}
-
-// UpdateInfo holds information about available updates
-type UpdateInfo struct {
-	Available     bool   `json:"available"`
-	CurrentVersion string `json:"current_version"`
-	NewVersion    string `json:"new_version"`
-	ReleaseNotes  string `json:"release_notes"`
-	DownloadURL   string `json:"download_url"`
-}
-
-// Global variables
-var (
-	models = []Model{
-		{
-			Name:        "llama2",
-			Description: "A foundational language model with 7B parameters",
-			Tags:        []string{"base", "popular"},
-			Size:        3800000000,
-			Downloaded:  false,
-			Recommended: true,
-			Version:     "2.0.0",
-		},
-		{
-			Name:        "mistral",
-			Description: "Powerful open-source model with instruction following",
-			Tags:        []string{"instruction", "popular"},
-			Size:        4200000000,
-			Downloaded:  false,
-			Recommended: true,
-			Version:     "0.1",
-		},
-		{
-			Name:        "orca-mini",
-			Description: "Smaller but efficient instruction-following model",
-			Tags:        []string{"instruction", "small"},
-			Size:        2100000000,
-			Downloaded:  false,
-			Recommended: true,
-			Version:     "3.0",
-		},
-	}
-	downloadStatus = make(map[string]float64)
-	updateInfo     = UpdateInfo{
-		Available:      false,
-		CurrentVersion: "0.1.0",
-		NewVersion:     "",
-		ReleaseNotes:   "",
-		DownloadURL:    "",
-	}
-	mutex = &sync.Mutex{}
-	
-	// Path for downloaded models and config
-	dataDir = filepath.Join(os.Getenv("APPDATA"), "KC-Riff")
-	modelDir = filepath.Join(dataDir, "models")
-)
-
-func init() {
-	// Ensure data directories exist
-	os.MkdirAll(dataDir, 0755)
-	os.MkdirAll(modelDir, 0755)
-	
-	// Load any saved models
-	loadLocalModels()
-}
-
-func loadLocalModels() {
-	// This would be expanded to read from a local database of models
-	// For now, we're just simulating with the hardcoded models
-}
-
-// CheckForUpdates checks for Ollama/KC-Riff updates
-func CheckForUpdates() {
-	// This would make an HTTP request to the Ollama GitHub releases API
-	// and update the updateInfo struct with the latest release information
-	
-	// For demonstration purposes:
-	// updateInfo.Available = true
-	// updateInfo.NewVersion = "0.2.0"
-	// updateInfo.ReleaseNotes = "Added support for new models and improved performance"
-	// updateInfo.DownloadURL = "https://github.com/sbug51/KC-Riff/releases/tag/v0.2.0"
-}
-
-//export GetModels
-func GetModels() *C.char {
-	jsonData, _ := json.Marshal(models)
-	return C.CString(string(jsonData))
-}
-
-//export DownloadModel
-func DownloadModel(modelName *C.char) *C.char {
-	name := C.GoString(modelName)
-	
-	// Start download in a goroutine
-	go func() {
-		mutex.Lock()
-		downloadStatus[name] = 0
-		mutex.Unlock()
-		
-		// Here we would integrate with Ollama's actual download mechanism
-		// For now, we're simulating the download process
-		
-		for i := 0; i <= 100; i += 5 {
-			mutex.Lock()
-			downloadStatus[name] = float64(i)
-			mutex.Unlock()
-			
-			// Simulate network activity
-			time.Sleep(300 * time.Millisecond)
-		}
-		
-		// Create a placeholder file for the downloaded model
-		modelFilePath := filepath.Join(modelDir, name+".bin")
-		f, err := os.Create(modelFilePath)
-		if err == nil {
-			// Write some placeholder data
-			f.WriteString("MODEL_DATA_PLACEHOLDER")
-			f.Close()
-		}
-		
-		// Update model status
-		for i := range models {
-			if models[i].Name == name {
-				models[i].Downloaded = true
-				break
-			}
-		}
-	}()
-	
-	return C.CString(`{"status":"downloading","model":"` + name + `"}`)
-}
-
-//export GetDownloadStatus
-func GetDownloadStatus(modelName *C.char) *C.char {
-	name := C.GoString(modelName)
-	
-	mutex.Lock()
-	progress, exists := downloadStatus[name]
-	mutex.Unlock()
-	
-	if !exists {
-		return C.CString(`{"error":"Model not found or not being downloaded"}`)
-	}
-	
-	completed := progress >= 100
-	result := fmt.Sprintf(`{"model":"%s","progress":%f,"completed":%t}`, name, progress, completed)
-	return C.CString(result)
-}
-
-//export RemoveModel
-func RemoveModel(modelName *C.char) *C.char {
-	name := C.GoString(modelName)
-	
-	// Remove the model file if it exists
-	modelFilePath := filepath.Join(modelDir, name+".bin")
-	os.Remove(modelFilePath)
-	
-	found := false
-	for i := range models {
-		if models[i].Name == name {
-			models[i].Downloaded = false
-			found = true
-			break
-		}
-	}
-	
-	if !found {
-		return C.CString(`{"error":"Model not found"}`)
-	}
-	
-	return C.CString(`{"status":"removed","model":"` + name + `"}`)
-}
-
-//export CheckForUpdatesC
-func CheckForUpdatesC() *C.char {
-	CheckForUpdates()
-	jsonData, _ := json.Marshal(updateInfo)
-	return C.CString(string(jsonData))
-}
-
-//export ApplyUpdate
-func ApplyUpdate() *C.char {
-	if !updateInfo.Available {
-		return C.CString(`{"error":"No update available"}`)
-	}
-	
-	// Here we would download and apply the update
-	// This would involve downloading the new version, stopping the current process,
-	// replacing the executable, and restarting
-	
-	return C.CString(`{"status":"updating","from_version":"` + updateInfo.CurrentVersion + 
-		`","to_version":"` + updateInfo.NewVersion + `"}`)
-}
-
-//export GetHealthCheck
-func GetHealthCheck() *C.char {
-	return C.CString(`{"status":"ok","version":"` + updateInfo.CurrentVersion + `","name":"KC-Riff"}`)
-}
-
-func main() {}
@@ -8,7 +8,7 @@ import json
 import os
 import platform
 import time
-from ctypes import c_char_p
+from ctypes import c_char_p, c_void_p
 
 class KCRiffLibrary:
     """Wrapper for the KC-Riff shared library"""
@@ -16,76 +16,102 @@ class KCRiffLibrary:
     def __init__(self):
         # Load the shared library based on platform
         if platform.system() == "Windows":
-            lib_path = os.path.join(os.path.dirname(__file__), "kcriff_updatable.dll")
+            lib_path = os.path.join(os.path.dirname(__file__), "kcriff.dll")
             self.lib = ctypes.CDLL(lib_path)
         elif platform.system() == "Linux":
-            lib_path = os.path.join(os.path.dirname(__file__), "kcriff_updatable.so")
+            lib_path = os.path.join(os.path.dirname(__file__), "libkcriff.so")
             self.lib = ctypes.CDLL(lib_path)
         else:
-            lib_path = os.path.join(os.path.dirname(__file__), "kcriff_updatable.dylib")
+            lib_path = os.path.join(os.path.dirname(__file__), "libkcriff.dylib")
             self.lib = ctypes.CDLL(lib_path)
         
-        # Configure function return types
-        self.lib.GetModels.restype = c_char_p
-        self.lib.DownloadModel.restype = c_char_p
-        self.lib.GetDownloadStatus.restype = c_char_p
-        self.lib.RemoveModel.restype = c_char_p
-        self.lib.CheckForUpdatesC.restype = c_char_p
-        self.lib.ApplyUpdate.restype = c_char_p
-        self.lib.GetHealthCheck.restype = c_char_p
+        # Strings returned by the library are owned by the caller, so keep
+        # them as raw pointers until they are copied and freed
+        for name in ("GetModels", "CheckForUpdatesC", "ApplyUpdate", "GetHealthCheck", "Shutdown"):
+            getattr(self.lib, name).restype = c_void_p
+            getattr(self.lib, name).argtypes = []
+        for name in ("Init", "DownloadModel", "GetDownloadStatus", "CancelDownload", "RemoveModel"):
+            getattr(self.lib, name).restype = c_void_p
+            getattr(self.lib, name).argtypes = [c_char_p]
+        self.lib.FreeString.restype = None
+        self.lib.FreeString.argtypes = [c_void_p]
+
+    def call(self, name, *args):
+        """Call a library function and return the data of its result envelope"""
+        ptr = getattr(self.lib, name)(*args)
+        try:
+            result = json.loads(ctypes.string_at(ptr).decode('utf-8'))
+        finally:
+            self.lib.FreeString(ptr)
+
+        if not result.get("ok"):
+            error = result.get("error") or {}
+            raise KCRiffError(error.get("code", "internal"), error.get("message", ""))
+        return result.get("data")
+
+class KCRiffError(Exception):
+    """Error returned by the KC-Riff library"""
+
+    def __init__(self, code, message):
+        super().__init__(f"{code}: {message}")
+        self.code = code
+        self.message = message
 
 class KCRiff:
     """KC-Riff integration class for KillChaos with update capabilities"""
     
     _instance = None
     
-    def __new__(cls):
+    def __new__(cls, config=None):
         if cls._instance is None:
             cls._instance = super(KCRiff, cls).__new__(cls)
             cls._instance.lib = KCRiffLibrary()
+            cls._instance.lib.call("Init", json.dumps(config or {}).encode('utf-8'))
         return cls._instance
+
+    def shutdown(self):
+        """Stop running downloads and release the library"""
+        self.lib.call("Shutdown")
+        KCRiff._instance = None
     
     def get_models(self):
         """Get available models"""
-        result = self.lib.lib.GetModels()
-        return json.loads(result.decode('utf-8'))
+        return self.lib.call("GetModels")
     
     def download_model(self, model_name):
         """Start downloading a model"""
-        result = self.lib.lib.DownloadModel(model_name.encode('utf-8'))
-        return json.loads(result.decode('utf-8'))
+        return self.lib.call("DownloadModel", model_name.encode('utf-8'))
     
     def get_download_status(self, model_name):
         """Get download status for a model"""
-        result = self.lib.lib.GetDownloadStatus(model_name.encode('utf-8'))
-        return json.loads(result.decode('utf-8'))
+        return self.lib.call("GetDownloadStatus", model_name.encode('utf-8'))
     
+    def cancel_download(self, model_name):
+        """Cancel a model download"""
+        return self.lib.call("CancelDownload", model_name.encode('utf-8'))
+
     def remove_model(self, model_name):
         """Remove a downloaded model"""
-        result = self.lib.lib.RemoveModel(model_name.encode('utf-8'))
-        return json.loads(result.decode('utf-8'))
+        return self.lib.call("RemoveModel", model_name.encode('utf-8'))
     
     def check_for_updates(self):
         """Check if updates are available"""
-        result = self.lib.lib.CheckForUpdatesC()
-        return json.loads(result.decode('utf-8'))
+        return self.lib.call("CheckForUpdatesC")
     
     def apply_update(self):
         """Apply available updates"""
-        result = self.lib.lib.ApplyUpdate()
-        return json.loads(result.decode('utf-8'))
+        return self.lib.call("ApplyUpdate")
     
     def health_check(self):
         """Check if KC-Riff is functional"""
-        result = self.lib.lib.GetHealthCheck()
-        return json.loads(result.decode('utf-8'))
+        return self.lib.call("GetHealthCheck")
     
     def wait_for_download_completion(self, model_name, progress_callback=None, timeout=300):
         """Wait for a model download to complete, with optional progress callback"""
         start_time = time.time()
         while time.time() - start_time < timeout:
             status = self.get_download_status(model_name)
-            if "error" in status:
+            if status.get("error"):
                 return False, status["error"]
             
             if progress_callback:
@@ -112,7 +138,12 @@ class KillChaosModelManager:
             raise Exception(f"KC-Riff health check failed: {health}")
         
         # Check for updates on initialization
-        update_info = self.kcriff.check_for_updates()
+        try:
+            update_info = self.kcriff.check_for_updates()
+        except KCRiffError as e:
+            print(f"KC-Riff update check failed: {e}")
+            return
+
         if update_info.get("available", False):
             print(f"KC-Riff update available: {update_info['new_version']}")
             # You can decide to prompt the user or apply automatically
@@ -124,7 +155,7 @@ class KillChaosModelManager:
     def get_recommended_models(self):
         """Get recommended models only"""
         all_models = self.kcriff.get_models()
-        return [m for m in all_models if m.get("recommended", False)]
+        return [m for m in all_models if m.get("kc_recommended", False)]
     
     def get_downloaded_models(self):
         """Get models that have been downloaded"""
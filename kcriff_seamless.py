@@ -71,7 +71,7 @@ class SimpleKCRiffDesktop(QMainWindow):
     
     def check_server(self):
         try:
-            response = requests.get("http://localhost:5000/api/health")
+            response = requests.get("http://localhost:5000/api/version")
             response.raise_for_status()
             
             if not self.server_connected:
@@ -86,9 +86,9 @@ class SimpleKCRiffDesktop(QMainWindow):
     
     def list_models(self):
         try:
-            response = requests.get("http://localhost:5000/api/models")
+            response = requests.get("http://localhost:5000/api/catalog")
             response.raise_for_status()
-            models = response.json()
+            models = response.json()["models"]
             
             model_text = "Available Models:\n\n"
             for model in models:
@@ -104,9 +104,9 @@ class SimpleKCRiffDesktop(QMainWindow):
     
     def download_recommended(self):
         try:
-            response = requests.get("http://localhost:5000/api/models")
+            response = requests.get("http://localhost:5000/api/catalog")
             response.raise_for_status()
-            models = response.json()
+            models = response.json()["models"]
             
             recommended = [model["name"] for model in models if model.get("kc_recommended", False)]
             
@@ -118,7 +118,7 @@ class SimpleKCRiffDesktop(QMainWindow):
             
             # Start downloads
             for model_name in recommended:
-                requests.get(f"http://localhost:5000/api/models/download?name={model_name}")
+                requests.post("http://localhost:5000/api/catalog/download", json={"model": model_name})
             
             self.status.setText("Downloads started in background. Check server logs for progress.")
         except Exception as e:
@@ -135,7 +135,7 @@ def start_server():
     global server_process
     try:
         script_dir = os.path.dirname(os.path.abspath(__file__))
-        server_path = os.path.join(script_dir, "minimal_server.go")
+        server_path = os.path.join(script_dir, "main.go")
         
         if not os.path.exists(server_path):
             print(f"Warning: Server file not found at {server_path}")
@@ -143,7 +143,8 @@ def start_server():
         
         print("Starting KC-Riff server...")
         server_process = subprocess.Popen(
-            ["go", "run", server_path],
+            ["go", "run", server_path, "serve"],
+            env={**os.environ, "OLLAMA_HOST": "127.0.0.1:5000"},
             stdout=subprocess.PIPE,
             stderr=subprocess.PIPE,
             text=True
@@ -187,7 +188,7 @@ def stop_server():
 def is_server_running():
     """Check if the server is already running"""
     try:
-        response = requests.get("http://localhost:5000/api/health", timeout=1)
+        response = requests.get("http://localhost:5000/api/version", timeout=1)
         return response.status_code == 200
     except:
         return False
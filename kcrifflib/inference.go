@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/server"
+	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/types/model"
+)
+
+// runner is the model loaded for Generate and Chat
+type runner struct {
+	model   *server.Model
+	options api.Options
+	llama   llm.LlamaServer
+}
+
+// inference holds the loaded model. mu is held for a whole completion, so
+// requests run one at a time and the model is never swapped out under one.
+var inference struct {
+	mu     sync.Mutex
+	runner *runner
+}
+
+// loadModel and newLlamaServer load models for inference; tests replace them
+var (
+	loadModel = func(name string) (*server.Model, error) {
+		m, err := server.GetModel(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, &libError{codeNotFound, fmt.Sprintf("model %q not found", name)}
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err := m.CheckCapabilities(model.CapabilityCompletion); err != nil {
+			return nil, &libError{codeInvalidArgument, fmt.Sprintf("%q does not support generate", name)}
+		}
+
+		return m, nil
+	}
+
+	newLlamaServer = func(m *server.Model, opts api.Options) (llm.LlamaServer, error) {
+		f, err := llm.LoadModel(m.ModelPath, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return llm.NewLlamaServer(discover.GetGPUInfo(), m.ModelPath, f, m.AdapterPaths, m.ProjectorPaths, opts, 1)
+	}
+)
+
+// load returns a runner for the named model, reusing the loaded one when it
+// is the same model with the same runner options. It must be called with
+// inference.mu held.
+func load(ctx context.Context, name string, requestOpts map[string]any) (*runner, *api.Options, error) {
+	m, err := loadModel(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := api.DefaultOptions()
+	if err := opts.FromMap(m.Options); err != nil {
+		return nil, nil, err
+	}
+	if err := opts.FromMap(requestOpts); err != nil {
+		return nil, nil, &libError{codeInvalidArgument, err.Error()}
+	}
+
+	if r := inference.runner; r != nil {
+		if r.model.Digest == m.Digest && reflect.DeepEqual(r.options.Runner, opts.Runner) {
+			return r, &opts, nil
+		}
+
+		r.llama.Close()
+		inference.runner = nil
+	}
+
+	llama, err := newLlamaServer(m, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := llama.WaitUntilRunning(ctx); err != nil {
+		llama.Close()
+		return nil, nil, err
+	}
+
+	inference.runner = &runner{model: m, options: opts, llama: llama}
+	return inference.runner, &opts, nil
+}
+
+// unload stops the loaded model
+func unload() {
+	inference.mu.Lock()
+	defer inference.mu.Unlock()
+
+	if inference.runner != nil {
+		inference.runner.llama.Close()
+		inference.runner = nil
+	}
+}
+
+func metrics(cr llm.CompletionResponse) api.Metrics {
+	return api.Metrics{
+		PromptEvalCount:    cr.PromptEvalCount,
+		PromptEvalDuration: cr.PromptEvalDuration,
+		EvalCount:          cr.EvalCount,
+		EvalDuration:       cr.EvalDuration,
+	}
+}
+
+// generate streams an api.GenerateRequest as api.GenerateResponse envelopes.
+// A request without a prompt only loads the model.
+func generate(data []byte, send sink) (uint64, error) {
+	if _, _, err := managers(); err != nil {
+		return 0, err
+	}
+
+	var req api.GenerateRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return 0, &libError{codeInvalidArgument, err.Error()}
+	}
+
+	if req.Model == "" {
+		return 0, &libError{codeInvalidArgument, "model is required"}
+	}
+
+	if len(req.Images) > 0 {
+		return 0, &libError{codeInvalidArgument, "images are not supported"}
+	}
+
+	return startStream(send, func(ctx context.Context) error {
+		inference.mu.Lock()
+		defer inference.mu.Unlock()
+
+		start := time.Now()
+		r, opts, err := load(ctx, req.Model, req.Options)
+		if err != nil {
+			return err
+		}
+		loaded := time.Now()
+
+		if req.Prompt == "" {
+			send(respond(api.GenerateResponse{
+				Model:      req.Model,
+				CreatedAt:  time.Now().UTC(),
+				Done:       true,
+				DoneReason: "load",
+			}, nil))
+			return nil
+		}
+
+		prompt := req.Prompt
+		if !req.Raw {
+			tmpl := r.model.Template
+			if req.Template != "" {
+				tmpl, err = template.Parse(req.Template)
+				if err != nil {
+					return &libError{codeInvalidArgument, err.Error()}
+				}
+			}
+
+			var values template.Values
+			if req.Suffix != "" {
+				values.Prompt = prompt
+				values.Suffix = req.Suffix
+			} else {
+				var msgs []api.Message
+				if req.System != "" {
+					msgs = append(msgs, api.Message{Role: "system", Content: req.System})
+				} else if r.model.System != "" {
+					msgs = append(msgs, api.Message{Role: "system", Content: r.model.System})
+				}
+
+				msgs = append(msgs, r.model.Messages...)
+				values.Messages = append(msgs, api.Message{Role: "user", Content: req.Prompt})
+			}
+
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, values); err != nil {
+				return err
+			}
+			prompt = b.String()
+		}
+
+		return r.llama.Completion(ctx, llm.CompletionRequest{
+			Prompt:      prompt,
+			Format:      req.Format,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
+		}, func(cr llm.CompletionResponse) {
+			res := api.GenerateResponse{
+				Model:     req.Model,
+				CreatedAt: time.Now().UTC(),
+				Response:  cr.Content,
+				Done:      cr.Done,
+				Logprobs:  cr.Logprobs,
+			}
+
+			if cr.Done {
+				res.Metrics = metrics(cr)
+				res.DoneReason = cr.DoneReason.String()
+				res.TotalDuration = time.Since(start)
+				res.LoadDuration = loaded.Sub(start)
+			}
+
+			send(respond(res, nil))
+		})
+	}), nil
+}
+
+// chat streams an api.ChatRequest as api.ChatResponse envelopes. A request
+// without messages only loads the model.
+func chat(data []byte, send sink) (uint64, error) {
+	if _, _, err := managers(); err != nil {
+		return 0, err
+	}
+
+	var req api.ChatRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return 0, &libError{codeInvalidArgument, err.Error()}
+	}
+
+	if req.Model == "" {
+		return 0, &libError{codeInvalidArgument, "model is required"}
+	}
+
+	for _, msg := range req.Messages {
+		if len(msg.Images) > 0 {
+			return 0, &libError{codeInvalidArgument, "images are not supported"}
+		}
+	}
+
+	return startStream(send, func(ctx context.Context) error {
+		inference.mu.Lock()
+		defer inference.mu.Unlock()
+
+		start := time.Now()
+		r, opts, err := load(ctx, req.Model, req.Options)
+		if err != nil {
+			return err
+		}
+		loaded := time.Now()
+
+		if len(req.Messages) == 0 {
+			send(respond(api.ChatResponse{
+				Model:      req.Model,
+				CreatedAt:  time.Now().UTC(),
+				Message:    api.Message{Role: "assistant"},
+				Done:       true,
+				DoneReason: "load",
+			}, nil))
+			return nil
+		}
+
+		msgs := slices.Concat(r.model.Messages, req.Messages)
+		if req.Messages[0].Role != "system" && r.model.System != "" {
+			msgs = append([]api.Message{{Role: "system", Content: r.model.System}}, msgs...)
+		}
+
+		var b strings.Builder
+		if err := r.model.Template.Execute(&b, template.Values{Messages: msgs, Tools: req.Tools}); err != nil {
+			return err
+		}
+
+		return r.llama.Completion(ctx, llm.CompletionRequest{
+			Prompt:      b.String(),
+			Format:      req.Format,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
+		}, func(cr llm.CompletionResponse) {
+			res := api.ChatResponse{
+				Model:     req.Model,
+				CreatedAt: time.Now().UTC(),
+				Message:   api.Message{Role: "assistant", Content: cr.Content},
+				Done:      cr.Done,
+				Logprobs:  cr.Logprobs,
+			}
+
+			if cr.Done {
+				res.Metrics = metrics(cr)
+				res.DoneReason = cr.DoneReason.String()
+				res.TotalDuration = time.Since(start)
+				res.LoadDuration = loaded.Sub(start)
+			}
+
+			send(respond(res, nil))
+		})
+	}), nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/server"
+	"github.com/ollama/ollama/template"
+)
+
+// fakeLlama completes every prompt with its words, one per response. It
+// blocks before the last word until release is closed.
+type fakeLlama struct {
+	llm.LlamaServer
+
+	prompts chan string
+	release chan struct{}
+	closed  atomic.Bool
+}
+
+func (f *fakeLlama) WaitUntilRunning(context.Context) error { return nil }
+
+func (f *fakeLlama) Close() error {
+	f.closed.Store(true)
+	return nil
+}
+
+func (f *fakeLlama) Completion(ctx context.Context, req llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+	f.prompts <- req.Prompt
+
+	words := strings.Fields(req.Prompt)
+	for i, word := range words {
+		if i == len(words)-1 {
+			select {
+			case <-f.release:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		fn(llm.CompletionResponse{Content: word})
+	}
+
+	fn(llm.CompletionResponse{Done: true, DoneReason: llm.DoneReasonStop, EvalCount: len(words)})
+	return nil
+}
+
+// recorder collects the envelopes of a stream
+type recorder chan testEnvelope
+
+func (r recorder) send(t *testing.T) sink {
+	return func(b []byte) {
+		r <- decode(t, b)
+	}
+}
+
+func (r recorder) next(t *testing.T) testEnvelope {
+	t.Helper()
+
+	select {
+	case env := <-r:
+		return env
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for stream")
+		return testEnvelope{}
+	}
+}
+
+func initTestLib(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_CATALOG_URL", "")
+	t.Setenv("OLLAMA_UPDATE_URL", "")
+
+	cfg, err := json.Marshal(Config{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := initialize(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { shutdown() })
+}
+
+// fakeModels serves the models "test" and "other" from fakeLlama and
+// returns the servers as they are loaded
+func fakeModels(t *testing.T) chan *fakeLlama {
+	t.Helper()
+
+	tmpl, err := template.Parse("{{ range .Messages }}{{ .Role }}: {{ .Content }} {{ end }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := make(chan *fakeLlama, 4)
+	origLoad, origNew := loadModel, newLlamaServer
+	loadModel = func(name string) (*server.Model, error) {
+		if name != "test" && name != "other" {
+			return nil, &libError{codeNotFound, "model not found"}
+		}
+		return &server.Model{Name: name, Digest: name, Template: tmpl, System: "be brief"}, nil
+	}
+	newLlamaServer = func(*server.Model, api.Options) (llm.LlamaServer, error) {
+		f := &fakeLlama{prompts: make(chan string, 1), release: make(chan struct{})}
+		loaded <- f
+		return f, nil
+	}
+	t.Cleanup(func() { loadModel, newLlamaServer = origLoad, origNew })
+
+	return loaded
+}
+
+func TestGenerate(t *testing.T) {
+	initTestLib(t)
+	loaded := fakeModels(t)
+
+	rec := make(recorder, 16)
+	if _, err := generate([]byte(`{"model":"test","prompt":"hello world"}`), rec.send(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	f := <-loaded
+	if prompt := <-f.prompts; prompt != "system: be brief user: hello world " {
+		t.Fatalf("unexpected prompt %q", prompt)
+	}
+	close(f.release)
+
+	var sb strings.Builder
+	for {
+		env := rec.next(t)
+		if !env.OK {
+			t.Fatalf("unexpected error %v", env.Error)
+		}
+
+		var res api.GenerateResponse
+		if err := json.Unmarshal(env.Data, &res); err != nil {
+			t.Fatal(err)
+		}
+		sb.WriteString(res.Response + " ")
+
+		if res.Done {
+			if res.DoneReason != "stop" || res.EvalCount != 6 {
+				t.Fatalf("unexpected final response %+v", res)
+			}
+			break
+		}
+	}
+
+	if got := sb.String(); got != "system: be brief user: hello world  " {
+		t.Fatalf("unexpected response %q", got)
+	}
+
+	// Another model replaces the loaded one
+	rec = make(recorder, 16)
+	if _, err := chat([]byte(`{"model":"other","messages":[{"role":"system","content":"sys"},{"role":"user","content":"hi"}]}`), rec.send(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	first := f
+	f = <-loaded
+	if prompt := <-f.prompts; prompt != "system: sys user: hi " {
+		t.Fatalf("unexpected prompt %q", prompt)
+	}
+	close(f.release)
+
+	for {
+		env := rec.next(t)
+		var res api.ChatResponse
+		if err := json.Unmarshal(env.Data, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Message.Role != "assistant" {
+			t.Fatalf("unexpected message %+v", res.Message)
+		}
+		if res.Done {
+			break
+		}
+	}
+
+	if !first.closed.Load() {
+		t.Fatal("expected the first model to be replaced")
+	}
+}
+
+func TestGenerateCancel(t *testing.T) {
+	initTestLib(t)
+	loaded := fakeModels(t)
+
+	rec := make(recorder, 16)
+	handle, err := generate([]byte(`{"model":"test","prompt":"one two","raw":true}`), rec.send(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prompt := <-(<-loaded).prompts; prompt != "one two" {
+		t.Fatalf("unexpected prompt %q", prompt)
+	}
+	rec.next(t)
+
+	if err := cancelStream(handle); err != nil {
+		t.Fatal(err)
+	}
+
+	if env := rec.next(t); env.OK || env.Error.Code != codeCancelled {
+		t.Fatalf("expected a cancelled stream, got %+v", env)
+	}
+
+	// Handles are forgotten once their stream ends
+	deadline := time.Now().Add(5 * time.Second)
+	for errorCode(cancelStream(handle)) != codeNotFound {
+		if time.Now().After(deadline) {
+			t.Fatal("handle was not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	initTestLib(t)
+	fakeModels(t)
+
+	cases := []struct {
+		request string
+		code    string
+	}{
+		{`{`, codeInvalidArgument},
+		{`{"prompt":"hi"}`, codeInvalidArgument},
+		{`{"model":"test","images":["aGk="]}`, codeInvalidArgument},
+	}
+
+	for _, tt := range cases {
+		if _, err := generate([]byte(tt.request), nil); errorCode(err) != tt.code {
+			t.Errorf("%s: expected %s, got %v", tt.request, tt.code, err)
+		}
+	}
+
+	// Errors from loading the model end the stream
+	rec := make(recorder, 1)
+	if _, err := generate([]byte(`{"model":"missing","prompt":"hi"}`), rec.send(t)); err != nil {
+		t.Fatal(err)
+	}
+	if env := rec.next(t); env.OK || env.Error.Code != codeNotFound {
+		t.Fatalf("expected not found, got %+v", env)
+	}
+}
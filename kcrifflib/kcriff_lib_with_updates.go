@@ -0,0 +1,417 @@
+// Package main builds the KC-Riff shared library, a C ABI over the model
+// catalog and the update service for desktop front ends.
+//
+// Build it with:
+//
+//	go build -buildmode=c-shared -o libkcriff.so ./kcrifflib
+//
+// Call Init before anything else and Shutdown before unloading the library.
+// Every function that returns a string returns a JSON envelope, either
+// {"ok":true,"data":...} or {"ok":false,"error":{"code":...,"message":...}}.
+// The string is owned by the caller and must be released with FreeString.
+//
+// WatchDownloads, Generate and Chat stream instead. They return a handle
+// for Cancel and pass each envelope of the stream to a callback, together
+// with the caller's user data. The callback runs on a library thread and
+// the string it is passed is freed when it returns. A stream ends with a
+// "done" or "completed" result, or with an error envelope such as
+// "cancelled".
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+	modelmanager "github.com/ollama/ollama/model_manager"
+	"github.com/ollama/ollama/server"
+	updateservice "github.com/ollama/ollama/update_service"
+	"github.com/ollama/ollama/version"
+)
+
+// catalogRefreshInterval is how often a remote catalog is reloaded
+const catalogRefreshInterval = 6 * time.Hour
+
+// Error codes reported in the error envelope
+const (
+	codeNotInitialized     = "not_initialized"
+	codeAlreadyInitialized = "already_initialized"
+	codeInvalidArgument    = "invalid_argument"
+	codeNotFound           = "not_found"
+	codeNotDownloaded      = "not_downloaded"
+	codeNoActiveDownload   = "no_active_download"
+	codeInsufficientDisk   = "insufficient_disk"
+	codeNoUpdate           = "no_update"
+	codeCancelled          = "cancelled"
+	codeUnavailable        = "unavailable"
+	codeInternal           = "internal"
+)
+
+// Config is the JSON document passed to Init. Empty fields fall back to the
+// environment variables the server reads.
+type Config struct {
+	// DataDir holds update state, $HOME/.ollama/update by default. Models
+	// are kept in the server's model store under OLLAMA_MODELS.
+	DataDir string `json:"data_dir"`
+
+	CatalogURL   string   `json:"catalog_url"`
+	TrustedKeys  []string `json:"trusted_keys"`
+	MaxDownloads int      `json:"max_downloads"`
+
+	UpdateURL     string `json:"update_url"`
+	UpdateChannel string `json:"update_channel"`
+	// Executable is the KC-Riff server binary. It runs models for Generate
+	// and Chat and is the binary ApplyUpdate replaces. The library is loaded
+	// into someone else's process, so neither works without it.
+	Executable string `json:"executable"`
+}
+
+// envelope is the JSON shape of every result
+type envelope struct {
+	OK    bool      `json:"ok"`
+	Data  any       `json:"data,omitempty"`
+	Error *libError `json:"error,omitempty"`
+}
+
+type libError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *libError) Error() string {
+	return e.Message
+}
+
+var (
+	errNotInitialized     = &libError{codeNotInitialized, "library is not initialized"}
+	errAlreadyInitialized = &libError{codeAlreadyInitialized, "library is already initialized"}
+	errNoExecutable       = &libError{codeUnavailable, "no executable configured for updates"}
+	errNoCallback         = &libError{codeInvalidArgument, "callback is required"}
+	errStreamNotFound     = &libError{codeNotFound, "no stream for handle"}
+)
+
+// lib holds the managers created by Init
+var lib struct {
+	mu      sync.Mutex
+	catalog *modelmanager.ModelManager
+	updates *updateservice.UpdateManager
+	// canExecute is set when Init was given the binary updates replace
+	canExecute bool
+	// stop ends the catalog refresh started by Init
+	stop context.CancelFunc
+}
+
+func initialize(data []byte) error {
+	var cfg Config
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return &libError{codeInvalidArgument, err.Error()}
+		}
+	}
+
+	if cfg.CatalogURL == "" {
+		cfg.CatalogURL = envconfig.CatalogURL()
+	}
+	if len(cfg.TrustedKeys) == 0 {
+		cfg.TrustedKeys = envconfig.TrustedKeys()
+	}
+	if cfg.MaxDownloads <= 0 {
+		cfg.MaxDownloads = int(envconfig.MaxDownloads())
+	}
+	if cfg.UpdateURL == "" {
+		cfg.UpdateURL = envconfig.UpdateURL()
+	}
+	if cfg.UpdateChannel == "" {
+		cfg.UpdateChannel = envconfig.UpdateChannel()
+	}
+	if cfg.DataDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		cfg.DataDir = filepath.Join(home, ".ollama", "update")
+	}
+
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	if lib.catalog != nil {
+		return errAlreadyInitialized
+	}
+
+	um, err := updateservice.NewWithConfig(cfg.DataDir, version.Version, updateservice.Config{
+		ManifestURL:    cfg.UpdateURL,
+		Channel:        cfg.UpdateChannel,
+		PublicKeys:     cfg.TrustedKeys,
+		ExecutablePath: cfg.Executable,
+	})
+	if err != nil {
+		return err
+	}
+
+	mm, err := modelmanager.NewWithBackend(envconfig.Models(), &server.ModelStore{})
+	if err != nil {
+		return err
+	}
+
+	mm.SetMaxConcurrentDownloads(cfg.MaxDownloads)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if cfg.CatalogURL != "" {
+		catalogCfg := modelmanager.CatalogConfig{
+			Source:          cfg.CatalogURL,
+			PublicKeys:      cfg.TrustedKeys,
+			RefreshInterval: catalogRefreshInterval,
+		}
+
+		if err := mm.ConfigureCatalog(ctx, catalogCfg); err != nil {
+			slog.Warn("failed to load model catalog, using built-in catalog", "source", cfg.CatalogURL, "error", err)
+		}
+
+		go mm.RunCatalogRefresh(ctx)
+	}
+
+	llm.RunnerExecutable = cfg.Executable
+
+	lib.catalog = mm
+	lib.updates = um
+	lib.canExecute = cfg.Executable != ""
+	lib.stop = cancel
+	return nil
+}
+
+// shutdown ends every stream, unloads the model and stops running
+// downloads. Stopped downloads are resumed by the next Init.
+func shutdown() error {
+	lib.mu.Lock()
+	mm, stop := lib.catalog, lib.stop
+	lib.catalog, lib.updates, lib.stop = nil, nil, nil
+	lib.mu.Unlock()
+
+	if mm == nil {
+		return errNotInitialized
+	}
+
+	stop()
+	stopStreams()
+	unload()
+	mm.Close()
+	return nil
+}
+
+// managers returns the managers created by Init
+func managers() (*modelmanager.ModelManager, *updateservice.UpdateManager, error) {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	if lib.catalog == nil {
+		return nil, nil, errNotInitialized
+	}
+
+	return lib.catalog, lib.updates, nil
+}
+
+// errorCode maps an error from the managers to the code reported for it
+func errorCode(err error) string {
+	var le *libError
+	switch {
+	case errors.As(err, &le):
+		return le.Code
+	case errors.Is(err, modelmanager.ErrModelNotFound):
+		return codeNotFound
+	case errors.Is(err, modelmanager.ErrNotDownloaded):
+		return codeNotDownloaded
+	case errors.Is(err, modelmanager.ErrNoActiveDownload):
+		return codeNoActiveDownload
+	case errors.Is(err, modelmanager.ErrInsufficientDisk):
+		return codeInsufficientDisk
+	case errors.Is(err, updateservice.ErrNoUpdate):
+		return codeNoUpdate
+	case errors.Is(err, updateservice.ErrNoManifestURL):
+		return codeUnavailable
+	case errors.Is(err, context.Canceled):
+		return codeCancelled
+	default:
+		return codeInternal
+	}
+}
+
+func respond(data any, err error) []byte {
+	env := envelope{OK: err == nil, Data: data}
+	if err != nil {
+		env.Data = nil
+		env.Error = &libError{Code: errorCode(err), Message: err.Error()}
+	}
+
+	b, err := json.Marshal(env)
+	if err != nil {
+		b, _ = json.Marshal(envelope{Error: &libError{Code: codeInternal, Message: err.Error()}})
+	}
+
+	return b
+}
+
+// result converts a result to a C string the caller frees with FreeString
+func result(data any, err error) *C.char {
+	return C.CString(string(respond(data, err)))
+}
+
+func getModels() ([]modelmanager.Model, error) {
+	mm, _, err := managers()
+	if err != nil {
+		return nil, err
+	}
+
+	return mm.GetModels(), nil
+}
+
+func downloadModel(name string) (*modelmanager.DownloadStatus, error) {
+	mm, _, err := managers()
+	if err != nil {
+		return nil, err
+	}
+
+	return mm.QueueDownload(name, 0)
+}
+
+func downloadStatus(name string) (*modelmanager.DownloadStatus, error) {
+	mm, _, err := managers()
+	if err != nil {
+		return nil, err
+	}
+
+	return mm.GetDownloadStatus(name)
+}
+
+func cancelDownload(name string) error {
+	mm, _, err := managers()
+	if err != nil {
+		return err
+	}
+
+	return mm.CancelDownload(name)
+}
+
+func removeModel(name string) error {
+	mm, _, err := managers()
+	if err != nil {
+		return err
+	}
+
+	return mm.RemoveModel(name)
+}
+
+func checkForUpdates() (*updateservice.UpdateInfo, error) {
+	_, um, err := managers()
+	if err != nil {
+		return nil, err
+	}
+
+	return um.CheckForUpdates(context.Background())
+}
+
+func applyUpdate() (*updateservice.UpdateInfo, error) {
+	_, um, err := managers()
+	if err != nil {
+		return nil, err
+	}
+
+	lib.mu.Lock()
+	canExecute := lib.canExecute
+	lib.mu.Unlock()
+
+	if !canExecute {
+		return nil, errNoExecutable
+	}
+
+	if err := um.ApplyUpdate(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return um.GetLastUpdateInfo(), nil
+}
+
+func healthCheck() map[string]any {
+	_, _, err := managers()
+	return map[string]any{
+		"status":      "ok",
+		"name":        "KC-Riff",
+		"version":     version.Version,
+		"initialized": err == nil,
+	}
+}
+
+//export Init
+func Init(configJSON *C.char) *C.char {
+	var data []byte
+	if configJSON != nil {
+		data = []byte(C.GoString(configJSON))
+	}
+
+	return result(nil, initialize(data))
+}
+
+//export Shutdown
+func Shutdown() *C.char {
+	return result(nil, shutdown())
+}
+
+//export FreeString
+func FreeString(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+//export GetModels
+func GetModels() *C.char {
+	return result(getModels())
+}
+
+//export DownloadModel
+func DownloadModel(modelName *C.char) *C.char {
+	return result(downloadModel(C.GoString(modelName)))
+}
+
+//export GetDownloadStatus
+func GetDownloadStatus(modelName *C.char) *C.char {
+	return result(downloadStatus(C.GoString(modelName)))
+}
+
+//export CancelDownload
+func CancelDownload(modelName *C.char) *C.char {
+	return result(nil, cancelDownload(C.GoString(modelName)))
+}
+
+//export RemoveModel
+func RemoveModel(modelName *C.char) *C.char {
+	return result(nil, removeModel(C.GoString(modelName)))
+}
+
+//export CheckForUpdatesC
+func CheckForUpdatesC() *C.char {
+	return result(checkForUpdates())
+}
+
+//export ApplyUpdate
+func ApplyUpdate() *C.char {
+	return result(applyUpdate())
+}
+
+//export GetHealthCheck
+func GetHealthCheck() *C.char {
+	return result(healthCheck(), nil)
+}
+
+func main() {}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testEnvelope struct {
+	OK    bool            `json:"ok"`
+	Data  json.RawMessage `json:"data"`
+	Error *libError       `json:"error"`
+}
+
+func decode(t *testing.T, b []byte) testEnvelope {
+	t.Helper()
+
+	var env testEnvelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		t.Fatalf("invalid envelope %s: %v", b, err)
+	}
+	return env
+}
+
+func expectCode(t *testing.T, b []byte, code string) {
+	t.Helper()
+
+	env := decode(t, b)
+	if env.OK || env.Error == nil || env.Error.Code != code {
+		t.Fatalf("expected error code %q, got %s", code, b)
+	}
+}
+
+func TestLibLifecycle(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_CATALOG_URL", "")
+	t.Setenv("OLLAMA_UPDATE_URL", "")
+
+	expectCode(t, respond(getModels()), codeNotInitialized)
+	expectCode(t, respond(nil, shutdown()), codeNotInitialized)
+	expectCode(t, respond(nil, initialize([]byte("{"))), codeInvalidArgument)
+
+	cfg, err := json.Marshal(Config{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if env := decode(t, respond(nil, initialize(cfg))); !env.OK {
+		t.Fatalf("init failed: %v", env.Error)
+	}
+	t.Cleanup(func() { shutdown() })
+
+	expectCode(t, respond(nil, initialize(cfg)), codeAlreadyInitialized)
+
+	env := decode(t, respond(getModels()))
+	var models []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(env.Data, &models); err != nil {
+		t.Fatal(err)
+	}
+	if !env.OK || len(models) == 0 {
+		t.Fatalf("expected the built-in catalog, got %s", env.Data)
+	}
+
+	expectCode(t, respond(downloadStatus("missing")), codeNotFound)
+	expectCode(t, respond(nil, removeModel(models[0].Name)), codeNotDownloaded)
+	expectCode(t, respond(nil, cancelDownload(models[0].Name)), codeNoActiveDownload)
+	expectCode(t, respond(checkForUpdates()), codeUnavailable)
+	expectCode(t, respond(applyUpdate()), codeUnavailable)
+
+	env = decode(t, respond(healthCheck(), nil))
+	var health struct {
+		Initialized bool `json:"initialized"`
+	}
+	if err := json.Unmarshal(env.Data, &health); err != nil {
+		t.Fatal(err)
+	}
+	if !health.Initialized {
+		t.Fatalf("expected initialized health check, got %s", env.Data)
+	}
+
+	if env := decode(t, respond(nil, shutdown())); !env.OK {
+		t.Fatalf("shutdown failed: %v", env.Error)
+	}
+	expectCode(t, respond(getModels()), codeNotInitialized)
+}
@@ -0,0 +1,183 @@
+package main
+
+/*
+#include <stdlib.h>
+
+typedef void (*kcriff_callback)(char* json, void* user_data);
+
+static inline void kcriff_invoke(kcriff_callback cb, char* json, void* user_data) {
+	cb(json, user_data);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"sync"
+	"unsafe"
+)
+
+// sink receives each JSON envelope of a stream
+type sink func([]byte)
+
+// streams tracks running streams by handle so they can be cancelled
+var streams struct {
+	mu      sync.Mutex
+	next    uint64
+	cancels map[uint64]context.CancelFunc
+	running sync.WaitGroup
+}
+
+// startStream runs fn in its own goroutine and returns the handle that
+// cancels it. An error from fn is sent as the last envelope of the stream.
+func startStream(send sink, fn func(ctx context.Context) error) uint64 {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	streams.mu.Lock()
+	if streams.cancels == nil {
+		streams.cancels = make(map[uint64]context.CancelFunc)
+	}
+	streams.next++
+	handle := streams.next
+	streams.cancels[handle] = cancel
+	streams.running.Add(1)
+	streams.mu.Unlock()
+
+	go func() {
+		defer streams.running.Done()
+		defer func() {
+			streams.mu.Lock()
+			delete(streams.cancels, handle)
+			streams.mu.Unlock()
+			cancel()
+		}()
+
+		if err := fn(ctx); err != nil {
+			send(respond(nil, err))
+		}
+	}()
+
+	return handle
+}
+
+func cancelStream(handle uint64) error {
+	streams.mu.Lock()
+	defer streams.mu.Unlock()
+
+	cancel, ok := streams.cancels[handle]
+	if !ok {
+		return errStreamNotFound
+	}
+
+	cancel()
+	return nil
+}
+
+// stopStreams cancels every stream and waits for them to end, so no
+// callback runs after Shutdown returns
+func stopStreams() {
+	streams.mu.Lock()
+	for _, cancel := range streams.cancels {
+		cancel()
+	}
+	streams.mu.Unlock()
+
+	streams.running.Wait()
+}
+
+// watchDownloads streams download progress for one model, or for every
+// model when name is empty. A stream for one model starts with its current
+// status and ends once the download finishes.
+func watchDownloads(name string, send sink) (uint64, error) {
+	mm, _, err := managers()
+	if err != nil {
+		return 0, err
+	}
+
+	if name != "" {
+		if _, err := mm.GetDownloadStatus(name); err != nil {
+			return 0, err
+		}
+	}
+
+	return startStream(send, func(ctx context.Context) error {
+		// Subscribe before reading the current status so no transition is missed
+		events := mm.Subscribe(ctx)
+
+		if name != "" {
+			current, err := mm.GetDownloadStatus(name)
+			if err != nil {
+				return err
+			}
+
+			send(respond(current, nil))
+			if current.Completed || current.Status == "not_started" {
+				return nil
+			}
+		}
+
+		for status := range events {
+			if name != "" && status.ModelName != name {
+				continue
+			}
+
+			send(respond(status, nil))
+			if name != "" && status.Completed {
+				return nil
+			}
+		}
+
+		return ctx.Err()
+	}), nil
+}
+
+// callbackSink sends envelopes to a C callback. The string passed to the
+// callback is only valid until it returns.
+func callbackSink(callback C.kcriff_callback, userData unsafe.Pointer) sink {
+	return func(b []byte) {
+		s := C.CString(string(b))
+		defer C.free(unsafe.Pointer(s))
+		C.kcriff_invoke(callback, s, userData)
+	}
+}
+
+// handleResult is the result of a call that starts a stream
+func handleResult(handle uint64, err error) *C.char {
+	if err != nil {
+		return result(nil, err)
+	}
+
+	return result(map[string]uint64{"handle": handle}, nil)
+}
+
+//export WatchDownloads
+func WatchDownloads(modelName *C.char, callback C.kcriff_callback, userData unsafe.Pointer) *C.char {
+	if callback == nil {
+		return result(nil, errNoCallback)
+	}
+
+	return handleResult(watchDownloads(C.GoString(modelName), callbackSink(callback, userData)))
+}
+
+//export Generate
+func Generate(requestJSON *C.char, callback C.kcriff_callback, userData unsafe.Pointer) *C.char {
+	if callback == nil {
+		return result(nil, errNoCallback)
+	}
+
+	return handleResult(generate([]byte(C.GoString(requestJSON)), callbackSink(callback, userData)))
+}
+
+//export Chat
+func Chat(requestJSON *C.char, callback C.kcriff_callback, userData unsafe.Pointer) *C.char {
+	if callback == nil {
+		return result(nil, errNoCallback)
+	}
+
+	return handleResult(chat([]byte(C.GoString(requestJSON)), callbackSink(callback, userData)))
+}
+
+//export Cancel
+func Cancel(handle C.ulonglong) *C.char {
+	return result(nil, cancelStream(uint64(handle)))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWatchDownloads(t *testing.T) {
+	initTestLib(t)
+
+	models, err := getModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := models[0].Name
+
+	if _, err := watchDownloads("missing", nil); errorCode(err) != codeNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	// A model that is not downloading reports its status and ends
+	rec := make(recorder, 4)
+	if _, err := watchDownloads(name, rec.send(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	env := rec.next(t)
+	var status struct {
+		Model  string `json:"model_name"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(env.Data, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Model != name || status.Status != "not_started" {
+		t.Fatalf("unexpected status %s", env.Data)
+	}
+
+	// Watching every model runs until it is cancelled
+	rec = make(recorder, 4)
+	handle, err := watchDownloads("", rec.send(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cancelStream(handle); err != nil {
+		t.Fatal(err)
+	}
+	if env := rec.next(t); env.OK || env.Error.Code != codeCancelled {
+		t.Fatalf("expected a cancelled stream, got %+v", env)
+	}
+
+	if err := cancelStream(12345); errorCode(err) != codeNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
@@ -193,6 +193,19 @@ func (c *Context) GetEmbeddingsIth(i int) []float32 {
 	return embeddings
 }
 
+// GetLogitsIth returns a copy of the logits for the ith token of the last
+// batch, which must have been marked for output
+func (c *Context) GetLogitsIth(i int) []float32 {
+	l := unsafe.Pointer(C.llama_get_logits_ith(c.c, C.int32_t(i)))
+	if l == nil {
+		return nil
+	}
+
+	logits := make([]float32, c.Model().NumVocab())
+	_ = copy(logits, unsafe.Slice((*float32)(l), c.Model().NumVocab()))
+	return logits
+}
+
 type ModelParams struct {
 	NumGpuLayers int
 	MainGpu      int
@@ -31,6 +31,7 @@ import (
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llama"
 	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/sample"
 )
 
 type LlamaServer interface {
@@ -95,6 +96,11 @@ func LoadModel(model string, maxArraySize int) (*ggml.GGML, error) {
 	return ggml, err
 }
 
+// RunnerExecutable is the binary started with "runner" to serve a model. It
+// defaults to the running executable, which is not an ollama binary when this
+// package is embedded in a shared library.
+var RunnerExecutable string
+
 // NewLlamaServer will run a server for the given GPUs
 // The gpu list must be a single family.
 func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, adapters, projectors []string, opts api.Options, numParallel int) (LlamaServer, error) {
@@ -260,9 +266,13 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		}
 	}
 	slog.Debug("compatible gpu libraries", "compatible", compatible)
-	exe, err := os.Executable()
-	if err != nil {
-		return nil, fmt.Errorf("unable to lookup executable path: %w", err)
+	var err error
+	exe := RunnerExecutable
+	if exe == "" {
+		exe, err = os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("unable to lookup executable path: %w", err)
+		}
 	}
 
 	if eval, err := filepath.EvalSymlinks(exe); err == nil {
@@ -672,7 +682,14 @@ type CompletionRequest struct {
 	Images  []ImageData
 	Options *api.Options
 
-	Grammar string // set before sending the request to the subprocess
+	// Logprobs requests the log probability of each token, with
+	// TopLogprobs alternatives
+	Logprobs    bool
+	TopLogprobs int
+
+	// Grammar constrains the output in GBNF. It is set from Format when
+	// one is given.
+	Grammar string
 }
 
 // DoneReason represents the reason why a completion response is done
@@ -706,6 +723,7 @@ type CompletionResponse struct {
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration"`
 	EvalCount          int           `json:"eval_count"`
 	EvalDuration       time.Duration `json:"eval_duration"`
+	Logprobs           []api.Logprob `json:"logprobs,omitempty"`
 }
 
 func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
@@ -719,12 +737,42 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 			req.Grammar = grammarJSON
 		default:
 			if req.Format[0] != '{' {
-				return fmt.Errorf("invalid format: %q; expected \"json\" or a valid JSON Schema object", req.Format)
+				return fmt.Errorf("invalid format: %q; expected \"json\" or a valid JSON Schema, regex or choice object", req.Format)
+			}
+
+			// Regular expressions and choices are compiled in Go for both
+			// engines, as GBNF for llama.cpp
+			var spec struct {
+				Type string `json:"type"`
+			}
+			if json.Unmarshal(req.Format, &spec) == nil && (spec.Type == "regex" || spec.Type == "choice") {
+				f, err := sample.CompileFormat(req.Format)
+				if err != nil {
+					return fmt.Errorf("invalid format: %w", err)
+				}
+
+				if s.textProcessor == nil {
+					req.Grammar = f.Grammar()
+				}
+				break
+			}
+
+			// The Ollama engine enforces JSON schemas itself, falling back
+			// to llama.cpp grammars for schemas it cannot compile
+			var serr error
+			if s.textProcessor != nil {
+				if _, serr = sample.CompileJSONSchema(req.Format); serr == nil {
+					break
+				}
+				slog.Debug("compiling JSON schema with llama.cpp", "error", serr)
 			}
 
 			// User provided a JSON schema
 			g := llama.SchemaToGrammar(req.Format)
 			if g == nil {
+				if serr != nil {
+					return fmt.Errorf("invalid format: %w", serr)
+				}
 				return fmt.Errorf("invalid JSON schema in format")
 			}
 			req.Grammar = string(g)
@@ -832,9 +880,12 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 				return ctx.Err()
 			}
 
-			if c.Content != "" {
+			if c.Content != "" || len(c.Logprobs) > 0 {
 				fn(CompletionResponse{
-					Content: c.Content,
+					Content:         c.Content,
+					Logprobs:        c.Logprobs,
+					PromptEvalCount: c.PromptEvalCount,
+					EvalCount:       c.EvalCount,
 				})
 			}
 
@@ -55,6 +55,10 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 		// JSON
 		`"json"`,
 		`{"type":"object"}`,
+
+		// regex and choice
+		`{"type":"regex","pattern":"[A-Z]{3}-\\d+"}`,
+		`{"type":"choice","values":["positive","negative"]}`,
 	}
 	for _, valid := range valids {
 		err := s.Completion(ctx, CompletionRequest{
@@ -69,4 +73,18 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 		Format:  nil, // missing format
 	}, nil)
 	checkValid(err)
+
+	for _, invalid := range []string{
+		`{"type":"regex","pattern":"("}`,
+		`{"type":"regex"}`,
+		`{"type":"choice","values":[]}`,
+	} {
+		err := s.Completion(ctx, CompletionRequest{
+			Options: new(api.Options),
+			Format:  []byte(invalid),
+		}, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid format: ") {
+			t.Errorf("format %s: err = %v; want invalid format", invalid, err)
+		}
+	}
 }
@@ -0,0 +1,140 @@
+package modelmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// Progress reports the state of a download in progress
+type Progress struct {
+	Status    string
+	Completed int64
+	Total     int64
+}
+
+// Backend stores the files behind the models in the catalog
+type Backend interface {
+	// Stat reports whether a model is installed and where it lives
+	Stat(model Model) (installed bool, location string, err error)
+
+	// Pull downloads a model, reporting progress through fn. It returns
+	// the context's error when the download is cancelled.
+	Pull(ctx context.Context, model Model, fn func(Progress)) error
+
+	// Remove deletes an installed model
+	Remove(model Model) error
+}
+
+// fileBackend keeps every model as a single "<name>.bin" file fetched from
+// the model's URL
+type fileBackend struct {
+	modelsDir string
+	client    *http.Client
+}
+
+func newFileBackend(modelsDir string) *fileBackend {
+	return &fileBackend{
+		modelsDir: modelsDir,
+		client:    http.DefaultClient,
+	}
+}
+
+// path returns the on-disk location of a downloaded model
+func (b *fileBackend) path(modelName string) string {
+	return filepath.Join(b.modelsDir, modelName+".bin")
+}
+
+func (b *fileBackend) Stat(model Model) (bool, string, error) {
+	modelPath := b.path(model.Name)
+	if _, err := os.Stat(modelPath); os.IsNotExist(err) {
+		return false, "", nil
+	} else if err != nil {
+		return false, "", err
+	}
+
+	return true, modelPath, nil
+}
+
+func (b *fileBackend) Pull(ctx context.Context, model Model, fn func(Progress)) error {
+	modelPath := b.path(model.Name)
+	partialPath := modelPath + partialSuffix
+
+	if err := b.fetchWithRetry(ctx, model, partialPath, fn); err != nil {
+		return err
+	}
+
+	fn(Progress{Status: "verifying"})
+	if err := verifyDigest(partialPath, model.Digest); err != nil {
+		if errors.Is(err, errDigestMismatch) {
+			// A corrupt partial file would never verify, so don't resume from it
+			os.Remove(partialPath)
+		}
+		return err
+	}
+
+	if err := os.Rename(partialPath, modelPath); err != nil {
+		return fmt.Errorf("failed to move model into place: %w", err)
+	}
+
+	return nil
+}
+
+func (b *fileBackend) Remove(model Model) error {
+	modelPath := b.path(model.Name)
+	if model.FilePath != "" {
+		modelPath = model.FilePath
+	}
+
+	// Delete the file along with any leftover partial download
+	if err := os.Remove(modelPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete model file: %w", err)
+	}
+	if err := os.Remove(b.path(model.Name) + partialSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete partial model file: %w", err)
+	}
+
+	return nil
+}
+
+// Metadata reads the GGUF header of an installed model from disk, or of a
+// model that has not been downloaded from the start of its URL
+func (b *fileBackend) Metadata(ctx context.Context, model Model) (*ggml.GGML, error) {
+	if f, err := os.Open(b.path(model.Name)); err == nil {
+		defer f.Close()
+
+		g, _, err := ggml.Decode(f, 0)
+		return g, err
+	}
+
+	if model.URL == "" {
+		return nil, errNoSource
+	}
+
+	return ReadMetadata(func(n int64) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, model.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch model header: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return nil, fmt.Errorf("failed to fetch model header: unexpected status code %d", resp.StatusCode)
+		}
+
+		// Servers that ignore Range send the whole file
+		return io.ReadAll(io.LimitReader(resp.Body, n))
+	})
+}
@@ -0,0 +1,304 @@
+package modelmanager
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/auth"
+)
+
+// defaultCatalog is used until a signed catalog has been loaded
+//
+//go:embed default_catalog.json
+var defaultCatalog []byte
+
+// catalogCacheFile is the name of the cached signed catalog in modelsDir
+const catalogCacheFile = "catalog.json"
+
+var (
+	ErrCatalogUnsigned     = errors.New("catalog is not signed")
+	ErrCatalogUntrustedKey = errors.New("catalog is signed by an untrusted key")
+	ErrCatalogSignature    = errors.New("catalog signature is invalid")
+	// ErrCatalogOutdated rejects a catalog older than the loaded or cached
+	// one, so an old signed catalog cannot be replayed
+	ErrCatalogOutdated = errors.New("catalog is older than the current catalog")
+)
+
+// Catalog is the list of models offered for download
+type Catalog struct {
+	UpdatedAt time.Time      `json:"updated_at"`
+	Models    []CatalogModel `json:"models"`
+}
+
+// CatalogModel describes a single model in the catalog
+type CatalogModel struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Size        int64    `json:"size"`
+	Parameters  int64    `json:"parameters,omitempty"`
+	Digest      string   `json:"digest,omitempty"`
+	URL         string   `json:"url,omitempty"`
+	Tags        []string `json:"tags"`
+	Category    string   `json:"category"`
+	Recommended bool     `json:"recommended"`
+	MinRAM      int64    `json:"min_ram,omitempty"`
+}
+
+// SignedCatalog wraps a catalog with a signature over its exact bytes. The
+// signature has the "<public key>:<signature>" form produced by auth.Sign.
+type SignedCatalog struct {
+	Catalog   json.RawMessage `json:"catalog"`
+	Signature string          `json:"signature"`
+}
+
+// CatalogConfig configures where the catalog comes from and who may sign it
+type CatalogConfig struct {
+	// Source is an http(s) URL or a local file path
+	Source string
+	// PublicKeys are the trusted ed25519 signing keys in any form
+	// auth.ParsePublicKey accepts
+	PublicKeys []string
+	// RefreshInterval is how often RunCatalogRefresh reloads the catalog
+	RefreshInterval time.Duration
+}
+
+// toModel converts a catalog entry into a model that is not yet downloaded
+func (cm CatalogModel) toModel() Model {
+	return Model{
+		Name:          cm.Name,
+		Description:   cm.Description,
+		Size:          cm.Size,
+		Parameters:    cm.Parameters,
+		Category:      cm.Category,
+		Tags:          cm.Tags,
+		KCRecommended: cm.Recommended,
+		URL:           cm.URL,
+		Digest:        cm.Digest,
+		MinRAM:        cm.MinRAM,
+	}
+}
+
+// parseCatalog decodes catalog JSON into models and the time the catalog
+// was updated
+func parseCatalog(data []byte) ([]Model, time.Time, error) {
+	var catalog Catalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to parse catalog: %w", err)
+	}
+
+	models := make([]Model, 0, len(catalog.Models))
+	for _, cm := range catalog.Models {
+		if cm.Name == "" {
+			return nil, time.Time{}, errors.New("catalog contains a model without a name")
+		}
+		models = append(models, cm.toModel())
+	}
+
+	return models, catalog.UpdatedAt, nil
+}
+
+// VerifyCatalog checks that signed was signed by one of the trusted keys and
+// returns the catalog bytes
+func VerifyCatalog(signed []byte, publicKeys []string) ([]byte, error) {
+	var sc SignedCatalog
+	if err := json.Unmarshal(signed, &sc); err != nil {
+		return nil, fmt.Errorf("failed to parse signed catalog: %w", err)
+	}
+
+	if len(sc.Catalog) == 0 {
+		return nil, ErrCatalogUnsigned
+	}
+
+	err := auth.Verify(sc.Signature, sc.Catalog, publicKeys)
+	switch {
+	case err == nil:
+		return sc.Catalog, nil
+	case errors.Is(err, auth.ErrUnsigned):
+		return nil, ErrCatalogUnsigned
+	case errors.Is(err, auth.ErrUntrustedKey):
+		return nil, ErrCatalogUntrustedKey
+	case errors.Is(err, auth.ErrInvalidSignature):
+		return nil, fmt.Errorf("%w: %w", ErrCatalogSignature, err)
+	default:
+		return nil, err
+	}
+}
+
+// loadInitialModels loads the built-in catalog
+func (mm *ModelManager) loadInitialModels() {
+	models, _, err := parseCatalog(defaultCatalog)
+	if err != nil {
+		// The embedded catalog is covered by tests, so this is a build problem
+		panic(err)
+	}
+	mm.state.models = models
+}
+
+// ConfigureCatalog sets the catalog source and loads the most recent
+// catalog, falling back to the cached copy when the source is unreachable
+func (mm *ModelManager) ConfigureCatalog(ctx context.Context, cfg CatalogConfig) error {
+	if len(cfg.PublicKeys) == 0 {
+		return errors.New("catalog requires at least one trusted public key")
+	}
+
+	// A source serving an older catalog than the cached one is treated as
+	// unreachable
+	var cachedAt time.Time
+	if cached, err := os.ReadFile(mm.catalogCachePath()); err == nil {
+		if data, err := VerifyCatalog(cached, cfg.PublicKeys); err == nil {
+			if _, updatedAt, err := parseCatalog(data); err == nil {
+				cachedAt = updatedAt
+			}
+		}
+	}
+
+	mm.mu.Lock()
+	mm.state.catalog = &cfg
+	mm.state.catalogUpdatedAt = cachedAt
+	mm.mu.Unlock()
+
+	err := mm.RefreshCatalog(ctx)
+	if err == nil {
+		return nil
+	}
+
+	// Only fall back for an unreachable source; a tampered catalog is
+	// reported even if an older cached copy exists
+	if errors.Is(err, ErrCatalogUnsigned) || errors.Is(err, ErrCatalogUntrustedKey) || errors.Is(err, ErrCatalogSignature) {
+		return err
+	}
+
+	cached, cacheErr := os.ReadFile(mm.catalogCachePath())
+	if cacheErr != nil {
+		return err
+	}
+
+	log.Printf("Warning: failed to load catalog from %s, using cached copy: %v", cfg.Source, err)
+	return mm.applyCatalog(cached, cfg.PublicKeys, false)
+}
+
+// RefreshCatalog reloads the catalog from its source. A catalog that fails
+// verification or is older than the current one is rejected and the current
+// models are kept.
+func (mm *ModelManager) RefreshCatalog(ctx context.Context) error {
+	mm.mu.Lock()
+	cfg := mm.state.catalog
+	mm.mu.Unlock()
+
+	if cfg == nil {
+		return errors.New("no catalog source configured")
+	}
+
+	signed, err := mm.fetchCatalog(ctx, cfg.Source)
+	if err != nil {
+		return err
+	}
+
+	return mm.applyCatalog(signed, cfg.PublicKeys, true)
+}
+
+// RunCatalogRefresh reloads the catalog every RefreshInterval until ctx is
+// done
+func (mm *ModelManager) RunCatalogRefresh(ctx context.Context) {
+	mm.mu.Lock()
+	cfg := mm.state.catalog
+	mm.mu.Unlock()
+
+	if cfg == nil || cfg.RefreshInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := mm.RefreshCatalog(ctx); err != nil {
+				log.Printf("Warning: failed to refresh catalog: %v", err)
+			}
+		}
+	}
+}
+
+func (mm *ModelManager) catalogCachePath() string {
+	return filepath.Join(mm.modelsDir, catalogCacheFile)
+}
+
+// fetchCatalog reads the signed catalog from a URL or local file
+func (mm *ModelManager) fetchCatalog(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		data, err := os.ReadFile(strings.TrimPrefix(source, "file://"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read catalog: %w", err)
+		}
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch catalog: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch catalog: unexpected status code %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// applyCatalog verifies a signed catalog and replaces the model list. If
+// cache is set the catalog also replaces the cached copy, with mm.mu held so
+// that concurrent refreshes leave the newest catalog cached.
+func (mm *ModelManager) applyCatalog(signed []byte, publicKeys []string, cache bool) error {
+	data, err := VerifyCatalog(signed, publicKeys)
+	if err != nil {
+		return err
+	}
+
+	models, updatedAt, err := parseCatalog(data)
+	if err != nil {
+		return err
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if updatedAt.Before(mm.state.catalogUpdatedAt) {
+		return fmt.Errorf("%w: updated %s, current catalog updated %s", ErrCatalogOutdated, updatedAt.Format(time.RFC3339), mm.state.catalogUpdatedAt.Format(time.RFC3339))
+	}
+
+	mm.state.catalogUpdatedAt = updatedAt
+	mm.state.models = models
+	mm.refreshDownloadStatus()
+
+	// Queued downloads may be waiting for a model this catalog adds
+	mm.schedule()
+
+	// Only cache catalogs that verified
+	if cache {
+		if err := writeFileAtomic(mm.catalogCachePath(), signed); err != nil {
+			return fmt.Errorf("failed to cache catalog: %w", err)
+		}
+	}
+
+	return nil
+}
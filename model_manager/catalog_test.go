@@ -0,0 +1,357 @@
+package modelmanager
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/auth"
+)
+
+// newSigningKey writes a fresh ed25519 key where auth.Sign looks for it and
+// returns its public half in authorized_keys format
+func newSigningKey(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(home, ".ollama"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".ollama", "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+}
+
+func signCatalog(t *testing.T, catalog Catalog) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := auth.Sign(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := json.Marshal(SignedCatalog{Catalog: data, Signature: sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func testCatalog(names ...string) Catalog {
+	var catalog Catalog
+	for _, name := range names {
+		catalog.Models = append(catalog.Models, CatalogModel{
+			Name:        name,
+			Description: name + " model",
+			Size:        1000,
+			Digest:      "sha256:" + strings.Repeat("0", 64),
+			Tags:        []string{"test"},
+			Category:    "general",
+			Recommended: name == "alpha",
+			MinRAM:      8 << 30,
+		})
+	}
+	return catalog
+}
+
+func modelNames(models []Model) []string {
+	var names []string
+	for _, m := range models {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func TestDefaultCatalog(t *testing.T) {
+	models, _, err := parseCatalog(defaultCatalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(models) == 0 {
+		t.Fatal("expected default catalog to contain models")
+	}
+
+	mm, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(mm.GetModels()); got != len(models) {
+		t.Fatalf("expected %d models, got %d", len(models), got)
+	}
+}
+
+func TestCatalogFromFile(t *testing.T) {
+	pub := newSigningKey(t)
+
+	source := filepath.Join(t.TempDir(), "catalog.json")
+	if err := os.WriteFile(source, signCatalog(t, testCatalog("alpha", "beta")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mm, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mm.ConfigureCatalog(context.Background(), CatalogConfig{Source: source, PublicKeys: []string{pub}}); err != nil {
+		t.Fatal(err)
+	}
+
+	models := mm.GetModels()
+	if got := strings.Join(modelNames(models), ","); got != "alpha,beta" {
+		t.Fatalf("expected alpha,beta, got %s", got)
+	}
+	if !models[0].KCRecommended || models[1].KCRecommended {
+		t.Fatal("expected only alpha to be recommended")
+	}
+	if models[0].MinRAM != 8<<30 || models[0].Digest == "" {
+		t.Fatalf("expected catalog metadata to be kept, got %+v", models[0])
+	}
+
+	if _, err := os.Stat(mm.catalogCachePath()); err != nil {
+		t.Fatalf("expected catalog to be cached: %v", err)
+	}
+}
+
+func TestCatalogRejectsTampering(t *testing.T) {
+	pub := newSigningKey(t)
+	signed := signCatalog(t, testCatalog("alpha"))
+
+	var sc SignedCatalog
+	if err := json.Unmarshal(signed, &sc); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := sc
+	tampered.Catalog = json.RawMessage(strings.Replace(string(sc.Catalog), `"size":1000`, `"size":1`, 1))
+
+	unsigned := sc
+	unsigned.Signature = ""
+
+	malformed := sc
+	malformed.Signature = strings.Replace(sc.Signature, ":", "x:", 1)
+
+	// Signed correctly, but by a key that is not trusted
+	untrusted := sc
+	otherPub := newSigningKey(t)
+
+	cases := []struct {
+		name    string
+		catalog SignedCatalog
+		keys    []string
+		err     error
+	}{
+		{"tampered", tampered, []string{pub}, ErrCatalogSignature},
+		{"unsigned", unsigned, []string{pub}, ErrCatalogUnsigned},
+		{"malformed signer", malformed, []string{pub}, ErrCatalogSignature},
+		{"untrusted key", untrusted, []string{otherPub}, ErrCatalogUntrustedKey},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.catalog)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			source := filepath.Join(t.TempDir(), "catalog.json")
+			if err := os.WriteFile(source, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			mm, err := New(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := modelNames(mm.GetModels())
+
+			err = mm.ConfigureCatalog(context.Background(), CatalogConfig{Source: source, PublicKeys: tt.keys})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+
+			if got := modelNames(mm.GetModels()); strings.Join(got, ",") != strings.Join(before, ",") {
+				t.Fatalf("expected models to be unchanged, got %v", got)
+			}
+			if _, err := os.Stat(mm.catalogCachePath()); !os.IsNotExist(err) {
+				t.Fatal("expected rejected catalog to not be cached")
+			}
+		})
+	}
+}
+
+func TestCatalogFromURL(t *testing.T) {
+	pub := newSigningKey(t)
+
+	var mu sync.Mutex
+	current := signCatalog(t, testCatalog("alpha"))
+	up := true
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(current)
+	}))
+	defer srv.Close()
+
+	modelsDir := t.TempDir()
+	mm, err := New(modelsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := CatalogConfig{Source: srv.URL, PublicKeys: []string{pub}, RefreshInterval: 10 * time.Millisecond}
+	if err := mm.ConfigureCatalog(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := modelNames(mm.GetModels()); len(got) != 1 || got[0] != "alpha" {
+		t.Fatalf("expected alpha, got %v", got)
+	}
+
+	// The refresh loop picks up a new catalog
+	mu.Lock()
+	current = signCatalog(t, testCatalog("alpha", "beta"))
+	mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		mm.RunCatalogRefresh(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(mm.GetModels()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("catalog was not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	// With the source down a new manager falls back to the cached copy
+	mu.Lock()
+	up = false
+	mu.Unlock()
+
+	cached, err := New(modelsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cached.ConfigureCatalog(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := modelNames(cached.GetModels()); strings.Join(got, ",") != "alpha,beta" {
+		t.Fatalf("expected cached alpha,beta, got %v", got)
+	}
+}
+
+func TestCatalogRejectsReplay(t *testing.T) {
+	pub := newSigningKey(t)
+
+	older := testCatalog("alpha")
+	older.UpdatedAt = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := testCatalog("alpha", "beta")
+	newer.UpdatedAt = older.UpdatedAt.Add(24 * time.Hour)
+
+	source := filepath.Join(t.TempDir(), "catalog.json")
+	if err := os.WriteFile(source, signCatalog(t, newer), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	modelsDir := t.TempDir()
+	mm, err := New(modelsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := CatalogConfig{Source: source, PublicKeys: []string{pub}}
+	if err := mm.ConfigureCatalog(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent refreshes each replace the cache whole
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := mm.RefreshCatalog(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// An older catalog signed by the same key is rejected
+	if err := os.WriteFile(source, signCatalog(t, older), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mm.RefreshCatalog(context.Background()); !errors.Is(err, ErrCatalogOutdated) {
+		t.Fatalf("expected %v, got %v", ErrCatalogOutdated, err)
+	}
+	if got := modelNames(mm.GetModels()); strings.Join(got, ",") != "alpha,beta" {
+		t.Fatalf("expected alpha,beta to be kept, got %v", got)
+	}
+
+	// A new manager falls back to the newer cached copy
+	restarted, err := New(modelsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.ConfigureCatalog(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := modelNames(restarted.GetModels()); strings.Join(got, ",") != "alpha,beta" {
+		t.Fatalf("expected cached alpha,beta, got %v", got)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(modelsDir, catalogCacheFile+".tmp*")); len(matches) > 0 {
+		t.Fatalf("expected no temporary files, got %v", matches)
+	}
+}
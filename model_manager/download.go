@@ -0,0 +1,324 @@
+package modelmanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// partialSuffix is appended to a model path while it is being downloaded
+	partialSuffix = ".partial"
+
+	// maxRetries is the number of times a dropped transfer is resumed
+	// before the download is marked as failed
+	maxRetries = 6
+)
+
+// retryBackoff is the base delay between resume attempts; it doubles on
+// every attempt
+var retryBackoff = 500 * time.Millisecond
+
+var (
+	errNoSource       = errors.New("no source URL configured for model")
+	errNoDigest       = errors.New("no digest configured for model")
+	errDigestMismatch = errors.New("digest mismatch")
+)
+
+// permanentError wraps failures that retrying cannot fix, such as a 404
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// progressWriter reports bytes written to the partial file
+type progressWriter struct {
+	fn        func(Progress)
+	completed int64
+	total     int64
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.completed += int64(len(b))
+	w.fn(Progress{Status: "downloading", Completed: w.completed, Total: w.total})
+	return len(b), nil
+}
+
+// downloadModel pulls a model through the backend and records the outcome
+// in its download status
+func (mm *ModelManager) downloadModel(ctx context.Context, model Model) {
+	defer mm.running.Done()
+
+	err := mm.backend.Pull(ctx, model, func(p Progress) {
+		mm.updateProgress(model.Name, p)
+	})
+
+	var location string
+	if err == nil {
+		var installed bool
+		installed, location, err = mm.backend.Stat(model)
+		if err == nil && !installed {
+			err = errors.New("model missing after download")
+		}
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	// Let the next queued download start
+	delete(mm.state.cancels, model.Name)
+	defer mm.schedule()
+
+	status := mm.state.downloads[model.Name]
+	status.ETA = 0
+	defer mm.publish(status)
+
+	entry := mm.state.queue[model.Name]
+	if errors.Is(err, context.Canceled) && entry != nil && !entry.cancelled {
+		// Paused, or paused and resumed again before the transfer stopped
+		if entry.Paused {
+			status.Status = "paused"
+			log.Printf("Download of model %s paused\n", model.Name)
+		} else {
+			status.Status = "queued"
+		}
+		return
+	}
+
+	delete(mm.state.queue, model.Name)
+	mm.saveQueue()
+	status.Completed = true
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		status.Status = "cancelled"
+		log.Printf("Download of model %s cancelled\n", model.Name)
+	case err != nil:
+		status.Status = "failed"
+		status.Error = err.Error()
+		log.Printf("Download of model %s failed: %v\n", model.Name, err)
+	default:
+		status.Status = "completed"
+		status.Progress = 100
+		status.Downloaded = status.TotalSize
+
+		if m := mm.findModel(model.Name); m != nil {
+			m.IsDownloaded = true
+			m.FilePath = location
+		}
+		log.Printf("Download of model %s completed\n", model.Name)
+	}
+}
+
+// updateProgress applies a backend progress report to a download status
+// and publishes it to subscribers
+func (mm *ModelManager) updateProgress(modelName string, p Progress) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	status, ok := mm.state.downloads[modelName]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	changed := p.Status != "" && p.Status != status.Status
+	if changed {
+		status.Status = p.Status
+		if p.Status != "downloading" {
+			status.ETA = 0
+		}
+	}
+
+	if p.Total > 0 {
+		status.TotalSize = p.Total
+		status.Downloaded = p.Completed
+		status.Progress = float64(p.Completed) * 100 / float64(p.Total)
+
+		// Measure from the first report so a resumed transfer doesn't count
+		// the bytes that were already on disk
+		if status.started.IsZero() {
+			status.started = now
+			status.startBytes = p.Completed
+		} else if elapsed := now.Sub(status.started).Seconds(); elapsed > 0 {
+			status.Rate = float64(p.Completed-status.startBytes) / elapsed
+			if status.Rate > 0 && status.Status == "downloading" {
+				status.ETA = float64(p.Total-p.Completed) / status.Rate
+			}
+		}
+	}
+
+	if changed || now.Sub(status.published) >= progressInterval {
+		mm.publish(status)
+	}
+}
+
+// fetchWithRetry downloads the model into partialPath, resuming after
+// dropped connections until maxRetries is exhausted
+func (b *fileBackend) fetchWithRetry(ctx context.Context, model Model, partialPath string, fn func(Progress)) error {
+	if model.URL == "" {
+		return errNoSource
+	}
+	// Fail before downloading a model that could never be verified
+	if model.Digest == "" {
+		return errNoDigest
+	}
+
+	var err error
+	for try := 0; try < maxRetries; try++ {
+		err = b.fetch(ctx, model, partialPath, fn)
+		var perr *permanentError
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.As(err, &perr):
+			return err
+		}
+
+		sleep := retryBackoff * time.Duration(1<<try)
+		log.Printf("Download of model %s attempt %d failed: %v, resuming in %s\n", model.Name, try, err, sleep)
+
+		t := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	return fmt.Errorf("max retries exceeded: %w", err)
+}
+
+// fetch performs a single HTTP request for the model, using a Range request
+// to continue from the end of an existing partial file
+func (b *fileBackend) fetch(ctx context.Context, model Model, partialPath string, fn func(Progress)) error {
+	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return &permanentError{fmt.Errorf("failed to open partial file: %w", err)}
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, model.URL, nil)
+	if err != nil {
+		return &permanentError{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var total int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		total = parseContentRangeTotal(resp.Header.Get("Content-Range"))
+	case http.StatusOK:
+		// The server ignored the range, start over
+		if err := file.Truncate(0); err != nil {
+			return &permanentError{err}
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return &permanentError{err}
+		}
+		offset = 0
+		total = resp.ContentLength
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file already holds every byte; the digest check
+		// decides whether it is usable
+		if t := parseContentRangeTotal(resp.Header.Get("Content-Range")); t == offset {
+			fn(Progress{Status: "downloading", Completed: offset, Total: t})
+			return nil
+		}
+		if err := file.Truncate(0); err != nil {
+			return &permanentError{err}
+		}
+		return errors.New("partial file is larger than the remote model")
+	default:
+		err := fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return &permanentError{err}
+		}
+		return err
+	}
+
+	if total <= 0 {
+		total = model.Size
+	}
+	fn(Progress{Status: "downloading", Completed: offset, Total: total})
+
+	n, err := io.Copy(io.MultiWriter(file, &progressWriter{fn: fn, completed: offset, total: total}), resp.Body)
+	if err != nil {
+		return err
+	}
+	if total > 0 && offset+n < total {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+// parseContentRangeTotal returns the complete length from a Content-Range
+// header such as "bytes 100-199/200", or -1 if it is unknown
+func parseContentRangeTotal(header string) int64 {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok {
+		return -1
+	}
+
+	n, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// verifyDigest checks the SHA-256 of the file at path against digest, which
+// may be given with or without its "sha256:" prefix. A file without a digest
+// cannot be verified and is rejected.
+func verifyDigest(path, digest string) error {
+	if digest == "" {
+		return errNoDigest
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash model file: %w", err)
+	}
+
+	want := strings.TrimPrefix(digest, "sha256:")
+	got := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(got, want) {
+		return fmt.Errorf("%w: expected sha256:%s, got sha256:%s", errDigestMismatch, want, got)
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+package modelmanager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// truncatingReader stops after limit bytes so the response is shorter than
+// its advertised Content-Length and the server drops the connection
+type truncatingReader struct {
+	*bytes.Reader
+	limit int
+}
+
+func (r *truncatingReader) Read(p []byte) (int, error) {
+	if r.limit <= 0 {
+		return 0, errors.New("connection dropped")
+	}
+	if len(p) > r.limit {
+		p = p[:r.limit]
+	}
+	n, err := r.Reader.Read(p)
+	r.limit -= n
+	return n, err
+}
+
+// fixtureServer serves blob with Range support. The first drops requests
+// are cut off after a sixteenth of the blob has been sent.
+type fixtureServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	drops  int
+	ranges []string
+}
+
+func newFixtureServer(t *testing.T, blob []byte, drops int) *fixtureServer {
+	t.Helper()
+
+	fs := &fixtureServer{drops: drops}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		fs.ranges = append(fs.ranges, r.Header.Get("Range"))
+		drop := fs.drops > 0
+		fs.drops--
+		fs.mu.Unlock()
+
+		var content io.ReadSeeker = bytes.NewReader(blob)
+		if drop {
+			content = &truncatingReader{Reader: bytes.NewReader(blob), limit: len(blob) / 16}
+		}
+		http.ServeContent(w, r, "blob", time.Time{}, content)
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func (fs *fixtureServer) Ranges() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]string(nil), fs.ranges...)
+}
+
+func fixtureBlob(size int) ([]byte, string) {
+	blob := make([]byte, size)
+	for i := range blob {
+		blob[i] = byte(i * 7)
+	}
+	sum := sha256.Sum256(blob)
+	return blob, "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func newTestManager(t *testing.T, models ...Model) *ModelManager {
+	t.Helper()
+
+	retryBackoff = time.Millisecond
+
+	mm, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.state.models = models
+	return mm
+}
+
+func waitForDownload(t *testing.T, mm *ModelManager, name string) *DownloadStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := mm.GetDownloadStatus(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Completed {
+			return status
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("download of %s did not finish", name)
+	return nil
+}
+
+func TestDownloadModel(t *testing.T) {
+	blob, digest := fixtureBlob(64 * 1024)
+
+	cases := []struct {
+		name   string
+		drops  int
+		digest string
+		status string
+	}{
+		{name: "complete", drops: 0, digest: digest, status: "completed"},
+		{name: "resume after drops", drops: 2, digest: digest, status: "completed"},
+		{name: "bare hex digest", drops: 0, digest: digest[len("sha256:"):], status: "completed"},
+		{name: "no digest", drops: 0, digest: "", status: "failed"},
+		{name: "digest mismatch", drops: 0, digest: "sha256:" + hex.EncodeToString(make([]byte, 32)), status: "failed"},
+		{name: "too many drops", drops: maxRetries, digest: digest, status: "failed"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFixtureServer(t, blob, tt.drops)
+			mm := newTestManager(t, Model{Name: "fixture", Size: int64(len(blob)), URL: srv.URL, Digest: tt.digest})
+
+			if _, err := mm.StartModelDownload("fixture"); err != nil {
+				t.Fatal(err)
+			}
+
+			status := waitForDownload(t, mm, "fixture")
+			if status.Status != tt.status {
+				t.Fatalf("expected status %q, got %q (%s)", tt.status, status.Status, status.Error)
+			}
+
+			_, err := os.Stat(filePath(mm, "fixture"))
+			switch tt.status {
+			case "completed":
+				got, err := os.ReadFile(filePath(mm, "fixture"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, blob) {
+					t.Fatal("downloaded file does not match fixture")
+				}
+				if _, err := os.Stat(filePath(mm, "fixture") + partialSuffix); !os.IsNotExist(err) {
+					t.Fatalf("expected partial file to be gone, got %v", err)
+				}
+				if !mm.GetModels()[0].IsDownloaded {
+					t.Fatal("expected model to be marked as downloaded")
+				}
+				if tt.drops > 0 {
+					ranges := srv.Ranges()
+					if len(ranges) != tt.drops+1 || ranges[len(ranges)-1] == "" {
+						t.Fatalf("expected ranged resume requests, got %q", ranges)
+					}
+				}
+			default:
+				if !os.IsNotExist(err) {
+					t.Fatalf("expected no model file, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadResumesPartialFile(t *testing.T) {
+	blob, digest := fixtureBlob(32 * 1024)
+	srv := newFixtureServer(t, blob, 0)
+	mm := newTestManager(t, Model{Name: "fixture", Size: int64(len(blob)), URL: srv.URL, Digest: digest})
+
+	// Simulate a previous run that crashed half way through
+	if err := os.WriteFile(filePath(mm, "fixture")+partialSuffix, blob[:10000], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mm.StartModelDownload("fixture"); err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitForDownload(t, mm, "fixture")
+	if status.Status != "completed" {
+		t.Fatalf("expected completed, got %q (%s)", status.Status, status.Error)
+	}
+
+	if ranges := srv.Ranges(); len(ranges) != 1 || ranges[0] != "bytes=10000-" {
+		t.Fatalf("expected a single resumed request, got %q", ranges)
+	}
+
+	got, err := os.ReadFile(filePath(mm, "fixture"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Fatal("downloaded file does not match fixture")
+	}
+}
+
+func TestCancelDownloadKeepsPartial(t *testing.T) {
+	blob, digest := fixtureBlob(32 * 1024)
+
+	// Hold the response open until the download has been cancelled
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "32768")
+		w.Write(blob[:1024])
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	mm := newTestManager(t, Model{Name: "fixture", Size: int64(len(blob)), URL: srv.URL, Digest: digest})
+	if _, err := mm.StartModelDownload("fixture"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := mm.GetDownloadStatus("fixture")
+		if status.Downloaded == 1024 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("download did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := mm.CancelDownload("fixture"); err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitForDownload(t, mm, "fixture")
+	if status.Status != "cancelled" {
+		t.Fatalf("expected cancelled, got %q", status.Status)
+	}
+
+	fi, err := os.Stat(filePath(mm, "fixture") + partialSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 1024 {
+		t.Fatalf("expected 1024 bytes in partial file, got %d", fi.Size())
+	}
+
+	if err := mm.CancelDownload("fixture"); err == nil {
+		t.Fatal("expected error cancelling a finished download")
+	}
+}
+
+func TestDownloadWithoutSource(t *testing.T) {
+	mm := newTestManager(t, Model{Name: "fixture", Size: 10})
+	if _, err := mm.StartModelDownload("fixture"); err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitForDownload(t, mm, "fixture")
+	if status.Status != "failed" || status.Error != errNoSource.Error() {
+		t.Fatalf("expected failure for missing source, got %q (%s)", status.Status, status.Error)
+	}
+}
+
+func TestParseContentRangeTotal(t *testing.T) {
+	cases := map[string]int64{
+		"bytes 0-99/200":   200,
+		"bytes */200":      200,
+		"bytes 0-99/*":     -1,
+		"":                 -1,
+		"bytes 10-19/1000": 1000,
+	}
+
+	for header, want := range cases {
+		if got := parseContentRangeTotal(header); got != want {
+			t.Errorf("%q: expected %d, got %d", header, want, got)
+		}
+	}
+}
+
+func filePath(mm *ModelManager, name string) string {
+	return mm.backend.(*fileBackend).path(name)
+}
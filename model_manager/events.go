@@ -0,0 +1,92 @@
+package modelmanager
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// progressInterval limits how often progress within a single state is
+// published. State transitions are always published.
+const progressInterval = 250 * time.Millisecond
+
+// subscriber queues status updates for one Subscribe call. Consecutive
+// updates for the same model and state are coalesced, so a slow subscriber
+// sees every state transition but not every progress report.
+type subscriber struct {
+	mu     sync.Mutex
+	queue  []DownloadStatus
+	notify chan struct{}
+}
+
+func (s *subscriber) push(status DownloadStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := len(s.queue); n > 0 && s.queue[n-1].ModelName == status.ModelName && s.queue[n-1].Status == status.Status {
+		s.queue[n-1] = status
+	} else {
+		s.queue = append(s.queue, status)
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) drain() []DownloadStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.queue
+	s.queue = nil
+	return queue
+}
+
+// Subscribe returns a channel that receives a copy of a download status
+// every time it changes. The channel is closed once ctx is done.
+func (mm *ModelManager) Subscribe(ctx context.Context) <-chan DownloadStatus {
+	sub := &subscriber{notify: make(chan struct{}, 1)}
+
+	mm.mu.Lock()
+	mm.state.subscribers[sub] = struct{}{}
+	mm.mu.Unlock()
+
+	ch := make(chan DownloadStatus)
+	go func() {
+		defer close(ch)
+		defer func() {
+			mm.mu.Lock()
+			delete(mm.state.subscribers, sub)
+			mm.mu.Unlock()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.notify:
+			}
+
+			for _, status := range sub.drain() {
+				select {
+				case ch <- status:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// publish sends a copy of status to every subscriber. It must be called
+// with mm.mu held.
+func (mm *ModelManager) publish(status *DownloadStatus) {
+	status.published = time.Now()
+	for sub := range mm.state.subscribers {
+		sub.push(*status)
+	}
+}
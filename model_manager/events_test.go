@@ -0,0 +1,153 @@
+package modelmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// collectEvents reads events for name until its download finishes
+func collectEvents(t *testing.T, events <-chan DownloadStatus, name string) []DownloadStatus {
+	t.Helper()
+
+	var got []DownloadStatus
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case status, ok := <-events:
+			if !ok {
+				t.Fatal("events closed before the download finished")
+			}
+			if status.ModelName != name {
+				continue
+			}
+			got = append(got, status)
+			if status.Completed {
+				return got
+			}
+		case <-timeout:
+			t.Fatalf("download of %s did not finish, got %v", name, got)
+		}
+	}
+}
+
+// states returns the distinct consecutive states in events
+func states(events []DownloadStatus) []string {
+	var s []string
+	for _, e := range events {
+		if len(s) == 0 || s[len(s)-1] != e.Status {
+			s = append(s, e.Status)
+		}
+	}
+	return s
+}
+
+func TestSubscribe(t *testing.T) {
+	blob, digest := fixtureBlob(64 * 1024)
+
+	// Trickle the blob out so progress is reported more than once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		for chunk := range slices.Chunk(blob, 4*1024) {
+			w.Write(chunk)
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	mm := newTestManager(t, Model{Name: "fixture", Size: int64(len(blob)), URL: srv.URL, Digest: digest})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := mm.Subscribe(ctx)
+	if _, err := mm.StartModelDownload("fixture"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := collectEvents(t, events, "fixture")
+	if want := []string{"queued", "downloading", "verifying", "completed"}; !slices.Equal(states(got), want) {
+		t.Fatalf("expected states %v, got %v", want, states(got))
+	}
+
+	var measured bool
+	var last int64
+	for _, e := range got {
+		if e.Downloaded < last {
+			t.Fatalf("progress went backwards: %d after %d", e.Downloaded, last)
+		}
+		last = e.Downloaded
+
+		if e.Status == "downloading" && e.Rate > 0 && e.ETA > 0 {
+			measured = true
+		}
+	}
+	if !measured {
+		t.Fatalf("expected a downloading event with a rate and ETA, got %+v", got)
+	}
+
+	final := got[len(got)-1]
+	if final.Progress != 100 || final.Downloaded != int64(len(blob)) || final.ETA != 0 {
+		t.Fatalf("unexpected final event %+v", final)
+	}
+
+	cancel()
+	for range events {
+	}
+
+	mm.mu.Lock()
+	n := len(mm.state.subscribers)
+	mm.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("expected subscriber to be removed, got %d", n)
+	}
+}
+
+func TestSubscribeFailed(t *testing.T) {
+	mm := newTestManager(t, Model{Name: "nosource", Size: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := mm.Subscribe(ctx)
+	if _, err := mm.StartModelDownload("nosource"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := collectEvents(t, events, "nosource")
+	final := got[len(got)-1]
+	if final.Status != "failed" || final.Error != errNoSource.Error() {
+		t.Fatalf("expected failed event, got %+v", final)
+	}
+}
+
+func TestSubscriberCoalesces(t *testing.T) {
+	sub := &subscriber{notify: make(chan struct{}, 1)}
+
+	sub.push(DownloadStatus{ModelName: "a", Status: "queued"})
+	for i := range 10 {
+		sub.push(DownloadStatus{ModelName: "a", Status: "downloading", Downloaded: int64(i)})
+	}
+	sub.push(DownloadStatus{ModelName: "b", Status: "downloading"})
+	sub.push(DownloadStatus{ModelName: "a", Status: "completed", Completed: true})
+
+	got := sub.drain()
+	var names []string
+	for _, e := range got {
+		names = append(names, e.ModelName+":"+e.Status)
+	}
+	if want := []string{"a:queued", "a:downloading", "b:downloading", "a:completed"}; !slices.Equal(names, want) {
+		t.Fatalf("expected events %v, got %v", want, names)
+	}
+	if got[1].Downloaded != 9 {
+		t.Fatalf("expected the latest progress to be kept, got %d", got[1].Downloaded)
+	}
+	if len(sub.drain()) != 0 {
+		t.Fatal("expected queue to be empty after drain")
+	}
+}
@@ -0,0 +1,247 @@
+package modelmanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+// Verdict summarizes whether a model can be downloaded and run on this
+// machine
+type Verdict string
+
+const (
+	// FitOK means the model fits on disk and runs at least partly on a GPU
+	FitOK Verdict = "ok"
+	// FitCPUOnly means the model fits on disk but no layer fits on a GPU
+	FitCPUOnly Verdict = "cpu_only"
+	// FitInsufficientDisk means there is not enough free space in the
+	// models directory to download the model
+	FitInsufficientDisk Verdict = "insufficient_disk"
+	// FitInsufficientRAM means the model needs more system memory than is
+	// available
+	FitInsufficientRAM Verdict = "insufficient_ram"
+)
+
+const (
+	// headerFetchSize is how much of a model is read first to decode its
+	// GGUF header. It doubles until the header fits or maxHeaderSize is
+	// reached.
+	headerFetchSize = 1 << 20
+	maxHeaderSize   = 64 << 20
+)
+
+// ErrInsufficientDisk is returned when a download would not fit in the
+// models directory
+var ErrInsufficientDisk = errors.New("insufficient disk space")
+
+// Hardware and disk probes, replaced in tests
+var (
+	getGPUInfo    = discover.GetGPUInfo
+	getCPUInfo    = discover.GetCPUInfo
+	freeDiskSpace = diskFree
+)
+
+// MetadataBackend is implemented by backends that can read the GGUF header
+// of a model without downloading all of it. Without it, fit checks fall back
+// to the sizes in the catalog.
+type MetadataBackend interface {
+	Metadata(ctx context.Context, model Model) (*ggml.GGML, error)
+}
+
+// FitReport is the outcome of a pre-flight check for one model
+type FitReport struct {
+	Verdict Verdict `json:"verdict"`
+	Reason  string  `json:"reason,omitempty"`
+
+	DiskRequired  uint64 `json:"disk_required"`
+	DiskAvailable uint64 `json:"disk_available"`
+
+	// MemoryRequired is the system memory needed once as many layers as
+	// possible are offloaded; VRAMRequired is the GPU memory for those
+	// layers
+	MemoryRequired  uint64 `json:"memory_required"`
+	MemoryAvailable uint64 `json:"memory_available"`
+	VRAMRequired    uint64 `json:"vram_required,omitempty"`
+
+	// GPULayers of Layers fit on the GPU. Both are zero when the model's
+	// metadata could not be read.
+	GPULayers int `json:"gpu_layers"`
+	Layers    int `json:"layers,omitempty"`
+}
+
+// CheckFit reports whether a model fits in the models directory and in
+// this machine's memory. Installed models always pass the disk check.
+func (mm *ModelManager) CheckFit(ctx context.Context, modelName string) (*FitReport, error) {
+	mm.mu.Lock()
+	mm.refreshDownloadStatus()
+	model := mm.findModel(modelName)
+	if model == nil {
+		mm.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrModelNotFound, modelName)
+	}
+	m := *model
+	var report FitReport
+	diskErr := mm.checkDisk(m, &report)
+	mm.mu.Unlock()
+
+	if diskErr != nil {
+		report.Verdict = FitInsufficientDisk
+		report.Reason = diskErr.Error()
+		return &report, nil
+	}
+
+	var f *ggml.GGML
+	if b, ok := mm.backend.(MetadataBackend); ok {
+		var err error
+		if f, err = b.Metadata(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Printf("Warning: failed to read metadata for %s, estimating from catalog size: %v", m.Name, err)
+			f = nil
+		}
+	}
+
+	checkMemory(m, f, &report)
+	return &report, nil
+}
+
+// checkDisk fills in the disk fields of report. Space is reserved for the
+// rest of the queue as well, so several downloads started at once cannot
+// overfill the disk. It must be called with mm.mu held.
+func (mm *ModelManager) checkDisk(model Model, report *FitReport) error {
+	if model.IsDownloaded {
+		return nil
+	}
+
+	available, err := freeDiskSpace(mm.modelsDir)
+	if err != nil {
+		// Not knowing is no reason to refuse a download
+		log.Printf("Warning: failed to check free disk space: %v", err)
+		return nil
+	}
+
+	required := uint64(max(model.Size, 0))
+	for name := range mm.state.queue {
+		if name == model.Name {
+			continue
+		}
+		if status := mm.state.downloads[name]; status != nil {
+			required += uint64(max(status.TotalSize-status.Downloaded, 0))
+		}
+	}
+
+	report.DiskRequired, report.DiskAvailable = required, available
+	if required > available {
+		return fmt.Errorf("%w: %s needs %s, %s available", ErrInsufficientDisk, model.Name, format.HumanBytes2(required), format.HumanBytes2(available))
+	}
+
+	return nil
+}
+
+// checkMemory fills in the memory fields and verdict of report. With GGUF
+// metadata it uses the same estimate the scheduler uses to load the model;
+// without it, the model is assumed to need its MinRAM, or its size.
+func checkMemory(model Model, f *ggml.GGML, report *FitReport) {
+	gpus := getGPUInfo()
+	cpus := getCPUInfo()
+
+	var system uint64
+	if len(cpus) > 0 {
+		system = cpus[0].FreeMemory + cpus[0].FreeSwap
+		if runtime.GOOS == "darwin" {
+			// Darwin has fully dynamic swap, so free memory undercounts
+			system = cpus[0].TotalMemory
+		}
+	}
+	report.MemoryAvailable = system
+
+	cpuOnly := len(gpus) == 0 || gpus[0].Library == "cpu"
+
+	var total, vram uint64
+	if f != nil {
+		opts := api.DefaultOptions()
+		report.Layers = int(f.KV().BlockCount()) + 1
+
+		if !cpuOnly {
+			if fits, estimatedVRAM := llm.PredictServerFit(gpus, f, nil, nil, opts, 1); fits {
+				report.GPULayers = report.Layers
+				report.VRAMRequired = estimatedVRAM
+				report.Verdict = FitOK
+				return
+			}
+
+			// Keep the library that offloads the most layers
+			for _, g := range gpus.ByLibrary() {
+				estimate := llm.EstimateGPULayers(g, f, nil, opts, 1)
+				if estimate.Layers > report.GPULayers || total == 0 {
+					report.GPULayers = estimate.Layers
+					total, vram = estimate.TotalSize, estimate.VRAMSize
+				}
+			}
+		}
+
+		if report.GPULayers == 0 && len(cpus) > 0 {
+			total = llm.EstimateGPULayers(cpus, f, nil, opts, 1).TotalSize
+			vram = 0
+		}
+	} else {
+		total = uint64(max(model.MinRAM, model.Size, 0))
+		if !cpuOnly {
+			var free uint64
+			for _, g := range gpus {
+				free += g.FreeMemory
+			}
+			if total <= free {
+				vram = total
+			}
+		}
+	}
+
+	report.VRAMRequired = vram
+	report.MemoryRequired = total - vram
+
+	switch {
+	case report.MemoryRequired > system:
+		report.Verdict = FitInsufficientRAM
+		report.Reason = fmt.Sprintf("%s needs %s of system memory, %s available", model.Name, format.HumanBytes2(report.MemoryRequired), format.HumanBytes2(system))
+	case vram == 0:
+		report.Verdict = FitCPUOnly
+		report.Reason = fmt.Sprintf("%s does not fit on a GPU and will run on the CPU", model.Name)
+	default:
+		report.Verdict = FitOK
+	}
+}
+
+// ReadMetadata decodes a GGUF header from the start of a model. fetch
+// returns up to n bytes from the start of the model file; a short read
+// means the file ended.
+func ReadMetadata(fetch func(n int64) ([]byte, error)) (*ggml.GGML, error) {
+	for n := int64(headerFetchSize); ; n *= 2 {
+		data, err := fetch(n)
+		if err != nil {
+			return nil, err
+		}
+
+		f, _, err := ggml.Decode(bytes.NewReader(data), 0)
+		if err == nil {
+			return f, nil
+		}
+
+		truncated := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if !truncated || int64(len(data)) < n || n >= maxHeaderSize {
+			return nil, fmt.Errorf("failed to decode model metadata: %w", err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package modelmanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+const gib = 1 << 30
+
+// fixtureGGUF returns a small llama GGUF with five blocks
+func fixtureGGUF(t *testing.T) []byte {
+	t.Helper()
+
+	var tensors []ggml.Tensor
+	for _, name := range []string{"blk.0.attn.weight", "blk.1.attn.weight", "blk.2.attn.weight", "blk.3.attn.weight", "blk.4.attn.weight", "output.weight"} {
+		tensors = append(tensors, ggml.Tensor{Name: name, Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))})
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "fixture.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ggml.WriteGGUF(f, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.context_length":          uint32(32),
+		"llama.embedding_length":        uint32(4096),
+		"llama.block_count":             uint32(5),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(32),
+		"tokenizer.ggml.tokens":         []string{" "},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, tensors); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// fakeHardware replaces the hardware and disk probes for one test
+func fakeHardware(t *testing.T, gpus, cpus discover.GpuInfoList, disk uint64) {
+	t.Helper()
+
+	getGPUInfo = func() discover.GpuInfoList { return gpus }
+	getCPUInfo = func() discover.GpuInfoList { return cpus }
+	freeDiskSpace = func(string) (uint64, error) { return disk, nil }
+	t.Cleanup(func() {
+		getGPUInfo = discover.GetGPUInfo
+		getCPUInfo = discover.GetCPUInfo
+		freeDiskSpace = diskFree
+	})
+}
+
+func gpuInfo(library string, free uint64) discover.GpuInfo {
+	var g discover.GpuInfo
+	g.Library = library
+	g.FreeMemory = free
+	g.TotalMemory = free
+	return g
+}
+
+func TestCheckFit(t *testing.T) {
+	t.Setenv("OLLAMA_CONTEXT_LENGTH", "2048")
+	t.Setenv("OLLAMA_KV_CACHE_TYPE", "")
+
+	blob := fixtureGGUF(t)
+	srv := newFixtureServer(t, blob, 0)
+
+	cases := []struct {
+		name      string
+		gpus      discover.GpuInfoList
+		ram       uint64
+		disk      uint64
+		verdict   Verdict
+		gpuLayers int
+	}{
+		{"gpu", discover.GpuInfoList{gpuInfo("cuda", 16*gib)}, 16 * gib, 100 * gib, FitOK, 6},
+		{"partial offload", discover.GpuInfoList{gpuInfo("cuda", 300<<20)}, 16 * gib, 100 * gib, FitOK, -1},
+		{"cpu", discover.GpuInfoList{gpuInfo("cpu", 0)}, 16 * gib, 100 * gib, FitCPUOnly, 0},
+		{"gpu too small", discover.GpuInfoList{gpuInfo("cuda", 1<<20)}, 16 * gib, 100 * gib, FitCPUOnly, 0},
+		{"ram", discover.GpuInfoList{gpuInfo("cpu", 0)}, 1 << 20, 100 * gib, FitInsufficientRAM, 0},
+		{"disk", discover.GpuInfoList{gpuInfo("cuda", 16*gib)}, 16 * gib, 1 << 10, FitInsufficientDisk, 0},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeHardware(t, tt.gpus, discover.GpuInfoList{gpuInfo("cpu", tt.ram)}, tt.disk)
+
+			mm := newTestManager(t, Model{Name: "fixture", Size: int64(len(blob)), URL: srv.URL})
+			report, err := mm.CheckFit(context.Background(), "fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Verdict != tt.verdict {
+				t.Fatalf("expected verdict %s, got %+v", tt.verdict, report)
+			}
+			if tt.verdict != FitOK && report.Reason == "" {
+				t.Fatal("expected a reason")
+			}
+			if tt.verdict == FitInsufficientDisk {
+				return
+			}
+
+			if report.Layers != 6 {
+				t.Fatalf("expected 6 layers from the GGUF header, got %d", report.Layers)
+			}
+			switch {
+			case tt.gpuLayers < 0:
+				if report.GPULayers == 0 || report.GPULayers >= report.Layers {
+					t.Fatalf("expected a partial offload, got %d of %d layers", report.GPULayers, report.Layers)
+				}
+			case report.GPULayers != tt.gpuLayers:
+				t.Fatalf("expected %d GPU layers, got %d", tt.gpuLayers, report.GPULayers)
+			}
+		})
+	}
+}
+
+func TestCheckFitWithoutMetadata(t *testing.T) {
+	fakeHardware(t, discover.GpuInfoList{gpuInfo("cpu", 0)}, discover.GpuInfoList{gpuInfo("cpu", 4*gib)}, 100*gib)
+
+	mm := newTestManager(t,
+		Model{Name: "small", Size: gib, MinRAM: 2 * gib},
+		Model{Name: "large", Size: gib, MinRAM: 8 * gib},
+	)
+
+	for name, want := range map[string]Verdict{"small": FitCPUOnly, "large": FitInsufficientRAM} {
+		report, err := mm.CheckFit(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Verdict != want || report.Layers != 0 {
+			t.Fatalf("expected %s to be %s, got %+v", name, want, report)
+		}
+	}
+
+	if _, err := mm.CheckFit(context.Background(), "missing"); !errors.Is(err, ErrModelNotFound) {
+		t.Fatalf("expected ErrModelNotFound, got %v", err)
+	}
+}
+
+func TestQueueDownloadDiskSpace(t *testing.T) {
+	fakeHardware(t, nil, nil, 150)
+
+	backend := newGateBackend()
+	mm := newQueueManager(t, t.TempDir(), backend, "a", "b")
+
+	if _, err := mm.QueueDownload("a", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// a has reserved 100 of the 150 bytes
+	_, err := mm.QueueDownload("b", 0)
+	if !errors.Is(err, ErrInsufficientDisk) {
+		t.Fatalf("expected ErrInsufficientDisk, got %v", err)
+	}
+	if status, _ := mm.GetDownloadStatus("b"); status.Status != "not_started" {
+		t.Fatalf("expected b to not be queued, got %s", status.Status)
+	}
+
+	backend.release(t, "a", nil)
+	waitForStatus(t, mm, "a", "completed")
+
+	if _, err := mm.QueueDownload("b", 0); err != nil {
+		t.Fatalf("expected b to fit once a finished, got %v", err)
+	}
+}
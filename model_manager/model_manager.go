@@ -2,11 +2,10 @@ package modelmanager
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 )
@@ -22,6 +21,14 @@ type Model struct {
 	IsDownloaded  bool     `json:"downloaded"`
 	KCRecommended bool     `json:"kc_recommended"`
 	FilePath      string   `json:"file_path,omitempty"`
+
+	// URL is the source the model file is fetched from
+	URL string `json:"url,omitempty"`
+	// Digest is the expected "sha256:<hex>" digest of the model file. Models
+	// fetched from URL are not downloaded without one.
+	Digest string `json:"digest,omitempty"`
+	// MinRAM is the memory in bytes the model needs to run
+	MinRAM int64 `json:"min_ram,omitempty"`
 }
 
 // DownloadStatus represents the current status of a model download
@@ -33,121 +40,107 @@ type DownloadStatus struct {
 	Completed  bool    `json:"completed"`
 	Downloaded int64   `json:"downloaded_bytes"`
 	TotalSize  int64   `json:"total_bytes"`
+
+	// Rate is the average transfer rate in bytes per second and ETA the
+	// estimated seconds left while downloading
+	Rate float64 `json:"bytes_per_second,omitempty"`
+	ETA  float64 `json:"eta_seconds,omitempty"`
+
+	// started and startBytes mark where this transfer began, for the rate
+	started    time.Time
+	startBytes int64
+	// published is when the status was last sent to subscribers
+	published time.Time
 }
 
+var (
+	ErrModelNotFound    = errors.New("model not found")
+	ErrNotDownloaded    = errors.New("model is not downloaded")
+	ErrNoActiveDownload = errors.New("no active download for model")
+)
+
 // ModelManager handles model discovery, downloading, and management
 type ModelManager struct {
-	modelsDir    string
-	models       []Model
-	downloads    map[string]*DownloadStatus
-	downloadLock sync.Mutex
-	cancelDownload map[string]bool
+	modelsDir string
+	backend   Backend
+
+	// mu guards state. Exported methods hand out copies, so callers never
+	// share memory with the download goroutines.
+	mu    sync.Mutex
+	state state
+
+	// running tracks download goroutines so Close can wait for them
+	running sync.WaitGroup
 }
 
-// New creates a new ModelManager instance
+// New creates a new ModelManager instance that keeps each model as a
+// single file in modelsDir
 func New(modelsDir string) (*ModelManager, error) {
+	return NewWithBackend(modelsDir, newFileBackend(modelsDir))
+}
+
+// NewWithBackend creates a new ModelManager instance that stores models
+// through backend
+func NewWithBackend(modelsDir string, backend Backend) (*ModelManager, error) {
 	// Create models directory if it doesn't exist
 	if err := os.MkdirAll(modelsDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create models directory: %w", err)
 	}
 
 	mm := &ModelManager{
-		modelsDir:      modelsDir,
-		downloads:      make(map[string]*DownloadStatus),
-		cancelDownload: make(map[string]bool),
+		modelsDir: modelsDir,
+		backend:   backend,
+		state:     newState(),
 	}
 
-	// Load initial models (in a real implementation, this would fetch from a server or local database)
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	// Start from the built-in catalog; ConfigureCatalog replaces it with a
+	// signed remote one
 	mm.loadInitialModels()
 
 	// Update download status of existing models
 	mm.refreshDownloadStatus()
 
-	return mm, nil
-}
-
-// loadInitialModels loads the initial set of models
-// In a real implementation, this would likely fetch models from a server
-func (mm *ModelManager) loadInitialModels() {
-	// These are example models - in a real implementation, we would fetch this list
-	// from a remote server or local database
-	mm.models = []Model{
-		{
-			Name:          "mistral-7b",
-			Description:   "Mistral 7B is a powerful general-purpose language model with 7.3B parameters",
-			Size:          4500000000,
-			Parameters:    7000000000,
-			Tags:          []string{"language", "general", "chat"},
-			Category:      "recommended",
-			IsDownloaded:  false,
-			KCRecommended: true,
-		},
-		{
-			Name:          "llava",
-			Description:   "LLaVA (Large Language and Vision Assistant) is a multimodal model for visual understanding",
-			Size:          4200000000,
-			Parameters:    7000000000,
-			Tags:          []string{"multimodal", "vision", "chat"},
-			Category:      "recommended",
-			IsDownloaded:  false,
-			KCRecommended: true,
-		},
-		{
-			Name:          "moondream",
-			Description:   "Moondream is optimized for vision tasks",
-			Size:          1200000000,
-			Parameters:    1500000000,
-			Tags:          []string{"vision", "efficient"},
-			Category:      "recommended",
-			IsDownloaded:  false,
-			KCRecommended: true,
-		},
-		{
-			Name:          "stablelm",
-			Description:   "StableLM is a lightweight model focused on stability for long-term deployment",
-			Size:          3200000000,
-			Parameters:    3000000000,
-			Tags:          []string{"stable", "general", "efficient"},
-			Category:      "general",
-			IsDownloaded:  false,
-			KCRecommended: false,
-		},
-		{
-			Name:          "phi-2",
-			Description:   "Phi-2 is a compact model with excellent reasoning capabilities",
-			Size:          2500000000,
-			Parameters:    2700000000,
-			Tags:          []string{"reasoning", "efficient", "small"},
-			Category:      "general",
-			IsDownloaded:  false,
-			KCRecommended: false,
-		},
+	// Resume downloads that were interrupted by a restart
+	if err := mm.loadQueue(); err != nil {
+		log.Printf("Warning: %v", err)
 	}
+	mm.schedule()
+
+	return mm, nil
 }
 
-// refreshDownloadStatus checks if the models are already downloaded
+// refreshDownloadStatus asks the backend which models are installed. Models
+// can be added or removed outside the manager, so this runs before every
+// read. It must be called with mm.mu held.
 func (mm *ModelManager) refreshDownloadStatus() {
-	for i, model := range mm.models {
-		// Check if the model file exists in the models directory
-		modelPath := filepath.Join(mm.modelsDir, model.Name+".bin")
-		_, err := os.Stat(modelPath)
-		if err == nil {
-			// Model exists, mark as downloaded
-			mm.models[i].IsDownloaded = true
-			mm.models[i].FilePath = modelPath
+	for i, model := range mm.state.models {
+		installed, location, err := mm.backend.Stat(model)
+		if err != nil {
+			log.Printf("Warning: failed to check model %s: %v", model.Name, err)
+			continue
 		}
+
+		mm.state.models[i].IsDownloaded = installed
+		mm.state.models[i].FilePath = location
 	}
 }
 
 // GetModels returns the current list of available models
 func (mm *ModelManager) GetModels() []Model {
-	return mm.models
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.refreshDownloadStatus()
+	return mm.state.snapshot()
 }
 
 // GetRecommendedModels returns only the recommended models
 func (mm *ModelManager) GetRecommendedModels() []Model {
 	var recommended []Model
-	for _, model := range mm.models {
+	for _, model := range mm.GetModels() {
 		if model.KCRecommended {
 			recommended = append(recommended, model)
 		}
@@ -158,7 +151,7 @@ func (mm *ModelManager) GetRecommendedModels() []Model {
 // GetDownloadedModels returns only the downloaded models
 func (mm *ModelManager) GetDownloadedModels() []Model {
 	var downloaded []Model
-	for _, model := range mm.models {
+	for _, model := range mm.GetModels() {
 		if model.IsDownloaded {
 			downloaded = append(downloaded, model)
 		}
@@ -166,66 +159,19 @@ func (mm *ModelManager) GetDownloadedModels() []Model {
 	return downloaded
 }
 
-// StartModelDownload begins the download process for a model
+// StartModelDownload queues a model for download at the default priority
 func (mm *ModelManager) StartModelDownload(modelName string) (*DownloadStatus, error) {
-	// Find the model
-	var modelToDownload *Model
-	for i, model := range mm.models {
-		if model.Name == modelName {
-			modelToDownload = &mm.models[i]
-			break
-		}
-	}
-
-	if modelToDownload == nil {
-		return nil, fmt.Errorf("model not found: %s", modelName)
-	}
-
-	// Check if already downloaded
-	if modelToDownload.IsDownloaded {
-		status := &DownloadStatus{
-			ModelName:  modelName,
-			Progress:   100,
-			Status:     "completed",
-			Completed:  true,
-			Downloaded: modelToDownload.Size,
-			TotalSize:  modelToDownload.Size,
-		}
-		return status, nil
-	}
-
-	// Check if already downloading
-	mm.downloadLock.Lock()
-	defer mm.downloadLock.Unlock()
-
-	existingStatus, exists := mm.downloads[modelName]
-	if exists {
-		return existingStatus, nil
-	}
-
-	// Create new download status
-	status := &DownloadStatus{
-		ModelName:  modelName,
-		Progress:   0,
-		Status:     "downloading",
-		Completed:  false,
-		Downloaded: 0,
-		TotalSize:  modelToDownload.Size,
-	}
-	mm.downloads[modelName] = status
-	mm.cancelDownload[modelName] = false
-
-	// Start download in background
-	go mm.downloadModel(modelName, modelToDownload.Size)
-
-	return status, nil
+	return mm.QueueDownload(modelName, 0)
 }
 
 // GetDownloadStatus returns the current download status for a model
 func (mm *ModelManager) GetDownloadStatus(modelName string) (*DownloadStatus, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
 	// Check if model exists
 	var modelExists bool
-	for _, model := range mm.models {
+	for _, model := range mm.state.models {
 		if model.Name == modelName {
 			modelExists = true
 			if model.IsDownloaded {
@@ -244,14 +190,11 @@ func (mm *ModelManager) GetDownloadStatus(modelName string) (*DownloadStatus, er
 	}
 
 	if !modelExists {
-		return nil, fmt.Errorf("model not found: %s", modelName)
+		return nil, fmt.Errorf("%w: %s", ErrModelNotFound, modelName)
 	}
 
 	// Check if currently downloading
-	mm.downloadLock.Lock()
-	defer mm.downloadLock.Unlock()
-
-	status, exists := mm.downloads[modelName]
+	status, exists := mm.state.downloads[modelName]
 	if !exists {
 		// Not downloaded and not in progress
 		return &DownloadStatus{
@@ -262,175 +205,100 @@ func (mm *ModelManager) GetDownloadStatus(modelName string) (*DownloadStatus, er
 		}, nil
 	}
 
-	return status, nil
+	statusCopy := *status
+	return &statusCopy, nil
 }
 
-// downloadModel simulates downloading a model
-// In a real implementation, this would use HTTP requests to download from a server
-func (mm *ModelManager) downloadModel(modelName string, totalSize int64) {
-	// Get model target path
-	modelPath := filepath.Join(mm.modelsDir, modelName+".bin")
+// CancelDownload cancels a queued, paused or active download and removes it
+// from the queue. The partial file is kept so a later StartModelDownload
+// resumes where this one stopped.
+func (mm *ModelManager) CancelDownload(modelName string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
 
-	// Create output file
-	outFile, err := os.Create(modelPath)
-	if err != nil {
-		mm.downloadLock.Lock()
-		status := mm.downloads[modelName]
-		status.Status = "failed"
-		status.Error = fmt.Sprintf("Failed to create output file: %s", err)
-		status.Completed = true
-		mm.downloadLock.Unlock()
-		return
+	entry, ok := mm.state.queue[modelName]
+	if !ok || entry.cancelled {
+		return fmt.Errorf("%w: %s", ErrNoActiveDownload, modelName)
 	}
-	defer outFile.Close()
-
-	// Simulate chunked download
-	totalChunks := 20
-	chunkSize := totalSize / int64(totalChunks)
-	chunk := make([]byte, chunkSize)
-
-	for i := 1; i <= totalChunks; i++ {
-		// Check if we should cancel
-		mm.downloadLock.Lock()
-		if mm.cancelDownload[modelName] {
-			// Cleanup the partial file
-			outFile.Close()
-			os.Remove(modelPath)
-			
-			status := mm.downloads[modelName]
-			status.Status = "cancelled"
-			status.Completed = true
-			mm.downloadLock.Unlock()
-			
-			log.Printf("Download of model %s cancelled\n", modelName)
-			return
-		}
-		mm.downloadLock.Unlock()
-
-		// Simulate network delay
-		time.Sleep(300 * time.Millisecond)
-
-		// Fill the chunk with random data
-		for j := range chunk {
-			chunk[j] = byte(j % 256)
-		}
-
-		// Write chunk to file
-		_, err := outFile.Write(chunk)
-		if err != nil {
-			mm.downloadLock.Lock()
-			status := mm.downloads[modelName]
-			status.Status = "failed"
-			status.Error = fmt.Sprintf("Failed to write chunk: %s", err)
-			status.Completed = true
-			mm.downloadLock.Unlock()
-			
-			// Cleanup the partial file
-			outFile.Close()
-			os.Remove(modelPath)
-			return
-		}
-
-		// Update progress
-		progress := float64(i) * 100 / float64(totalChunks)
-		downloaded := int64(float64(totalSize) * progress / 100)
 
-		mm.downloadLock.Lock()
-		status := mm.downloads[modelName]
-		status.Progress = progress
-		status.Downloaded = downloaded
-		mm.downloadLock.Unlock()
+	// An active download is marked cancelled once its transfer has stopped
+	if cancel, ok := mm.state.cancels[modelName]; ok {
+		entry.cancelled = true
+		mm.saveQueue()
+		cancel()
+		return nil
 	}
 
-	// Mark download as complete
-	mm.downloadLock.Lock()
+	delete(mm.state.queue, modelName)
+	mm.saveQueue()
 
-	// Update download status
-	status := mm.downloads[modelName]
-	status.Progress = 100
-	status.Status = "completed"
+	status := mm.state.downloads[modelName]
+	status.Status = "cancelled"
 	status.Completed = true
-	status.Downloaded = totalSize
-
-	// Update model status
-	for i, model := range mm.models {
-		if model.Name == modelName {
-			mm.models[i].IsDownloaded = true
-			mm.models[i].FilePath = modelPath
-			break
-		}
-	}
-
-	mm.downloadLock.Unlock()
-
-	log.Printf("Download of model %s completed\n", modelName)
+	mm.publish(status)
+	log.Printf("Download of model %s cancelled\n", modelName)
+	return nil
 }
 
-// CancelDownload cancels an in-progress download
-func (mm *ModelManager) CancelDownload(modelName string) error {
-	mm.downloadLock.Lock()
-	defer mm.downloadLock.Unlock()
-
-	if _, exists := mm.downloads[modelName]; !exists {
-		return fmt.Errorf("no active download for model: %s", modelName)
+// Close stops running downloads and waits for them to stop. Stopped
+// downloads stay in the saved queue, so they start again the next time a
+// manager is created for the same directory. Downloads queued after Close
+// are saved but not started.
+func (mm *ModelManager) Close() {
+	mm.mu.Lock()
+	mm.state.closed = true
+	for _, cancel := range mm.state.cancels {
+		cancel()
 	}
+	mm.mu.Unlock()
 
-	mm.cancelDownload[modelName] = true
-	return nil
+	mm.running.Wait()
 }
 
 // RemoveModel deletes a downloaded model
 func (mm *ModelManager) RemoveModel(modelName string) error {
-	// Check if model exists and is downloaded
-	var modelToRemove *Model
-	var modelIndex int
-	for i, model := range mm.models {
-		if model.Name == modelName {
-			if !model.IsDownloaded {
-				return fmt.Errorf("model is not downloaded: %s", modelName)
-			}
-			modelToRemove = &model
-			modelIndex = i
-			break
-		}
-	}
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
 
-	if modelToRemove == nil {
-		return fmt.Errorf("model not found: %s", modelName)
-	}
+	mm.refreshDownloadStatus()
 
-	// Get model file path
-	modelPath := filepath.Join(mm.modelsDir, modelName+".bin")
-	if modelToRemove.FilePath != "" {
-		modelPath = modelToRemove.FilePath
+	model := mm.findModel(modelName)
+	if model == nil {
+		return fmt.Errorf("%w: %s", ErrModelNotFound, modelName)
+	}
+	if !model.IsDownloaded {
+		return fmt.Errorf("%w: %s", ErrNotDownloaded, modelName)
 	}
 
-	// Delete the file
-	if err := os.Remove(modelPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete model file: %w", err)
+	if err := mm.backend.Remove(*model); err != nil {
+		return err
 	}
 
 	// Update model status
-	mm.models[modelIndex].IsDownloaded = false
-	mm.models[modelIndex].FilePath = ""
+	model.IsDownloaded = false
+	model.FilePath = ""
 
-	// Remove from downloads if present
-	mm.downloadLock.Lock()
-	delete(mm.downloads, modelName)
-	delete(mm.cancelDownload, modelName)
-	mm.downloadLock.Unlock()
+	// Forget the finished download, if any
+	if _, queued := mm.state.queue[modelName]; !queued {
+		delete(mm.state.downloads, modelName)
+	}
 
 	return nil
 }
 
-// SaveModels saves the current models state to a file
+// SaveModels saves the current models state to a file. The file is replaced
+// atomically, so a concurrent LoadModels never reads a partial file.
 func (mm *ModelManager) SaveModels(filename string) error {
-	data, err := json.MarshalIndent(mm.models, "", "  ")
+	mm.mu.Lock()
+	models := mm.state.snapshot()
+	mm.mu.Unlock()
+
+	data, err := json.MarshalIndent(models, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal models: %w", err)
 	}
 
-	if err := os.WriteFile(filename, data, 0644); err != nil {
+	if err := writeFileAtomic(filename, data); err != nil {
 		return fmt.Errorf("failed to write models to file: %w", err)
 	}
 
@@ -453,7 +321,13 @@ func (mm *ModelManager) LoadModels(filename string) error {
 		return fmt.Errorf("failed to parse models file: %w", err)
 	}
 
-	mm.models = models
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.state.models = models
 	mm.refreshDownloadStatus()
+
+	// Queued downloads may be waiting for a model this list adds
+	mm.schedule()
 	return nil
-}
\ No newline at end of file
+}
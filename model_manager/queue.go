@@ -0,0 +1,306 @@
+package modelmanager
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// queueFile is the name of the persisted download queue in modelsDir
+	queueFile = "download_queue.json"
+
+	// DefaultMaxDownloads is the number of downloads that run at once
+	// unless SetMaxConcurrentDownloads is called
+	DefaultMaxDownloads = 2
+)
+
+// queueEntry is a download that has not finished. Entries are persisted so
+// downloads interrupted by a restart start again.
+type queueEntry struct {
+	Model    string    `json:"model"`
+	Priority int       `json:"priority"`
+	Paused   bool      `json:"paused,omitempty"`
+	Added    time.Time `json:"added"`
+
+	// cancelled is set while an active download is being cancelled
+	cancelled bool
+}
+
+// QueueDownload adds a model to the download queue. Downloads with a higher
+// priority start first, and downloads with the same priority start in the
+// order they were queued. Queueing a paused download resumes it.
+func (mm *ModelManager) QueueDownload(modelName string, priority int) (*DownloadStatus, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.refreshDownloadStatus()
+
+	model := mm.findModel(modelName)
+	if model == nil {
+		return nil, fmt.Errorf("%w: %s", ErrModelNotFound, modelName)
+	}
+
+	// Check if already downloaded
+	if model.IsDownloaded {
+		return &DownloadStatus{
+			ModelName:  modelName,
+			Progress:   100,
+			Status:     "completed",
+			Completed:  true,
+			Downloaded: model.Size,
+			TotalSize:  model.Size,
+		}, nil
+	}
+
+	// Check if already queued. Finished downloads (failed or cancelled) have
+	// left the queue, so they are queued again and resume from their partial
+	// file.
+	if entry, ok := mm.state.queue[modelName]; ok {
+		if entry.Paused {
+			mm.resume(entry)
+		}
+
+		statusCopy := *mm.state.downloads[modelName]
+		return &statusCopy, nil
+	}
+
+	// Memory is only a warning, reported by CheckFit, but a download that
+	// cannot fit on disk would fail part way through
+	if err := mm.checkDisk(*model, &FitReport{}); err != nil {
+		return nil, err
+	}
+
+	status := &DownloadStatus{
+		ModelName: modelName,
+		Status:    "queued",
+		TotalSize: model.Size,
+	}
+	mm.state.downloads[modelName] = status
+	mm.state.queue[modelName] = &queueEntry{Model: modelName, Priority: priority, Added: time.Now()}
+	mm.publish(status)
+
+	mm.saveQueue()
+	mm.schedule()
+
+	// Return a copy so callers don't race with the download goroutine
+	statusCopy := *status
+	return &statusCopy, nil
+}
+
+// SetDownloadPriority changes the priority of a queued download. It does not
+// interrupt downloads that have already started.
+func (mm *ModelManager) SetDownloadPriority(modelName string, priority int) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	entry, ok := mm.state.queue[modelName]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoActiveDownload, modelName)
+	}
+
+	entry.Priority = priority
+	mm.saveQueue()
+	return nil
+}
+
+// PauseDownload stops a download but keeps it in the queue and keeps its
+// partial file. ResumeDownload or QueueDownload continue it.
+func (mm *ModelManager) PauseDownload(modelName string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	entry, ok := mm.state.queue[modelName]
+	if !ok || entry.cancelled {
+		return fmt.Errorf("%w: %s", ErrNoActiveDownload, modelName)
+	}
+	if entry.Paused {
+		return nil
+	}
+
+	entry.Paused = true
+	mm.saveQueue()
+
+	// An active download is marked paused once its transfer has stopped
+	if cancel, ok := mm.state.cancels[modelName]; ok {
+		cancel()
+		return nil
+	}
+
+	status := mm.state.downloads[modelName]
+	status.Status = "paused"
+	mm.publish(status)
+	return nil
+}
+
+// ResumeDownload queues a paused download again
+func (mm *ModelManager) ResumeDownload(modelName string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	entry, ok := mm.state.queue[modelName]
+	if !ok || entry.cancelled {
+		return fmt.Errorf("%w: %s", ErrNoActiveDownload, modelName)
+	}
+
+	if entry.Paused {
+		mm.resume(entry)
+	}
+	return nil
+}
+
+// resume clears the paused flag of an entry and schedules it. It must be
+// called with mm.mu held.
+func (mm *ModelManager) resume(entry *queueEntry) {
+	entry.Paused = false
+
+	// A download that is still stopping is queued again once it has stopped
+	if _, active := mm.state.cancels[entry.Model]; !active {
+		status := mm.state.downloads[entry.Model]
+		status.Status = "queued"
+		mm.publish(status)
+	}
+
+	mm.saveQueue()
+	mm.schedule()
+}
+
+// SetMaxConcurrentDownloads limits how many downloads run at once. Lowering
+// the limit does not interrupt downloads that have already started.
+func (mm *ModelManager) SetMaxConcurrentDownloads(n int) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.state.maxDownloads = max(n, 1)
+	mm.schedule()
+}
+
+// schedule starts queued downloads until maxDownloads are running. Entries
+// for models missing from the catalog wait until a catalog that has them is
+// loaded. It must be called with mm.mu held.
+func (mm *ModelManager) schedule() {
+	if mm.state.closed {
+		return
+	}
+
+	var pending []*queueEntry
+	for _, entry := range mm.state.queue {
+		if _, active := mm.state.cancels[entry.Model]; !active && !entry.Paused {
+			pending = append(pending, entry)
+		}
+	}
+
+	slices.SortFunc(pending, func(a, b *queueEntry) int {
+		return cmp.Or(
+			cmp.Compare(b.Priority, a.Priority),
+			a.Added.Compare(b.Added),
+			cmp.Compare(a.Model, b.Model),
+		)
+	})
+
+	var changed bool
+	for _, entry := range pending {
+		if len(mm.state.cancels) >= mm.state.maxDownloads {
+			break
+		}
+
+		model := mm.findModel(entry.Model)
+		if model == nil {
+			continue
+		}
+
+		status := mm.state.downloads[entry.Model]
+		if model.IsDownloaded {
+			// Installed while it was queued, for example before a restart
+			delete(mm.state.queue, entry.Model)
+			changed = true
+
+			status.Status = "completed"
+			status.Completed = true
+			status.Progress = 100
+			mm.publish(status)
+			continue
+		}
+
+		status.Status = "downloading"
+		status.Error = ""
+		status.Rate, status.ETA = 0, 0
+		status.started = time.Time{}
+		mm.publish(status)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		mm.state.cancels[entry.Model] = cancel
+
+		mm.running.Add(1)
+		go mm.downloadModel(ctx, *model)
+	}
+
+	if changed {
+		mm.saveQueue()
+	}
+}
+
+func (mm *ModelManager) queuePath() string {
+	return filepath.Join(mm.modelsDir, queueFile)
+}
+
+// saveQueue persists the queue. It must be called with mm.mu held.
+func (mm *ModelManager) saveQueue() {
+	entries := make([]*queueEntry, 0, len(mm.state.queue))
+	for _, entry := range mm.state.queue {
+		if !entry.cancelled {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b *queueEntry) int {
+		return a.Added.Compare(b.Added)
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		log.Printf("Warning: failed to marshal download queue: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(mm.queuePath(), data); err != nil {
+		log.Printf("Warning: failed to save download queue: %v", err)
+	}
+}
+
+// loadQueue restores the queue saved by a previous run. Downloads that were
+// running are queued again.
+func (mm *ModelManager) loadQueue() error {
+	data, err := os.ReadFile(mm.queuePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read download queue: %w", err)
+	}
+
+	var entries []*queueEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse download queue: %w", err)
+	}
+
+	for _, entry := range entries {
+		status := &DownloadStatus{ModelName: entry.Model, Status: "queued"}
+		if entry.Paused {
+			status.Status = "paused"
+		}
+		if model := mm.findModel(entry.Model); model != nil {
+			status.TotalSize = model.Size
+		}
+
+		mm.state.queue[entry.Model] = entry
+		mm.state.downloads[entry.Model] = status
+	}
+
+	return nil
+}
@@ -0,0 +1,402 @@
+package modelmanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gateBackend is a Backend whose downloads block until they are released
+type gateBackend struct {
+	mu        sync.Mutex
+	installed map[string]bool
+	started   []string
+	active    int
+	maxActive int
+	gates     map[string]chan error
+	pulls     map[string]chan struct{}
+}
+
+func newGateBackend() *gateBackend {
+	return &gateBackend{installed: map[string]bool{}, gates: map[string]chan error{}, pulls: map[string]chan struct{}{}}
+}
+
+func (b *gateBackend) gate(name string) chan error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.gates[name]; !ok {
+		b.gates[name] = make(chan error)
+	}
+	return b.gates[name]
+}
+
+// entered is signalled each time a download of name calls Pull
+func (b *gateBackend) entered(name string) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.pulls[name]; !ok {
+		b.pulls[name] = make(chan struct{}, 8)
+	}
+	return b.pulls[name]
+}
+
+// waitForPull waits until a download of name is running in Pull. The
+// download status changes before Pull is called, so waiting for it is not
+// enough to know the download is active.
+func (b *gateBackend) waitForPull(t *testing.T, name string) {
+	t.Helper()
+
+	select {
+	case <-b.entered(name):
+	case <-time.After(5 * time.Second):
+		t.Fatalf("download of %s did not start", name)
+	}
+}
+
+// release lets the download of name finish with err
+func (b *gateBackend) release(t *testing.T, name string, err error) {
+	t.Helper()
+
+	select {
+	case b.gate(name) <- err:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("download of %s was not running", name)
+	}
+}
+
+func (b *gateBackend) Started() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.started)
+}
+
+func (b *gateBackend) MaxActive() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.maxActive
+}
+
+func (b *gateBackend) Stat(model Model) (bool, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.installed[model.Name], "", nil
+}
+
+func (b *gateBackend) Pull(ctx context.Context, model Model, fn func(Progress)) error {
+	b.mu.Lock()
+	b.started = append(b.started, model.Name)
+	b.active++
+	b.maxActive = max(b.maxActive, b.active)
+	b.mu.Unlock()
+
+	b.entered(model.Name) <- struct{}{}
+
+	defer func() {
+		b.mu.Lock()
+		b.active--
+		b.mu.Unlock()
+	}()
+
+	select {
+	case err := <-b.gate(model.Name):
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.installed[model.Name] = true
+	return nil
+}
+
+func (b *gateBackend) Remove(model Model) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.installed, model.Name)
+	return nil
+}
+
+// newQueueManager returns a manager for dir with a catalog of names
+func newQueueManager(t *testing.T, dir string, backend Backend, names ...string) *ModelManager {
+	t.Helper()
+
+	mm, err := NewWithBackend(dir, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stop the downloads before dir is removed, since they write to it
+	// until they return
+	t.Cleanup(mm.Close)
+
+	var models []Model
+	for _, name := range names {
+		models = append(models, Model{Name: name, Size: 100})
+	}
+
+	mm.mu.Lock()
+	mm.state.models = models
+	mm.refreshDownloadStatus()
+	mm.schedule()
+	mm.mu.Unlock()
+	return mm
+}
+
+func waitForStatus(t *testing.T, mm *ModelManager, name, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := mm.GetDownloadStatus(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to be %s, got %s", name, want, status.Status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	backend := newGateBackend()
+	mm := newQueueManager(t, t.TempDir(), backend, "a", "b", "c", "d")
+	mm.SetMaxConcurrentDownloads(1)
+
+	for _, q := range []struct {
+		name     string
+		priority int
+	}{{"a", 0}, {"b", 0}, {"c", 5}, {"d", -1}} {
+		if _, err := mm.QueueDownload(q.name, q.priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitForStatus(t, mm, "a", "downloading")
+	for _, name := range []string{"b", "c", "d"} {
+		waitForStatus(t, mm, name, "queued")
+	}
+
+	if err := mm.SetDownloadPriority("d", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "d", "c", "b"} {
+		backend.waitForPull(t, name)
+		backend.release(t, name, nil)
+		waitForStatus(t, mm, name, "completed")
+	}
+
+	if want := []string{"a", "d", "c", "b"}; !slices.Equal(backend.Started(), want) {
+		t.Fatalf("expected downloads to start in order %v, got %v", want, backend.Started())
+	}
+	if n := backend.MaxActive(); n != 1 {
+		t.Fatalf("expected at most 1 concurrent download, got %d", n)
+	}
+}
+
+func TestQueueConcurrency(t *testing.T) {
+	backend := newGateBackend()
+	mm := newQueueManager(t, t.TempDir(), backend, "a", "b", "c")
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := mm.StartModelDownload(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitForStatus(t, mm, "a", "downloading")
+	waitForStatus(t, mm, "b", "downloading")
+	waitForStatus(t, mm, "c", "queued")
+	backend.waitForPull(t, "a")
+	backend.waitForPull(t, "b")
+
+	backend.release(t, "b", nil)
+	waitForStatus(t, mm, "c", "downloading")
+	backend.waitForPull(t, "c")
+
+	backend.release(t, "a", nil)
+	backend.release(t, "c", nil)
+	waitForStatus(t, mm, "c", "completed")
+
+	if n := backend.MaxActive(); n != DefaultMaxDownloads {
+		t.Fatalf("expected %d concurrent downloads, got %d", DefaultMaxDownloads, n)
+	}
+}
+
+func TestQueuePauseResume(t *testing.T) {
+	backend := newGateBackend()
+	mm := newQueueManager(t, t.TempDir(), backend, "a", "b")
+	mm.SetMaxConcurrentDownloads(1)
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := mm.StartModelDownload(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitForStatus(t, mm, "a", "downloading")
+
+	// Pausing the active download lets the next one start
+	if err := mm.PauseDownload("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, mm, "a", "paused")
+	waitForStatus(t, mm, "b", "downloading")
+
+	if err := mm.ResumeDownload("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, mm, "a", "queued")
+
+	// Pausing a queued download
+	if err := mm.PauseDownload("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, mm, "a", "paused")
+
+	backend.release(t, "b", nil)
+	waitForStatus(t, mm, "b", "completed")
+	waitForStatus(t, mm, "a", "paused")
+
+	// Queueing a paused download resumes it
+	if _, err := mm.StartModelDownload("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, mm, "a", "downloading")
+
+	backend.release(t, "a", nil)
+	waitForStatus(t, mm, "a", "completed")
+
+	if err := mm.PauseDownload("a"); err == nil {
+		t.Fatal("expected error pausing a finished download")
+	}
+	if err := mm.ResumeDownload("a"); err == nil {
+		t.Fatal("expected error resuming a finished download")
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	backend := newGateBackend()
+	mm := newQueueManager(t, t.TempDir(), backend, "a", "b")
+	mm.SetMaxConcurrentDownloads(1)
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := mm.StartModelDownload(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitForStatus(t, mm, "a", "downloading")
+	backend.waitForPull(t, "a")
+
+	if err := mm.CancelDownload("b"); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, mm, "b", "cancelled")
+
+	if err := mm.CancelDownload("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, mm, "a", "cancelled")
+
+	if err := mm.CancelDownload("a"); err == nil {
+		t.Fatal("expected error cancelling a cancelled download")
+	}
+	if started := backend.Started(); !slices.Equal(started, []string{"a"}) {
+		t.Fatalf("expected only a to start, got %v", started)
+	}
+}
+
+func TestQueueRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	backend := newGateBackend()
+	mm := newQueueManager(t, dir, backend, "a", "b", "c")
+	mm.SetMaxConcurrentDownloads(1)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := mm.QueueDownload(name, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mm.PauseDownload("c"); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, mm, "a", "downloading")
+
+	// Start a second manager from a copy of the queue, as if the first
+	// one had been killed mid-download
+	restarted := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(dir, queueFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(restarted, queueFile), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	backend2 := newGateBackend()
+	mm2 := newQueueManager(t, restarted, backend2, "a", "b", "c")
+
+	waitForStatus(t, mm2, "a", "downloading")
+	waitForStatus(t, mm2, "b", "downloading")
+	waitForStatus(t, mm2, "c", "paused")
+	backend2.waitForPull(t, "a")
+	backend2.waitForPull(t, "b")
+
+	backend2.release(t, "a", nil)
+	backend2.release(t, "b", nil)
+	waitForStatus(t, mm2, "b", "completed")
+
+	if err := mm.CancelDownload("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, mm, "a", "cancelled")
+}
+
+func TestQueueClose(t *testing.T) {
+	dir := t.TempDir()
+
+	backend := newGateBackend()
+	mm := newQueueManager(t, dir, backend, "a", "b")
+	mm.SetMaxConcurrentDownloads(1)
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := mm.StartModelDownload(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForStatus(t, mm, "a", "downloading")
+	backend.waitForPull(t, "a")
+
+	mm.Close()
+
+	// The stopped download goes back to the queue instead of letting the
+	// next one start
+	waitForStatus(t, mm, "a", "queued")
+	waitForStatus(t, mm, "b", "queued")
+	if started := backend.Started(); !slices.Equal(started, []string{"a"}) {
+		t.Fatalf("expected only a to start, got %v", started)
+	}
+
+	mm2 := newQueueManager(t, dir, backend, "a", "b")
+	waitForStatus(t, mm2, "a", "downloading")
+	backend.waitForPull(t, "a")
+	backend.release(t, "a", nil)
+	waitForStatus(t, mm2, "b", "downloading")
+	backend.waitForPull(t, "b")
+	backend.release(t, "b", nil)
+	waitForStatus(t, mm2, "b", "completed")
+}
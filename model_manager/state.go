@@ -0,0 +1,84 @@
+package modelmanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// state is everything a ModelManager mutates after it is created. It is
+// only read or written with ModelManager.mu held.
+type state struct {
+	// models is the catalog, with the installed state of each model
+	models []Model
+	// downloads holds the status of every queued or finished download
+	downloads map[string]*DownloadStatus
+	// cancels stops each running download
+	cancels map[string]context.CancelFunc
+	// queue holds the downloads that have not finished
+	queue        map[string]*queueEntry
+	maxDownloads int
+	// closed stops new downloads from starting
+	closed bool
+
+	subscribers map[*subscriber]struct{}
+	catalog     *CatalogConfig
+	// catalogUpdatedAt is when the loaded catalog, or the cached one if it
+	// is newer, was updated. Older catalogs are rejected.
+	catalogUpdatedAt time.Time
+}
+
+func newState() state {
+	return state{
+		downloads:    make(map[string]*DownloadStatus),
+		cancels:      make(map[string]context.CancelFunc),
+		queue:        make(map[string]*queueEntry),
+		maxDownloads: DefaultMaxDownloads,
+		subscribers:  make(map[*subscriber]struct{}),
+	}
+}
+
+// snapshot returns a deep copy of the models
+func (s *state) snapshot() []Model {
+	models := make([]Model, len(s.models))
+	for i, model := range s.models {
+		model.Tags = slices.Clone(model.Tags)
+		models[i] = model
+	}
+	return models
+}
+
+// findModel returns the catalog entry for name. It must be called with
+// mm.mu held.
+func (mm *ModelManager) findModel(name string) *Model {
+	for i := range mm.state.models {
+		if mm.state.models[i].Name == name {
+			return &mm.state.models[i]
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces name with data so readers never see a partial
+// file
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
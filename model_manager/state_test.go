@@ -0,0 +1,204 @@
+package modelmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyBackend is a Backend whose downloads take a moment and sometimes fail
+type flakyBackend struct {
+	mu        sync.Mutex
+	installed map[string]bool
+}
+
+func (b *flakyBackend) Stat(model Model) (bool, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.installed[model.Name], "", nil
+}
+
+func (b *flakyBackend) Pull(ctx context.Context, model Model, fn func(Progress)) error {
+	for i := range 5 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(rand.IntN(500)) * time.Microsecond):
+		}
+		fn(Progress{Status: "downloading", Completed: int64(i+1) * 20, Total: 100})
+	}
+
+	if rand.IntN(4) == 0 {
+		return errors.New("connection reset")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.installed[model.Name] = true
+	return nil
+}
+
+func (b *flakyBackend) Remove(model Model) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.installed, model.Name)
+	return nil
+}
+
+func TestGetModelsReturnsCopy(t *testing.T) {
+	mm := newTestManager(t, Model{Name: "a", Tags: []string{"chat"}})
+
+	models := mm.GetModels()
+	models[0].Name = "b"
+	models[0].Tags[0] = "code"
+
+	got := mm.GetModels()
+	if got[0].Name != "a" || got[0].Tags[0] != "chat" {
+		t.Fatalf("expected the manager's models to be unchanged, got %+v", got[0])
+	}
+}
+
+func TestSaveModelsAtomic(t *testing.T) {
+	mm := newTestManager(t, Model{Name: "a", Size: 1}, Model{Name: "b", Size: 2})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "models.json")
+	if err := mm.SaveModels(path); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the models file, got %v", entries)
+	}
+
+	mm2 := newTestManager(t)
+	if err := mm2.LoadModels(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := mm2.GetModels(); len(got) != 2 || got[1].Name != "b" || got[1].Size != 2 {
+		t.Fatalf("unexpected models after load: %+v", got)
+	}
+}
+
+// TestConcurrentOperations runs every operation from many goroutines at
+// once. It is meant to be run with -race.
+func TestConcurrentOperations(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"a", "b", "c", "d", "e"}
+
+	backend := &flakyBackend{installed: map[string]bool{}}
+	mm := newQueueManager(t, dir, backend, names...)
+
+	modelsFile := filepath.Join(dir, "models.json")
+	if err := mm.SaveModels(modelsFile); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A subscriber that reads every event, and one that never reads
+	events := mm.Subscribe(ctx)
+	mm.Subscribe(ctx)
+	go func() {
+		for range events {
+		}
+	}()
+
+	ops := []func(name string) error{
+		func(name string) error { _, err := mm.StartModelDownload(name); return err },
+		func(name string) error { _, err := mm.QueueDownload(name, rand.IntN(3)); return err },
+		func(name string) error { return mm.CancelDownload(name) },
+		func(name string) error { return mm.PauseDownload(name) },
+		func(name string) error { return mm.ResumeDownload(name) },
+		func(name string) error { return mm.SetDownloadPriority(name, rand.IntN(3)) },
+		func(name string) error { return mm.RemoveModel(name) },
+		func(name string) error { _, err := mm.GetDownloadStatus(name); return err },
+		func(string) error { return mm.SaveModels(modelsFile) },
+		func(string) error { return mm.LoadModels(modelsFile) },
+		func(string) error { mm.GetModels(); return nil },
+		func(string) error { mm.SetMaxConcurrentDownloads(1 + rand.IntN(3)); return nil },
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 300 {
+				name := names[rand.IntN(len(names))]
+				err := ops[rand.IntN(len(ops))](name)
+				if err != nil && !errors.Is(err, ErrNotDownloaded) && !errors.Is(err, ErrNoActiveDownload) {
+					errs <- fmt.Errorf("%s: %w", name, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Stop whatever is left in the queue and wait for it to drain
+	for _, name := range names {
+		if err := mm.CancelDownload(name); err != nil && !errors.Is(err, ErrNoActiveDownload) {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mm.mu.Lock()
+		active, queued := len(mm.state.cancels), len(mm.state.queue)
+		mm.mu.Unlock()
+
+		if active == 0 && queued == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the queue to drain, got %d active and %d queued", active, queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, name := range names {
+		status, err := mm.GetDownloadStatus(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.Completed && status.Status != "not_started" {
+			t.Errorf("expected %s to be finished, got %+v", name, status)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, queueFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []queueEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected the saved queue to be empty, got %+v", entries)
+	}
+
+	if err := mm.LoadModels(modelsFile); err != nil {
+		t.Fatal(err)
+	}
+}
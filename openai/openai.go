@@ -40,21 +40,48 @@ type Message struct {
 }
 
 type Choice struct {
-	Index        int     `json:"index"`
-	Message      Message `json:"message"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Message      Message         `json:"message"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs"`
+	FinishReason *string         `json:"finish_reason"`
 }
 
 type ChunkChoice struct {
-	Index        int     `json:"index"`
-	Delta        Message `json:"delta"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Delta        Message         `json:"delta"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs"`
+	FinishReason *string         `json:"finish_reason"`
 }
 
 type CompleteChunkChoice struct {
-	Text         string  `json:"text"`
-	Index        int     `json:"index"`
-	FinishReason *string `json:"finish_reason"`
+	Text         string              `json:"text"`
+	Index        int                 `json:"index"`
+	Logprobs     *CompletionLogprobs `json:"logprobs"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+// ChoiceLogprobs holds the log probabilities of a chat completion choice
+type ChoiceLogprobs struct {
+	Content []ChatLogprob `json:"content"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+type ChatLogprob struct {
+	TopLogprob
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+// CompletionLogprobs holds the log probabilities of a legacy completion
+// choice, with one entry per token in each list
+type CompletionLogprobs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogprobs []float64            `json:"token_logprobs"`
+	TopLogprobs   []map[string]float64 `json:"top_logprobs"`
 }
 
 type Usage struct {
@@ -82,19 +109,23 @@ type StreamOptions struct {
 }
 
 type ChatCompletionRequest struct {
-	Model            string          `json:"model"`
-	Messages         []Message       `json:"messages"`
-	Stream           bool            `json:"stream"`
-	StreamOptions    *StreamOptions  `json:"stream_options"`
-	MaxTokens        *int            `json:"max_tokens"`
-	Seed             *int            `json:"seed"`
-	Stop             any             `json:"stop"`
-	Temperature      *float64        `json:"temperature"`
-	FrequencyPenalty *float64        `json:"frequency_penalty"`
-	PresencePenalty  *float64        `json:"presence_penalty"`
-	TopP             *float64        `json:"top_p"`
-	ResponseFormat   *ResponseFormat `json:"response_format"`
-	Tools            []api.Tool      `json:"tools"`
+	Model             string          `json:"model"`
+	Messages          []Message       `json:"messages"`
+	Stream            bool            `json:"stream"`
+	StreamOptions     *StreamOptions  `json:"stream_options"`
+	MaxTokens         *int            `json:"max_tokens"`
+	Seed              *int            `json:"seed"`
+	Stop              any             `json:"stop"`
+	Temperature       *float64        `json:"temperature"`
+	FrequencyPenalty  *float64        `json:"frequency_penalty"`
+	PresencePenalty   *float64        `json:"presence_penalty"`
+	TopP              *float64        `json:"top_p"`
+	ResponseFormat    *ResponseFormat `json:"response_format"`
+	Tools             []api.Tool      `json:"tools"`
+	ToolChoice        *api.ToolChoice `json:"tool_choice"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls"`
+	Logprobs          bool            `json:"logprobs"`
+	TopLogprobs       int             `json:"top_logprobs"`
 }
 
 type ChatCompletion struct {
@@ -131,6 +162,7 @@ type CompletionRequest struct {
 	Temperature      *float32       `json:"temperature"`
 	TopP             float32        `json:"top_p"`
 	Suffix           string         `json:"suffix"`
+	Logprobs         *int           `json:"logprobs"`
 }
 
 type Completion struct {
@@ -198,6 +230,10 @@ func NewError(code int, message string) ErrorResponse {
 	switch code {
 	case http.StatusBadRequest:
 		etype = "invalid_request_error"
+	case http.StatusUnauthorized:
+		etype = "authentication_error"
+	case http.StatusForbidden:
+		etype = "permission_error"
 	case http.StatusNotFound:
 		etype = "not_found_error"
 	default:
@@ -207,6 +243,13 @@ func NewError(code int, message string) ErrorResponse {
 	return ErrorResponse{Error{Type: etype, Message: message}}
 }
 
+// NewRateLimitError returns the error of a request over its limit of
+// requests or tokens
+func NewRateLimitError(limit, message string) ErrorResponse {
+	code := "rate_limit_exceeded"
+	return ErrorResponse{Error{Type: limit, Message: message, Code: &code}}
+}
+
 func toUsage(r api.ChatResponse) Usage {
 	return Usage{
 		PromptTokens:     r.PromptEvalCount,
@@ -243,6 +286,52 @@ func toToolCalls(tc []api.ToolCall) []ToolCall {
 	return toolCalls
 }
 
+// tokenBytes returns the UTF-8 bytes of a token as OpenAI reports them
+func tokenBytes(token string) []int {
+	b := make([]int, len(token))
+	for i := range len(token) {
+		b[i] = int(token[i])
+	}
+	return b
+}
+
+func toChoiceLogprobs(lps []api.Logprob) *ChoiceLogprobs {
+	if len(lps) == 0 {
+		return nil
+	}
+
+	content := make([]ChatLogprob, len(lps))
+	for i, lp := range lps {
+		content[i].TopLogprob = TopLogprob{Token: lp.Token, Logprob: lp.Logprob, Bytes: tokenBytes(lp.Token)}
+		content[i].TopLogprobs = make([]TopLogprob, len(lp.TopLogprobs))
+		for j, top := range lp.TopLogprobs {
+			content[i].TopLogprobs[j] = TopLogprob{Token: top.Token, Logprob: top.Logprob, Bytes: tokenBytes(top.Token)}
+		}
+	}
+
+	return &ChoiceLogprobs{Content: content}
+}
+
+func toCompletionLogprobs(lps []api.Logprob) *CompletionLogprobs {
+	if len(lps) == 0 {
+		return nil
+	}
+
+	var c CompletionLogprobs
+	for _, lp := range lps {
+		c.Tokens = append(c.Tokens, lp.Token)
+		c.TokenLogprobs = append(c.TokenLogprobs, lp.Logprob)
+
+		top := make(map[string]float64, len(lp.TopLogprobs))
+		for _, t := range lp.TopLogprobs {
+			top[t.Token] = t.Logprob
+		}
+		c.TopLogprobs = append(c.TopLogprobs, top)
+	}
+
+	return &c
+}
+
 func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 	toolCalls := toToolCalls(r.Message.ToolCalls)
 	return ChatCompletion{
@@ -252,8 +341,9 @@ func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []Choice{{
-			Index:   0,
-			Message: Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
+			Index:    0,
+			Message:  Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
+			Logprobs: toChoiceLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(toolCalls) > 0 {
 					reason = "tool_calls"
@@ -277,11 +367,13 @@ func toChunk(id string, r api.ChatResponse, toolCallSent bool) ChatCompletionChu
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []ChunkChoice{{
-			Index: 0,
-			Delta: Message{Role: "assistant", Content: r.Message.Content, ToolCalls: toolCalls},
+			Index:    0,
+			Delta:    Message{Role: "assistant", Content: r.Message.Content, ToolCalls: toolCalls},
+			Logprobs: toChoiceLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
-					if toolCallSent {
+					// the last chunk may hold the only tool calls
+					if toolCallSent || len(toolCalls) > 0 {
 						return &finishReasonToolCalls
 					}
 					return &reason
@@ -308,8 +400,9 @@ func toCompletion(id string, r api.GenerateResponse) Completion {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
+			Text:     r.Response,
+			Index:    0,
+			Logprobs: toCompletionLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -329,8 +422,9 @@ func toCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
+			Text:     r.Response,
+			Index:    0,
+			Logprobs: toCompletionLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -521,13 +615,23 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 		}
 	}
 
+	// without parallel tool calls, the model calls at most one tool a turn
+	var maxToolCalls int
+	if r.ParallelToolCalls != nil && !*r.ParallelToolCalls {
+		maxToolCalls = 1
+	}
+
 	return &api.ChatRequest{
-		Model:    r.Model,
-		Messages: messages,
-		Format:   format,
-		Options:  options,
-		Stream:   &r.Stream,
-		Tools:    r.Tools,
+		Model:        r.Model,
+		Messages:     messages,
+		Format:       format,
+		Options:      options,
+		Stream:       &r.Stream,
+		Tools:        r.Tools,
+		ToolChoice:   r.ToolChoice,
+		MaxToolCalls: maxToolCalls,
+		Logprobs:     r.Logprobs,
+		TopLogprobs:  r.TopLogprobs,
 	}, nil
 }
 
@@ -573,13 +677,21 @@ func fromCompleteRequest(r CompletionRequest) (api.GenerateRequest, error) {
 		options["top_p"] = 1.0
 	}
 
-	return api.GenerateRequest{
+	req := api.GenerateRequest{
 		Model:   r.Model,
 		Prompt:  r.Prompt,
 		Options: options,
 		Stream:  &r.Stream,
 		Suffix:  r.Suffix,
-	}, nil
+	}
+
+	// The legacy API asks for logprobs with the number of alternatives
+	if r.Logprobs != nil {
+		req.Logprobs = true
+		req.TopLogprobs = *r.Logprobs
+	}
+
+	return req, nil
 }
 
 type BaseWriter struct {
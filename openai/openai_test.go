@@ -112,6 +112,33 @@ func TestChatMiddleware(t *testing.T) {
 				Stream: &True,
 			},
 		},
+		{
+			name: "chat handler with logprobs",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				],
+				"logprobs": true,
+				"top_logprobs": 3
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "Hello",
+					},
+				},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream:      &False,
+				Logprobs:    true,
+				TopLogprobs: 3,
+			},
+		},
 		{
 			name: "chat handler with streaming usage",
 			body: `{
@@ -319,6 +346,33 @@ func TestChatMiddleware(t *testing.T) {
 				Stream: &True,
 			},
 		},
+		{
+			name: "chat handler with tool choice",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "What's the weather like in Paris?"}
+				],
+				"tool_choice": {"type": "function", "function": {"name": "get_weather"}},
+				"parallel_tool_calls": false
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "What's the weather like in Paris?",
+					},
+				},
+				ToolChoice:   &api.ToolChoice{Mode: "function", Name: "get_weather"},
+				MaxToolCalls: 1,
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream: &False,
+			},
+		},
 		{
 			name: "chat handler error forwarding",
 			body: `{
@@ -406,6 +460,27 @@ func TestCompletionsMiddleware(t *testing.T) {
 				Stream: &False,
 			},
 		},
+		{
+			name: "completions handler with logprobs",
+			body: `{
+				"model": "test-model",
+				"prompt": "Hello",
+				"logprobs": 2
+			}`,
+			req: api.GenerateRequest{
+				Model:  "test-model",
+				Prompt: "Hello",
+				Options: map[string]any{
+					"frequency_penalty": 0.0,
+					"presence_penalty":  0.0,
+					"temperature":       1.0,
+					"top_p":             1.0,
+				},
+				Stream:      &False,
+				Logprobs:    true,
+				TopLogprobs: 2,
+			},
+		},
 		{
 			name: "completions handler stream",
 			body: `{
@@ -731,3 +806,133 @@ func TestRetrieveMiddleware(t *testing.T) {
 		}
 	}
 }
+
+func TestLogprobsResponses(t *testing.T) {
+	lps := []api.Logprob{
+		{
+			TokenLogprob: api.TokenLogprob{Token: "Hi", Logprob: -0.5},
+			TopLogprobs: []api.TokenLogprob{
+				{Token: "Hi", Logprob: -0.5},
+				{Token: "Hey", Logprob: -1.5},
+			},
+		},
+		{
+			TokenLogprob: api.TokenLogprob{Token: "!", Logprob: -0.25},
+		},
+	}
+
+	t.Run("chat", func(t *testing.T) {
+		got := toChatCompletion("id", api.ChatResponse{
+			Message:  api.Message{Role: "assistant", Content: "Hi!"},
+			Logprobs: lps,
+		})
+
+		want := &ChoiceLogprobs{Content: []ChatLogprob{
+			{
+				TopLogprob: TopLogprob{Token: "Hi", Logprob: -0.5, Bytes: []int{72, 105}},
+				TopLogprobs: []TopLogprob{
+					{Token: "Hi", Logprob: -0.5, Bytes: []int{72, 105}},
+					{Token: "Hey", Logprob: -1.5, Bytes: []int{72, 101, 121}},
+				},
+			},
+			{
+				TopLogprob:  TopLogprob{Token: "!", Logprob: -0.25, Bytes: []int{33}},
+				TopLogprobs: []TopLogprob{},
+			},
+		}}
+
+		if diff := cmp.Diff(want, got.Choices[0].Logprobs); diff != "" {
+			t.Errorf("logprobs mismatch (-want +got):\n%s", diff)
+		}
+
+		if chunk := toChunk("id", api.ChatResponse{}, false); chunk.Choices[0].Logprobs != nil {
+			t.Errorf("expected no logprobs, got %+v", chunk.Choices[0].Logprobs)
+		}
+	})
+
+	t.Run("completion", func(t *testing.T) {
+		got := toCompleteChunk("id", api.GenerateResponse{Response: "Hi!", Logprobs: lps})
+
+		want := &CompletionLogprobs{
+			Tokens:        []string{"Hi", "!"},
+			TokenLogprobs: []float64{-0.5, -0.25},
+			TopLogprobs:   []map[string]float64{{"Hi": -0.5, "Hey": -1.5}, {}},
+		}
+
+		if diff := cmp.Diff(want, got.Choices[0].Logprobs); diff != "" {
+			t.Errorf("logprobs mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestChatWriterToolCalls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	resp := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(resp)
+	w := &ChatWriter{stream: true, id: "id", BaseWriter: BaseWriter{ResponseWriter: c.Writer}}
+
+	call := func(index int, location string) api.ToolCall {
+		return api.ToolCall{Function: api.ToolCallFunction{
+			Index:     index,
+			Name:      "get_weather",
+			Arguments: api.ToolCallFunctionArguments{"location": location},
+		}}
+	}
+
+	for _, r := range []api.ChatResponse{
+		{Message: api.Message{Role: "assistant", Content: "Let me check. "}},
+		{Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{call(0, "Paris")}}},
+		{Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{call(1, "Rome")}}, Done: true, DoneReason: "stop"},
+	} {
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var chunks []ChatCompletionChunk
+	for _, line := range strings.Split(resp.Body.String(), "\n\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+
+	if delta := chunks[0].Choices[0].Delta; delta.Content != "Let me check. " || len(delta.ToolCalls) != 0 {
+		t.Errorf("unexpected first delta %+v", delta)
+	}
+
+	for i, location := range []string{"Paris", "Rome"} {
+		calls := chunks[i+1].Choices[0].Delta.ToolCalls
+		if len(calls) != 1 {
+			t.Fatalf("expected a tool call in chunk %d, got %+v", i+1, calls)
+		}
+
+		if calls[0].Index != i || calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments != `{"location":"`+location+`"}` || calls[0].ID == "" {
+			t.Errorf("unexpected tool call delta %+v", calls[0])
+		}
+	}
+
+	if reason := chunks[2].Choices[0].FinishReason; reason == nil || *reason != "tool_calls" {
+		t.Errorf("expected finish reason tool_calls, got %v", reason)
+	}
+
+	// the tool calls may all arrive with the last chunk
+	last := toChunk("id", api.ChatResponse{Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{call(0, "Paris")}}, Done: true, DoneReason: "stop"}, false)
+	if reason := last.Choices[0].FinishReason; reason == nil || *reason != "tool_calls" {
+		t.Errorf("expected finish reason tool_calls for the last chunk, got %v", reason)
+	}
+}
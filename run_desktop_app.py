@@ -58,7 +58,7 @@ class SimpleKCRiffDesktop(QMainWindow):
     
     def check_server(self):
         try:
-            response = requests.get("http://localhost:5000/api/health")
+            response = requests.get("http://localhost:5000/api/version")
             response.raise_for_status()
             self.status.setText("Server is running!")
             self.status.setStyleSheet("color: green;")
@@ -68,9 +68,9 @@ class SimpleKCRiffDesktop(QMainWindow):
     
     def list_models(self):
         try:
-            response = requests.get("http://localhost:5000/api/models")
+            response = requests.get("http://localhost:5000/api/catalog")
             response.raise_for_status()
-            models = response.json()
+            models = response.json()["models"]
             
             model_text = "Available Models:\n\n"
             for model in models:
@@ -86,9 +86,9 @@ class SimpleKCRiffDesktop(QMainWindow):
     
     def download_recommended(self):
         try:
-            response = requests.get("http://localhost:5000/api/models")
+            response = requests.get("http://localhost:5000/api/catalog")
             response.raise_for_status()
-            models = response.json()
+            models = response.json()["models"]
             
             recommended = [model["name"] for model in models if model.get("kc_recommended", False)]
             
@@ -100,7 +100,7 @@ class SimpleKCRiffDesktop(QMainWindow):
             
             # Start downloads
             for model_name in recommended:
-                requests.get(f"http://localhost:5000/api/models/download?name={model_name}")
+                requests.post("http://localhost:5000/api/catalog/download", json={"model": model_name})
             
             self.status.setText("Downloads started in background. Check server logs for progress.")
         except Exception as e:
@@ -111,14 +111,15 @@ def start_go_backend():
     """Start the Go backend server in a separate process"""
     try:
         script_dir = os.path.dirname(os.path.abspath(__file__))
-        server_path = os.path.join(script_dir, "minimal_server.go")
+        server_path = os.path.join(script_dir, "main.go")
         
         if not os.path.exists(server_path):
             print(f"Warning: Server file not found at {server_path}")
             return None
         
         process = subprocess.Popen(
-            ["go", "run", server_path],
+            ["go", "run", server_path, "serve"],
+            env={**os.environ, "OLLAMA_HOST": "127.0.0.1:5000"},
             stdout=subprocess.PIPE,
             stderr=subprocess.PIPE,
             text=True
@@ -143,7 +144,7 @@ def main():
     # Start backend if not already running
     try:
         # Check if server is already running
-        requests.get("http://localhost:5000/api/health")
+        requests.get("http://localhost:5000/api/version")
         print("Server is already running")
     except:
         print("Starting server...")
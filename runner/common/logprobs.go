@@ -0,0 +1,44 @@
+package common
+
+import (
+	"errors"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/sample"
+)
+
+// Logprob returns the log probability of the sampled token id and its n most
+// likely alternatives, with each token decoded to its text by decode
+func Logprob(logits []float32, id int32, n int, decode func(int32) (string, error)) (api.Logprob, error) {
+	if id < 0 || int(id) >= len(logits) {
+		return api.Logprob{}, errors.New("no logits for sampled token")
+	}
+
+	chosen, top := sample.Logprobs(logits, id, n)
+
+	toAPI := func(t sample.TokenLogprob) (api.TokenLogprob, error) {
+		piece, err := decode(t.ID)
+		if err != nil {
+			return api.TokenLogprob{}, err
+		}
+
+		return api.TokenLogprob{Token: piece, Logprob: float64(t.Logprob)}, nil
+	}
+
+	var lp api.Logprob
+	var err error
+	lp.TokenLogprob, err = toAPI(chosen)
+	if err != nil {
+		return api.Logprob{}, err
+	}
+
+	for _, t := range top {
+		alt, err := toAPI(t)
+		if err != nil {
+			return api.Logprob{}, err
+		}
+		lp.TopLogprobs = append(lp.TopLogprobs, alt)
+	}
+
+	return lp, nil
+}
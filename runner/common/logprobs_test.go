@@ -0,0 +1,39 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestLogprob(t *testing.T) {
+	decode := func(id int32) (string, error) {
+		return fmt.Sprintf("<%d>", id), nil
+	}
+
+	lp, err := Logprob([]float32{0, 2, 1}, 2, 2, decode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lp.Token != "<2>" || lp.Logprob >= 0 {
+		t.Errorf("unexpected logprob %+v", lp.TokenLogprob)
+	}
+
+	if len(lp.TopLogprobs) != 2 || lp.TopLogprobs[0].Token != "<1>" || lp.TopLogprobs[1].Token != "<2>" {
+		t.Errorf("unexpected alternatives %+v", lp.TopLogprobs)
+	}
+
+	if lp.TopLogprobs[1].Logprob != lp.Logprob {
+		t.Errorf("expected the sampled token's logprob %f, got %f", lp.Logprob, lp.TopLogprobs[1].Logprob)
+	}
+
+	if _, err := Logprob([]float32{0, 1}, 5, 0, decode); err == nil {
+		t.Error("expected an error for a token without logits")
+	}
+
+	errDecode := errors.New("decode failed")
+	if _, err := Logprob([]float32{0, 1}, 1, 1, func(int32) (string, error) { return "", errDecode }); !errors.Is(err, errDecode) {
+		t.Errorf("expected %v, got %v", errDecode, err)
+	}
+}
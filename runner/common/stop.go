@@ -2,6 +2,7 @@ package common
 
 import (
 	"strings"
+	"unicode/utf8"
 )
 
 func FindStop(sequence string, stops []string) (bool, string) {
@@ -94,3 +95,25 @@ func IncompleteUnicode(token string) bool {
 
 	return incomplete
 }
+
+// TruncateInvalidUnicode joins pieces and removes bytes from the end until
+// the result is valid UTF-8. It also returns how many pieces are kept whole,
+// so that per-piece data such as logprobs can be dropped together with the
+// bytes of the pieces that were cut.
+func TruncateInvalidUnicode(pieces []string) (string, int) {
+	joined := strings.Join(pieces, "")
+	for !utf8.ValidString(joined) {
+		joined = joined[:len(joined)-1]
+	}
+
+	kept, n := 0, 0
+	for _, piece := range pieces {
+		if n+len(piece) > len(joined) {
+			break
+		}
+		n += len(piece)
+		kept++
+	}
+
+	return joined, kept
+}
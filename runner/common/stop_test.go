@@ -60,6 +60,49 @@ func TestTruncateStop(t *testing.T) {
 	}
 }
 
+func TestTruncateInvalidUnicode(t *testing.T) {
+	tests := []struct {
+		name         string
+		pieces       []string
+		expected     string
+		expectedKept int
+	}{
+		{
+			name:         "Valid",
+			pieces:       []string{"hello", " world"},
+			expected:     "hello world",
+			expectedKept: 2,
+		},
+		{
+			name:         "Partial last piece",
+			pieces:       []string{"hello", " ", "\xe2\x82"},
+			expected:     "hello ",
+			expectedKept: 2,
+		},
+		{
+			name:         "Character split across pieces",
+			pieces:       []string{"hello", " \xe2", "\x82"},
+			expected:     "hello ",
+			expectedKept: 1,
+		},
+		{
+			name:         "Empty",
+			pieces:       []string{"\xe2\x82"},
+			expected:     "",
+			expectedKept: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, kept := TruncateInvalidUnicode(tt.pieces)
+			if result != tt.expected || kept != tt.expectedKept {
+				t.Errorf("TruncateInvalidUnicode(%q): have %q (%d); want %q (%d)", tt.pieces, result, kept, tt.expected, tt.expectedKept)
+			}
+		})
+	}
+}
+
 func TestIncompleteUnicode(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -18,7 +18,6 @@ import (
 	"strings"
 	"sync"
 	"time"
-	"unicode/utf8"
 
 	"golang.org/x/sync/semaphore"
 
@@ -53,6 +52,9 @@ type Sequence struct {
 	// tokens that have been generated but not returned yet (e.g. for stop sequences)
 	pendingResponses []string
 
+	// log probabilities of the pending responses, one per token
+	pendingLogprobs []api.Logprob
+
 	// input cache being used by this sequence
 	cache *InputCacheSlot
 
@@ -61,7 +63,7 @@ type Sequence struct {
 	crossAttention bool
 
 	// channel to send responses over
-	responses chan string
+	responses chan llm.CompletionResponse
 
 	// channel to stop decoding (such as if the remote connection is closed)
 	quit chan bool
@@ -71,6 +73,10 @@ type Sequence struct {
 
 	samplingCtx *llama.SamplingContext
 
+	// whether to return log probabilities, and how many alternatives
+	logprobs    bool
+	topLogprobs int
+
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
@@ -98,6 +104,9 @@ type NewSequenceParams struct {
 	numKeep        int
 	samplingParams *llama.SamplingParams
 	embedding      bool
+
+	logprobs    bool
+	topLogprobs int
 }
 
 func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSequenceParams) (*Sequence, error) {
@@ -151,10 +160,12 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		startProcessingTime: startTime,
 		numPredict:          params.numPredict,
 		pendingResponses:    make([]string, 0),
-		responses:           make(chan string, 100),
+		responses:           make(chan llm.CompletionResponse, 100),
 		quit:                make(chan bool, 1),
 		embedding:           make(chan []float32, 1),
 		samplingCtx:         sc,
+		logprobs:            params.logprobs,
+		topLogprobs:         params.topLogprobs,
 		embeddingOnly:       params.embedding,
 		stop:                params.stop,
 		numKeep:             params.numKeep,
@@ -276,25 +287,29 @@ func (s *Server) allNil() bool {
 }
 
 func flushPending(seq *Sequence) bool {
-	joined := strings.Join(seq.pendingResponses, "")
-	seq.pendingResponses = []string{}
-
 	// Check if there are any partial UTF-8 characters remaining.
 	// We already check and queue as we are generating but some may
 	// still make it here:
 	// - Sequence is ending, e.g. generation limit has been hit
 	// - Invalid characters in the middle of a string
 	// This is a stricter check to ensure we never output invalid Unicode.
-	for !utf8.ValidString(joined) {
-		joined = joined[:len(joined)-1]
+	// The logprobs of tokens that were cut are dropped with their bytes.
+	joined, kept := common.TruncateInvalidUnicode(seq.pendingResponses)
+	logprobs := seq.pendingLogprobs
+	if len(logprobs) > kept {
+		logprobs = logprobs[:kept]
 	}
+	seq.pendingResponses = []string{}
+	seq.pendingLogprobs = nil
 
-	if len(joined) == 0 {
+	if len(joined) == 0 && len(logprobs) == 0 {
 		return true
 	}
 
+	// the counts so far let the server charge for a completion the client
+	// stops reading before it is done
 	select {
-	case seq.responses <- joined:
+	case seq.responses <- llm.CompletionResponse{Content: joined, Logprobs: logprobs, PromptEvalCount: seq.numPromptInputs, EvalCount: seq.numDecoded}:
 		return true
 	case <-seq.quit:
 		return false
@@ -505,6 +520,16 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 
 		seq.inputs = []input{{token: token}}
 
+		if seq.logprobs {
+			lp, err := common.Logprob(s.lc.GetLogitsIth(seq.iBatch), int32(token), seq.topLogprobs, func(id int32) (string, error) {
+				return s.model.TokenToPiece(int(id)), nil
+			})
+			if err != nil {
+				return err
+			}
+			seq.pendingLogprobs = append(seq.pendingLogprobs, lp)
+		}
+
 		seq.pendingResponses = append(seq.pendingResponses, piece)
 		sequence := strings.Join(seq.pendingResponses, "")
 
@@ -515,6 +540,9 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			origLen := len(seq.pendingResponses)
 			seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
 			newLen := len(seq.pendingResponses)
+			if seq.logprobs {
+				seq.pendingLogprobs = seq.pendingLogprobs[:newLen]
+			}
 
 			// Update the cache based on the tokens that will be returned:
 			// - We have 1 token more than is currently in the cache because
@@ -596,6 +624,9 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		numKeep:        req.Options.NumKeep,
 		samplingParams: &samplingParams,
 		embedding:      false,
+
+		logprobs:    req.Logprobs,
+		topLogprobs: req.TopLogprobs,
 	})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
@@ -645,11 +676,9 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		case <-r.Context().Done():
 			close(seq.quit)
 			return
-		case content, ok := <-seq.responses:
+		case resp, ok := <-seq.responses:
 			if ok {
-				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
-					Content: content,
-				}); err != nil {
+				if err := json.NewEncoder(w).Encode(&resp); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 					close(seq.quit)
 					return
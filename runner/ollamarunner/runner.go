@@ -19,7 +19,6 @@ import (
 	"strings"
 	"sync"
 	"time"
-	"unicode/utf8"
 
 	"golang.org/x/sync/semaphore"
 
@@ -55,11 +54,14 @@ type Sequence struct {
 	// tokens that have been generated but not returned yet (e.g. for stop sequences)
 	pendingResponses []string
 
+	// log probabilities of the pending responses, one per token
+	pendingLogprobs []api.Logprob
+
 	// input cache being used by this sequence
 	cache *InputCacheSlot
 
 	// channel to send responses over
-	responses chan string
+	responses chan llm.CompletionResponse
 
 	// channel to stop decoding (such as if the remote connection is closed)
 	quit chan bool
@@ -70,6 +72,10 @@ type Sequence struct {
 	// sampler with transforms to run on generated logits
 	sampler sample.Sampler
 
+	// whether to return log probabilities, and how many alternatives
+	logprobs    bool
+	topLogprobs int
+
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
@@ -97,6 +103,9 @@ type NewSequenceParams struct {
 	numKeep    int32
 	sampler    sample.Sampler
 	embedding  bool
+
+	logprobs    bool
+	topLogprobs int
 }
 
 func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSequenceParams) (*Sequence, error) {
@@ -157,6 +166,14 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 
 	// TODO(jessegross): Ingest cached history for grammar
 
+	// The prompt counts towards the history for repeat penalties, as it
+	// does in the llama.cpp runner
+	for _, inp := range inputs {
+		if inp.Multimodal == nil {
+			params.sampler.Accept(inp.Token)
+		}
+	}
+
 	return &Sequence{
 		ctxs:                ctxs,
 		inputs:              inputs,
@@ -164,10 +181,12 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		startProcessingTime: startTime,
 		numPredict:          params.numPredict,
 		pendingResponses:    make([]string, 0),
-		responses:           make(chan string, 100),
+		responses:           make(chan llm.CompletionResponse, 100),
 		quit:                make(chan bool, 1),
 		embedding:           make(chan []float32, 1),
 		sampler:             params.sampler,
+		logprobs:            params.logprobs,
+		topLogprobs:         params.topLogprobs,
 		embeddingOnly:       params.embedding,
 		stop:                params.stop,
 		numKeep:             params.numKeep,
@@ -316,25 +335,29 @@ func (s *Server) allNil() bool {
 }
 
 func flushPending(seq *Sequence) bool {
-	joined := strings.Join(seq.pendingResponses, "")
-	seq.pendingResponses = []string{}
-
 	// Check if there are any partial UTF-8 characters remaining.
 	// We already check and queue as we are generating but some may
 	// still make it here:
 	// - Sequence is ending, e.g. generation limit has been hit
 	// - Invalid characters in the middle of a string
 	// This is a stricter check to ensure we never output invalid Unicode.
-	for !utf8.ValidString(joined) {
-		joined = joined[:len(joined)-1]
+	// The logprobs of tokens that were cut are dropped with their bytes.
+	joined, kept := common.TruncateInvalidUnicode(seq.pendingResponses)
+	logprobs := seq.pendingLogprobs
+	if len(logprobs) > kept {
+		logprobs = logprobs[:kept]
 	}
+	seq.pendingResponses = []string{}
+	seq.pendingLogprobs = nil
 
-	if len(joined) == 0 {
+	if len(joined) == 0 && len(logprobs) == 0 {
 		return true
 	}
 
+	// the counts so far let the server charge for a completion the client
+	// stops reading before it is done
 	select {
-	case seq.responses <- joined:
+	case seq.responses <- llm.CompletionResponse{Content: joined, Logprobs: logprobs, PromptEvalCount: seq.numPromptInputs, EvalCount: seq.numPredicted}:
 		return true
 	case <-seq.quit:
 		return false
@@ -517,7 +540,8 @@ func (s *Server) processBatch() error {
 		// sample a token
 		vocabSize := len(logits) / len(batch.Outputs)
 
-		token, err := seq.sampler.Sample(logits[seq.iBatch*vocabSize : (seq.iBatch+1)*vocabSize])
+		seqLogits := logits[seq.iBatch*vocabSize : (seq.iBatch+1)*vocabSize]
+		token, err := seq.sampler.Sample(seqLogits)
 		if err != nil {
 			return fmt.Errorf("failed to sample token: %w", err)
 		}
@@ -537,8 +561,19 @@ func (s *Server) processBatch() error {
 			return err
 		}
 
+		seq.sampler.Accept(token)
 		seq.inputs = []input.Input{{Token: token}}
 
+		if seq.logprobs {
+			lp, err := common.Logprob(seqLogits, token, seq.topLogprobs, func(id int32) (string, error) {
+				return s.model.(model.TextProcessor).Decode([]int32{id})
+			})
+			if err != nil {
+				return err
+			}
+			seq.pendingLogprobs = append(seq.pendingLogprobs, lp)
+		}
+
 		seq.pendingResponses = append(seq.pendingResponses, piece)
 		sequence := strings.Join(seq.pendingResponses, "")
 
@@ -549,6 +584,9 @@ func (s *Server) processBatch() error {
 			origLen := len(seq.pendingResponses)
 			seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
 			newLen := len(seq.pendingResponses)
+			if seq.logprobs {
+				seq.pendingLogprobs = seq.pendingLogprobs[:newLen]
+			}
 
 			// Update the cache based on the tokens that will be returned:
 			// - We have 1 token more than is currently in the cache because
@@ -625,12 +663,30 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		grammar,
 	)
 
+	// formats other than "json" are compiled here unless the server fell
+	// back to a llama.cpp grammar for them
+	if req.Grammar == "" && len(req.Format) > 0 && req.Format[0] == '{' {
+		f, err := sample.CompileFormat(req.Format)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid format: %v", err), http.StatusBadRequest)
+			return
+		}
+		sampler.SetConstraint(sample.NewConstraint(s.model.(model.TextProcessor), f))
+	}
+
+	sampler.SetPenalties(req.Options.RepeatLastN, req.Options.RepeatPenalty, req.Options.PresencePenalty, req.Options.FrequencyPenalty)
+	sampler.SetTypicalP(req.Options.TypicalP)
+	sampler.SetMirostat(req.Options.Mirostat, req.Options.MirostatTau, req.Options.MirostatEta)
+
 	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
 		numPredict: req.Options.NumPredict,
 		stop:       req.Options.Stop,
 		numKeep:    int32(req.Options.NumKeep),
 		sampler:    sampler,
 		embedding:  false,
+
+		logprobs:    req.Logprobs,
+		topLogprobs: req.TopLogprobs,
 	})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
@@ -678,11 +734,9 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		case <-r.Context().Done():
 			close(seq.quit)
 			return
-		case content, ok := <-seq.responses:
+		case resp, ok := <-seq.responses:
 			if ok {
-				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
-					Content: content,
-				}); err != nil {
+				if err := json.NewEncoder(w).Encode(&resp); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 					close(seq.quit)
 					return
@@ -0,0 +1,483 @@
+package sample
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ollama/ollama/model"
+)
+
+// frame is one level of a parser stack: the position of the next element
+// to match in an alternative, and the frame to continue with once the
+// alternative is done. Frames are interned by a matcher so that equal
+// stacks are the same pointer.
+type frame struct {
+	id              int32
+	rule, alt, elem int32
+	next            *frame
+}
+
+type frameKey struct {
+	rule, alt, elem int32
+	next            *frame
+}
+
+type consumeKey struct {
+	f *frame
+	r rune
+}
+
+// matcher follows a ruleSet character by character. Like llama.cpp's
+// grammar sampler it tracks every parser stack that the text so far leaves
+// open; a nil stack means the text matches the root rule.
+type matcher struct {
+	rs       *ruleSet
+	frames   map[frameKey]*frame
+	expanded map[*frame][]*frame
+	consumed map[consumeKey][]*frame
+}
+
+func newMatcher(rs *ruleSet) *matcher {
+	return &matcher{
+		rs:       rs,
+		frames:   make(map[frameKey]*frame),
+		expanded: make(map[*frame][]*frame),
+		consumed: make(map[consumeKey][]*frame),
+	}
+}
+
+func (m *matcher) intern(rule, alt, elem int32, next *frame) *frame {
+	k := frameKey{rule, alt, elem, next}
+	if f, ok := m.frames[k]; ok {
+		return f
+	}
+
+	f := &frame{id: int32(len(m.frames)) + 1, rule: rule, alt: alt, elem: elem, next: next}
+	m.frames[k] = f
+	return f
+}
+
+func (m *matcher) element(f *frame) element {
+	return m.rs.rules[f.rule][f.alt][f.elem]
+}
+
+// after returns the frame that follows the element at f
+func (m *matcher) after(f *frame) *frame {
+	if int(f.elem)+1 < len(m.rs.rules[f.rule][f.alt]) {
+		return m.intern(f.rule, f.alt, f.elem+1, f.next)
+	}
+	return f.next
+}
+
+// start returns the stacks that begin matching rule, then continue with next
+func (m *matcher) start(rule int, next *frame) []*frame {
+	var stacks []*frame
+	seen := make(map[*frame]bool)
+	m.expandRule(rule, next, seen, &stacks)
+	return sortStacks(stacks)
+}
+
+// expand returns the stacks reachable from f whose top is a character
+// element, or nil when f can finish without matching anything more
+func (m *matcher) expand(f *frame) []*frame {
+	if stacks, ok := m.expanded[f]; ok {
+		return stacks
+	}
+
+	var stacks []*frame
+	m.expandInto(f, make(map[*frame]bool), &stacks)
+	stacks = sortStacks(stacks)
+	m.expanded[f] = stacks
+	return stacks
+}
+
+func (m *matcher) expandInto(f *frame, seen map[*frame]bool, stacks *[]*frame) {
+	if seen[f] {
+		return
+	}
+	seen[f] = true
+
+	if f == nil {
+		*stacks = append(*stacks, nil)
+		return
+	}
+
+	e := m.element(f)
+	if !e.ref {
+		*stacks = append(*stacks, f)
+		return
+	}
+
+	m.expandRule(e.rule, m.after(f), seen, stacks)
+}
+
+func (m *matcher) expandRule(rule int, next *frame, seen map[*frame]bool, stacks *[]*frame) {
+	for i, alt := range m.rs.rules[rule] {
+		if len(alt) == 0 {
+			m.expandInto(next, seen, stacks)
+			continue
+		}
+
+		m.expandInto(m.intern(int32(rule), int32(i), 0, next), seen, stacks)
+	}
+}
+
+// advance returns the stacks left after matching r against stacks
+func (m *matcher) advance(stacks []*frame, r rune) []*frame {
+	var out []*frame
+	for _, f := range stacks {
+		if f == nil {
+			continue
+		}
+
+		k := consumeKey{f, r}
+		next, ok := m.consumed[k]
+		if !ok {
+			if inRanges(m.element(f).ranges, r, r) {
+				next = m.expand(m.after(f))
+			}
+			m.consumed[k] = next
+		}
+
+		out = append(out, next...)
+	}
+
+	return sortStacks(out)
+}
+
+// accepts reports whether any of stacks can match a character in lo-hi
+func (m *matcher) accepts(stacks []*frame, lo, hi rune) bool {
+	for _, f := range stacks {
+		if f != nil && inRanges(m.element(f).ranges, lo, hi) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inRanges reports whether any character in lo-hi is in ranges
+func inRanges(ranges []runeRange, lo, hi rune) bool {
+	for _, r := range ranges {
+		if r.lo <= hi && lo <= r.hi {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortStacks orders stacks by id with duplicates removed, the nil stack first
+func sortStacks(stacks []*frame) []*frame {
+	id := func(f *frame) int32 {
+		if f == nil {
+			return 0
+		}
+		return f.id
+	}
+
+	slices.SortFunc(stacks, func(a, b *frame) int { return int(id(a) - id(b)) })
+	return slices.Compact(stacks)
+}
+
+// matchState is the position of a matcher in generated text: the open
+// stacks, and any bytes of a character split across tokens
+type matchState struct {
+	stacks []*frame
+	// need is how many continuation bytes the current character still
+	// needs out of size, and value its bits so far
+	need, size int
+	value      rune
+}
+
+// key identifies the state among those of the same matcher
+func (s matchState) key() string {
+	b := make([]byte, 0, 4*len(s.stacks)+6)
+	for _, f := range s.stacks {
+		var id int32
+		if f != nil {
+			id = f.id
+		}
+		b = binary.LittleEndian.AppendUint32(b, uint32(id))
+	}
+
+	b = append(b, byte(s.need), byte(s.size))
+	return string(binary.LittleEndian.AppendUint32(b, uint32(s.value)))
+}
+
+func (s matchState) complete() bool {
+	return s.need == 0 && len(s.stacks) > 0 && s.stacks[0] == nil
+}
+
+// utf8Min is the smallest character that needs n bytes, to reject overlong
+// encodings
+var utf8Min = [...]rune{0, 0, 0x80, 0x800, 0x10000}
+
+// feed matches one byte of text. It returns false if no stack accepts it.
+func (m *matcher) feed(s matchState, b byte) (matchState, bool) {
+	if s.need == 0 {
+		switch {
+		case b < utf8.RuneSelf:
+			s.stacks = m.advance(s.stacks, rune(b))
+			return s, len(s.stacks) > 0
+		case b&0xe0 == 0xc0:
+			s.need, s.size, s.value = 1, 2, rune(b&0x1f)
+		case b&0xf0 == 0xe0:
+			s.need, s.size, s.value = 2, 3, rune(b&0x0f)
+		case b&0xf8 == 0xf0:
+			s.need, s.size, s.value = 3, 4, rune(b&0x07)
+		default:
+			return s, false
+		}
+	} else {
+		if b&0xc0 != 0x80 {
+			return s, false
+		}
+		s.need, s.value = s.need-1, s.value<<6|rune(b&0x3f)
+	}
+
+	if s.need == 0 {
+		r := s.value
+		s.value = 0
+		if !utf8.ValidRune(r) || r < utf8Min[s.size] {
+			return s, false
+		}
+
+		s.stacks = m.advance(s.stacks, r)
+		return s, len(s.stacks) > 0
+	}
+
+	// check whether any character this one could still become is accepted
+	lo := s.value << (6 * s.need)
+	hi := lo | (1<<(6*s.need) - 1)
+	return s, m.accepts(s.stacks, max(lo, utf8Min[s.size]), min(hi, utf8.MaxRune))
+}
+
+// feedString matches text, returning false if any byte is rejected
+func (m *matcher) feedString(s matchState, text string) (matchState, bool) {
+	for i := range len(text) {
+		var ok bool
+		if s, ok = m.feed(s, text[i]); !ok {
+			return s, false
+		}
+	}
+
+	return s, true
+}
+
+// Constraint masks the tokens of a vocabulary that would take the output
+// out of a Format, checked in Go against the vocabulary of the Ollama engine
+// rather than by llama.cpp
+type Constraint struct {
+	vocab   model.TextProcessor
+	matcher *matcher
+	state   matchState
+	done    bool
+
+	// masks caches the tokens allowed in each state, which makes the
+	// constraint a token-level DFA built as it is used
+	masks map[string][]bool
+}
+
+// maxMasks is how many states Constraint keeps the allowed tokens of
+const maxMasks = 64
+
+// NewConstraint returns a constraint that keeps the tokens of vocab within f
+func NewConstraint(vocab model.TextProcessor, f *Format) *Constraint {
+	m := newMatcher(f.rules)
+	return &Constraint{
+		vocab:   vocab,
+		matcher: m,
+		state:   matchState{stacks: m.start(f.rules.root, nil)},
+	}
+}
+
+// directLimit is the most tokens Apply checks one at a time before it walks
+// the whole vocabulary at once
+const directLimit = 64
+
+// Apply sets the value of tokens that are not allowed next to -Inf
+func (c *Constraint) Apply(tokens []token) {
+	if len(tokens) <= directLimit {
+		for i := range tokens {
+			if !c.allows(tokens[i].id) {
+				tokens[i].value = float32(math.Inf(-1))
+			}
+		}
+		return
+	}
+
+	var n int32
+	for _, t := range tokens {
+		n = max(n, t.id+1)
+	}
+
+	allowed := c.mask(loadVocabIndex(c.vocab, int(n)))
+	for i := range tokens {
+		if !allowed[tokens[i].id] {
+			tokens[i].value = float32(math.Inf(-1))
+		}
+	}
+}
+
+// Accept moves the constraint past a sampled token
+func (c *Constraint) Accept(id int32) {
+	if c.done {
+		return
+	}
+
+	if c.vocab.Is(id, model.SpecialEOS) {
+		c.done = true
+		return
+	}
+
+	piece, err := c.vocab.Decode([]int32{id})
+	if err != nil {
+		c.done = true
+		return
+	}
+
+	s, ok := c.matcher.feedString(c.state, piece)
+	if !ok {
+		// only tokens that were not allowed get here, which leaves nothing
+		// more to match
+		c.done = true
+		return
+	}
+	c.state = s
+}
+
+// allows reports whether token id may be sampled next
+func (c *Constraint) allows(id int32) bool {
+	if c.done {
+		return false
+	}
+
+	if c.vocab.Is(id, model.SpecialEOS) {
+		return c.state.complete()
+	}
+
+	piece, err := c.vocab.Decode([]int32{id})
+	if err != nil || piece == "" {
+		return false
+	}
+
+	_, ok := c.matcher.feedString(c.state, piece)
+	return ok
+}
+
+// mask returns which tokens of the index may be sampled next, computing
+// them if the current state has not been seen before
+func (c *Constraint) mask(idx *vocabIndex) []bool {
+	if c.done {
+		return make([]bool, len(idx.eos))
+	}
+
+	key := c.state.key()
+	if allowed, ok := c.masks[key]; ok && len(allowed) == len(idx.eos) {
+		return allowed
+	}
+
+	if c.masks == nil || len(c.masks) >= maxMasks {
+		c.masks = make(map[string][]bool)
+	}
+
+	allowed := c.allowed(idx)
+	c.masks[key] = allowed
+	return allowed
+}
+
+// allowed returns which tokens of the index may be sampled next
+func (c *Constraint) allowed(idx *vocabIndex) []bool {
+	allowed := make([]bool, len(idx.eos))
+	if c.state.complete() {
+		for i, eos := range idx.eos {
+			allowed[i] = eos
+		}
+	}
+
+	c.walk(&idx.root, c.state, allowed)
+	return allowed
+}
+
+// walk marks the tokens below node that can follow state. Tokens that share
+// a prefix are only matched against it once.
+func (c *Constraint) walk(node *trieNode, s matchState, allowed []bool) {
+	for _, child := range node.children {
+		next, ok := c.matcher.feed(s, child.b)
+		if !ok {
+			continue
+		}
+
+		for _, id := range child.ids {
+			allowed[id] = true
+		}
+		c.walk(child, next, allowed)
+	}
+}
+
+// trieNode holds the tokens whose text ends after the bytes leading to it
+type trieNode struct {
+	b        byte
+	ids      []int32
+	children []*trieNode
+}
+
+func (n *trieNode) child(b byte) *trieNode {
+	for _, c := range n.children {
+		if c.b == b {
+			return c
+		}
+	}
+
+	c := &trieNode{b: b}
+	n.children = append(n.children, c)
+	return c
+}
+
+// vocabIndex is the text of every token in a vocabulary arranged as a trie
+type vocabIndex struct {
+	eos  []bool
+	root trieNode
+}
+
+type vocabIndexKey struct {
+	vocab model.TextProcessor
+	n     int
+}
+
+// vocabIndexes caches vocabIndex by vocabulary and size, as building one
+// decodes every token
+var vocabIndexes sync.Map
+
+func loadVocabIndex(vocab model.TextProcessor, n int) *vocabIndex {
+	key := vocabIndexKey{vocab, n}
+	if idx, ok := vocabIndexes.Load(key); ok {
+		return idx.(*vocabIndex)
+	}
+
+	idx := &vocabIndex{eos: make([]bool, n)}
+	for id := range int32(n) {
+		if vocab.Is(id, model.SpecialEOS) {
+			idx.eos[id] = true
+			continue
+		}
+
+		piece, err := vocab.Decode([]int32{id})
+		if err != nil || piece == "" {
+			continue
+		}
+
+		node := &idx.root
+		for i := range len(piece) {
+			node = node.child(piece[i])
+		}
+		node.ids = append(node.ids, id)
+	}
+
+	actual, _ := vocabIndexes.LoadOrStore(key, idx)
+	return actual.(*vocabIndex)
+}
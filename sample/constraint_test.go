@@ -0,0 +1,160 @@
+package sample
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/model"
+)
+
+// testVocab is a synthetic vocabulary where each token is the string at
+// its index and the last token is EOS
+type testVocab []string
+
+func (v testVocab) Encode(string, bool) ([]int32, error) {
+	return nil, nil
+}
+
+func (v testVocab) Decode(ids []int32) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		sb.WriteString(v[id])
+	}
+	return sb.String(), nil
+}
+
+func (v testVocab) Is(id int32, special model.Special) bool {
+	return special == model.SpecialEOS && int(id) == len(v)-1
+}
+
+func (v testVocab) Vocabulary() *model.Vocabulary {
+	return &model.Vocabulary{Values: v}
+}
+
+func newTestVocab() *testVocab {
+	var v testVocab
+	for c := byte(' '); c <= '~'; c++ {
+		v = append(v, string(c))
+	}
+
+	v = append(v,
+		`{"`, `":`, `",`, `"}`, `true`, `false`, `null`, `name`, `tags`, "\n  ",
+		// a character split across two tokens
+		"\xc3", "\xa9",
+		// a control token that decodes to nothing
+		"",
+		"</s>",
+	)
+	return &v
+}
+
+func TestConstraint(t *testing.T) {
+	vocab := newTestVocab()
+	eos := int32(len(*vocab) - 1)
+
+	f, err := CompileJSONSchema([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "maxLength": 8},
+			"tags": {"type": "array", "items": {"enum": ["a", "é", true]}, "maxItems": 3},
+			"ok": {"type": "boolean"}
+		},
+		"required": ["name", "ok"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		c := NewConstraint(vocab, f)
+
+		tokens := make([]token, len(*vocab))
+		for i := range tokens {
+			tokens[i].id = int32(i)
+		}
+		c.Apply(tokens)
+
+		var allowed []string
+		for _, tok := range tokens {
+			if !math.IsInf(float64(tok.value), -1) {
+				allowed = append(allowed, (*vocab)[tok.id])
+			}
+		}
+
+		if want := []string{" ", "{", `{"`, "\n  "}; !slices.Equal(allowed, want) {
+			t.Errorf("expected %q, got %q", want, allowed)
+		}
+	})
+
+	t.Run("generate", func(t *testing.T) {
+		r := rand.New(rand.NewPCG(1, 2))
+		for range 50 {
+			sampler := NewSampler(1, 0, 0, 0, int(r.Int32()), nil)
+			sampler.SetConstraint(NewConstraint(vocab, f))
+
+			var ids []int32
+			for len(ids) < 500 {
+				logits := make([]float32, len(*vocab))
+				for i := range logits {
+					logits[i] = r.Float32() * 4
+				}
+				// favor ending so that the output is short
+				logits[eos] = 6
+
+				id, err := sampler.Sample(logits)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id == eos {
+					break
+				}
+				ids = append(ids, id)
+			}
+
+			text, _ := vocab.Decode(ids)
+
+			var out struct {
+				Name *string
+				Tags []any
+				OK   *bool
+			}
+			if err := json.Unmarshal([]byte(text), &out); err != nil {
+				t.Fatalf("invalid JSON %q: %v", text, err)
+			}
+
+			if out.Name == nil || out.OK == nil || len([]rune(*out.Name)) > 8 || len(out.Tags) > 3 {
+				t.Errorf("output does not follow the schema: %q", text)
+			}
+			for _, tag := range out.Tags {
+				if tag != "a" && tag != "é" && tag != true {
+					t.Errorf("unexpected tag in %q", text)
+				}
+			}
+		}
+	})
+
+	t.Run("split character", func(t *testing.T) {
+		c := NewConstraint(vocab, f)
+		for _, s := range []string{`{"`, "name", `":`, `"`, "\xc3"} {
+			c.Accept(int32(slices.Index(*vocab, s)))
+		}
+
+		tokens := []token{
+			{id: int32(slices.Index(*vocab, "\xa9"))},
+			{id: int32(slices.Index(*vocab, "\xc3"))},
+			{id: int32(slices.Index(*vocab, "a"))},
+		}
+		c.Apply(tokens)
+
+		if math.IsInf(float64(tokens[0].value), -1) {
+			t.Error("expected the rest of the character to be allowed")
+		}
+		if !math.IsInf(float64(tokens[1].value), -1) || !math.IsInf(float64(tokens[2].value), -1) {
+			t.Error("expected tokens that do not finish the character to be masked")
+		}
+	})
+}
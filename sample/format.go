@@ -0,0 +1,468 @@
+package sample
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Format is an output format compiled to a grammar that the sampler can
+// enforce one token at a time, such as a JSON schema
+type Format struct {
+	rules *ruleSet
+}
+
+// CompileFormat compiles the format of a request given as a JSON object:
+// {"type": "regex", "pattern": "..."} for text matching a regular
+// expression, {"type": "choice", "values": ["...", ...]} for one of a list
+// of strings, or otherwise a JSON schema
+func CompileFormat(format []byte) (*Format, error) {
+	var spec struct {
+		Type    any      `json:"type"`
+		Pattern *string  `json:"pattern"`
+		Values  []string `json:"values"`
+	}
+	if err := json.Unmarshal(format, &spec); err != nil {
+		return CompileJSONSchema(format)
+	}
+
+	switch spec.Type {
+	case "regex":
+		if spec.Pattern == nil {
+			return nil, errors.New("regex format requires a pattern")
+		}
+		return CompileRegex(*spec.Pattern)
+	case "choice":
+		return CompileChoice(spec.Values)
+	default:
+		return CompileJSONSchema(format)
+	}
+}
+
+// CompileRegex compiles a Format for text that matches all of pattern, in
+// the syntax of the regexp package. Word boundaries are not supported.
+func CompileRegex(pattern string) (*Format, error) {
+	b := newBuilder()
+	rule, err := b.regex(pattern, []runeRange{{0, utf8.MaxRune}})
+	if err != nil {
+		return nil, err
+	}
+
+	b.rs.root = rule
+	return &Format{rules: b.rs}, nil
+}
+
+// CompileChoice compiles a Format for text that is exactly one of values
+func CompileChoice(values []string) (*Format, error) {
+	if len(values) == 0 {
+		return nil, errors.New("choice format requires at least one value")
+	}
+
+	b := newBuilder()
+	alts := make([][]element, len(values))
+	for i, v := range values {
+		alts[i] = literal(v)
+	}
+
+	b.rs.root = b.rule(alts...)
+	return &Format{rules: b.rs}, nil
+}
+
+// Sequence returns a Format for text matching each of formats in turn
+func Sequence(formats ...*Format) *Format {
+	b := newBuilder()
+	var s []element
+	for _, f := range formats {
+		s = append(s, ref(b.include(f)))
+	}
+
+	b.rs.root = b.rule(s)
+	return &Format{rules: b.rs}
+}
+
+// Repeat returns a Format for text matching f at least min and at most max
+// times, or without limit if max is negative, with text matching sep
+// between each. sep may be nil for no separator.
+func Repeat(f *Format, min, max int, sep *Format) *Format {
+	b := newBuilder()
+	item := []element{ref(b.include(f))}
+	next := item
+	if sep != nil {
+		next = seq([]element{ref(b.include(sep))}, item)
+	}
+
+	// the first item has no separator before it
+	more := min - 1
+	if more < 0 {
+		more = 0
+	}
+
+	var s []element
+	switch {
+	case max == 0:
+	case max < 0:
+		s = seq(item, b.repeat(next, more, -1))
+	default:
+		s = seq(item, b.repeat(next, more, max-1))
+	}
+
+	if min == 0 && len(s) > 0 {
+		s = []element{b.optional(s)}
+	}
+
+	b.rs.root = b.rule(s)
+	return &Format{rules: b.rs}
+}
+
+// include adds a copy of the rules of f and returns the index of its root
+func (b *builder) include(f *Format) int {
+	offset := len(b.rs.rules)
+	for _, alts := range f.rules.rules {
+		copied := make([][]element, len(alts))
+		for i, alt := range alts {
+			copied[i] = slices.Clone(alt)
+			for j := range copied[i] {
+				if copied[i][j].ref {
+					copied[i][j].rule += offset
+				}
+			}
+		}
+		b.rs.rules = append(b.rs.rules, copied)
+	}
+
+	return f.rules.root + offset
+}
+
+// Match reports whether text is complete output of f
+func (f *Format) Match(text string) bool {
+	m := newMatcher(f.rules)
+	s, ok := m.feedString(matchState{stacks: m.start(f.rules.root, nil)}, text)
+	return ok && s.complete()
+}
+
+// Grammar returns f in the GBNF syntax of llama.cpp grammars
+func (f *Format) Grammar() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "root ::= r%d\n", f.rules.root)
+	for i, alts := range f.rules.rules {
+		fmt.Fprintf(&sb, "r%d ::=", i)
+		for j, alt := range alts {
+			if j > 0 {
+				sb.WriteString(" |")
+			}
+
+			if len(alt) == 0 {
+				sb.WriteString(` ""`)
+			}
+
+			for _, e := range alt {
+				sb.WriteByte(' ')
+				if e.ref {
+					fmt.Fprintf(&sb, "r%d", e.rule)
+					continue
+				}
+
+				if len(e.ranges) == 0 {
+					// nothing matches a character not in any range
+					sb.WriteString(`[^\U00000000-\U0010FFFF]`)
+					continue
+				}
+
+				sb.WriteByte('[')
+				for _, r := range e.ranges {
+					sb.WriteString(gbnfChar(r.lo))
+					if r.hi != r.lo {
+						sb.WriteByte('-')
+						sb.WriteString(gbnfChar(r.hi))
+					}
+				}
+				sb.WriteByte(']')
+			}
+		}
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
+
+// gbnfChar writes a character for a GBNF character class, escaping all but
+// letters and digits
+func gbnfChar(r rune) string {
+	if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+		return string(r)
+	}
+	return fmt.Sprintf(`\U%08X`, r)
+}
+
+// runeRange is an inclusive range of characters
+type runeRange struct {
+	lo, hi rune
+}
+
+// element is one step of an alternative: either a character from ranges or,
+// when ref is set, a match of the rule at index rule
+type element struct {
+	ref    bool
+	rule   int
+	ranges []runeRange
+}
+
+// ruleSet is a context-free grammar. Each rule is a list of alternatives and
+// each alternative a sequence of elements, like GBNF. Rules must not be left
+// recursive.
+type ruleSet struct {
+	rules [][][]element
+	root  int
+}
+
+// builder adds rules to a ruleSet
+type builder struct {
+	rs *ruleSet
+}
+
+func newBuilder() *builder {
+	return &builder{rs: &ruleSet{}}
+}
+
+// reserve adds an empty rule to be defined later with define, so rules can
+// refer to themselves
+func (b *builder) reserve() int {
+	b.rs.rules = append(b.rs.rules, nil)
+	return len(b.rs.rules) - 1
+}
+
+func (b *builder) define(rule int, alts ...[]element) {
+	b.rs.rules[rule] = alts
+}
+
+// rule adds a rule matching any of alts and returns its index
+func (b *builder) rule(alts ...[]element) int {
+	r := b.reserve()
+	b.define(r, alts...)
+	return r
+}
+
+func ref(rule int) element {
+	return element{ref: true, rule: rule}
+}
+
+// chars matches one character in any of ranges
+func chars(ranges ...runeRange) element {
+	return element{ranges: normalizeRanges(ranges)}
+}
+
+// literal matches s exactly
+func literal(s string) []element {
+	var seq []element
+	for _, r := range s {
+		seq = append(seq, chars(runeRange{r, r}))
+	}
+	return seq
+}
+
+// seq concatenates sequences of elements
+func seq(parts ...[]element) []element {
+	var s []element
+	for _, p := range parts {
+		s = append(s, p...)
+	}
+	return s
+}
+
+// optional matches s or nothing
+func (b *builder) optional(s []element) element {
+	return ref(b.rule(nil, s))
+}
+
+// repeat matches s at least min and at most max times, or without limit if
+// max is negative
+func (b *builder) repeat(s []element, min, max int) []element {
+	var out []element
+	for range min {
+		out = append(out, s...)
+	}
+
+	if max < 0 {
+		r := b.reserve()
+		b.define(r, nil, seq(s, []element{ref(r)}))
+		return append(out, ref(r))
+	}
+
+	// nest the optional repetitions so that each one requires the previous
+	var tail []element
+	for range max - min {
+		tail = []element{b.optional(seq(s, tail))}
+	}
+	return append(out, tail...)
+}
+
+// normalizeRanges sorts ranges and merges any that overlap or touch
+func normalizeRanges(ranges []runeRange) []runeRange {
+	ranges = slices.Clone(ranges)
+	slices.SortFunc(ranges, func(a, b runeRange) int { return int(a.lo - b.lo) })
+
+	var out []runeRange
+	for _, r := range ranges {
+		if r.lo > r.hi {
+			continue
+		}
+
+		if n := len(out); n > 0 && r.lo <= out[n-1].hi+1 {
+			out[n-1].hi = max(out[n-1].hi, r.hi)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// subtractRanges removes the characters in sub from ranges. Both must be
+// normalized.
+func subtractRanges(ranges, sub []runeRange) []runeRange {
+	var out []runeRange
+	for _, r := range ranges {
+		lo := r.lo
+		for _, s := range sub {
+			if s.hi < lo || s.lo > r.hi {
+				continue
+			}
+			if s.lo > lo {
+				out = append(out, runeRange{lo, s.lo - 1})
+			}
+			lo = s.hi + 1
+		}
+		if lo <= r.hi {
+			out = append(out, runeRange{lo, r.hi})
+		}
+	}
+	return out
+}
+
+// RegexError reports a regular expression that cannot be compiled to a
+// grammar, such as one with a word boundary
+type RegexError struct {
+	Pattern string
+	// Feature is the unsupported part of the pattern, such as `\b`
+	Feature string
+	Err     error
+}
+
+func (e *RegexError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("invalid regular expression %q: %v", e.Pattern, e.Err)
+	}
+
+	return fmt.Sprintf("regular expression %q: %s is not supported", e.Pattern, e.Feature)
+}
+
+func (e *RegexError) Unwrap() error {
+	return e.Err
+}
+
+// regex adds a rule matching the whole of pattern, using only characters in
+// allowed. Anchors at the start and end of pattern are implied and ignored.
+func (b *builder) regex(pattern string, allowed []runeRange) (int, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0, &RegexError{Pattern: pattern, Err: err}
+	}
+
+	c := regexCompiler{b: b, pattern: pattern, allowed: allowed}
+	s, err := c.compile(re.Simplify())
+	if err != nil {
+		return 0, err
+	}
+
+	return b.rule(s), nil
+}
+
+type regexCompiler struct {
+	b       *builder
+	pattern string
+	allowed []runeRange
+}
+
+// class matches one character in ranges that is also allowed
+func (c *regexCompiler) class(ranges []runeRange) element {
+	return element{ranges: subtractRanges(c.allowed, subtractRanges(c.allowed, normalizeRanges(ranges)))}
+}
+
+func (c *regexCompiler) compile(re *syntax.Regexp) ([]element, error) {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return []element{{}}, nil
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+		return nil, nil
+	case syntax.OpWordBoundary:
+		return nil, &RegexError{Pattern: c.pattern, Feature: `\b`}
+	case syntax.OpNoWordBoundary:
+		return nil, &RegexError{Pattern: c.pattern, Feature: `\B`}
+	case syntax.OpLiteral:
+		var s []element
+		for _, r := range re.Rune {
+			ranges := []runeRange{{r, r}}
+			if re.Flags&syntax.FoldCase != 0 {
+				for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+					ranges = append(ranges, runeRange{f, f})
+				}
+			}
+			s = append(s, c.class(ranges))
+		}
+		return s, nil
+	case syntax.OpCharClass:
+		var ranges []runeRange
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			ranges = append(ranges, runeRange{re.Rune[i], re.Rune[i+1]})
+		}
+		return []element{c.class(ranges)}, nil
+	case syntax.OpAnyChar:
+		return []element{c.class([]runeRange{{0, utf8.MaxRune}})}, nil
+	case syntax.OpAnyCharNotNL:
+		return []element{c.class([]runeRange{{0, '\n' - 1}, {'\n' + 1, utf8.MaxRune}})}, nil
+	case syntax.OpCapture:
+		return c.compile(re.Sub[0])
+	case syntax.OpConcat:
+		var s []element
+		for _, sub := range re.Sub {
+			ss, err := c.compile(sub)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, ss...)
+		}
+		return s, nil
+	case syntax.OpAlternate:
+		alts := make([][]element, len(re.Sub))
+		for i, sub := range re.Sub {
+			s, err := c.compile(sub)
+			if err != nil {
+				return nil, err
+			}
+			alts[i] = s
+		}
+		return []element{ref(c.b.rule(alts...))}, nil
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		s, err := c.compile(re.Sub[0])
+		if err != nil {
+			return nil, err
+		}
+
+		switch re.Op {
+		case syntax.OpStar:
+			return c.b.repeat(s, 0, -1), nil
+		case syntax.OpPlus:
+			return c.b.repeat(s, 1, -1), nil
+		case syntax.OpQuest:
+			return c.b.repeat(s, 0, 1), nil
+		default:
+			return c.b.repeat(s, re.Min, re.Max), nil
+		}
+	default:
+		return nil, &RegexError{Pattern: c.pattern, Feature: re.String()}
+	}
+}
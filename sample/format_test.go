@@ -0,0 +1,220 @@
+package sample
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCompileFormat(t *testing.T) {
+	cases := []struct {
+		name    string
+		format  string
+		valid   []string
+		invalid []string
+	}{
+		{
+			name:    "regex",
+			format:  `{"type": "regex", "pattern": "^[A-Z]{3}-\\d{2,4}$"}`,
+			valid:   []string{"ABC-12", "XYZ-1234"},
+			invalid: []string{"AB-12", "ABC-1", "ABC-12345", "abc-12", `"ABC-12"`},
+		},
+		{
+			name:    "regex alternation",
+			format:  `{"type": "regex", "pattern": "(?i)yes|no(pe)?|\\s*"}`,
+			valid:   []string{"yes", "YeS", "no", "nope", "", " \n"},
+			invalid: []string{"yesno", "nop"},
+		},
+		{
+			name:    "regex any",
+			format:  `{"type": "regex", "pattern": "a.+"}`,
+			valid:   []string{"a\"\\é", "a a"},
+			invalid: []string{"a", "a\n"},
+		},
+		{
+			name:    "choice",
+			format:  `{"type": "choice", "values": ["positive", "negative", "neutral", "né"]}`,
+			valid:   []string{"positive", "negative", "neutral", "né"},
+			invalid: []string{"", "neg", "positive ", `"positive"`},
+		},
+		{
+			name:    "schema",
+			format:  `{"type": "object", "properties": {"type": {"const": "regex"}}, "required": ["type"]}`,
+			valid:   []string{`{"type": "regex"}`},
+			invalid: []string{`{}`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := CompileFormat([]byte(tt.format))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.valid {
+				if !f.Match(s) {
+					t.Errorf("expected %q to match", s)
+				}
+			}
+
+			for _, s := range tt.invalid {
+				if f.Match(s) {
+					t.Errorf("expected %q not to match", s)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileFormatErrors(t *testing.T) {
+	var rerr *RegexError
+	if _, err := CompileFormat([]byte(`{"type": "regex", "pattern": "\\bword\\b"}`)); !errors.As(err, &rerr) || rerr.Feature != `\b` {
+		t.Errorf("expected word boundaries to be unsupported, got %v", err)
+	}
+
+	if _, err := CompileFormat([]byte(`{"type": "regex", "pattern": "a("}`)); !errors.As(err, &rerr) || rerr.Err == nil {
+		t.Errorf("expected invalid pattern error, got %v", err)
+	}
+
+	for _, format := range []string{
+		`{"type": "regex"}`,
+		`{"type": "choice"}`,
+		`{"type": "choice", "values": []}`,
+	} {
+		if _, err := CompileFormat([]byte(format)); err == nil {
+			t.Errorf("expected %s to fail", format)
+		}
+	}
+}
+
+func TestSequenceRepeat(t *testing.T) {
+	mustCompile := func(f *Format, err error) *Format {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	tag := mustCompile(CompileChoice([]string{"<call>"}))
+	item := mustCompile(CompileJSONSchema([]byte(`{"type": "integer"}`)))
+	comma := mustCompile(CompileChoice([]string{","}))
+
+	cases := []struct {
+		name    string
+		format  *Format
+		valid   []string
+		invalid []string
+	}{
+		{
+			name:    "sequence",
+			format:  Sequence(tag, item, tag),
+			valid:   []string{"<call>1<call>", "<call> 12 <call>"},
+			invalid: []string{"<call>1", "1<call>", "<call><call>"},
+		},
+		{
+			name:    "repeat",
+			format:  Repeat(item, 1, 3, comma),
+			valid:   []string{"1", "1,2", "1, 2 ,3"},
+			invalid: []string{"", "1,", "1,2,3,4", "1 2"},
+		},
+		{
+			name:    "repeat optional",
+			format:  Repeat(item, 0, -1, nil),
+			valid:   []string{"", "1", "1 2 3 4 5"},
+			invalid: []string{"1,2"},
+		},
+		{
+			name:    "repeat none",
+			format:  Repeat(item, 0, 0, comma),
+			valid:   []string{""},
+			invalid: []string{"1"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, s := range tt.valid {
+				if !tt.format.Match(s) {
+					t.Errorf("expected %q to match", s)
+				}
+			}
+
+			for _, s := range tt.invalid {
+				if tt.format.Match(s) {
+					t.Errorf("expected %q not to match", s)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatGrammar(t *testing.T) {
+	f, err := CompileRegex(`[a-c]x?|-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `root ::= r2
+r0 ::= "" | [x]
+r1 ::= [a-c] r0 | [\U0000002D]
+r2 ::= r1
+`
+	if got := f.Grammar(); got != want {
+		t.Errorf("unexpected grammar:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestConstraintChoice(t *testing.T) {
+	vocab := &testVocab{"pos", "neg", "itive", "ative", "p", "n", "x", "</s>"}
+	eos := int32(len(*vocab) - 1)
+
+	f, err := CompileChoice([]string{"positive", "negative"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewConstraint(vocab, f)
+	allowed := func() []int32 {
+		// more tokens than directLimit so the whole vocabulary is walked
+		tokens := make([]token, directLimit+1)
+		for i := range tokens {
+			tokens[i].id = int32(i % len(*vocab))
+		}
+		c.Apply(tokens)
+
+		var ids []int32
+		for _, tok := range tokens[:len(*vocab)] {
+			if !math.IsInf(float64(tok.value), -1) {
+				ids = append(ids, tok.id)
+			}
+		}
+		return ids
+	}
+
+	steps := []struct {
+		accept int32
+		want   []int32
+	}{
+		{-1, []int32{0, 1, 4, 5}},
+		{1, []int32{3}},
+		{3, []int32{eos}},
+	}
+
+	for _, step := range steps {
+		if step.accept >= 0 {
+			c.Accept(step.accept)
+		}
+
+		got := allowed()
+		if len(got) != len(step.want) {
+			t.Fatalf("after %d: expected %v, got %v", step.accept, step.want, got)
+		}
+		for i := range got {
+			if got[i] != step.want[i] {
+				t.Fatalf("after %d: expected %v, got %v", step.accept, step.want, got)
+			}
+		}
+	}
+}
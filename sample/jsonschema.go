@@ -0,0 +1,727 @@
+package sample
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SchemaError reports a JSON schema that cannot be compiled, naming the
+// keyword responsible and where it appears in the schema
+type SchemaError struct {
+	// Path is a JSON pointer to the schema with the error, such as
+	// /properties/age
+	Path string
+	// Keyword is the keyword that is not supported or not valid, such as
+	// "minimum". It is empty if the schema itself is not valid.
+	Keyword string
+	// Reason is why the keyword cannot be compiled
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+
+	if e.Keyword == "" {
+		return fmt.Sprintf("json schema at %s: %s", path, e.Reason)
+	}
+
+	return fmt.Sprintf("json schema at %s: keyword %q %s", path, e.Keyword, e.Reason)
+}
+
+// unsupportedKeywords are the validation keywords a grammar cannot enforce.
+// Keywords not listed and not compiled, such as "title", are annotations
+// and ignored.
+var unsupportedKeywords = []string{
+	"not", "if", "then", "else",
+	"dependentSchemas", "dependentRequired", "dependencies",
+	"patternProperties", "propertyNames", "unevaluatedProperties", "unevaluatedItems",
+	"minProperties", "maxProperties",
+	"contains", "minContains", "maxContains", "uniqueItems",
+	"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf",
+	"$dynamicRef", "$recursiveRef",
+}
+
+// formatPatterns are the regular expressions for the string formats that
+// can be enforced
+var formatPatterns = map[string]string{
+	"date":      `[0-9]{4}-(0[1-9]|1[0-2])-(0[1-9]|[12][0-9]|3[01])`,
+	"time":      `([01][0-9]|2[0-3]):[0-5][0-9]:[0-5][0-9](\.[0-9]{1,9})?(Z|[+-]([01][0-9]|2[0-3]):[0-5][0-9])`,
+	"date-time": `[0-9]{4}-(0[1-9]|1[0-2])-(0[1-9]|[12][0-9]|3[01])T([01][0-9]|2[0-3]):[0-5][0-9]:[0-5][0-9](\.[0-9]{1,9})?(Z|[+-]([01][0-9]|2[0-3]):[0-5][0-9])`,
+	"uuid":      `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+}
+
+// stringChars are the characters a JSON string holds without escaping
+var stringChars = []runeRange{{0x20, '"' - 1}, {'"' + 1, '\\' - 1}, {'\\' + 1, utf8.MaxRune}}
+
+// CompileJSONSchema compiles a JSON schema to a Format. Schemas may use
+// type, enum, const, properties, required, additionalProperties, items,
+// prefixItems, minItems, maxItems, minLength, maxLength, pattern, format,
+// anyOf, oneOf, a single allOf and local $ref. Other validation keywords
+// return a *SchemaError. Objects have their properties in the order of the
+// schema and no others unless additionalProperties allows them.
+func CompileJSONSchema(schema []byte) (*Format, error) {
+	if !json.Valid(schema) {
+		var v any
+		err := json.Unmarshal(schema, &v)
+		return nil, &SchemaError{Reason: fmt.Sprintf("not valid JSON: %v", err)}
+	}
+
+	c := schemaCompiler{
+		b:    newBuilder(),
+		root: schema,
+		refs: make(map[string]int),
+	}
+
+	// the root is rule 0, so no other rule is
+	root := c.b.reserve()
+	rule, err := c.compile(schema, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// allow whitespace around the value, as the root of a schema is often
+	// generated after a newline
+	c.b.define(root, seq([]element{c.ws()}, []element{ref(rule)}, []element{c.ws()}))
+	c.b.rs.root = root
+	return &Format{rules: c.b.rs}, nil
+}
+
+type schemaCompiler struct {
+	b    *builder
+	root json.RawMessage
+	// refs holds the rule compiled for each $ref, which lets schemas refer
+	// to themselves
+	refs map[string]int
+
+	// rules shared by every schema, added when first used and 0 until then
+	space, value, str int
+}
+
+// ws matches the whitespace between tokens of JSON, limited so a model
+// cannot fill its context with it
+func (c *schemaCompiler) ws() element {
+	if c.space == 0 {
+		indent := c.b.repeat([]element{chars(runeRange{' ', ' '}, runeRange{'\t', '\t'})}, 0, 20)
+		c.space = c.b.rule(nil, literal(" "), seq(literal("\n"), indent))
+	}
+	return ref(c.space)
+}
+
+func (c *schemaCompiler) compile(raw json.RawMessage, path string) (int, error) {
+	raw = bytes.TrimSpace(raw)
+	switch string(raw) {
+	case "true", "{}":
+		return c.anyValue(), nil
+	case "false":
+		return 0, &SchemaError{Path: path, Reason: "schema never validates"}
+	}
+
+	var s map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, &SchemaError{Path: path, Reason: "schema must be an object or a boolean"}
+	}
+
+	for _, k := range unsupportedKeywords {
+		if _, ok := s[k]; ok {
+			return 0, &SchemaError{Path: path, Keyword: k, Reason: "is not supported"}
+		}
+	}
+
+	if r, ok := s["$ref"]; ok {
+		var uri string
+		if err := json.Unmarshal(r, &uri); err != nil {
+			return 0, &SchemaError{Path: path, Keyword: "$ref", Reason: "must be a string"}
+		}
+		return c.resolve(uri, path)
+	}
+
+	if r, ok := s["allOf"]; ok {
+		var subs []json.RawMessage
+		if err := json.Unmarshal(r, &subs); err != nil || len(subs) == 0 {
+			return 0, &SchemaError{Path: path, Keyword: "allOf", Reason: "must be a non-empty array"}
+		}
+		if len(subs) > 1 {
+			return 0, &SchemaError{Path: path, Keyword: "allOf", Reason: "is only supported with a single schema"}
+		}
+		return c.compile(subs[0], path+"/allOf/0")
+	}
+
+	for _, k := range []string{"anyOf", "oneOf"} {
+		if r, ok := s[k]; ok {
+			var subs []json.RawMessage
+			if err := json.Unmarshal(r, &subs); err != nil || len(subs) == 0 {
+				return 0, &SchemaError{Path: path, Keyword: k, Reason: "must be a non-empty array"}
+			}
+
+			alts := make([][]element, len(subs))
+			for i, sub := range subs {
+				rule, err := c.compile(sub, fmt.Sprintf("%s/%s/%d", path, k, i))
+				if err != nil {
+					return 0, err
+				}
+				alts[i] = []element{ref(rule)}
+			}
+			return c.b.rule(alts...), nil
+		}
+	}
+
+	if r, ok := s["const"]; ok {
+		lit, err := jsonLiteral(r)
+		if err != nil {
+			return 0, &SchemaError{Path: path, Keyword: "const", Reason: err.Error()}
+		}
+		return c.b.rule(literal(lit)), nil
+	}
+
+	if r, ok := s["enum"]; ok {
+		var values []json.RawMessage
+		if err := json.Unmarshal(r, &values); err != nil || len(values) == 0 {
+			return 0, &SchemaError{Path: path, Keyword: "enum", Reason: "must be a non-empty array"}
+		}
+
+		alts := make([][]element, len(values))
+		for i, v := range values {
+			lit, err := jsonLiteral(v)
+			if err != nil {
+				return 0, &SchemaError{Path: path, Keyword: "enum", Reason: err.Error()}
+			}
+			alts[i] = literal(lit)
+		}
+		return c.b.rule(alts...), nil
+	}
+
+	types, err := schemaTypes(s, path)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(types) == 0 {
+		return c.anyValue(), nil
+	}
+
+	alts := make([][]element, len(types))
+	for i, t := range types {
+		rule, err := c.typed(t, s, path)
+		if err != nil {
+			return 0, err
+		}
+		alts[i] = []element{ref(rule)}
+	}
+
+	if len(alts) == 1 {
+		return alts[0][0].rule, nil
+	}
+	return c.b.rule(alts...), nil
+}
+
+// schemaTypes returns the types s allows, inferring them from its keywords
+// when it has no type
+func schemaTypes(s map[string]json.RawMessage, path string) ([]string, error) {
+	if r, ok := s["type"]; ok {
+		var t string
+		if err := json.Unmarshal(r, &t); err == nil {
+			return []string{t}, nil
+		}
+
+		var ts []string
+		if err := json.Unmarshal(r, &ts); err != nil || len(ts) == 0 {
+			return nil, &SchemaError{Path: path, Keyword: "type", Reason: "must be a string or a non-empty array of strings"}
+		}
+		return ts, nil
+	}
+
+	has := func(keywords ...string) bool {
+		return slices.ContainsFunc(keywords, func(k string) bool {
+			_, ok := s[k]
+			return ok
+		})
+	}
+
+	switch {
+	case has("properties", "additionalProperties", "required"):
+		return []string{"object"}, nil
+	case has("items", "prefixItems", "minItems", "maxItems"):
+		return []string{"array"}, nil
+	case has("pattern", "format", "minLength", "maxLength"):
+		return []string{"string"}, nil
+	}
+
+	return nil, nil
+}
+
+func (c *schemaCompiler) typed(t string, s map[string]json.RawMessage, path string) (int, error) {
+	switch t {
+	case "object":
+		return c.object(s, path)
+	case "array":
+		return c.array(s, path)
+	case "string":
+		return c.stringSchema(s, path)
+	case "integer":
+		return c.b.rule(c.integer()), nil
+	case "number":
+		return c.b.rule(c.number()), nil
+	case "boolean":
+		return c.b.rule(literal("true"), literal("false")), nil
+	case "null":
+		return c.b.rule(literal("null")), nil
+	default:
+		return 0, &SchemaError{Path: path, Keyword: "type", Reason: fmt.Sprintf("has unknown type %q", t)}
+	}
+}
+
+// resolve compiles the schema at a JSON pointer within the root schema,
+// such as #/$defs/item
+func (c *schemaCompiler) resolve(uri, path string) (int, error) {
+	if rule, ok := c.refs[uri]; ok {
+		return rule, nil
+	}
+
+	pointer, ok := strings.CutPrefix(uri, "#")
+	if !ok {
+		return 0, &SchemaError{Path: path, Keyword: "$ref", Reason: fmt.Sprintf("%q is not a reference within the schema", uri)}
+	}
+
+	pointer, err := url.PathUnescape(pointer)
+	if err != nil {
+		return 0, &SchemaError{Path: path, Keyword: "$ref", Reason: fmt.Sprintf("%q is not a valid reference", uri)}
+	}
+
+	target := json.RawMessage(c.root)
+	if pointer != "" {
+		for _, part := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+			part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+
+			var next json.RawMessage
+			var obj map[string]json.RawMessage
+			var arr []json.RawMessage
+			if err := json.Unmarshal(target, &obj); err == nil {
+				next = obj[part]
+			} else if err := json.Unmarshal(target, &arr); err == nil {
+				if i, err := strconv.Atoi(part); err == nil && i >= 0 && i < len(arr) {
+					next = arr[i]
+				}
+			}
+
+			if next == nil {
+				return 0, &SchemaError{Path: path, Keyword: "$ref", Reason: fmt.Sprintf("%q does not resolve", uri)}
+			}
+			target = next
+		}
+	}
+
+	// reserve the rule before compiling the target so references back to
+	// it, directly or not, end up here
+	rule := c.b.reserve()
+	c.refs[uri] = rule
+
+	compiled, err := c.compile(target, pointer)
+	if err != nil {
+		return 0, err
+	}
+
+	c.b.define(rule, []element{ref(compiled)})
+	return rule, nil
+}
+
+func (c *schemaCompiler) object(s map[string]json.RawMessage, path string) (int, error) {
+	type property struct {
+		name     string
+		rule     int
+		required bool
+	}
+
+	var required []string
+	if r, ok := s["required"]; ok {
+		if err := json.Unmarshal(r, &required); err != nil {
+			return 0, &SchemaError{Path: path, Keyword: "required", Reason: "must be an array of strings"}
+		}
+	}
+
+	var names []string
+	var props []property
+	if r, ok := s["properties"]; ok {
+		var err error
+		names, err = objectKeys(r)
+		if err != nil {
+			return 0, &SchemaError{Path: path, Keyword: "properties", Reason: "must be an object"}
+		}
+
+		var schemas map[string]json.RawMessage
+		if err := json.Unmarshal(r, &schemas); err != nil {
+			return 0, &SchemaError{Path: path, Keyword: "properties", Reason: "must be an object"}
+		}
+
+		for _, name := range names {
+			rule, err := c.compile(schemas[name], path+"/properties/"+escapePointer(name))
+			if err != nil {
+				return 0, err
+			}
+			props = append(props, property{name: name, rule: rule, required: slices.Contains(required, name)})
+		}
+	}
+
+	for _, name := range required {
+		if !slices.Contains(names, name) {
+			return 0, &SchemaError{Path: path, Keyword: "required", Reason: fmt.Sprintf("names %q, which is not in properties", name)}
+		}
+	}
+
+	// without properties or additionalProperties any object goes, otherwise
+	// only the properties listed unless additionalProperties allows more
+	additional := -1
+	if r, ok := s["additionalProperties"]; ok {
+		if string(bytes.TrimSpace(r)) != "false" {
+			rule, err := c.compile(r, path+"/additionalProperties")
+			if err != nil {
+				return 0, err
+			}
+			additional = rule
+		}
+	} else if len(props) == 0 {
+		additional = c.anyValue()
+	}
+
+	ws := []element{c.ws()}
+	comma := seq(literal(","), ws)
+	member := func(key []element, value int) []element {
+		return seq(key, ws, literal(":"), ws, []element{ref(value)}, ws)
+	}
+
+	// rest[first] matches the members from property i on, where first is
+	// whether no member came before them
+	var rest [2]int
+	if additional >= 0 {
+		m := member([]element{ref(c.anyString())}, additional)
+		more := c.b.repeat(seq(comma, m), 0, -1)
+		rest[0] = c.b.rule(more)
+		rest[1] = c.b.rule(nil, seq(m, more))
+	} else {
+		rest[0] = c.b.rule(nil)
+		rest[1] = rest[0]
+	}
+
+	for i := len(props) - 1; i >= 0; i-- {
+		p := props[i]
+		key, _ := json.Marshal(p.name)
+		m := member(literal(string(key)), p.rule)
+
+		var next [2]int
+		for first := range 2 {
+			sep := comma
+			if first == 1 {
+				sep = nil
+			}
+
+			alts := [][]element{seq(sep, m, []element{ref(rest[0])})}
+			if !p.required {
+				alts = append(alts, []element{ref(rest[first])})
+			}
+			next[first] = c.b.rule(alts...)
+		}
+		rest = next
+	}
+
+	return c.b.rule(seq(literal("{"), ws, []element{ref(rest[1])}, literal("}"))), nil
+}
+
+func (c *schemaCompiler) array(s map[string]json.RawMessage, path string) (int, error) {
+	bound := func(k string) (int, error) {
+		r, ok := s[k]
+		if !ok {
+			return -1, nil
+		}
+
+		var n int
+		if err := json.Unmarshal(r, &n); err != nil || n < 0 {
+			return 0, &SchemaError{Path: path, Keyword: k, Reason: "must be a non-negative integer"}
+		}
+		return n, nil
+	}
+
+	minItems, err := bound("minItems")
+	if err != nil {
+		return 0, err
+	}
+	maxItems, err := bound("maxItems")
+	if err != nil {
+		return 0, err
+	}
+	minItems = max(minItems, 0)
+
+	if maxItems >= 0 && maxItems < minItems {
+		return 0, &SchemaError{Path: path, Keyword: "maxItems", Reason: "is less than minItems"}
+	}
+
+	ws := []element{c.ws()}
+	comma := seq(literal(","), ws)
+	open, closing := seq(literal("["), ws), literal("]")
+
+	items := -1
+	if r, ok := s["items"]; ok {
+		if string(bytes.TrimSpace(r)) != "false" {
+			rule, err := c.compile(r, path+"/items")
+			if err != nil {
+				return 0, err
+			}
+			items = rule
+		}
+	} else if _, ok := s["prefixItems"]; !ok {
+		items = c.anyValue()
+	}
+
+	if r, ok := s["prefixItems"]; ok {
+		if _, ok := s["minItems"]; ok {
+			return 0, &SchemaError{Path: path, Keyword: "minItems", Reason: "is not supported with prefixItems"}
+		}
+		if _, ok := s["maxItems"]; ok {
+			return 0, &SchemaError{Path: path, Keyword: "maxItems", Reason: "is not supported with prefixItems"}
+		}
+
+		var prefix []json.RawMessage
+		if err := json.Unmarshal(r, &prefix); err != nil {
+			return 0, &SchemaError{Path: path, Keyword: "prefixItems", Reason: "must be an array"}
+		}
+
+		body := open
+		for i, p := range prefix {
+			rule, err := c.compile(p, fmt.Sprintf("%s/prefixItems/%d", path, i))
+			if err != nil {
+				return 0, err
+			}
+
+			if i > 0 {
+				body = seq(body, comma)
+			}
+			body = seq(body, []element{ref(rule)}, ws)
+		}
+
+		if items >= 0 {
+			item := seq([]element{ref(items)}, ws)
+			if len(prefix) > 0 {
+				body = seq(body, c.b.repeat(seq(comma, item), 0, -1))
+			} else {
+				body = seq(body, []element{c.b.optional(seq(item, c.b.repeat(seq(comma, item), 0, -1)))})
+			}
+		}
+
+		return c.b.rule(seq(body, closing)), nil
+	}
+
+	if items < 0 || maxItems == 0 {
+		if minItems > 0 {
+			return 0, &SchemaError{Path: path, Keyword: "minItems", Reason: "requires items that are not allowed"}
+		}
+		return c.b.rule(seq(open, closing)), nil
+	}
+
+	item := seq([]element{ref(items)}, ws)
+	more := -1
+	if maxItems >= 0 {
+		more = maxItems - 1
+	}
+
+	list := seq(item, c.b.repeat(seq(comma, item), max(minItems-1, 0), more))
+	if minItems == 0 {
+		list = []element{c.b.optional(list)}
+	}
+
+	return c.b.rule(seq(open, list, closing)), nil
+}
+
+func (c *schemaCompiler) stringSchema(s map[string]json.RawMessage, path string) (int, error) {
+	quote := literal(`"`)
+
+	if r, ok := s["pattern"]; ok {
+		var pattern string
+		if err := json.Unmarshal(r, &pattern); err != nil {
+			return 0, &SchemaError{Path: path, Keyword: "pattern", Reason: "must be a string"}
+		}
+
+		rule, err := c.b.regex(pattern, stringChars)
+		if err != nil {
+			return 0, &SchemaError{Path: path, Keyword: "pattern", Reason: err.Error()}
+		}
+		return c.b.rule(seq(quote, []element{ref(rule)}, quote)), nil
+	}
+
+	if r, ok := s["format"]; ok {
+		var format string
+		if err := json.Unmarshal(r, &format); err != nil {
+			return 0, &SchemaError{Path: path, Keyword: "format", Reason: "must be a string"}
+		}
+
+		pattern, ok := formatPatterns[format]
+		if !ok {
+			return 0, &SchemaError{Path: path, Keyword: "format", Reason: fmt.Sprintf("%q is not supported", format)}
+		}
+
+		rule, err := c.b.regex(pattern, stringChars)
+		if err != nil {
+			return 0, err
+		}
+		return c.b.rule(seq(quote, []element{ref(rule)}, quote)), nil
+	}
+
+	length := func(k string) (int, error) {
+		r, ok := s[k]
+		if !ok {
+			return -1, nil
+		}
+
+		var n int
+		if err := json.Unmarshal(r, &n); err != nil || n < 0 {
+			return 0, &SchemaError{Path: path, Keyword: k, Reason: "must be a non-negative integer"}
+		}
+		return n, nil
+	}
+
+	minLength, err := length("minLength")
+	if err != nil {
+		return 0, err
+	}
+	maxLength, err := length("maxLength")
+	if err != nil {
+		return 0, err
+	}
+
+	if minLength < 0 && maxLength < 0 {
+		return c.anyString(), nil
+	}
+
+	if maxLength >= 0 && maxLength < max(minLength, 0) {
+		return 0, &SchemaError{Path: path, Keyword: "maxLength", Reason: "is less than minLength"}
+	}
+
+	return c.b.rule(seq(quote, c.b.repeat([]element{c.char()}, max(minLength, 0), maxLength), quote)), nil
+}
+
+// char matches one character of a JSON string, escaped or not
+func (c *schemaCompiler) char() element {
+	hex := chars(runeRange{'0', '9'}, runeRange{'a', 'f'}, runeRange{'A', 'F'})
+	escape := chars(runeRange{'"', '"'}, runeRange{'\\', '\\'}, runeRange{'/', '/'},
+		runeRange{'b', 'b'}, runeRange{'f', 'f'}, runeRange{'n', 'n'}, runeRange{'r', 'r'}, runeRange{'t', 't'})
+
+	return ref(c.b.rule(
+		[]element{chars(stringChars...)},
+		seq(literal(`\`), []element{escape}),
+		seq(literal(`\u`), []element{hex, hex, hex, hex}),
+	))
+}
+
+// anyString matches any JSON string
+func (c *schemaCompiler) anyString() int {
+	if c.str == 0 {
+		c.str = c.b.rule(seq(literal(`"`), c.b.repeat([]element{c.char()}, 0, -1), literal(`"`)))
+	}
+	return c.str
+}
+
+// integer matches a JSON integer of at most 16 digits
+func (c *schemaCompiler) integer() []element {
+	digit := []element{chars(runeRange{'0', '9'})}
+	nonzero := seq([]element{chars(runeRange{'1', '9'})}, c.b.repeat(digit, 0, 15))
+	return seq([]element{c.b.optional(literal("-"))}, []element{ref(c.b.rule(literal("0"), nonzero))})
+}
+
+// number matches a JSON number with at most 16 digits in each part
+func (c *schemaCompiler) number() []element {
+	digit := []element{chars(runeRange{'0', '9'})}
+	fraction := seq(literal("."), c.b.repeat(digit, 1, 16))
+	exponent := seq([]element{chars(runeRange{'e', 'e'}, runeRange{'E', 'E'})},
+		[]element{c.b.optional([]element{chars(runeRange{'+', '+'}, runeRange{'-', '-'})})},
+		c.b.repeat(digit, 1, 3))
+	return seq(c.integer(), []element{c.b.optional(fraction)}, []element{c.b.optional(exponent)})
+}
+
+// anyValue matches any JSON value
+func (c *schemaCompiler) anyValue() int {
+	if c.value != 0 {
+		return c.value
+	}
+
+	c.value = c.b.reserve()
+	ws := []element{c.ws()}
+	comma := seq(literal(","), ws)
+
+	member := seq([]element{ref(c.anyString())}, ws, literal(":"), ws, []element{ref(c.value)}, ws)
+	object := seq(literal("{"), ws, []element{c.b.optional(seq(member, c.b.repeat(seq(comma, member), 0, -1)))}, literal("}"))
+
+	item := seq([]element{ref(c.value)}, ws)
+	array := seq(literal("["), ws, []element{c.b.optional(seq(item, c.b.repeat(seq(comma, item), 0, -1)))}, literal("]"))
+
+	c.b.define(c.value,
+		object,
+		array,
+		[]element{ref(c.anyString())},
+		c.number(),
+		literal("true"),
+		literal("false"),
+		literal("null"),
+	)
+	return c.value
+}
+
+// jsonLiteral returns the compact JSON text of a value, with strings
+// escaped as json.Marshal would without HTML escaping
+func jsonLiteral(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", err
+		}
+
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(s); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(b.String(), "\n"), nil
+	}
+
+	var b bytes.Buffer
+	if err := json.Compact(&b, raw); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// objectKeys returns the keys of a JSON object in the order they appear
+func objectKeys(raw json.RawMessage) ([]string, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("not an object")
+	}
+
+	var keys []string
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, t.(string))
+
+		var skip json.RawMessage
+		if err := d.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
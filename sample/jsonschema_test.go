@@ -0,0 +1,172 @@
+package sample
+
+import (
+	"errors"
+	"testing"
+)
+
+// matches reports whether text is complete and valid for f
+func TestJSONSchema(t *testing.T) {
+	cases := []struct {
+		name    string
+		schema  string
+		valid   []string
+		invalid []string
+	}{
+		{
+			name:    "any",
+			schema:  `{}`,
+			valid:   []string{`1`, `"a"`, `{"a": [1, true, null]}`, ` [] `},
+			invalid: []string{``, `{`, `[1,]`, `tru`},
+		},
+		{
+			name:   "object",
+			schema: `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}, "required": ["name"]}`,
+			valid: []string{
+				`{"name": "a", "age": 3}`,
+				`{"name":"a"}`,
+				"{\n  \"name\": \"\\\"é\",\n  \"age\": -10\n}",
+			},
+			invalid: []string{
+				`{"age": 3}`,
+				`{"age": 3, "name": "a"}`,
+				`{"name": "a", "age": 3.5}`,
+				`{"name": "a", "other": 1}`,
+				`{"name": "a",}`,
+				`{"name": "a"} x`,
+			},
+		},
+		{
+			name:    "optional properties",
+			schema:  `{"properties": {"a": {"type": "null"}, "b": {"type": "null"}, "c": {"type": "null"}}, "required": ["c"]}`,
+			valid:   []string{`{"c": null}`, `{"a": null, "c": null}`, `{"b": null, "c": null}`, `{"a": null, "b": null, "c": null}`},
+			invalid: []string{`{}`, `{"a": null}`, `{, "c": null}`},
+		},
+		{
+			name:    "additional properties",
+			schema:  `{"properties": {"a": {"type": "boolean"}}, "additionalProperties": {"type": "number"}}`,
+			valid:   []string{`{}`, `{"a": true}`, `{"a": false, "x": 1.5e3}`, `{"x": 1, "y": 2}`},
+			invalid: []string{`{"x": "1"}`, `{"a": true "x": 1}`},
+		},
+		{
+			name:    "array bounds",
+			schema:  `{"type": "array", "items": {"type": "boolean"}, "minItems": 1, "maxItems": 2}`,
+			valid:   []string{`[true]`, `[true, false]`},
+			invalid: []string{`[]`, `[true, true, true]`, `[1]`},
+		},
+		{
+			name:    "prefix items",
+			schema:  `{"prefixItems": [{"type": "integer"}, {"type": "string"}]}`,
+			valid:   []string{`[1, "a"]`},
+			invalid: []string{`[1]`, `["a", 1]`, `[1, "a", 2]`},
+		},
+		{
+			name:    "string length",
+			schema:  `{"type": "string", "minLength": 2, "maxLength": 3}`,
+			valid:   []string{`"ab"`, `"a\nb"`, `"日本語"`},
+			invalid: []string{`"a"`, `"abcd"`},
+		},
+		{
+			name:    "pattern",
+			schema:  `{"type": "string", "pattern": "^[A-Z]{2}-\\d+$"}`,
+			valid:   []string{`"AB-1"`, `"XY-123"`},
+			invalid: []string{`"ab-1"`, `"AB-"`, `"AB-1x"`},
+		},
+		{
+			name:    "format",
+			schema:  `{"type": "string", "format": "date"}`,
+			valid:   []string{`"2024-02-29"`},
+			invalid: []string{`"2024-13-01"`, `"24-01-01"`},
+		},
+		{
+			name:    "enum of objects",
+			schema:  `{"enum": [{"b": 1, "a": [2]}, "x", null]}`,
+			valid:   []string{`{"b":1,"a":[2]}`, `"x"`, `null`},
+			invalid: []string{`{"a":[2],"b":1}`, `"y"`},
+		},
+		{
+			name:    "const",
+			schema:  `{"const": "<é>"}`,
+			valid:   []string{`"<é>"`},
+			invalid: []string{`"<e>"`, `"\u003cé>"`},
+		},
+		{
+			name:    "type array",
+			schema:  `{"type": ["number", "null"]}`,
+			valid:   []string{`-0.5`, `null`, `12E+3`},
+			invalid: []string{`01`, `"1"`, `1.`},
+		},
+		{
+			name:    "any of",
+			schema:  `{"anyOf": [{"type": "integer"}, {"type": "array", "items": {"type": "integer"}}]}`,
+			valid:   []string{`1`, `[1, 2]`},
+			invalid: []string{`[1, "2"]`},
+		},
+		{
+			name: "recursive ref",
+			schema: `{
+				"$defs": {"node": {"type": "object", "properties": {"value": {"type": "integer"}, "children": {"type": "array", "items": {"$ref": "#/$defs/node"}}}, "required": ["value"]}},
+				"allOf": [{"$ref": "#/$defs/node"}]
+			}`,
+			valid:   []string{`{"value": 1}`, `{"value": 1, "children": [{"value": 2, "children": []}, {"value": 3}]}`},
+			invalid: []string{`{"value": 1, "children": [{}]}`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := CompileJSONSchema([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.valid {
+				if !f.Match(s) {
+					t.Errorf("expected %q to match", s)
+				}
+			}
+
+			for _, s := range tt.invalid {
+				if f.Match(s) {
+					t.Errorf("expected %q not to match", s)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONSchemaErrors(t *testing.T) {
+	cases := []struct {
+		schema  string
+		path    string
+		keyword string
+	}{
+		{`{"type": "integer", "minimum": 0}`, "", "minimum"},
+		{`{"properties": {"a/b": {"not": {}}}}`, "/properties/a~1b", "not"},
+		{`{"items": {"uniqueItems": true}}`, "/items", "uniqueItems"},
+		{`{"allOf": [{}, {}]}`, "", "allOf"},
+		{`{"$ref": "https://example.com/schema"}`, "", "$ref"},
+		{`{"$ref": "#/$defs/missing"}`, "", "$ref"},
+		{`{"type": "string", "format": "email"}`, "", "format"},
+		{`{"type": "string", "pattern": "\\bword"}`, "", "pattern"},
+		{`{"type": "thing"}`, "", "type"},
+		{`{"required": ["a"]}`, "", "required"},
+		{`{"anyOf": [{"type": "string"}, {"type": "object", "patternProperties": {}}]}`, "/anyOf/1", "patternProperties"},
+		{`[]`, "", ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.schema, func(t *testing.T) {
+			_, err := CompileJSONSchema([]byte(tt.schema))
+
+			var serr *SchemaError
+			if !errors.As(err, &serr) {
+				t.Fatalf("expected a SchemaError, got %v", err)
+			}
+
+			if serr.Path != tt.path || serr.Keyword != tt.keyword {
+				t.Errorf("expected keyword %q at %q, got %q at %q: %v", tt.keyword, tt.path, serr.Keyword, serr.Path, err)
+			}
+		})
+	}
+}
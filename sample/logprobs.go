@@ -0,0 +1,45 @@
+package sample
+
+import (
+	"math"
+)
+
+// TokenLogprob is the log probability of a token
+type TokenLogprob struct {
+	ID      int32
+	Logprob float32
+}
+
+// Logprobs returns the log probability of id under the distribution the
+// model predicted, before any sampling transforms, and the n most likely
+// tokens in descending order of probability
+func Logprobs(logits []float32, id int32, n int) (TokenLogprob, []TokenLogprob) {
+	maxLogit := float32(math.Inf(-1))
+	for _, l := range logits {
+		maxLogit = max(maxLogit, l)
+	}
+
+	var sum float64
+	for _, l := range logits {
+		sum += math.Exp(float64(l - maxLogit))
+	}
+	logSum := maxLogit + float32(math.Log(sum))
+
+	chosen := TokenLogprob{ID: id, Logprob: logits[id] - logSum}
+	if n <= 0 {
+		return chosen, nil
+	}
+
+	tokens := make([]token, len(logits))
+	for i, l := range logits {
+		tokens[i] = token{id: int32(i), value: l}
+	}
+
+	tokens = topK(tokens, min(n, len(tokens)))
+	top := make([]TokenLogprob, len(tokens))
+	for i, t := range tokens {
+		top[i] = TokenLogprob{ID: t.id, Logprob: t.value - logSum}
+	}
+
+	return chosen, top
+}
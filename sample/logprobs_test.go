@@ -0,0 +1,47 @@
+package sample
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLogprobs(t *testing.T) {
+	logits := []float32{1, 3, 2, 3}
+
+	var sum float64
+	for _, l := range logits {
+		sum += math.Exp(float64(l))
+	}
+	want := func(id int) float32 {
+		return float32(float64(logits[id]) - math.Log(sum))
+	}
+
+	chosen, top := Logprobs(logits, 2, 0)
+	if chosen.ID != 2 || math.Abs(float64(chosen.Logprob-want(2))) > 1e-5 {
+		t.Errorf("unexpected logprob %+v, want %f", chosen, want(2))
+	}
+	if top != nil {
+		t.Errorf("expected no alternatives, got %v", top)
+	}
+
+	_, top = Logprobs(logits, 2, 3)
+	if len(top) != 3 {
+		t.Fatalf("expected 3 alternatives, got %v", top)
+	}
+	for i, tl := range top {
+		if math.Abs(float64(tl.Logprob-want(int(tl.ID)))) > 1e-5 {
+			t.Errorf("unexpected logprob %+v, want %f", tl, want(int(tl.ID)))
+		}
+		if i > 0 && tl.Logprob > top[i-1].Logprob {
+			t.Errorf("alternatives not in descending order: %v", top)
+		}
+	}
+	if top[2].ID != 2 {
+		t.Errorf("expected token 2 third, got %v", top)
+	}
+
+	// Asking for more alternatives than there are tokens returns them all
+	if _, top = Logprobs(logits, 0, 10); len(top) != len(logits) {
+		t.Errorf("expected %d alternatives, got %v", len(logits), top)
+	}
+}
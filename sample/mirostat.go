@@ -0,0 +1,89 @@
+package sample
+
+import (
+	"math"
+)
+
+// mirostatM is the number of most probable tokens Mirostat v1 uses to
+// estimate the Zipf exponent of the distribution
+const mirostatM = 100
+
+// mirostat holds the state of a Mirostat sampler, which adjusts how many
+// tokens are considered after every sample to keep the surprise of the
+// output close to tau
+type mirostat struct {
+	version int
+	tau     float32
+	eta     float32
+	// mu is the maximum surprise, updated after every sample
+	mu float32
+}
+
+func newMirostat(version int, tau, eta float32) *mirostat {
+	return &mirostat{
+		version: version,
+		tau:     tau,
+		eta:     eta,
+		mu:      2 * tau,
+	}
+}
+
+// truncate limits tokens to those Mirostat considers for the next sample.
+// requires ts to be probabilities sorted in descending order
+func (m *mirostat) truncate(ts []token) []token {
+	if m.version == 1 {
+		return m.truncateV1(ts)
+	}
+
+	return m.truncateV2(ts)
+}
+
+// truncateV1 keeps the top k tokens, with k estimated from the Zipf exponent
+// of the distribution and mu
+func (m *mirostat) truncateV1(ts []token) []token {
+	n := float64(len(ts))
+
+	var sumTiBi, sumTiSq float64
+	for i := range min(mirostatM, len(ts)) - 1 {
+		if ts[i+1].value <= 0 {
+			break
+		}
+
+		ti := math.Log(float64(i+2) / float64(i+1))
+		bi := math.Log(float64(ts[i].value / ts[i+1].value))
+		sumTiBi += ti * bi
+		sumTiSq += ti * ti
+	}
+
+	if sumTiSq == 0 {
+		return ts[:1]
+	}
+
+	sHat := sumTiBi / sumTiSq
+	epsilonHat := sHat - 1
+	k := math.Pow(epsilonHat*math.Pow(2, float64(m.mu))/(1-math.Pow(n, -epsilonHat)), 1/sHat)
+	if math.IsNaN(k) || k < 1 {
+		k = 1
+	}
+
+	return ts[:int(min(k, n))]
+}
+
+// truncateV2 keeps the tokens whose surprise is at most mu
+func (m *mirostat) truncateV2(ts []token) []token {
+	for i, t := range ts {
+		if i > 0 && -math.Log2(float64(t.value)) > float64(m.mu) {
+			return ts[:i]
+		}
+	}
+
+	return ts
+}
+
+// update moves mu by the error between the surprise of the sampled token
+// and tau. p is the probability of the sampled token among the tokens kept
+// by truncate.
+func (m *mirostat) update(p float32) {
+	surprise := float32(-math.Log2(float64(p)))
+	m.mu -= m.eta * (surprise - m.tau)
+}
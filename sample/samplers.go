@@ -16,13 +16,33 @@ type token struct {
 	value float32 // The raw logit or probability from the model
 }
 
+// tokenFilter masks the tokens that may not be sampled next and follows
+// the tokens that are, as Grammar and Constraint do
+type tokenFilter interface {
+	Apply([]token)
+	Accept(int32)
+}
+
 type Sampler struct {
 	rng         *rand.Rand
 	topK        int
 	topP        float32
 	minP        float32
 	temperature float32
-	grammar     *Grammar
+	typicalP    float32
+	grammar     tokenFilter
+
+	// mirostat replaces top-k, top-p, min-p and typical-p when set
+	mirostat *mirostat
+
+	// repeatLastN is how many recent tokens are penalized, 0 to disable
+	// penalties and negative for every token
+	repeatLastN      int
+	repeatPenalty    float32
+	presencePenalty  float32
+	frequencyPenalty float32
+	// history holds the last repeatLastN tokens passed to Accept
+	history []int32
 }
 
 func (s *Sampler) Sample(logits []float32) (int32, error) {
@@ -35,6 +55,7 @@ func (s *Sampler) Sample(logits []float32) (int32, error) {
 		tokens[i].id = int32(i)
 		tokens[i].value = logits[i]
 	}
+	s.penalize(tokens)
 
 	t, err := s.sample(tokens)
 	if err != nil {
@@ -48,6 +69,7 @@ func (s *Sampler) Sample(logits []float32) (int32, error) {
 		s.grammar.Apply(top)
 		if !math.IsInf(float64(top[0].value), -1) {
 			s.grammar.Accept(top[0].id)
+			s.update(t)
 			return top[0].id, nil
 		}
 
@@ -58,6 +80,7 @@ func (s *Sampler) Sample(logits []float32) (int32, error) {
 			tokens[i].id = int32(i)
 			tokens[i].value = logits[i]
 		}
+		s.penalize(tokens)
 		s.grammar.Apply(tokens)
 		t, err = s.sample(tokens)
 		if err != nil {
@@ -66,9 +89,88 @@ func (s *Sampler) Sample(logits []float32) (int32, error) {
 		s.grammar.Accept(t.id)
 	}
 
+	s.update(t)
 	return t.id, nil
 }
 
+// update adjusts stateful samplers to the token that was picked
+func (s *Sampler) update(t token) {
+	if s.mirostat != nil && s.temperature != 0 {
+		s.mirostat.update(t.value)
+	}
+}
+
+// SetPenalties discourages repeating any of the last lastN tokens passed to
+// Accept, or any token at all if lastN is negative. A repeat penalty of 1
+// with presence and frequency penalties of 0 leaves the logits unchanged.
+func (s *Sampler) SetPenalties(lastN int, repeat, presence, frequency float32) {
+	if repeat <= 0 {
+		repeat = 1
+	}
+
+	if repeat == 1 && presence == 0 && frequency == 0 {
+		lastN = 0
+	}
+
+	s.repeatLastN = lastN
+	s.repeatPenalty = repeat
+	s.presencePenalty = presence
+	s.frequencyPenalty = frequency
+	s.history = nil
+}
+
+// SetTypicalP keeps the locally typical tokens, those whose surprise is
+// closest to the entropy of the distribution, until their probabilities add
+// up to p. A p of 1 or more disables it.
+func (s *Sampler) SetTypicalP(p float32) {
+	if p <= 0 {
+		p = 1
+	}
+
+	s.typicalP = p
+}
+
+// SetMirostat samples with Mirostat version 1 or 2 instead of top-k, top-p,
+// min-p and typical-p. Mirostat targets a surprise of tau, adjusting at rate
+// eta after every sample. Version 0 disables it.
+func (s *Sampler) SetMirostat(version int, tau, eta float32) {
+	if version != 1 && version != 2 {
+		s.mirostat = nil
+		return
+	}
+
+	s.mirostat = newMirostat(version, tau, eta)
+}
+
+// SetConstraint restricts sampling to tokens allowed by c in place of any
+// grammar. A nil c removes the restriction.
+func (s *Sampler) SetConstraint(c *Constraint) {
+	s.grammar = nil
+	if c != nil {
+		s.grammar = c
+	}
+}
+
+// Accept adds a token of the sequence to the history used for penalties
+func (s *Sampler) Accept(token int32) {
+	if s.repeatLastN == 0 {
+		return
+	}
+
+	s.history = append(s.history, token)
+	if s.repeatLastN > 0 && len(s.history) > s.repeatLastN {
+		s.history = s.history[len(s.history)-s.repeatLastN:]
+	}
+}
+
+func (s *Sampler) penalize(tokens []token) {
+	if len(s.history) == 0 {
+		return
+	}
+
+	penalties(tokens, s.history, s.repeatPenalty, s.presencePenalty, s.frequencyPenalty)
+}
+
 // greedy returns the highest probability token from the tokens
 func greedy(tokens []token) token {
 	max := tokens[0]
@@ -88,6 +190,14 @@ func (s *Sampler) sample(tokens []token) (token, error) {
 		return greedy(tokens), nil
 	}
 
+	if s.mirostat != nil {
+		temperature(tokens, s.temperature)
+		tokens = topK(tokens, 0)
+		softmax(tokens)
+
+		return s.draw(s.mirostat.truncate(tokens))
+	}
+
 	// topK also sorts the tokens in descending order of logits
 	tokens = topK(tokens, s.topK)
 
@@ -95,9 +205,17 @@ func (s *Sampler) sample(tokens []token) (token, error) {
 	temperature(tokens, s.temperature)
 	softmax(tokens)
 
+	tokens = typicalP(tokens, s.typicalP)
 	tokens = topP(tokens, s.topP)
 	tokens = minP(tokens, s.minP)
 
+	return s.draw(tokens)
+}
+
+// draw picks a token at random in proportion to its probability. The
+// returned token holds its probability among tokens. It also has side
+// effects of modifying the tokens.
+func (s *Sampler) draw(tokens []token) (token, error) {
 	var r float32
 	if s.rng != nil {
 		r = s.rng.Float32()
@@ -123,7 +241,13 @@ func (s *Sampler) sample(tokens []token) (token, error) {
 	if math.IsNaN(float64(sum)) {
 		return token{}, errors.New("sample: logits sum to NaN, check model output")
 	}
-	return tokens[idx], nil
+
+	t := tokens[idx]
+	if idx > 0 {
+		t.value -= tokens[idx-1].value
+	}
+	t.value /= sum
+	return t, nil
 }
 
 // TODO(parthsareen): update sampler interface to use json unmarshal https://github.com/ollama/ollama/issues/9278
@@ -154,14 +278,20 @@ func NewSampler(temperature float32, topK int, topP float32, minP float32, seed
 		minP = 1.0
 	}
 
-	return Sampler{
+	s := Sampler{
 		rng:         rng,
 		topK:        topK,
 		topP:        topP,
 		minP:        minP,
 		temperature: temperature,
-		grammar:     grammar,
+		typicalP:    1.0,
 	}
+
+	if grammar != nil {
+		s.grammar = grammar
+	}
+
+	return s
 }
 
 type Grammar struct {
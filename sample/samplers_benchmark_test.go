@@ -90,3 +90,35 @@ func BenchmarkGreedySampler(b *testing.B) {
 		})
 	}
 }
+
+func BenchmarkStatefulSampler(b *testing.B) {
+	configs := []struct {
+		name     string
+		typicalP float32
+		mirostat int
+	}{
+		{"TypicalP", 0.9, 0},
+		{"MirostatV1", 1, 1},
+		{"MirostatV2", 1, 2},
+	}
+
+	// Fixed size for common vocab size
+	size := 128000
+	logits := make([]float32, size)
+	for i := range logits {
+		logits[i] = float32(rand.Float64()*10 - 5)
+	}
+
+	for _, tc := range configs {
+		b.Run(tc.name, func(b *testing.B) {
+			sampler := NewSampler(0.8, -1, 1, 0, 42, nil)
+			sampler.SetTypicalP(tc.typicalP)
+			sampler.SetMirostat(tc.mirostat, 5, 0.1)
+			b.ResetTimer()
+
+			for b.Loop() {
+				sampler.Sample(logits)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package sample
 
 import (
+	"fmt"
 	"math"
 	"math/rand/v2"
+	"slices"
 	"testing"
 )
 
@@ -78,3 +80,106 @@ func BenchmarkSample(b *testing.B) {
 		})
 	}
 }
+
+func TestSamplerPenalties(t *testing.T) {
+	logits := []float32{2, 1.9, 0, 0}
+
+	sampler := NewSampler(0, 0, 0, 0, 0, nil)
+	sampler.SetPenalties(2, 2, 0, 0)
+	sampler.Accept(0)
+
+	got, err := sampler.Sample(logits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Errorf("expected the repeated token to be penalized, got %d", got)
+	}
+
+	// Only the last two tokens are penalized
+	sampler.Accept(2)
+	sampler.Accept(3)
+	got, err = sampler.Sample(logits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0 {
+		t.Errorf("expected token 0 to leave the window, got %d", got)
+	}
+
+	// Neutral penalties keep no history
+	sampler.SetPenalties(64, 1, 0, 0)
+	sampler.Accept(0)
+	got, err = sampler.Sample(logits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 0 {
+		t.Errorf("expected no penalty, got %d", got)
+	}
+}
+
+func TestMirostat(t *testing.T) {
+	// Zipf-like logits, so the surprise of a sample depends on how many
+	// tokens are considered
+	logits := make([]float32, 1000)
+	for i := range logits {
+		logits[i] = float32(-math.Log(float64(i + 1)))
+	}
+
+	probs := toTokens(logits)
+	softmax(probs)
+
+	// run returns the sampled tokens and their average surprise in bits
+	run := func(version int, tau float32) ([]int32, float64) {
+		sampler := NewSampler(1, 0, 1, 0, 42, nil)
+		sampler.SetMirostat(version, tau, 0.1)
+
+		var ids []int32
+		var surprise float64
+		for range 500 {
+			id, err := sampler.Sample(logits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+			surprise -= math.Log2(float64(probs[id].value))
+		}
+		return ids, surprise / 500
+	}
+
+	for _, version := range []int{1, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			ids, low := run(version, 2)
+			if again, _ := run(version, 2); !slices.Equal(ids, again) {
+				t.Error("expected the same tokens with the same seed")
+			}
+
+			if _, high := run(version, 6); low >= high {
+				t.Errorf("expected a higher tau to be more surprising, got %f for tau 2 and %f for tau 6", low, high)
+			}
+		})
+	}
+}
+
+func TestMirostatTruncate(t *testing.T) {
+	tokens := toTokens([]float32{0.5, 0.25, 0.125, 0.0625, 0.0625})
+
+	m := newMirostat(2, 2, 0.1)
+	m.mu = 2
+	if got := m.truncate(slices.Clone(tokens)); len(got) != 2 {
+		t.Errorf("expected tokens with a surprise of at most 2 bits, got %d", len(got))
+	}
+
+	m.mu = 0
+	if got := m.truncate(slices.Clone(tokens)); len(got) != 1 {
+		t.Errorf("expected at least one token, got %d", len(got))
+	}
+
+	// Sampling a token more surprising than tau lowers mu
+	m.mu = 4
+	m.update(0.0625)
+	if m.mu != 3.8 {
+		t.Errorf("expected mu of 3.8, got %f", m.mu)
+	}
+}
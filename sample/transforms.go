@@ -1,6 +1,7 @@
 package sample
 
 import (
+	"cmp"
 	"container/heap"
 	"math"
 	"slices"
@@ -128,3 +129,87 @@ func minP(ts []token, p float32) []token {
 	}
 	return ts
 }
+
+// penalties discourages tokens that appear in history. Each repeated token's
+// logit is divided by repeat (multiplied if negative), then reduced by
+// presence once and by frequency for every occurrence.
+// requires ts to be indexed by token id
+func penalties(ts []token, history []int32, repeat, presence, frequency float32) {
+	counts := make(map[int32]int, len(history))
+	for _, id := range history {
+		counts[id]++
+	}
+
+	for id, count := range counts {
+		if id < 0 || int(id) >= len(ts) {
+			continue
+		}
+
+		t := &ts[id]
+		if t.value <= 0 {
+			t.value *= repeat
+		} else {
+			t.value /= repeat
+		}
+
+		t.value -= float32(count)*frequency + presence
+	}
+}
+
+// typicalP keeps the tokens whose surprise is closest to the entropy of the
+// distribution, until their probabilities add up to p. The kept tokens are
+// renormalized and sorted in descending order of probabilities again.
+// requires ts to be probabilities
+func typicalP(ts []token, p float32) []token {
+	if p >= 1.0 || len(ts) < 2 {
+		return ts
+	}
+
+	var entropy float64
+	for _, t := range ts {
+		if t.value > 0 {
+			entropy -= float64(t.value) * math.Log(float64(t.value))
+		}
+	}
+
+	// Order tokens by the distance of their surprise from the entropy
+	type shifted struct {
+		token
+		shift float64
+	}
+	ss := make([]shifted, len(ts))
+	for i, t := range ts {
+		ss[i] = shifted{t, math.Abs(-math.Log(float64(t.value)) - entropy)}
+	}
+	slices.SortStableFunc(ss, func(a, b shifted) int {
+		return cmp.Compare(a.shift, b.shift)
+	})
+
+	var sum float32
+	for i, s := range ss {
+		ts[i] = s.token
+		sum += s.value
+		if sum > p {
+			ts = ts[:i+1]
+			break
+		}
+	}
+
+	normalize(ts)
+	slices.SortStableFunc(ts, func(a, b token) int {
+		return cmp.Compare(b.value, a.value)
+	})
+	return ts
+}
+
+// normalize scales probabilities to add up to 1
+func normalize(ts []token) {
+	var sum float32
+	for _, t := range ts {
+		sum += t.value
+	}
+
+	for i := range ts {
+		ts[i].value /= sum
+	}
+}
@@ -295,6 +295,125 @@ func TestMinP(t *testing.T) {
 	}
 }
 
+func TestPenalties(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []float32
+		history   []int32
+		repeat    float32
+		presence  float32
+		frequency float32
+		expected  []float32
+	}{
+		{
+			name:     "no history",
+			input:    []float32{1, 2, -1, 0},
+			repeat:   1.5,
+			presence: 1,
+			expected: []float32{1, 2, -1, 0},
+		},
+		{
+			name:     "repeat positive and negative logits",
+			input:    []float32{3, 2, -1, 0},
+			history:  []int32{0, 2, 0},
+			repeat:   2,
+			expected: []float32{1.5, 2, -2, 0},
+		},
+		{
+			name:     "presence counts once",
+			input:    []float32{3, 2, -1, 0},
+			history:  []int32{1, 1, 1},
+			repeat:   1,
+			presence: 0.5,
+			expected: []float32{3, 1.5, -1, 0},
+		},
+		{
+			name:      "frequency counts every occurrence",
+			input:     []float32{3, 2, -1, 0},
+			history:   []int32{1, 1, 3},
+			repeat:    1,
+			frequency: 0.25,
+			expected:  []float32{3, 1.5, -1, -0.25},
+		},
+		{
+			name:      "combined",
+			input:     []float32{4, 2, -1, 0},
+			history:   []int32{0, 0, 2},
+			repeat:    2,
+			presence:  0.5,
+			frequency: 0.25,
+			expected:  []float32{1, 2, -2.75, 0},
+		},
+		{
+			name:     "ids outside the vocabulary",
+			input:    []float32{1, 2},
+			history:  []int32{-1, 5},
+			repeat:   2,
+			expected: []float32{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := toTokens(tt.input)
+			penalties(tokens, tt.history, tt.repeat, tt.presence, tt.frequency)
+			compareLogits(t, tt.name, tt.expected, tokens)
+		})
+	}
+}
+
+func TestTypicalP(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []float32
+		p        float32
+		ids      []int32
+		expected []float32
+	}{
+		{
+			name:     "disabled",
+			input:    []float32{0.5, 0.2, 0.15, 0.1, 0.05},
+			p:        1,
+			ids:      []int32{0, 1, 2, 3, 4},
+			expected: []float32{0.5, 0.2, 0.15, 0.1, 0.05},
+		},
+		{
+			name:     "most typical tokens first",
+			input:    []float32{0.5, 0.2, 0.15, 0.1, 0.05},
+			p:        0.3,
+			ids:      []int32{1, 2},
+			expected: []float32{0.571429, 0.428571},
+		},
+		{
+			name:     "drops the least typical tokens",
+			input:    []float32{0.5, 0.2, 0.15, 0.1, 0.05},
+			p:        0.9,
+			ids:      []int32{0, 1, 2, 3},
+			expected: []float32{0.526316, 0.210526, 0.157895, 0.105263},
+		},
+		{
+			name:     "single token",
+			input:    []float32{1},
+			p:        0.5,
+			ids:      []int32{0},
+			expected: []float32{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := typicalP(toTokens(tt.input), tt.p)
+			compareLogits(t, tt.name, tt.expected, tokens)
+
+			for i, id := range tt.ids {
+				if i < len(tokens) && tokens[i].id != id {
+					t.Errorf("index %d: want id %d, got %d", i, id, tokens[i].id)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkTransforms(b *testing.B) {
 	// Generate random logits
 	tokens := make([]token, 1<<16)
@@ -347,6 +466,15 @@ func BenchmarkTransforms(b *testing.B) {
 		}
 	})
 
+	b.Run("TypicalP", func(b *testing.B) {
+		b.ResetTimer()
+		for b.Loop() {
+			copy(tokensCopy, tokens)
+			softmax(tokensCopy)
+			tokens = typicalP(tokensCopy, 0.9)
+		}
+	})
+
 	b.Run("SortTokens", func(b *testing.B) {
 		b.ResetTimer()
 		for b.Loop() {
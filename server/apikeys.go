@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/openai"
+	"github.com/ollama/ollama/types/model"
+)
+
+// apiScope is a set of routes an API key may use
+type apiScope string
+
+const (
+	// scopeInference covers generating, embedding and tokenizing with models
+	// and listing them
+	scopeInference apiScope = "inference"
+	// scopeManage covers pulling, pushing, creating, copying and deleting
+	// models and the model catalog
+	scopeManage apiScope = "manage"
+	// scopeAdmin covers updates and metrics, and includes the other scopes
+	scopeAdmin apiScope = "admin"
+)
+
+var errModelForbidden = errors.New("API key may not use model")
+
+// apiKey is an entry of the API keys file
+type apiKey struct {
+	// ID names the key in logs and limits; it is not a secret
+	ID string `json:"id"`
+	// Key is the bearer token, or SHA256 is the hex digest of it
+	Key    string `json:"key,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	Scopes []apiScope `json:"scopes"`
+	// Models are patterns of the model names the key may use, as for
+	// path.Match. A pattern without a tag matches every tag of the models it
+	// matches. No patterns allow every model.
+	Models []string `json:"models,omitempty"`
+
+	// RequestsPerMinute and TokensPerDay replace the default limits of
+	// clients if set
+	RequestsPerMinute uint `json:"requests_per_minute,omitempty"`
+	TokensPerDay      uint `json:"tokens_per_day,omitempty"`
+}
+
+// apiKeys are the keys the server accepts, by the digest of their token
+type apiKeys map[[sha256.Size]byte]*apiKey
+
+// loadAPIKeys reads the API keys file at path. It returns nil keys, which
+// disable authentication, if path is empty.
+func loadAPIKeys(path string) (apiKeys, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f struct {
+		Keys []*apiKey `json:"keys"`
+	}
+	if err := json.Unmarshal(bts, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(f.Keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+
+	keys := make(apiKeys)
+	ids := make(map[string]bool)
+	for _, k := range f.Keys {
+		if err := k.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if ids[k.ID] {
+			return nil, fmt.Errorf("%s: duplicate key id %q", path, k.ID)
+		}
+		ids[k.ID] = true
+
+		digest := sha256.Sum256([]byte(k.Key))
+		if k.SHA256 != "" {
+			hex.Decode(digest[:], []byte(k.SHA256))
+		}
+
+		if _, ok := keys[digest]; ok {
+			return nil, fmt.Errorf("%s: key %q is the same as another key", path, k.ID)
+		}
+		keys[digest] = k
+	}
+
+	return keys, nil
+}
+
+func (k *apiKey) validate() error {
+	if k.ID == "" {
+		return errors.New("key without an id")
+	}
+
+	switch {
+	case k.Key == "" && k.SHA256 == "":
+		return fmt.Errorf("key %q: key or sha256 is required", k.ID)
+	case k.Key != "" && k.SHA256 != "":
+		return fmt.Errorf("key %q: only one of key and sha256 may be set", k.ID)
+	case k.SHA256 != "":
+		if b, err := hex.DecodeString(k.SHA256); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("key %q: sha256 must be %d hex digits", k.ID, 2*sha256.Size)
+		}
+	}
+
+	if len(k.Scopes) == 0 {
+		return fmt.Errorf("key %q: no scopes", k.ID)
+	}
+
+	for _, s := range k.Scopes {
+		if !slices.Contains([]apiScope{scopeInference, scopeManage, scopeAdmin}, s) {
+			return fmt.Errorf("key %q: invalid scope %q; expected inference, manage or admin", k.ID, s)
+		}
+	}
+
+	for _, p := range k.Models {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("key %q: invalid model pattern %q", k.ID, p)
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the key with the bearer token, or nil if there is none
+func (keys apiKeys) lookup(token string) *apiKey {
+	return keys[sha256.Sum256([]byte(token))]
+}
+
+func (k *apiKey) hasScope(s apiScope) bool {
+	return slices.Contains(k.Scopes, s) || slices.Contains(k.Scopes, scopeAdmin)
+}
+
+func (k *apiKey) allowsModel(n model.Name) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+
+	name := strings.ToLower(n.DisplayShortest())
+	untagged := strings.TrimSuffix(name, ":"+strings.ToLower(n.Tag))
+	for _, p := range k.Models {
+		p = strings.ToLower(p)
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+
+		if !strings.Contains(path.Base(p), ":") {
+			if ok, _ := path.Match(p, untagged); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type apiKeyKey struct{}
+
+// apiKeyFrom returns the key a request was made with, or nil if
+// authentication is disabled
+func apiKeyFrom(ctx context.Context) *apiKey {
+	k, _ := ctx.Value(apiKeyKey{}).(*apiKey)
+	return k
+}
+
+// clientID identifies the client of a request to fair queuing and rate
+// limits: its API key if it has one and its address otherwise. Bearer tokens
+// that are not keys are ignored, since a client can make up a new one for
+// every request.
+func clientID(c *gin.Context) string {
+	if k := apiKeyFrom(c.Request.Context()); k != nil {
+		return "key:" + k.ID
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+// checkModel returns errModelForbidden if the request's API key may not use
+// the model n
+func checkModel(ctx context.Context, n model.Name) error {
+	if k := apiKeyFrom(ctx); k != nil && !k.allowsModel(n) {
+		return fmt.Errorf("%w %q", errModelForbidden, n.DisplayShortest())
+	}
+
+	return nil
+}
+
+// allowModel writes a forbidden response and returns false if the request's
+// API key may not use the model n
+func allowModel(c *gin.Context, n model.Name) bool {
+	if err := checkModel(c.Request.Context(), n); err != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// requireScope returns a middleware that authenticates requests by their
+// bearer token and lets them through if the key has scope. It lets every
+// request through if authentication is disabled.
+func (s *Server) requireScope(scope apiScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.keys == nil {
+			c.Next()
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			abortAuth(c, http.StatusUnauthorized, "API key required")
+			return
+		}
+
+		k := s.keys.lookup(token)
+		if k == nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			abortAuth(c, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+
+		if !k.hasScope(scope) {
+			abortAuth(c, http.StatusForbidden, fmt.Sprintf("API key lacks the %s scope", scope))
+			return
+		}
+
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), apiKeyKey{}, k))
+		c.Next()
+	}
+}
+
+// abortAuth writes an authentication error in the shape of the route's API
+func abortAuth(c *gin.Context, code int, message string) {
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/") {
+		c.AbortWithStatusJSON(code, openai.NewError(code, message))
+		return
+	}
+
+	c.AbortWithStatusJSON(code, gin.H{"error": message})
+}
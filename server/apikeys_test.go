@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/openai"
+	"github.com/ollama/ollama/types/model"
+)
+
+func writeAPIKeys(t *testing.T, keys string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(p, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	digest := sha256.Sum256([]byte("hashed"))
+
+	cases := []struct {
+		name string
+		keys string
+		err  bool
+	}{
+		{"valid", `{"keys": [{"id": "a", "key": "secret", "scopes": ["inference"], "models": ["llama3*"]}, {"id": "b", "sha256": "` + hex.EncodeToString(digest[:]) + `", "scopes": ["admin"]}]}`, false},
+		{"no keys", `{"keys": []}`, true},
+		{"no id", `{"keys": [{"key": "secret", "scopes": ["inference"]}]}`, true},
+		{"no token", `{"keys": [{"id": "a", "scopes": ["inference"]}]}`, true},
+		{"key and sha256", `{"keys": [{"id": "a", "key": "secret", "sha256": "` + hex.EncodeToString(digest[:]) + `", "scopes": ["inference"]}]}`, true},
+		{"short sha256", `{"keys": [{"id": "a", "sha256": "abcd", "scopes": ["inference"]}]}`, true},
+		{"no scopes", `{"keys": [{"id": "a", "key": "secret"}]}`, true},
+		{"invalid scope", `{"keys": [{"id": "a", "key": "secret", "scopes": ["root"]}]}`, true},
+		{"invalid pattern", `{"keys": [{"id": "a", "key": "secret", "scopes": ["inference"], "models": ["llama["]}]}`, true},
+		{"duplicate id", `{"keys": [{"id": "a", "key": "x", "scopes": ["inference"]}, {"id": "a", "key": "y", "scopes": ["inference"]}]}`, true},
+		{"duplicate key", `{"keys": [{"id": "a", "key": "hashed", "scopes": ["inference"]}, {"id": "b", "sha256": "` + hex.EncodeToString(digest[:]) + `", "scopes": ["inference"]}]}`, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := loadAPIKeys(writeAPIKeys(t, tt.keys))
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if k := keys.lookup("secret"); k == nil || k.ID != "a" {
+				t.Errorf("expected key a, got %v", k)
+			}
+
+			if k := keys.lookup("hashed"); k == nil || k.ID != "b" {
+				t.Errorf("expected key b, got %v", k)
+			}
+
+			if k := keys.lookup("other"); k != nil {
+				t.Errorf("expected no key, got %v", k)
+			}
+		})
+	}
+
+	if keys, err := loadAPIKeys(""); keys != nil || err != nil {
+		t.Errorf("expected no keys without a file, got %v, %v", keys, err)
+	}
+}
+
+func TestAPIKeyAllowsModel(t *testing.T) {
+	k := apiKey{Models: []string{"llama3", "mistral:7b", "qwen*", "myorg/*"}}
+
+	cases := map[string]bool{
+		"llama3":                               true,
+		"llama3:70b":                           true,
+		"Llama3:latest":                        true,
+		"llama3.1":                             false,
+		"mistral:7b":                           true,
+		"mistral":                              false,
+		"qwen2.5:0.5b":                         true,
+		"myorg/model:v1":                       true,
+		"otherorg/model":                       false,
+		"example.com/myorg/model":              false,
+		"registry.ollama.ai/library/llama3:8b": true,
+	}
+
+	for name, want := range cases {
+		if got := k.allowsModel(model.ParseName(name)); got != want {
+			t.Errorf("%s: expected %t, got %t", name, want, got)
+		}
+	}
+
+	if !(&apiKey{}).allowsModel(model.ParseName("anything")) {
+		t.Error("expected a key without patterns to allow every model")
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys, err := loadAPIKeys(writeAPIKeys(t, `{"keys": [
+		{"id": "user", "key": "user-key", "scopes": ["inference"], "models": ["allowed"]},
+		{"id": "ops", "key": "ops-key", "scopes": ["admin"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := Server{keys: keys, sched: &Scheduler{}}
+	for _, n := range []string{"allowed", "denied"} {
+		_, digest := createBinFile(t, nil, nil)
+		createRequest(t, s.CreateHandler, api.CreateRequest{Model: n, Files: map[string]string{"test.gguf": digest}})
+	}
+
+	h, err := s.GenerateRoutes(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path, key string, body any) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&b).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r := httptest.NewRequest(method, path, &b)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		body   any
+		status int
+	}{
+		{"public", http.MethodGet, "/api/version", "", nil, http.StatusOK},
+		{"no key", http.MethodGet, "/api/tags", "", nil, http.StatusUnauthorized},
+		{"invalid key", http.MethodGet, "/api/tags", "other-key", nil, http.StatusUnauthorized},
+		{"scope", http.MethodGet, "/api/tags", "user-key", nil, http.StatusOK},
+		{"missing scope", http.MethodDelete, "/api/delete", "user-key", api.DeleteRequest{Model: "allowed"}, http.StatusForbidden},
+		{"admin", http.MethodGet, "/metrics", "ops-key", nil, http.StatusOK},
+		{"allowed model", http.MethodPost, "/api/show", "user-key", api.ShowRequest{Model: "allowed"}, http.StatusOK},
+		{"denied model", http.MethodPost, "/api/show", "user-key", api.ShowRequest{Model: "denied"}, http.StatusForbidden},
+		{"denied runner", http.MethodPost, "/api/embed", "user-key", api.EmbedRequest{Model: "denied"}, http.StatusForbidden},
+		{"admin model", http.MethodPost, "/api/show", "ops-key", api.ShowRequest{Model: "denied"}, http.StatusOK},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(tt.method, tt.path, tt.key, tt.body); w.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("list", func(t *testing.T) {
+		var resp api.ListResponse
+		if err := json.NewDecoder(do(http.MethodGet, "/api/tags", "user-key", nil).Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, m := range resp.Models {
+			names = append(names, m.Name)
+		}
+
+		if !slices.Equal(names, []string{"allowed:latest"}) {
+			t.Errorf("expected only the allowed model, got %v", names)
+		}
+	})
+
+	t.Run("openai", func(t *testing.T) {
+		w := do(http.MethodGet, "/v1/models", "other-key", nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status 401, got %d", w.Code)
+		}
+
+		var resp openai.ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Error.Type != "authentication_error" || resp.Error.Message != "invalid API key" {
+			t.Errorf("unexpected error %+v", resp.Error)
+		}
+	})
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/types/model"
+)
+
+const (
+	// auditLogMaxSize is the size at which the audit log is rotated
+	auditLogMaxSize = 10 * format.MebiByte
+	// auditLogFiles is the number of rotated audit logs kept
+	auditLogFiles = 5
+)
+
+// auditOperations are the model management operations that are audited
+var auditOperations = []string{"pull", "push", "delete", "copy", "create"}
+
+// auditLog is an append-only log of model management operations, one JSON
+// entry per line. When it grows past maxSize it is renamed to path.1,
+// shifting older logs up to path.5.
+type auditLog struct {
+	path    string
+	maxSize int64
+
+	mu sync.Mutex
+}
+
+func (l *auditLog) append(e api.AuditEntry) error {
+	bts, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	bts = append(bts, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(l.path); err == nil && fi.Size() > 0 && fi.Size()+int64(len(bts)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(bts); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// file returns the name of the log rotated i times
+func (l *auditLog) file(i int) string {
+	if i == 0 {
+		return l.path
+	}
+
+	return l.path + "." + strconv.Itoa(i)
+}
+
+// rotate shifts each log to the next name, dropping the oldest. l.mu must
+// be held.
+func (l *auditLog) rotate() error {
+	for i := auditLogFiles - 1; i >= 0; i-- {
+		if err := os.Rename(l.file(i), l.file(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tail returns the last n entries that match, oldest first
+func (l *auditLog) tail(n int, match func(api.AuditEntry) bool) ([]api.AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []api.AuditEntry
+	for i := 0; i <= auditLogFiles && len(entries) < n; i++ {
+		bts, err := os.ReadFile(l.file(i))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		var matched []api.AuditEntry
+		for line := range bytes.Lines(bts) {
+			var e api.AuditEntry
+			if err := json.Unmarshal(line, &e); err != nil {
+				// skip a line left partly written by a crash
+				continue
+			}
+
+			if match(e) {
+				matched = append(matched, e)
+			}
+		}
+
+		entries = append(matched, entries...)
+	}
+
+	return entries[max(0, len(entries)-n):], nil
+}
+
+// auditRecord is an operation being audited
+type auditRecord struct {
+	log   *auditLog
+	name  model.Name
+	entry api.AuditEntry
+}
+
+// audit starts recording the operation op of the request c on the model n,
+// made from the model or file source if it is set. It returns nil, whose
+// methods do nothing, if the audit log is disabled.
+func (s *Server) audit(c *gin.Context, op string, n model.Name, source string) *auditRecord {
+	if s.auditLog == nil {
+		return nil
+	}
+
+	r := &auditRecord{
+		log:  s.auditLog,
+		name: n,
+		entry: api.AuditEntry{
+			Client:    c.ClientIP(),
+			Operation: op,
+			Model:     n.DisplayShortest(),
+			Source:    source,
+		},
+	}
+
+	if k := apiKeyFrom(c.Request.Context()); k != nil {
+		r.entry.KeyID = k.ID
+	}
+
+	// the digest of a deleted model is the one it had before
+	if m, err := ParseNamedManifest(n); err == nil {
+		r.entry.Digest = m.digest
+	}
+
+	return r
+}
+
+// done appends the entry of the operation to the audit log with its outcome
+func (r *auditRecord) done(err error) {
+	if r == nil {
+		return
+	}
+
+	e := r.entry
+	e.Time = time.Now().UTC()
+	e.Outcome = "success"
+	if err != nil {
+		e.Outcome = "error"
+		e.Error = err.Error()
+	} else if e.Operation != "delete" {
+		if m, err := ParseNamedManifest(r.name); err == nil {
+			e.Digest = m.digest
+		}
+	}
+
+	if err := r.log.append(e); err != nil {
+		slog.Error("failed to write audit log", "error", err)
+	}
+}
+
+// stream passes the progress of a streaming operation from ch to the client
+// and records its outcome once ch is closed. Progress the client is no
+// longer there to read is dropped.
+func (r *auditRecord) stream(ctx context.Context, ch chan any) chan any {
+	if r == nil {
+		return ch
+	}
+
+	out := make(chan any)
+	go func() {
+		defer close(out)
+
+		var err error
+		var success bool
+		for resp := range ch {
+			switch resp := resp.(type) {
+			case gin.H:
+				if msg, ok := resp["error"].(string); ok {
+					err = errors.New(msg)
+				}
+			case api.ProgressResponse:
+				success = success || resp.Status == "success"
+			}
+
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+			}
+		}
+
+		if err == nil && !success {
+			err = cmp.Or(ctx.Err(), errors.New("operation did not finish"))
+		}
+
+		r.done(err)
+	}()
+
+	return out
+}
+
+func (s *Server) AuditHandler(c *gin.Context) {
+	if s.auditLog == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "audit log is not enabled"})
+		return
+	}
+
+	n := 100
+	if q := c.Query("n"); q != "" {
+		var err error
+		if n, err = strconv.Atoi(q); err != nil || n <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid n %q", q)})
+			return
+		}
+	}
+
+	op := c.Query("operation")
+	if op != "" && !slices.Contains(auditOperations, op) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid operation %q", op)})
+		return
+	}
+
+	var name model.Name
+	if q := c.Query("model"); q != "" {
+		if name = model.ParseName(q); !name.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid model %q", q)})
+			return
+		}
+	}
+
+	entries, err := s.auditLog.tail(n, func(e api.AuditEntry) bool {
+		if op != "" && e.Operation != op {
+			return false
+		}
+
+		if name.IsValid() && !model.ParseName(e.Model).EqualFold(name) && !model.ParseName(e.Source).EqualFold(name) {
+			return false
+		}
+
+		return true
+	})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if entries == nil {
+		entries = []api.AuditEntry{}
+	}
+
+	c.JSON(http.StatusOK, api.AuditResponse{Entries: entries})
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestAuditLogRotate(t *testing.T) {
+	l := &auditLog{path: filepath.Join(t.TempDir(), "audit.jsonl"), maxSize: 300}
+
+	// each entry is about 100 bytes, so the log rotates every few entries
+	var models []string
+	for i := range 40 {
+		models = append(models, string(rune('a'+i%26))+string(rune('a'+i/26)))
+		if err := l.append(api.AuditEntry{Operation: "pull", Model: models[i], Outcome: "success"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i <= auditLogFiles+1; i++ {
+		_, err := os.Stat(l.file(i))
+		if exists := i <= auditLogFiles; exists != (err == nil) {
+			t.Errorf("%s: expected exists %t, got error %v", l.file(i), exists, err)
+		}
+	}
+
+	entries, err := l.tail(5, func(api.AuditEntry) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Model)
+	}
+
+	if !slices.Equal(got, models[35:]) {
+		t.Errorf("expected %v, got %v", models[35:], got)
+	}
+
+	// older entries are dropped with the oldest log
+	entries, err = l.tail(len(models), func(api.AuditEntry) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) == 0 || len(entries) == len(models) || entries[len(entries)-1].Model != models[len(models)-1] {
+		t.Errorf("expected the newest entries of the kept logs, got %d ending with %v", len(entries), entries[len(entries)-1])
+	}
+}
+
+func TestAuditHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := Server{auditLog: &auditLog{path: filepath.Join(t.TempDir(), "audit.jsonl"), maxSize: auditLogMaxSize}}
+
+	_, digest := createBinFile(t, nil, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{Model: "test", Files: map[string]string{"test.gguf": digest}}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if w := createRequest(t, s.CopyHandler, api.CopyRequest{Source: "test", Destination: "test-copy"}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if w := createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "test-copy"}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if w := createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "test-copy"}); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+
+	h, err := s.GenerateRoutes(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audit := func(query string) []api.AuditEntry {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/audit"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+
+		var resp api.AuditResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Entries
+	}
+
+	entries := audit("")
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+
+	want := []struct{ operation, model, source, outcome string }{
+		{"create", "test:latest", "", "success"},
+		{"copy", "test-copy:latest", "test:latest", "success"},
+		{"delete", "test-copy:latest", "", "success"},
+		{"delete", "test-copy:latest", "", "error"},
+	}
+	for i, e := range entries {
+		w := want[i]
+		if e.Operation != w.operation || e.Model != w.model || e.Source != w.source || e.Outcome != w.outcome {
+			t.Errorf("entry %d: expected %v, got %+v", i, w, e)
+		}
+
+		if e.Time.IsZero() {
+			t.Errorf("entry %d: expected a time, got %+v", i, e)
+		}
+	}
+
+	// the digest of the copy is the one it had when it was deleted
+	if entries[0].Digest == "" || entries[1].Digest != entries[0].Digest || entries[2].Digest != entries[0].Digest {
+		t.Errorf("unexpected digests %q, %q, %q", entries[0].Digest, entries[1].Digest, entries[2].Digest)
+	}
+
+	if got := audit("?model=test"); len(got) != 2 || got[0].Operation != "create" || got[1].Operation != "copy" {
+		t.Errorf("expected the create and copy of test, got %+v", got)
+	}
+
+	if got := audit("?operation=delete&n=1"); len(got) != 1 || got[0].Outcome != "error" {
+		t.Errorf("expected the last delete, got %+v", got)
+	}
+
+	for _, query := range []string{"?n=0", "?operation=rename", "?model=a%20b"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/audit"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, w.Code)
+		}
+	}
+}
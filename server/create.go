@@ -67,6 +67,16 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		return
 	}
 
+	if !allowModel(c, name) {
+		return
+	}
+
+	if from := model.ParseName(r.From); from.IsValid() && !allowModel(c, from) {
+		return
+	}
+
+	rec := s.audit(c, "create", name, r.From)
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -146,12 +156,13 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		ch <- api.ProgressResponse{Status: "success"}
 	}()
 
+	out := rec.stream(c.Request.Context(), ch)
 	if r.Stream != nil && !*r.Stream {
-		waitForStream(c, ch)
+		waitForStream(c, out)
 		return
 	}
 
-	streamResponse(c, ch)
+	streamResponse(c, out)
 }
 
 func convertModelFromFiles(files map[string]string, baseLayers []*layerGGML, isAdapter bool, fn func(resp api.ProgressResponse)) ([]*layerGGML, error) {
@@ -117,6 +117,7 @@ func (p *blobDownloadPart) StopsAt() int64 {
 func (p *blobDownloadPart) Write(b []byte) (n int, err error) {
 	n = len(b)
 	p.blobDownload.Completed.Add(int64(n))
+	transferBytesTotal.add(float64(n), "download")
 	p.lastUpdatedMu.Lock()
 	p.lastUpdated = time.Now()
 	p.lastUpdatedMu.Unlock()
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// metricFamily is a metric with its series for each set of label values,
+// written in the Prometheus text exposition format
+type metricFamily struct {
+	name, help string
+	// kind is "counter", "gauge" or "histogram"
+	kind   string
+	labels []string
+	// buckets are the upper bounds of a histogram's buckets, ascending
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+
+	// value is the value of a counter or gauge
+	value float64
+
+	// counts, sum and count are the observations of a histogram, where
+	// counts[i] is the number in buckets[i] but not an earlier bucket
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newCounter(name, help string, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, kind: "counter", labels: labels}
+}
+
+func newGauge(name, help string, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, kind: "gauge", labels: labels}
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets}
+}
+
+// get returns the series for values, adding it if it is new. f.mu must be
+// held.
+func (f *metricFamily) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", f.name, len(values), len(f.labels)))
+	}
+
+	if f.series == nil {
+		f.series = make(map[string]*series)
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: values}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// add adds v to a counter or gauge
+func (f *metricFamily) add(v float64, values ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(values).value += v
+}
+
+// set sets a gauge to v
+func (f *metricFamily) set(v float64, values ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(values).value = v
+}
+
+// observe adds an observation of v to a histogram
+func (f *metricFamily) observe(v float64, values ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.get(values)
+	if i, _ := slices.BinarySearch(f.buckets, v); i < len(f.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (f *metricFamily) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		s := f.series[k]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.values), formatValue(s.value))
+			continue
+		}
+
+		labels := append(slices.Clone(f.labels), "le")
+		var cumulative uint64
+		for i, le := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(labels, append(slices.Clone(s.values), formatValue(le))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(labels, append(slices.Clone(s.values), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.values), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.values), s.count)
+	}
+}
+
+func formatLabels(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(l)
+		sb.WriteString(`="`)
+		sb.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+	loadBuckets     = []float64{.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+	rateBuckets     = []float64{1, 5, 10, 20, 30, 50, 75, 100, 150, 200, 500}
+)
+
+// metrics recorded as they happen; the state of the scheduler is read when
+// metrics are written instead
+var (
+	requestsTotal   = newCounter("ollama_requests_total", "Requests handled, by route, model and status code.", "route", "model", "status")
+	requestDuration = newHistogram("ollama_request_duration_seconds", "Time to handle a request, including streaming its response.", durationBuckets, "route")
+
+	runnerLoadsTotal     = newCounter("ollama_runner_loads_total", "Runners loaded, by model and whether the load succeeded.", "model", "status")
+	runnerLoadDuration   = newHistogram("ollama_runner_load_duration_seconds", "Time to start a runner until it is ready.", loadBuckets, "model")
+	runnerUnloadsTotal   = newCounter("ollama_runner_unloads_total", "Runners unloaded, by model.", "model")
+	runnerUnloadDuration = newHistogram("ollama_runner_unload_duration_seconds", "Time to stop a runner until its memory is freed.", loadBuckets, "model")
+
+	promptTokensTotal   = newCounter("ollama_prompt_tokens_total", "Prompt tokens evaluated.", "model")
+	promptSecondsTotal  = newCounter("ollama_prompt_eval_seconds_total", "Time spent evaluating prompts.", "model")
+	evalTokensTotal     = newCounter("ollama_eval_tokens_total", "Tokens generated.", "model")
+	evalSecondsTotal    = newCounter("ollama_eval_seconds_total", "Time spent generating tokens.", "model")
+	evalTokensPerSecond = newHistogram("ollama_eval_tokens_per_second", "Rate of token generation of each completion.", rateBuckets, "model")
+	timeToFirstToken    = newHistogram("ollama_time_to_first_token_seconds", "Time from receiving a completion request to its first token, including any model load.", durationBuckets, "model")
+	transferBytesTotal  = newCounter("ollama_transfer_bytes_total", "Bytes of model blobs transferred to or from a registry, by direction.", "direction")
+
+	recordedMetrics = []*metricFamily{
+		requestsTotal, requestDuration,
+		runnerLoadsTotal, runnerLoadDuration, runnerUnloadsTotal, runnerUnloadDuration,
+		promptTokensTotal, promptSecondsTotal, evalTokensTotal, evalSecondsTotal, evalTokensPerSecond, timeToFirstToken,
+		transferBytesTotal,
+	}
+)
+
+// modelLabel is the name of m in metrics
+func modelLabel(m *Model) string {
+	if m == nil {
+		return ""
+	}
+
+	if m.ShortName != "" {
+		return m.ShortName
+	}
+	return m.Name
+}
+
+type metricsKey struct{}
+
+// requestInfo holds what handlers learn about a request that is recorded
+// with it, such as the model it is for
+type requestInfo struct {
+	mu    sync.Mutex
+	model string
+}
+
+// setRequestModel records the model a request is for
+func setRequestModel(ctx context.Context, model string) {
+	if info, ok := ctx.Value(metricsKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.model = model
+		info.mu.Unlock()
+	}
+}
+
+// metricsMiddleware counts requests and times them
+func metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	info := &requestInfo{}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), metricsKey{}, info))
+
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+
+	info.mu.Lock()
+	model := info.model
+	info.mu.Unlock()
+
+	requestsTotal.add(1, route, model, strconv.Itoa(c.Writer.Status()))
+	requestDuration.observe(time.Since(start).Seconds(), route)
+}
+
+// completionMetrics records the metrics of a completion as its responses
+// arrive
+type completionMetrics struct {
+	model string
+	start time.Time
+	first bool
+}
+
+func newCompletionMetrics(m *Model, start time.Time) *completionMetrics {
+	return &completionMetrics{model: modelLabel(m), start: start}
+}
+
+func (cm *completionMetrics) observe(r llm.CompletionResponse) {
+	if !cm.first && (r.Content != "" || r.Done) {
+		cm.first = true
+		timeToFirstToken.observe(time.Since(cm.start).Seconds(), cm.model)
+	}
+
+	if !r.Done {
+		return
+	}
+
+	promptTokensTotal.add(float64(r.PromptEvalCount), cm.model)
+	promptSecondsTotal.add(r.PromptEvalDuration.Seconds(), cm.model)
+	evalTokensTotal.add(float64(r.EvalCount), cm.model)
+	evalSecondsTotal.add(r.EvalDuration.Seconds(), cm.model)
+	if r.EvalDuration > 0 {
+		evalTokensPerSecond.observe(float64(r.EvalCount)/r.EvalDuration.Seconds(), cm.model)
+	}
+}
+
+// writeMetrics writes the state of the scheduler and the recorded metrics
+func (s *Scheduler) writeMetrics(w io.Writer) {
+	queued := newGauge("ollama_queue_pending_requests", "Requests waiting for a runner, by priority class.", "class")
+	for _, class := range []requestClass{classInteractive, classBatch, classBackground} {
+		queued.set(float64(s.queue.count(class)), class.String())
+	}
+
+	loaded := newGauge("ollama_runners_loaded", "Runners loaded or loading.")
+	refs := newGauge("ollama_runner_requests", "Requests using each runner.", "model")
+	vram := newGauge("ollama_runner_vram_bytes", "Estimated VRAM used by each runner, by GPU.", "model", "gpu")
+	size := newGauge("ollama_runner_size_bytes", "Estimated memory used by each runner.", "model")
+
+	s.loadedMu.Lock()
+	loaded.set(float64(len(s.loaded)))
+	for _, r := range s.loaded {
+		r.refMu.Lock()
+		if r.llama != nil {
+			name := modelLabel(r.model)
+			refs.set(float64(r.refCount), name)
+			size.set(float64(r.estimatedTotal), name)
+			for _, gpu := range r.gpus {
+				vram.set(float64(r.llama.EstimatedVRAMByGPU(gpu.ID)), name, gpu.Library+":"+gpu.ID)
+			}
+		}
+		r.refMu.Unlock()
+	}
+	s.loadedMu.Unlock()
+
+	for _, f := range append([]*metricFamily{queued, loaded, refs, vram, size}, recordedMetrics...) {
+		f.write(w)
+	}
+}
+
+func (s *Server) MetricsHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := bufio.NewWriter(c.Writer)
+	s.sched.writeMetrics(w)
+	w.Flush()
+}
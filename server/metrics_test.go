@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestMetricFamilyWrite(t *testing.T) {
+	c := newCounter("test_total", "A counter.", "model")
+	c.add(1, "b")
+	c.add(2, `a "quoted"`+"\n")
+	c.add(0.5, "b")
+
+	h := newHistogram("test_seconds", "A histogram.", []float64{1, 5}, "model")
+	h.observe(0.5, "a")
+	h.observe(1, "a")
+	h.observe(3, "a")
+	h.observe(10, "a")
+
+	var sb strings.Builder
+	c.write(&sb)
+	h.write(&sb)
+
+	want := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{model="a \"quoted\"\n"} 2
+test_total{model="b"} 1.5
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{model="a",le="1"} 2
+test_seconds_bucket{model="a",le="5"} 3
+test_seconds_bucket{model="a",le="+Inf"} 4
+test_seconds_sum{model="a"} 14.5
+test_seconds_count{model="a"} 4
+`
+	if got := sb.String(); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := Server{sched: &Scheduler{loaded: map[string]*runnerRef{
+		"path": {
+			model:          &Model{ShortName: "llama3:latest"},
+			llama:          &mockLlm{estimatedVRAMByGPU: map[string]uint64{"0": 1024, "1": 2048}},
+			gpus:           discover.GpuInfoList{{Library: "cuda", ID: "0"}, {Library: "cuda", ID: "1"}},
+			estimatedTotal: 4096,
+			refCount:       2,
+		},
+	}}}
+
+	for _, class := range []requestClass{classBatch, classBatch, classBackground} {
+		if err := s.sched.queue.push(&LlmRequest{ctx: ctx, flow: flow{class: class}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cm := newCompletionMetrics(&Model{ShortName: "metrics-test:latest"}, time.Now())
+	cm.observe(llm.CompletionResponse{Content: "Hi"})
+	cm.observe(llm.CompletionResponse{Done: true, PromptEvalCount: 10, EvalCount: 50, EvalDuration: 2 * time.Second})
+
+	r := gin.New()
+	r.Use(metricsMiddleware)
+	r.GET("/metrics", s.MetricsHandler)
+	r.POST("/test/metrics", func(c *gin.Context) {
+		setRequestModel(c.Request.Context(), "metrics-test:latest")
+		c.Status(http.StatusTeapot)
+	})
+	r.POST("/api/push", s.PushHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test/metrics", nil))
+
+	// a model that does not exist is not a label
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/push", strings.NewReader(`{"model": "made-up-metrics-model", "stream": false}`)))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	body := w.Body.String()
+	if strings.Contains(body, "made-up-metrics-model") {
+		t.Errorf("expected no series for a model that does not exist:\n%s", body)
+	}
+
+	for _, line := range []string{
+		`ollama_queue_pending_requests{class="interactive"} 0`,
+		`ollama_queue_pending_requests{class="batch"} 2`,
+		`ollama_queue_pending_requests{class="background"} 1`,
+		`ollama_runners_loaded 1`,
+		`ollama_runner_requests{model="llama3:latest"} 2`,
+		`ollama_runner_size_bytes{model="llama3:latest"} 4096`,
+		`ollama_runner_vram_bytes{model="llama3:latest",gpu="cuda:0"} 1024`,
+		`ollama_runner_vram_bytes{model="llama3:latest",gpu="cuda:1"} 2048`,
+		`ollama_requests_total{route="/test/metrics",model="metrics-test:latest",status="418"} 1`,
+		`ollama_requests_total{route="/api/push",model="",status="500"} 1`,
+		`ollama_prompt_tokens_total{model="metrics-test:latest"} 10`,
+		`ollama_eval_tokens_total{model="metrics-test:latest"} 50`,
+		`ollama_eval_tokens_per_second_bucket{model="metrics-test:latest",le="30"} 1`,
+		`ollama_time_to_first_token_seconds_count{model="metrics-test:latest"} 1`,
+		`# TYPE ollama_transfer_bytes_total counter`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in metrics:\n%s", line, body)
+		}
+	}
+}
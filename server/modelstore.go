@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	modelmanager "github.com/ollama/ollama/model_manager"
+	"github.com/ollama/ollama/types/model"
+)
+
+// ModelStore is a modelmanager.Backend over the manifests and blobs under
+// envconfig.Models(), so the catalog sees the same models as /api/tags.
+// Catalog entries are pulled from the registry by name.
+type ModelStore struct {
+	// Insecure allows pulling from registries over plain HTTP
+	Insecure bool
+}
+
+var (
+	_ modelmanager.Backend         = (*ModelStore)(nil)
+	_ modelmanager.MetadataBackend = (*ModelStore)(nil)
+)
+
+// catalogModelName parses the name of a catalog entry. It does not look up
+// the name of an installed model that differs only in case, since that scans
+// every manifest and Stat is called for each entry of every catalog refresh.
+func catalogModelName(m modelmanager.Model) (model.Name, error) {
+	n := model.ParseName(m.Name)
+	if !n.IsValid() {
+		return n, fmt.Errorf("name %q is invalid", m.Name)
+	}
+
+	return n, nil
+}
+
+func (s *ModelStore) Stat(m modelmanager.Model) (bool, string, error) {
+	n, err := catalogModelName(m)
+	if err != nil {
+		return false, "", err
+	}
+
+	mf, err := ParseNamedManifest(n)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, "", nil
+	} else if err != nil {
+		return false, "", err
+	}
+
+	return true, mf.filepath, nil
+}
+
+func (s *ModelStore) Pull(ctx context.Context, m modelmanager.Model, fn func(modelmanager.Progress)) error {
+	n, err := catalogModelName(m)
+	if err != nil {
+		return err
+	}
+
+	// PullModel reports progress per layer, the catalog wants the total
+	var mu sync.Mutex
+	layers := make(map[string]api.ProgressResponse)
+	report := func(resp api.ProgressResponse) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Other statuses such as "pulling manifest" keep the current state
+		var p modelmanager.Progress
+		switch {
+		case resp.Digest != "":
+			layers[resp.Digest] = resp
+			p.Status = "downloading"
+			for _, l := range layers {
+				p.Completed += l.Completed
+				p.Total += l.Total
+			}
+		case strings.HasPrefix(resp.Status, "verifying"):
+			p.Status = "verifying"
+		}
+
+		fn(p)
+	}
+
+	return PullModel(ctx, n.DisplayShortest(), &registryOptions{Insecure: s.Insecure}, report)
+}
+
+func (s *ModelStore) Remove(m modelmanager.Model) error {
+	n, err := catalogModelName(m)
+	if err != nil {
+		return err
+	}
+
+	return deleteModel(n)
+}
+
+// Metadata reads the GGUF header of an installed model from its blob, or of
+// a model that has not been pulled from the start of its blob in the
+// registry
+func (s *ModelStore) Metadata(ctx context.Context, m modelmanager.Model) (*ggml.GGML, error) {
+	n, err := catalogModelName(m)
+	if err != nil {
+		return nil, err
+	}
+
+	mf, err := ParseNamedManifest(n)
+	if err == nil {
+		for _, layer := range mf.Layers {
+			if layer.MediaType != "application/vnd.ollama.image.model" {
+				continue
+			}
+
+			p, err := GetBlobsPath(layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			return llm.LoadModel(p, 0)
+		}
+
+		return nil, fmt.Errorf("%s has no model layer", n.DisplayShortest())
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	regOpts := &registryOptions{Insecure: s.Insecure}
+	mp := ParseModelPath(n.DisplayShortest())
+	remote, err := pullModelManifest(ctx, mp, regOpts)
+	if err != nil {
+		return nil, fmt.Errorf("pull model manifest: %w", err)
+	}
+
+	for _, layer := range remote.Layers {
+		if layer.MediaType != "application/vnd.ollama.image.model" {
+			continue
+		}
+
+		requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "blobs", layer.Digest)
+		return modelmanager.ReadMetadata(func(size int64) ([]byte, error) {
+			headers := make(http.Header)
+			headers.Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+
+			resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			return io.ReadAll(io.LimitReader(resp.Body, size))
+		})
+	}
+
+	return nil, fmt.Errorf("%s has no model layer", n.DisplayShortest())
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	modelmanager "github.com/ollama/ollama/model_manager"
+)
+
+func TestModelStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "test",
+		Files: map[string]string{"test.gguf": digest},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var store ModelStore
+
+	installed, location, err := store.Stat(modelmanager.Model{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !installed {
+		t.Fatal("expected test to be installed")
+	}
+	if want := filepath.Join(p, "manifests", "registry.ollama.ai", "library", "test", "latest"); location != want {
+		t.Fatalf("expected location %s, got %s", want, location)
+	}
+
+	installed, _, err = store.Stat(modelmanager.Model{Name: "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installed {
+		t.Fatal("expected missing to not be installed")
+	}
+
+	if _, _, err := store.Stat(modelmanager.Model{Name: "bad name!"}); err == nil {
+		t.Fatal("expected error for invalid name")
+	}
+
+	mm, err := modelmanager.NewWithBackend(p, &store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded := mm.GetDownloadedModels()
+	if len(downloaded) != 0 {
+		t.Fatalf("expected no downloaded catalog models, got %v", downloaded)
+	}
+
+	if err := mm.LoadModels(writeCatalog(t, `[{"name":"test"},{"name":"other"}]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded = mm.GetDownloadedModels()
+	if len(downloaded) != 1 || downloaded[0].Name != "test" {
+		t.Fatalf("expected test to be downloaded, got %v", downloaded)
+	}
+
+	if err := mm.RemoveModel("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), []string{})
+	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{})
+
+	if downloaded := mm.GetDownloadedModels(); len(downloaded) != 0 {
+		t.Fatalf("expected no downloaded models after remove, got %v", downloaded)
+	}
+
+	if err := mm.RemoveModel("other"); err == nil {
+		t.Fatal("expected error removing a model that is not installed")
+	}
+}
+
+func writeCatalog(t *testing.T, s string) string {
+	t.Helper()
+
+	p := filepath.Join(t.TempDir(), "models.json")
+	if err := os.WriteFile(p, []byte(s), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// requestClass is the priority of a request waiting for a runner. Requests
+// of each class take a share of the scheduler in proportion to its weight,
+// so interactive requests go ahead of a backlog of batch requests without
+// starving it.
+type requestClass int
+
+const (
+	classInteractive requestClass = iota
+	classBatch
+	classBackground
+)
+
+// priorityHeader sets the class of a request
+const priorityHeader = "X-Ollama-Priority"
+
+var classNames = []string{"interactive", "batch", "background"}
+
+func (c requestClass) String() string {
+	return classNames[c]
+}
+
+func parseRequestClass(s string) (requestClass, error) {
+	if s == "" {
+		return classInteractive, nil
+	}
+
+	i := slices.Index(classNames, strings.ToLower(s))
+	if i < 0 {
+		return 0, fmt.Errorf("invalid priority %q; expected one of %s", s, strings.Join(classNames, ", "))
+	}
+
+	return requestClass(i), nil
+}
+
+// weight is the share of the scheduler the class gets when requests of
+// several classes are waiting
+func (c requestClass) weight() float64 {
+	switch c {
+	case classInteractive:
+		return 16
+	case classBatch:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// maxQueue is the most requests of the class that may wait at once
+func (c requestClass) maxQueue() int {
+	switch c {
+	case classBatch:
+		return int(envconfig.MaxQueueBatch())
+	case classBackground:
+		return int(envconfig.MaxQueueBackground())
+	default:
+		return int(envconfig.MaxQueue())
+	}
+}
+
+type queueKey struct{}
+
+// flow is the class and client of a request. Requests of a flow are
+// scheduled in order, and flows of a class share it fairly.
+type flow struct {
+	class  requestClass
+	client string
+}
+
+// withFlow returns a context whose requests are queued in f
+func withFlow(ctx context.Context, f flow) context.Context {
+	return context.WithValue(ctx, queueKey{}, f)
+}
+
+func flowFrom(ctx context.Context) flow {
+	f, _ := ctx.Value(queueKey{}).(flow)
+	return f
+}
+
+// flowMiddleware sets the flow of each request from its priority header and
+// its client. It must run after requireScope so the client is the request's
+// API key when it has one.
+func flowMiddleware(c *gin.Context) {
+	class, err := parseRequestClass(c.GetHeader(priorityHeader))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Request = c.Request.WithContext(withFlow(c.Request.Context(), flow{class: class, client: clientID(c)}))
+	c.Next()
+}
+
+// setRequestPriority sets the class of a request from the priority field of
+// its body, which handlers only see once they have bound it. The priority
+// header takes precedence.
+func setRequestPriority(c *gin.Context, priority string) error {
+	if priority == "" || c.GetHeader(priorityHeader) != "" {
+		return nil
+	}
+
+	class, err := parseRequestClass(priority)
+	if err != nil {
+		return err
+	}
+
+	f := flowFrom(c.Request.Context())
+	f.class = class
+	c.Request = c.Request.WithContext(withFlow(c.Request.Context(), f))
+	return nil
+}
+
+// pendingQueue holds the requests waiting for a runner. It orders them by
+// start-time fair queuing: each request is tagged with the virtual time its
+// flow would start it, one request later than the previous request of the
+// flow, where a request costs the inverse of its class's weight. The request
+// with the earliest tag goes first, so a flow that has queued many requests
+// only delays others by its share. The zero value is an empty queue.
+type pendingQueue struct {
+	mu   sync.Mutex
+	reqs []*LlmRequest
+
+	// vtime is the tag of the last request taken from the queue
+	vtime float64
+	// finish is the tag after the last request of each flow
+	finish map[flow]float64
+	seq    uint64
+
+	// changed is signaled when a request is added
+	changed chan struct{}
+}
+
+// push adds req to the queue, or returns ErrMaxQueue if its class has as
+// many requests waiting as it may
+func (q *pendingQueue) push(req *LlmRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.dropCanceled()
+
+	if q.countLocked(req.flow.class) >= req.flow.class.maxQueue() {
+		return ErrMaxQueue
+	}
+
+	q.add(req)
+	return nil
+}
+
+// requeue adds req back to the queue, behind the requests of its flow,
+// regardless of limits
+func (q *pendingQueue) requeue(req *LlmRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.add(req)
+}
+
+func (q *pendingQueue) add(req *LlmRequest) {
+	if q.finish == nil {
+		q.finish = make(map[flow]float64)
+	}
+
+	req.start = max(q.vtime, q.finish[req.flow])
+	req.seq = q.seq
+	q.seq++
+	q.finish[req.flow] = req.start + 1/req.flow.class.weight()
+	q.reqs = append(q.reqs, req)
+
+	select {
+	case q.signal() <- struct{}{}:
+	default:
+	}
+}
+
+// peek returns the request to schedule next, or nil if there is none. Ties
+// go to the higher class and then to the earlier request.
+func (q *pendingQueue) peek() *LlmRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.dropCanceled()
+
+	var next *LlmRequest
+	for _, r := range q.reqs {
+		if next == nil ||
+			r.start < next.start ||
+			r.start == next.start && (r.flow.class < next.flow.class ||
+				r.flow.class == next.flow.class && r.seq < next.seq) {
+			next = r
+		}
+	}
+
+	return next
+}
+
+// remove takes req from the queue once it is scheduled
+func (q *pendingQueue) remove(req *LlmRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.reqs = slices.DeleteFunc(q.reqs, func(r *LlmRequest) bool { return r == req })
+	q.vtime = max(q.vtime, req.start)
+
+	// flows with nothing left before the virtual time start from it anyway
+	for f, finish := range q.finish {
+		if finish <= q.vtime {
+			delete(q.finish, f)
+		}
+	}
+}
+
+// dropCanceled removes requests whose context is done, which no longer need
+// a runner or count toward the limits
+func (q *pendingQueue) dropCanceled() {
+	q.reqs = slices.DeleteFunc(q.reqs, func(r *LlmRequest) bool { return r.ctx.Err() != nil })
+}
+
+// Len returns the number of requests waiting
+func (q *pendingQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.reqs)
+}
+
+// count returns the number of requests of class waiting
+func (q *pendingQueue) count(class requestClass) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.countLocked(class)
+}
+
+func (q *pendingQueue) countLocked(class requestClass) int {
+	var n int
+	for _, r := range q.reqs {
+		if r.flow.class == class && r.ctx.Err() == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// signal returns the channel signaled when a request is added. q.mu must be
+// held.
+func (q *pendingQueue) signal() chan struct{} {
+	if q.changed == nil {
+		q.changed = make(chan struct{}, 1)
+	}
+	return q.changed
+}
+
+// wait returns a channel that receives once a request has been added since
+// the last receive
+func (q *pendingQueue) wait() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.signal()
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/openai"
+)
+
+const (
+	// requestPeriod is the period of request limits
+	requestPeriod = time.Minute
+	// tokenPeriod is the period of token quotas
+	tokenPeriod = 24 * time.Hour
+
+	// rateLimitsSaveInterval is how often changed usage is saved
+	rateLimitsSaveInterval = 30 * time.Second
+)
+
+// bucket is a token bucket that holds up to a limit and refills by the limit
+// each period. It is overdrawn if a request uses more than it holds.
+type bucket struct {
+	Level   float64   `json:"level"`
+	Updated time.Time `json:"updated"`
+}
+
+// refill adds what the bucket has gained since it was last updated. A new
+// bucket starts full.
+func (b *bucket) refill(now time.Time, limit float64, period time.Duration) {
+	if b.Updated.IsZero() {
+		b.Level = limit
+	} else if elapsed := now.Sub(b.Updated); elapsed > 0 {
+		b.Level = min(limit, b.Level+limit*elapsed.Seconds()/period.Seconds())
+	}
+
+	b.Updated = now
+}
+
+// wait returns how long until the bucket holds n
+func (b *bucket) wait(n, limit float64, period time.Duration) time.Duration {
+	if b.Level >= n {
+		return 0
+	}
+
+	return time.Duration((n - b.Level) / limit * float64(period))
+}
+
+// usage is what a client has left of its limits
+type usage struct {
+	Requests bucket `json:"requests"`
+	Tokens   bucket `json:"tokens"`
+}
+
+// rateLimits tracks the usage of each client, which is its API key if it has
+// one and its address otherwise. Clients without a key cannot choose a new
+// client by sending another bearer token.
+type rateLimits struct {
+	// path is the file usage is saved to
+	path string
+
+	mu      sync.Mutex
+	clients map[string]*usage
+	// dirty is set when usage changes after it is saved
+	dirty bool
+}
+
+// loadRateLimits reads the usage saved at path. It starts with no usage if
+// the file does not exist.
+func loadRateLimits(path string) (*rateLimits, error) {
+	l := &rateLimits{path: path, clients: make(map[string]*usage)}
+
+	bts, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &l.clients); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return l, nil
+}
+
+// initRateLimits loads the usage saved in $HOME/.ollama and saves it as it
+// changes until ctx is done
+func (s *Server) initRateLimits(ctx context.Context) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(home, ".ollama", "ratelimits.json")
+	l, err := loadRateLimits(path)
+	if err != nil {
+		slog.Warn("discarding saved rate limits", "error", err)
+		l = &rateLimits{path: path, clients: make(map[string]*usage)}
+	}
+
+	go func() {
+		ticker := time.NewTicker(rateLimitsSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := l.save(); err != nil {
+					slog.Warn("failed to save rate limits", "error", err)
+				}
+			}
+		}
+	}()
+
+	s.limits = l
+	return nil
+}
+
+// save writes usage to the file if it changed. Clients whose buckets have
+// had a full period to refill are dropped.
+func (l *rateLimits) save() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.dirty {
+		return nil
+	}
+
+	for client, u := range l.clients {
+		if time.Since(u.Requests.Updated) > tokenPeriod && time.Since(u.Tokens.Updated) > tokenPeriod {
+			delete(l.clients, client)
+		}
+	}
+
+	bts, err := json.Marshal(l.clients)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(bts); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), l.path); err != nil {
+		return err
+	}
+
+	l.dirty = false
+	return nil
+}
+
+// usage returns the usage of client. l.mu must be held.
+func (l *rateLimits) usage(client string) *usage {
+	u, ok := l.clients[client]
+	if !ok {
+		u = &usage{}
+		l.clients[client] = u
+	}
+
+	return u
+}
+
+// clientLimits returns the client of a request and its requests per minute
+// and tokens per day, where zero is unlimited. A key's own limits replace
+// the defaults.
+func clientLimits(c *gin.Context) (client string, requests, tokens float64) {
+	requests, tokens = float64(envconfig.RateLimit()), float64(envconfig.TokenQuota())
+
+	client = clientID(c)
+
+	k := apiKeyFrom(c.Request.Context())
+	if k == nil {
+		return client, requests, tokens
+	}
+
+	if k.RequestsPerMinute > 0 {
+		requests = float64(k.RequestsPerMinute)
+	}
+
+	if k.TokensPerDay > 0 {
+		tokens = float64(k.TokensPerDay)
+	}
+
+	return client, requests, tokens
+}
+
+type limitsKey struct{}
+
+// limitedClient is the client a request's tokens are counted against
+type limitedClient struct {
+	limits *rateLimits
+	client string
+	tokens float64
+}
+
+// limitRequests takes a request from the client's request limit and lets it
+// through if it has requests and tokens left
+func (s *Server) limitRequests(c *gin.Context) {
+	l := s.limits
+	if l == nil {
+		return
+	}
+
+	client, requestLimit, tokenLimit := clientLimits(c)
+	if requestLimit == 0 && tokenLimit == 0 {
+		return
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	u := l.usage(client)
+
+	var limited string
+	var retry time.Duration
+	if requestLimit > 0 {
+		u.Requests.refill(now, requestLimit, requestPeriod)
+		if retry = u.Requests.wait(1, requestLimit, requestPeriod); retry > 0 {
+			limited = "requests"
+		}
+	}
+
+	if tokenLimit > 0 {
+		u.Tokens.refill(now, tokenLimit, tokenPeriod)
+		if wait := u.Tokens.wait(1, tokenLimit, tokenPeriod); wait > 0 && limited == "" {
+			limited, retry = "tokens", wait
+		}
+	}
+
+	if requestLimit > 0 && limited == "" {
+		u.Requests.Level--
+	}
+	l.dirty = true
+
+	if requestLimit > 0 {
+		setRateLimitHeaders(c, "Requests", u.Requests, requestLimit, requestPeriod)
+	}
+
+	if tokenLimit > 0 {
+		setRateLimitHeaders(c, "Tokens", u.Tokens, tokenLimit, tokenPeriod)
+	}
+	l.mu.Unlock()
+
+	if limited != "" {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		message := fmt.Sprintf("rate limit exceeded for %s, retry in %s", limited, retry.Round(time.Second))
+		if strings.HasPrefix(c.Request.URL.Path, "/v1/") {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, openai.NewRateLimitError(limited, message))
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": message})
+		return
+	}
+
+	if tokenLimit > 0 {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), limitsKey{}, &limitedClient{l, client, tokenLimit}))
+	}
+}
+
+// setRateLimitHeaders reports what is left of a limit and how long until it
+// is reset to full
+func setRateLimitHeaders(c *gin.Context, kind string, b bucket, limit float64, period time.Duration) {
+	c.Header("X-RateLimit-Limit-"+kind, strconv.FormatFloat(limit, 'f', 0, 64))
+	c.Header("X-RateLimit-Remaining-"+kind, strconv.FormatFloat(max(0, math.Floor(b.Level)), 'f', 0, 64))
+	c.Header("X-RateLimit-Reset-"+kind, b.wait(limit, limit, period).Round(time.Millisecond).String())
+}
+
+// countsTokens reports whether the tokens of a request are counted against
+// a token quota, so handlers only count tokens that are not otherwise known
+// when they are needed
+func countsTokens(ctx context.Context) bool {
+	_, ok := ctx.Value(limitsKey{}).(*limitedClient)
+	return ok
+}
+
+// recordUsage counts the prompt and completion tokens of a request against
+// its client's token quota
+func recordUsage(ctx context.Context, tokens int) {
+	lc, ok := ctx.Value(limitsKey{}).(*limitedClient)
+	if !ok {
+		return
+	}
+
+	lc.limits.mu.Lock()
+	defer lc.limits.mu.Unlock()
+
+	u := lc.limits.usage(lc.client)
+	u.Tokens.refill(time.Now(), lc.tokens, tokenPeriod)
+	u.Tokens.Level -= float64(tokens)
+	lc.limits.dirty = true
+}
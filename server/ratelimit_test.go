@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/openai"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+
+	var b bucket
+	b.refill(now, 60, time.Minute)
+	if b.Level != 60 {
+		t.Fatalf("expected a new bucket to be full, got %v", b.Level)
+	}
+
+	b.Level = -30
+	if wait := b.wait(1, 60, time.Minute); wait != 31*time.Second {
+		t.Errorf("expected to wait 31s, got %v", wait)
+	}
+
+	b.refill(now.Add(10*time.Second), 60, time.Minute)
+	if b.Level != -20 {
+		t.Errorf("expected level -20, got %v", b.Level)
+	}
+
+	b.refill(now.Add(time.Hour), 60, time.Minute)
+	if b.Level != 60 {
+		t.Errorf("expected the bucket to refill to its limit, got %v", b.Level)
+	}
+}
+
+func TestLimitRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_RATE_LIMIT", "2")
+	t.Setenv("OLLAMA_TOKEN_QUOTA", "100")
+
+	path := filepath.Join(t.TempDir(), "ratelimits.json")
+	limits, err := loadRateLimits(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := Server{limits: limits}
+	r := gin.New()
+	g := r.Group("", s.requireScope(scopeInference), s.limitRequests)
+	g.POST("/api/generate", func(c *gin.Context) {
+		recordUsage(c.Request.Context(), 150)
+		c.Status(http.StatusOK)
+	})
+	g.GET("/v1/models", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(method, path, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodGet, "/v1/models", "10.0.0.1:1234")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	for header, want := range map[string]string{
+		"X-RateLimit-Limit-Requests":     "2",
+		"X-RateLimit-Remaining-Requests": "1",
+		"X-RateLimit-Reset-Requests":     "30s",
+		"X-RateLimit-Limit-Tokens":       "100",
+		"X-RateLimit-Remaining-Tokens":   "100",
+		"X-RateLimit-Reset-Tokens":       "0s",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("expected %s %q, got %q", header, want, got)
+		}
+	}
+
+	// the request goes over the token quota, which stops the next one
+	if w := do(http.MethodPost, "/api/generate", "10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	w = do(http.MethodGet, "/v1/models", "10.0.0.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After")
+	}
+
+	var resp openai.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Error.Type != "requests" || resp.Error.Code == nil || *resp.Error.Code != "rate_limit_exceeded" {
+		t.Errorf("unexpected error %+v", resp.Error)
+	}
+
+	// another client has its own limits
+	if w := do(http.MethodGet, "/v1/models", "10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	// the quota is still overdrawn once requests refill
+	limits.mu.Lock()
+	limits.clients["ip:10.0.0.1"].Requests.Level = 2
+	limits.mu.Unlock()
+
+	w = do(http.MethodPost, "/api/generate", "10.0.0.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+
+	var native struct{ Error string }
+	if err := json.NewDecoder(w.Body).Decode(&native); err != nil {
+		t.Fatal(err)
+	}
+
+	if native.Error == "" || w.Header().Get("X-RateLimit-Remaining-Tokens") != "0" {
+		t.Errorf("unexpected response %q with %v", native.Error, w.Header())
+	}
+
+	if err := limits.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := loadRateLimits(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u := saved.clients["ip:10.0.0.1"]; u == nil || u.Tokens.Level >= 0 {
+		t.Errorf("expected the overdrawn quota to be saved, got %+v", u)
+	}
+}
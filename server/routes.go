@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -33,12 +34,14 @@ import (
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/model/models/mllama"
+	modelmanager "github.com/ollama/ollama/model_manager"
 	"github.com/ollama/ollama/openai"
 	"github.com/ollama/ollama/server/internal/client/ollama"
 	"github.com/ollama/ollama/server/internal/registry"
 	"github.com/ollama/ollama/template"
 	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
+	updateservice "github.com/ollama/ollama/update_service"
 	"github.com/ollama/ollama/version"
 )
 
@@ -51,8 +54,21 @@ var useClient2 = experimentEnabled("client2")
 var mode string = gin.DebugMode
 
 type Server struct {
-	addr  net.Addr
-	sched *Scheduler
+	// ctx is done when the server shuts down. Work that must not stop when
+	// the client of the request that started it goes away runs in it.
+	ctx     context.Context
+	addr    net.Addr
+	sched   *Scheduler
+	catalog *modelmanager.ModelManager
+	updates *updateservice.UpdateManager
+	// keys are the API keys requests must use, or nil if authentication is
+	// disabled
+	keys apiKeys
+	// limits tracks the usage of clients against their rate limits
+	limits *rateLimits
+	// auditLog records model management operations, or is nil if auditing
+	// is disabled
+	auditLog *auditLog
 }
 
 func init() {
@@ -92,11 +108,17 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []model.C
 		return nil, nil, nil, fmt.Errorf("model %w", errRequired)
 	}
 
+	if err := checkModel(ctx, model.ParseName(name)); err != nil {
+		return nil, nil, nil, err
+	}
+
 	model, err := GetModel(name)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
+	setRequestModel(ctx, modelLabel(model))
+
 	if err := model.CheckCapabilities(caps...); err != nil {
 		return nil, nil, nil, fmt.Errorf("%s %w", name, err)
 	}
@@ -117,6 +139,21 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []model.C
 	return runner.llama, model, &opts, nil
 }
 
+// maxTopLogprobs is the most alternatives a request can ask for per token
+const maxTopLogprobs = 20
+
+func validateLogprobs(logprobs bool, topLogprobs int) error {
+	if topLogprobs < 0 || topLogprobs > maxTopLogprobs {
+		return fmt.Errorf("top_logprobs must be between 0 and %d", maxTopLogprobs)
+	}
+
+	if topLogprobs > 0 && !logprobs {
+		return errors.New("top_logprobs requires logprobs")
+	}
+
+	return nil
+}
+
 func (s *Server) GenerateHandler(c *gin.Context) {
 	checkpointStart := time.Now()
 	var req api.GenerateRequest
@@ -128,6 +165,16 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	if err := validateLogprobs(req.Logprobs, req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := setRequestPriority(c, req.Priority); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
 		// Ideally this is "invalid model name" but we're keeping with
@@ -144,6 +191,10 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	if !allowModel(c, name) {
+		return
+	}
+
 	m, err := GetModel(name.String())
 	if err != nil {
 		switch {
@@ -296,28 +347,33 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 	slog.Debug("generate request", "images", len(images), "prompt", prompt)
 
+	// the tokens generated so far are charged however the request ends,
+	// including when the client stops reading before it is done
+	var used atomic.Int64
+	defer func() { recordUsage(c.Request.Context(), int(used.Load())) }()
+
 	ch := make(chan any)
 	go func() {
 		// TODO (jmorganca): avoid building the response twice both here and below
 		var sb strings.Builder
 		defer close(ch)
+		cm := newCompletionMetrics(m, checkpointStart)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:      prompt,
+			Images:      images,
+			Format:      req.Format,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
 		}, func(cr llm.CompletionResponse) {
+			cm.observe(cr)
+			used.Store(int64(cr.PromptEvalCount + cr.EvalCount))
 			res := api.GenerateResponse{
 				Model:     req.Model,
 				CreatedAt: time.Now().UTC(),
 				Response:  cr.Content,
 				Done:      cr.Done,
-				Metrics: api.Metrics{
-					PromptEvalCount:    cr.PromptEvalCount,
-					PromptEvalDuration: cr.PromptEvalDuration,
-					EvalCount:          cr.EvalCount,
-					EvalDuration:       cr.EvalDuration,
-				},
+				Logprobs:  cr.Logprobs,
 			}
 
 			if _, err := sb.WriteString(cr.Content); err != nil {
@@ -325,6 +381,12 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			}
 
 			if cr.Done {
+				res.Metrics = api.Metrics{
+					PromptEvalCount:    cr.PromptEvalCount,
+					PromptEvalDuration: cr.PromptEvalDuration,
+					EvalCount:          cr.EvalCount,
+					EvalDuration:       cr.EvalDuration,
+				}
 				res.DoneReason = cr.DoneReason.String()
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
@@ -348,10 +410,12 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	if req.Stream != nil && !*req.Stream {
 		var r api.GenerateResponse
 		var sb strings.Builder
+		var logprobs []api.Logprob
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.GenerateResponse:
 				sb.WriteString(t.Response)
+				logprobs = append(logprobs, t.Logprobs...)
 				r = t
 			case gin.H:
 				msg, ok := t["error"].(string)
@@ -368,6 +432,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		}
 
 		r.Response = sb.String()
+		r.Logprobs = logprobs
 		c.JSON(http.StatusOK, r)
 		return
 	}
@@ -388,6 +453,11 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
+	if err := setRequestPriority(c, req.Priority); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	truncate := true
 
 	if req.Truncate != nil && !*req.Truncate {
@@ -494,6 +564,7 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
 		PromptEvalCount: count,
 	}
+	recordUsage(c.Request.Context(), count)
 	c.JSON(http.StatusOK, resp)
 }
 
@@ -542,12 +613,25 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
+	// the runner does not report how many tokens the prompt has, so it is
+	// only tokenized when there is a quota to charge
+	var tokens []int
+	if countsTokens(c.Request.Context()) {
+		tokens, err = r.Tokenize(c.Request.Context(), req.Prompt)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	embedding, err := r.Embedding(c.Request.Context(), req.Prompt)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
 		return
 	}
 
+	recordUsage(c.Request.Context(), len(tokens))
+
 	var e []float64
 	for _, v := range embedding {
 		e = append(e, float64(v))
@@ -583,6 +667,12 @@ func (s *Server) PullHandler(c *gin.Context) {
 		return
 	}
 
+	if !allowModel(c, name) {
+		return
+	}
+
+	rec := s.audit(c, "pull", name, "")
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -599,15 +689,21 @@ func (s *Server) PullHandler(c *gin.Context) {
 
 		if err := PullModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
+			return
 		}
+
+		// only models that exist are labeled, so pulls of made up names do
+		// not each add a metric series
+		setRequestModel(c.Request.Context(), name.DisplayShortest())
 	}()
 
+	out := rec.stream(c.Request.Context(), ch)
 	if req.Stream != nil && !*req.Stream {
-		waitForStream(c, ch)
+		waitForStream(c, out)
 		return
 	}
 
-	streamResponse(c, ch)
+	streamResponse(c, out)
 }
 
 func (s *Server) PushHandler(c *gin.Context) {
@@ -632,6 +728,12 @@ func (s *Server) PushHandler(c *gin.Context) {
 		return
 	}
 
+	if !allowModel(c, model.ParseName(mname)) {
+		return
+	}
+
+	rec := s.audit(c, "push", model.ParseName(mname), "")
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -652,17 +754,22 @@ func (s *Server) PushHandler(c *gin.Context) {
 			return
 		}
 
+		if _, err := ParseNamedManifest(name); err == nil {
+			setRequestModel(c.Request.Context(), name.DisplayShortest())
+		}
+
 		if err := PushModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
 		}
 	}()
 
+	out := rec.stream(c.Request.Context(), ch)
 	if req.Stream != nil && !*req.Stream {
-		waitForStream(c, ch)
+		waitForStream(c, out)
 		return
 	}
 
-	streamResponse(c, ch)
+	streamResponse(c, out)
 }
 
 // getExistingName searches the models directory for the longest prefix match of
@@ -715,7 +822,13 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 		return
 	}
 
-	m, err := ParseNamedManifest(n)
+	if !allowModel(c, n) {
+		return
+	}
+
+	rec := s.audit(c, "delete", n, "")
+	err = deleteModel(n)
+	rec.done(err)
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
@@ -725,16 +838,20 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 		}
 		return
 	}
+}
 
-	if err := m.Remove(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// deleteModel removes the manifest for n and any layers it references
+func deleteModel(n model.Name) error {
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		return err
 	}
 
-	if err := m.RemoveLayers(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := m.Remove(); err != nil {
+		return err
 	}
+
+	return m.RemoveLayers()
 }
 
 func (s *Server) ShowHandler(c *gin.Context) {
@@ -758,6 +875,10 @@ func (s *Server) ShowHandler(c *gin.Context) {
 		return
 	}
 
+	if !allowModel(c, model.ParseName(req.Model)) {
+		return
+	}
+
 	resp, err := GetModelInfo(req)
 	if err != nil {
 		switch {
@@ -907,6 +1028,10 @@ func (s *Server) ListHandler(c *gin.Context) {
 
 	models := []api.ListModelResponse{}
 	for n, m := range ms {
+		if checkModel(c.Request.Context(), n) != nil {
+			continue
+		}
+
 		var cf ConfigV2
 
 		if m.Config.Digest != "" {
@@ -980,7 +1105,14 @@ func (s *Server) CopyHandler(c *gin.Context) {
 		return
 	}
 
-	if err := CopyModel(src, dst); errors.Is(err, os.ErrNotExist) {
+	if !allowModel(c, src) || !allowModel(c, dst) {
+		return
+	}
+
+	rec := s.audit(c, "copy", dst, src.DisplayShortest())
+	err = CopyModel(src, dst)
+	rec.done(err)
+	if errors.Is(err, os.ErrNotExist) {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", r.Source)})
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -1150,6 +1282,7 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 		"User-Agent",
 		"Accept",
 		"X-Requested-With",
+		priorityHeader,
 
 		// OpenAI compatibility headers
 		"OpenAI-Beta",
@@ -1170,43 +1303,79 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 
 	r := gin.Default()
 	r.Use(
+		metricsMiddleware,
 		cors.New(corsConfig),
 		allowedHostsMiddleware(s.addr),
 	)
 
+	// routes that need an API key with a scope when authentication is
+	// enabled, are queued by client, and count toward the client's rate
+	// limits
+	inference := r.Group("", s.requireScope(scopeInference), flowMiddleware, s.limitRequests)
+	manage := r.Group("", s.requireScope(scopeManage), flowMiddleware, s.limitRequests)
+	admin := r.Group("", s.requireScope(scopeAdmin), flowMiddleware, s.limitRequests)
+
 	// General
 	r.HEAD("/", func(c *gin.Context) { c.String(http.StatusOK, "Ollama is running") })
 	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "Ollama is running") })
 	r.HEAD("/api/version", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": version.Version}) })
 	r.GET("/api/version", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": version.Version}) })
+	admin.GET("/metrics", s.MetricsHandler)
+	admin.GET("/api/audit", s.AuditHandler)
 
 	// Local model cache management (new implementation is at end of function)
-	r.POST("/api/pull", s.PullHandler)
-	r.POST("/api/push", s.PushHandler)
-	r.HEAD("/api/tags", s.ListHandler)
-	r.GET("/api/tags", s.ListHandler)
-	r.POST("/api/show", s.ShowHandler)
-	r.DELETE("/api/delete", s.DeleteHandler)
+	manage.POST("/api/pull", s.PullHandler)
+	manage.POST("/api/push", s.PushHandler)
+	inference.HEAD("/api/tags", s.ListHandler)
+	inference.GET("/api/tags", s.ListHandler)
+	inference.POST("/api/show", s.ShowHandler)
+	manage.DELETE("/api/delete", s.DeleteHandler)
 
 	// Create
-	r.POST("/api/create", s.CreateHandler)
-	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
-	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
-	r.POST("/api/copy", s.CopyHandler)
+	manage.POST("/api/create", s.CreateHandler)
+	manage.POST("/api/blobs/:digest", s.CreateBlobHandler)
+	manage.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
+	manage.POST("/api/copy", s.CopyHandler)
+
+	// Model catalog
+	manage.HEAD("/api/catalog", s.CatalogHandler)
+	manage.GET("/api/catalog", s.CatalogHandler)
+	manage.DELETE("/api/catalog", s.CatalogRemoveHandler)
+	manage.POST("/api/catalog/download", s.CatalogDownloadHandler)
+	manage.POST("/api/catalog/check", s.CatalogCheckHandler)
+	manage.POST("/api/catalog/cancel", s.CatalogCancelHandler)
+	manage.POST("/api/catalog/pause", s.CatalogPauseHandler)
+	manage.POST("/api/catalog/resume", s.CatalogResumeHandler)
+	manage.POST("/api/catalog/status", s.CatalogStatusHandler)
+	manage.POST("/api/catalog/progress", s.CatalogProgressHandler)
+
+	// Updates
+	admin.GET("/api/update", s.UpdateHandler)
+	admin.POST("/api/update/check", s.CheckUpdateHandler)
+	admin.POST("/api/update/apply", s.ApplyUpdateHandler)
 
 	// Inference
-	r.GET("/api/ps", s.PsHandler)
-	r.POST("/api/generate", s.GenerateHandler)
-	r.POST("/api/chat", s.ChatHandler)
-	r.POST("/api/embed", s.EmbedHandler)
-	r.POST("/api/embeddings", s.EmbeddingsHandler)
+	inference.GET("/api/ps", s.PsHandler)
+	inference.POST("/api/generate", s.GenerateHandler)
+	inference.POST("/api/chat", s.ChatHandler)
+	inference.POST("/api/embed", s.EmbedHandler)
+	inference.POST("/api/embeddings", s.EmbeddingsHandler)
+	inference.POST("/api/tokenize", s.TokenizeHandler)
+	inference.POST("/api/detokenize", s.DetokenizeHandler)
 
 	// Inference (OpenAI compatibility)
-	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
-	r.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
-	r.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbedHandler)
-	r.GET("/v1/models", openai.ListMiddleware(), s.ListHandler)
-	r.GET("/v1/models/:model", openai.RetrieveMiddleware(), s.ShowHandler)
+	inference.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
+	inference.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
+	inference.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbedHandler)
+	inference.GET("/v1/models", openai.ListMiddleware(), s.ListHandler)
+	inference.GET("/v1/models/:model", openai.RetrieveMiddleware(), s.ShowHandler)
+
+	if rc != nil && (s.keys != nil || s.auditLog != nil) {
+		// the new implementation of pull and delete does not check API keys
+		// or write the audit log
+		slog.Warn("API keys or the audit log are configured, not using the experimental registry client")
+		rc = nil
+	}
 
 	if rc != nil {
 		// wrap old with new
@@ -1273,7 +1442,15 @@ func Serve(ln net.Listener) error {
 		}
 	}
 
-	s := &Server{addr: ln.Addr()}
+	keys, err := loadAPIKeys(envconfig.APIKeys())
+	if err != nil {
+		return fmt.Errorf("API keys: %w", err)
+	}
+
+	s := &Server{addr: ln.Addr(), keys: keys}
+	if path := envconfig.AuditLog(); path != "" {
+		s.auditLog = &auditLog{path: path, maxSize: auditLogMaxSize}
+	}
 
 	var rc *ollama.Registry
 	if useClient2 {
@@ -1292,6 +1469,19 @@ func Serve(ln net.Listener) error {
 	http.Handle("/", h)
 
 	ctx, done := context.WithCancel(context.Background())
+	s.ctx = ctx
+
+	if err := s.initCatalog(ctx); err != nil {
+		slog.Warn("model catalog is unavailable", "error", err)
+	}
+	if err := s.initUpdates(); err != nil {
+		slog.Warn("updates are unavailable", "error", err)
+	}
+	if err := s.initRateLimits(ctx); err != nil {
+		done()
+		return err
+	}
+
 	schedCtx, schedDone := context.WithCancel(ctx)
 	sched := InitScheduler(schedCtx)
 	s.sched = sched
@@ -1317,6 +1507,9 @@ func Serve(ln net.Listener) error {
 		srvr.Close()
 		schedDone()
 		sched.unloadAllRunners()
+		if err := s.limits.save(); err != nil {
+			slog.Warn("failed to save rate limits", "error", err)
+		}
 		done()
 	}()
 
@@ -1395,6 +1588,10 @@ func (s *Server) PsHandler(c *gin.Context) {
 	models := []api.ProcessModelResponse{}
 
 	for _, v := range s.sched.loaded {
+		if checkModel(c.Request.Context(), model.ParseName(v.model.Name)) != nil {
+			continue
+		}
+
 		model := v.model
 		modelDetails := api.ModelDetails{
 			Format:            model.Config.ModelFormat,
@@ -1444,6 +1641,16 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	if err := validateLogprobs(req.Logprobs, req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := setRequestPriority(c, req.Priority); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// expire the runner
 	if len(req.Messages) == 0 && req.KeepAlive != nil && int(req.KeepAlive.Seconds()) == 0 {
 		model, err := GetModel(req.Model)
@@ -1470,8 +1677,14 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	tools, err := toolChoice(req)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	caps := []model.Capability{model.CapabilityCompletion}
-	if len(req.Tools) > 0 {
+	if len(tools) > 0 {
 		caps = append(caps, model.CapabilityTools)
 	}
 
@@ -1480,7 +1693,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
 		return
 	}
-	name, err := getExistingName(name)
+	name, err = getExistingName(name)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
 		return
@@ -1513,7 +1726,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
 	}
 
-	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools)
+	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, tools)
 	if err != nil {
 		slog.Error("chat prompt error", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -1522,67 +1735,110 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 	slog.Debug("chat request", "images", len(images), "prompt", prompt)
 
+	var parser *toolParser
+	if len(tools) > 0 {
+		parser = m.toolParser()
+	}
+
+	maxToolCalls := -1
+	if req.MaxToolCalls > 0 {
+		maxToolCalls = req.MaxToolCalls
+	}
+
+	// a required tool call is enforced by constraining the output to the
+	// tool calls the template writes
+	var grammar string
+	if mode := req.ToolChoice; mode != nil && (mode.Mode == "required" || mode.Mode == "function") {
+		if parser == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support tool_choice %q", req.Model, mode.Mode)})
+			return
+		}
+
+		f, err := parser.format(tools, maxToolCalls)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		grammar = f.Grammar()
+	}
+
+	// the tokens generated so far are charged however the request ends,
+	// including when the client stops reading before it is done
+	var used atomic.Int64
+	defer func() { recordUsage(c.Request.Context(), int(used.Load())) }()
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
-		var sb strings.Builder
+		var logprobs []api.Logprob
 		var toolCallIndex int = 0
+		cm := newCompletionMetrics(m, checkpointStart)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:      prompt,
+			Images:      images,
+			Format:      req.Format,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
+			Grammar:     grammar,
 		}, func(r llm.CompletionResponse) {
+			cm.observe(r)
+			used.Store(int64(r.PromptEvalCount + r.EvalCount))
 			res := api.ChatResponse{
 				Model:     req.Model,
 				CreatedAt: time.Now().UTC(),
 				Message:   api.Message{Role: "assistant", Content: r.Content},
 				Done:      r.Done,
-				Metrics: api.Metrics{
+				Logprobs:  r.Logprobs,
+			}
+
+			if r.Done {
+				res.Metrics = api.Metrics{
 					PromptEvalCount:    r.PromptEvalCount,
 					PromptEvalDuration: r.PromptEvalDuration,
 					EvalCount:          r.EvalCount,
 					EvalDuration:       r.EvalDuration,
-				},
-			}
-
-			if r.Done {
+				}
 				res.DoneReason = r.DoneReason.String()
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
 			}
 
-			// TODO: tool call checking and filtering should be moved outside of this callback once streaming
-			// however this was a simple change for now without reworking streaming logic of this (and other)
-			// handlers
-			if req.Stream != nil && !*req.Stream || len(req.Tools) == 0 {
+			if parser == nil {
 				ch <- res
 				return
 			}
 
 			// Streaming tool calls:
-			// If tools are recognized, use a flag to track the sending of a tool downstream
-			// This ensures that content is cleared from the message on the last chunk sent
-			sb.WriteString(r.Content)
-			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
-				res.Message.ToolCalls = toolCalls
-				for i := range toolCalls {
-					toolCalls[i].Function.Index = toolCallIndex
-					toolCallIndex++
-				}
-				res.Message.Content = ""
-				sb.Reset()
-				ch <- res
+			// Content is sent as soon as the parser knows it is not part of a
+			// tool call, and each tool call once its JSON is complete
+			content, toolCalls := parser.Add(r.Content)
+			if r.Done {
+				rest, moreCalls := parser.Done()
+				content += rest
+				toolCalls = append(toolCalls, moreCalls...)
+			}
+
+			logprobs = append(logprobs, r.Logprobs...)
+			if content == "" && len(toolCalls) == 0 && !r.Done {
 				return
 			}
 
-			if r.Done {
-				// Send any remaining content if no tool calls were detected
-				if toolCallIndex == 0 {
-					res.Message.Content = sb.String()
-				}
-				ch <- res
+			// calls past the limit are dropped
+			if maxToolCalls >= 0 {
+				toolCalls = toolCalls[:min(len(toolCalls), max(maxToolCalls-toolCallIndex, 0))]
 			}
+
+			for i := range toolCalls {
+				toolCalls[i].Function.Index = toolCallIndex
+				toolCallIndex++
+			}
+
+			res.Message.Content = content
+			res.Message.ToolCalls = toolCalls
+			res.Logprobs = logprobs
+			logprobs = nil
+			ch <- res
 		}); err != nil {
 			ch <- gin.H{"error": err.Error()}
 		}
@@ -1591,10 +1847,14 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	if req.Stream != nil && !*req.Stream {
 		var resp api.ChatResponse
 		var sb strings.Builder
+		var logprobs []api.Logprob
+		var toolCalls []api.ToolCall
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.ChatResponse:
 				sb.WriteString(t.Message.Content)
+				logprobs = append(logprobs, t.Logprobs...)
+				toolCalls = append(toolCalls, t.Message.ToolCalls...)
 				resp = t
 			case gin.H:
 				msg, ok := t["error"].(string)
@@ -1611,13 +1871,8 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		}
 
 		resp.Message.Content = sb.String()
-
-		if len(req.Tools) > 0 {
-			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
-				resp.Message.ToolCalls = toolCalls
-				resp.Message.Content = ""
-			}
-		}
+		resp.Message.ToolCalls = toolCalls
+		resp.Logprobs = logprobs
 
 		c.JSON(http.StatusOK, resp)
 		return
@@ -1626,6 +1881,41 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	streamResponse(c, ch)
 }
 
+// toolChoice returns the tools the model may call for req, which are none if
+// its tool choice is "none" and only the chosen one if it names a function
+func toolChoice(req api.ChatRequest) ([]api.Tool, error) {
+	if req.MaxToolCalls < 0 {
+		return nil, errors.New("max_tool_calls must not be negative")
+	}
+
+	if req.ToolChoice == nil {
+		return req.Tools, nil
+	}
+
+	switch req.ToolChoice.Mode {
+	case "none":
+		return nil, nil
+	case "required", "function":
+		if len(req.Tools) == 0 {
+			return nil, fmt.Errorf("tool_choice %q requires tools", req.ToolChoice.Mode)
+		}
+
+		if len(req.Format) > 0 {
+			return nil, fmt.Errorf("format cannot be used with tool_choice %q", req.ToolChoice.Mode)
+		}
+
+		if req.ToolChoice.Mode == "function" {
+			i := slices.IndexFunc(req.Tools, func(t api.Tool) bool { return t.Function.Name == req.ToolChoice.Name })
+			if i < 0 {
+				return nil, fmt.Errorf("tool_choice function %q is not in tools", req.ToolChoice.Name)
+			}
+			return req.Tools[i : i+1], nil
+		}
+	}
+
+	return req.Tools, nil
+}
+
 func handleScheduleError(c *gin.Context, name string, err error) {
 	switch {
 	case errors.Is(err, errCapabilities), errors.Is(err, errRequired):
@@ -1634,6 +1924,8 @@ func handleScheduleError(c *gin.Context, name string, err error) {
 		c.JSON(499, gin.H{"error": "request canceled"})
 	case errors.Is(err, ErrMaxQueue):
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, errModelForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 	case errors.Is(err, os.ErrNotExist):
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found, try pulling it first", name)})
 	default:
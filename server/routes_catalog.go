@@ -0,0 +1,426 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	modelmanager "github.com/ollama/ollama/model_manager"
+	"github.com/ollama/ollama/types/model"
+	updateservice "github.com/ollama/ollama/update_service"
+	"github.com/ollama/ollama/version"
+)
+
+// catalogRefreshInterval is how often a remote catalog is reloaded
+const catalogRefreshInterval = 6 * time.Hour
+
+// initCatalog sets up the model catalog on top of the local model store. A
+// remote catalog that cannot be loaded leaves the built-in catalog in place.
+func (s *Server) initCatalog(ctx context.Context) error {
+	mm, err := modelmanager.NewWithBackend(envconfig.Models(), &ModelStore{})
+	if err != nil {
+		return err
+	}
+
+	mm.SetMaxConcurrentDownloads(int(envconfig.MaxDownloads()))
+
+	if source := envconfig.CatalogURL(); source != "" {
+		cfg := modelmanager.CatalogConfig{
+			Source:          source,
+			PublicKeys:      envconfig.TrustedKeys(),
+			RefreshInterval: catalogRefreshInterval,
+		}
+
+		if err := mm.ConfigureCatalog(ctx, cfg); err != nil {
+			slog.Warn("failed to load model catalog, using built-in catalog", "source", source, "error", err)
+		}
+
+		go mm.RunCatalogRefresh(ctx)
+	}
+
+	s.catalog = mm
+	return nil
+}
+
+// initUpdates sets up the update manager. Updates are staged under
+// $HOME/.ollama/update.
+func (s *Server) initUpdates() error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	um, err := updateservice.NewWithConfig(filepath.Join(home, ".ollama", "update"), version.Version, updateservice.Config{
+		ManifestURL: envconfig.UpdateURL(),
+		Channel:     envconfig.UpdateChannel(),
+		PublicKeys:  envconfig.TrustedKeys(),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.updates = um
+	return nil
+}
+
+func catalogModel(m modelmanager.Model) api.CatalogModel {
+	return api.CatalogModel{
+		Name:        m.Name,
+		Description: m.Description,
+		Size:        m.Size,
+		Parameters:  m.Parameters,
+		Category:    m.Category,
+		Tags:        m.Tags,
+		Downloaded:  m.IsDownloaded,
+		Recommended: m.KCRecommended,
+	}
+}
+
+func catalogStatus(ds *modelmanager.DownloadStatus) api.CatalogStatusResponse {
+	return api.CatalogStatusResponse{
+		Model:      ds.ModelName,
+		Status:     ds.Status,
+		Error:      ds.Error,
+		Completed:  ds.Completed,
+		Progress:   ds.Progress,
+		Downloaded: ds.Downloaded,
+		Total:      ds.TotalSize,
+		Rate:       ds.Rate,
+		ETA:        ds.ETA,
+	}
+}
+
+func catalogCheck(name string, r *modelmanager.FitReport) api.CatalogCheckResponse {
+	return api.CatalogCheckResponse{
+		Model:           name,
+		Verdict:         string(r.Verdict),
+		Reason:          r.Reason,
+		DiskRequired:    r.DiskRequired,
+		DiskAvailable:   r.DiskAvailable,
+		MemoryRequired:  r.MemoryRequired,
+		MemoryAvailable: r.MemoryAvailable,
+		VRAMRequired:    r.VRAMRequired,
+		GPULayers:       r.GPULayers,
+		Layers:          r.Layers,
+	}
+}
+
+func updateResponse(info *updateservice.UpdateInfo) api.UpdateResponse {
+	return api.UpdateResponse{
+		Available:       info.Available,
+		Required:        info.Required,
+		CurrentVersion:  info.CurrentVersion,
+		NewVersion:      info.NewVersion,
+		PreviousVersion: info.PreviousVersion,
+		Channel:         info.Channel,
+		Reason:          info.Reason,
+		ReleaseDate:     info.ReleaseDate,
+		ReleaseNotes:    info.ReleaseNotes,
+	}
+}
+
+// catalogError writes the response for an error returned by the model manager
+func catalogError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, modelmanager.ErrModelNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, modelmanager.ErrNotDownloaded), errors.Is(err, modelmanager.ErrNoActiveDownload):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, modelmanager.ErrInsufficientDisk):
+		c.AbortWithStatusJSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// bindCatalogRequest reads a request naming a catalog model. It writes the
+// error response and returns false if the request is invalid.
+func (s *Server) bindCatalogRequest(c *gin.Context) (api.CatalogRequest, bool) {
+	var req api.CatalogRequest
+	if s.catalog == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "model catalog is not available"})
+		return req, false
+	}
+
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return req, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model %v", errRequired)})
+		return req, false
+	}
+
+	if !allowModel(c, model.ParseName(req.Model)) {
+		return req, false
+	}
+
+	return req, true
+}
+
+func (s *Server) CatalogHandler(c *gin.Context) {
+	if s.catalog == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "model catalog is not available"})
+		return
+	}
+
+	models := []api.CatalogModel{}
+	for _, m := range s.catalog.GetModels() {
+		if checkModel(c.Request.Context(), model.ParseName(m.Name)) != nil {
+			continue
+		}
+
+		models = append(models, catalogModel(m))
+	}
+
+	c.JSON(http.StatusOK, api.CatalogResponse{Models: models})
+}
+
+func (s *Server) CatalogDownloadHandler(c *gin.Context) {
+	req, ok := s.bindCatalogRequest(c)
+	if !ok {
+		return
+	}
+
+	status, err := s.catalog.QueueDownload(req.Model, req.Priority)
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, catalogStatus(status))
+}
+
+// CatalogCheckHandler reports whether a catalog model fits on disk and in
+// memory, so clients can warn before starting a download
+func (s *Server) CatalogCheckHandler(c *gin.Context) {
+	req, ok := s.bindCatalogRequest(c)
+	if !ok {
+		return
+	}
+
+	report, err := s.catalog.CheckFit(c.Request.Context(), req.Model)
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, catalogCheck(req.Model, report))
+}
+
+func (s *Server) CatalogCancelHandler(c *gin.Context) {
+	req, ok := s.bindCatalogRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := s.catalog.CancelDownload(req.Model); err != nil {
+		catalogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) CatalogPauseHandler(c *gin.Context) {
+	req, ok := s.bindCatalogRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := s.catalog.PauseDownload(req.Model); err != nil {
+		catalogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) CatalogResumeHandler(c *gin.Context) {
+	req, ok := s.bindCatalogRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := s.catalog.ResumeDownload(req.Model); err != nil {
+		catalogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) CatalogStatusHandler(c *gin.Context) {
+	req, ok := s.bindCatalogRequest(c)
+	if !ok {
+		return
+	}
+
+	status, err := s.catalog.GetDownloadStatus(req.Model)
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, catalogStatus(status))
+}
+
+// CatalogProgressHandler streams download progress as NDJSON, or as
+// Server-Sent Events when the client accepts text/event-stream. A request
+// for a single model starts with its current status and ends once the
+// download finishes.
+func (s *Server) CatalogProgressHandler(c *gin.Context) {
+	if s.catalog == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "model catalog is not available"})
+		return
+	}
+
+	var req api.CatalogRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Subscribe before reading the current status so no transition is missed
+	events := s.catalog.Subscribe(ctx)
+
+	var current *modelmanager.DownloadStatus
+	if req.Model != "" {
+		status, err := s.catalog.GetDownloadStatus(req.Model)
+		if err != nil {
+			catalogError(c, err)
+			return
+		}
+		current = status
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+
+		send := func(status *modelmanager.DownloadStatus) bool {
+			select {
+			case ch <- catalogStatus(status):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if current != nil {
+			if !send(current) || current.Completed || current.Status == "not_started" {
+				return
+			}
+		}
+
+		for status := range events {
+			if req.Model != "" && status.ModelName != req.Model {
+				continue
+			}
+			if !send(&status) || (req.Model != "" && status.Completed) {
+				return
+			}
+		}
+	}()
+
+	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		c.Stream(func(w io.Writer) bool {
+			val, ok := <-ch
+			if !ok {
+				return false
+			}
+
+			c.SSEvent("progress", val)
+			return true
+		})
+		return
+	}
+
+	streamResponse(c, ch)
+}
+
+func (s *Server) CatalogRemoveHandler(c *gin.Context) {
+	req, ok := s.bindCatalogRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := s.catalog.RemoveModel(req.Model); err != nil {
+		catalogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) UpdateHandler(c *gin.Context) {
+	if s.updates == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "updates are not available"})
+		return
+	}
+
+	info := s.updates.GetLastUpdateInfo()
+	if info == nil {
+		// Nothing has been checked yet
+		info = &updateservice.UpdateInfo{CurrentVersion: s.updates.CurrentVersion()}
+	}
+
+	c.JSON(http.StatusOK, updateResponse(info))
+}
+
+func (s *Server) CheckUpdateHandler(c *gin.Context) {
+	if s.updates == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "updates are not available"})
+		return
+	}
+
+	info, err := s.updates.CheckForUpdates(c.Request.Context())
+	switch {
+	case errors.Is(err, updateservice.ErrNoManifestURL):
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updateResponse(info))
+}
+
+func (s *Server) ApplyUpdateHandler(c *gin.Context) {
+	if s.updates == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "updates are not available"})
+		return
+	}
+
+	// an update is not stopped halfway because the client went away
+	err := s.updates.ApplyUpdate(cmp.Or(s.ctx, context.Background()))
+	switch {
+	case errors.Is(err, updateservice.ErrNoUpdate):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updateResponse(s.updates.GetLastUpdateInfo()))
+}
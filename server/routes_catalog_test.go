@@ -0,0 +1,457 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	modelmanager "github.com/ollama/ollama/model_manager"
+	updateservice "github.com/ollama/ollama/update_service"
+)
+
+// newCatalogClient serves s over HTTP and returns a client for it
+func newCatalogClient(t *testing.T, s *Server) *api.Client {
+	t.Helper()
+
+	router, err := s.GenerateRoutes(nil)
+	if err != nil {
+		t.Fatalf("failed to generate routes: %v", err)
+	}
+
+	httpSrv := httptest.NewServer(router)
+	t.Cleanup(httpSrv.Close)
+
+	u, err := url.Parse(httpSrv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api.NewClient(u, httpSrv.Client())
+}
+
+func expectStatusError(t *testing.T, err error, code int) {
+	t.Helper()
+
+	var serr api.StatusError
+	if !errors.As(err, &serr) || serr.StatusCode != code {
+		t.Fatalf("expected status code %d, got %v", code, err)
+	}
+}
+
+func TestCatalogRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	s := &Server{}
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []ggml.Tensor{
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "test",
+		Files: map[string]string{"test.gguf": digest},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	client := newCatalogClient(t, s)
+	ctx := context.Background()
+
+	_, err := client.Catalog(ctx)
+	expectStatusError(t, err, http.StatusServiceUnavailable)
+
+	mm, err := modelmanager.NewWithBackend(p, &ModelStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.LoadModels(writeCatalog(t, `[{"name":"test","kc_recommended":true},{"name":"other"}]`)); err != nil {
+		t.Fatal(err)
+	}
+	s.catalog = mm
+
+	resp, err := client.Catalog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Models) != 2 {
+		t.Fatalf("expected 2 models, got %v", resp.Models)
+	}
+	if m := resp.Models[0]; m.Name != "test" || !m.Downloaded || !m.Recommended {
+		t.Fatalf("expected test to be downloaded and recommended, got %+v", m)
+	}
+	if m := resp.Models[1]; m.Name != "other" || m.Downloaded {
+		t.Fatalf("expected other to not be downloaded, got %+v", m)
+	}
+
+	t.Run("status", func(t *testing.T) {
+		status, err := client.CatalogStatus(ctx, &api.CatalogRequest{Model: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.Completed || status.Status != "completed" || status.Progress != 100 {
+			t.Fatalf("expected completed download, got %+v", status)
+		}
+
+		status, err = client.CatalogStatus(ctx, &api.CatalogRequest{Model: "other"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Completed || status.Status != "not_started" {
+			t.Fatalf("expected download to not be started, got %+v", status)
+		}
+
+		_, err = client.CatalogStatus(ctx, &api.CatalogRequest{Model: "missing"})
+		expectStatusError(t, err, http.StatusNotFound)
+
+		_, err = client.CatalogStatus(ctx, &api.CatalogRequest{})
+		expectStatusError(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("check", func(t *testing.T) {
+		check, err := client.CatalogCheck(ctx, &api.CatalogRequest{Model: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if check.Model != "test" || check.DiskRequired != 0 || check.Layers != 2 {
+			t.Fatalf("expected an installed model to need no disk space, got %+v", check)
+		}
+		if !slices.Contains([]string{"ok", "cpu_only", "insufficient_ram"}, check.Verdict) {
+			t.Fatalf("unexpected verdict %q", check.Verdict)
+		}
+
+		_, err = client.CatalogCheck(ctx, &api.CatalogRequest{Model: "missing"})
+		expectStatusError(t, err, http.StatusNotFound)
+	})
+
+	t.Run("download completed", func(t *testing.T) {
+		status, err := client.CatalogDownload(ctx, &api.CatalogRequest{Model: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.Completed {
+			t.Fatalf("expected downloaded model to be completed, got %+v", status)
+		}
+
+		_, err = client.CatalogDownload(ctx, &api.CatalogRequest{Model: "missing"})
+		expectStatusError(t, err, http.StatusNotFound)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		err := client.CatalogCancel(ctx, &api.CatalogRequest{Model: "other"})
+		expectStatusError(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		err := client.CatalogRemove(ctx, &api.CatalogRequest{Model: "other"})
+		expectStatusError(t, err, http.StatusBadRequest)
+
+		if err := client.CatalogRemove(ctx, &api.CatalogRequest{Model: "test"}); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := client.Catalog(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range resp.Models {
+			if m.Downloaded {
+				t.Fatalf("expected no downloaded models, got %+v", m)
+			}
+		}
+
+		checkFileExists(t, filepath.Join(p, "manifests", "*", "*", "*", "*"), []string{})
+	})
+}
+
+// stepBackend is a modelmanager.Backend whose downloads report progress
+// and then wait to be released
+type stepBackend struct {
+	mu        sync.Mutex
+	installed map[string]bool
+	release   chan error
+}
+
+func (b *stepBackend) Stat(model modelmanager.Model) (bool, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.installed[model.Name], "", nil
+}
+
+func (b *stepBackend) Pull(ctx context.Context, model modelmanager.Model, fn func(modelmanager.Progress)) error {
+	fn(modelmanager.Progress{Status: "downloading", Completed: 50, Total: 100})
+
+	select {
+	case err := <-b.release:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	fn(modelmanager.Progress{Status: "verifying", Completed: 100, Total: 100})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.installed[model.Name] = true
+	return nil
+}
+
+func (b *stepBackend) Remove(model modelmanager.Model) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.installed, model.Name)
+	return nil
+}
+
+func newStepCatalog(t *testing.T) (*modelmanager.ModelManager, *stepBackend) {
+	t.Helper()
+
+	backend := &stepBackend{installed: map[string]bool{}, release: make(chan error)}
+	mm, err := modelmanager.NewWithBackend(t.TempDir(), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.LoadModels(writeCatalog(t, `[{"name":"test"},{"name":"other"}]`)); err != nil {
+		t.Fatal(err)
+	}
+	return mm, backend
+}
+
+func TestCatalogProgress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mm, backend := newStepCatalog(t)
+	s := &Server{catalog: mm}
+	client := newCatalogClient(t, s)
+	ctx := context.Background()
+
+	t.Run("not started", func(t *testing.T) {
+		var got []api.CatalogStatusResponse
+		if err := client.CatalogProgress(ctx, &api.CatalogRequest{Model: "test"}, func(resp api.CatalogStatusResponse) error {
+			got = append(got, resp)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != 1 || got[0].Status != "not_started" {
+			t.Fatalf("expected a single not_started event, got %+v", got)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		err := client.CatalogProgress(ctx, &api.CatalogRequest{Model: "missing"}, func(api.CatalogStatusResponse) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), "model not found") {
+			t.Fatalf("expected model not found, got %v", err)
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		if _, err := client.CatalogDownload(ctx, &api.CatalogRequest{Model: "test"}); err != nil {
+			t.Fatal(err)
+		}
+
+		var states []string
+		if err := client.CatalogProgress(ctx, &api.CatalogRequest{Model: "test"}, func(resp api.CatalogStatusResponse) error {
+			if len(states) == 0 || states[len(states)-1] != resp.Status {
+				states = append(states, resp.Status)
+			}
+			if resp.Status == "downloading" && resp.Downloaded == 50 {
+				backend.release <- nil
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The stream may start before the download leaves the queue
+		if len(states) > 0 && states[0] == "queued" {
+			states = states[1:]
+		}
+		if want := []string{"downloading", "verifying", "completed"}; !slices.Equal(states, want) {
+			t.Fatalf("expected states %v, got %v", want, states)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		if _, err := client.CatalogDownload(ctx, &api.CatalogRequest{Model: "other"}); err != nil {
+			t.Fatal(err)
+		}
+
+		err := client.CatalogProgress(ctx, &api.CatalogRequest{Model: "other"}, func(resp api.CatalogStatusResponse) error {
+			if resp.Status == "downloading" && resp.Downloaded == 50 {
+				backend.release <- errors.New("disk full")
+			}
+			return nil
+		})
+		if err == nil || err.Error() != "disk full" {
+			t.Fatalf("expected the download error, got %v", err)
+		}
+	})
+
+	t.Run("sse", func(t *testing.T) {
+		if err := client.CatalogRemove(ctx, &api.CatalogRequest{Model: "test"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.CatalogDownload(ctx, &api.CatalogRequest{Model: "test"}); err != nil {
+			t.Fatal(err)
+		}
+
+		router, err := s.GenerateRoutes(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewServer(router)
+		defer srv.Close()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/api/catalog/progress", strings.NewReader(`{"model":"test"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+			t.Fatalf("expected event stream, got %s", ct)
+		}
+
+		var last api.CatalogStatusResponse
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line != "" && line != "event:progress" {
+				data, ok := strings.CutPrefix(line, "data:")
+				if !ok {
+					t.Fatalf("unexpected line %q", line)
+				}
+				if err := json.Unmarshal([]byte(data), &last); err != nil {
+					t.Fatal(err)
+				}
+				if last.Status == "downloading" && last.Downloaded == 50 {
+					backend.release <- nil
+				}
+			}
+		}
+
+		if last.Status != "completed" || !last.Completed {
+			t.Fatalf("expected the stream to end with completed, got %+v", last)
+		}
+	})
+}
+
+func TestCatalogPauseResume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mm, backend := newStepCatalog(t)
+	s := &Server{catalog: mm}
+	client := newCatalogClient(t, s)
+	ctx := context.Background()
+
+	waitFor := func(t *testing.T, want string) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			status, err := client.CatalogStatus(ctx, &api.CatalogRequest{Model: "test"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Status == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %s, got %s", want, status.Status)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	err := client.CatalogPause(ctx, &api.CatalogRequest{Model: "test"})
+	expectStatusError(t, err, http.StatusBadRequest)
+
+	if _, err := client.CatalogDownload(ctx, &api.CatalogRequest{Model: "test", Priority: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "downloading")
+
+	if err := client.CatalogPause(ctx, &api.CatalogRequest{Model: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "paused")
+
+	if err := client.CatalogResume(ctx, &api.CatalogRequest{Model: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "downloading")
+
+	backend.release <- nil
+	waitFor(t, "completed")
+
+	err = client.CatalogResume(ctx, &api.CatalogRequest{Model: "test"})
+	expectStatusError(t, err, http.StatusBadRequest)
+}
+
+func TestUpdateRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{}
+	client := newCatalogClient(t, s)
+	ctx := context.Background()
+
+	_, err := client.Update(ctx)
+	expectStatusError(t, err, http.StatusServiceUnavailable)
+
+	um, err := updateservice.New(t.TempDir(), "0.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.updates = um
+
+	resp, err := client.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Available || resp.CurrentVersion != "0.1.0" {
+		t.Fatalf("expected no update for 0.1.0, got %+v", resp)
+	}
+
+	_, err = client.CheckUpdate(ctx)
+	expectStatusError(t, err, http.StatusServiceUnavailable)
+
+	_, err = client.ApplyUpdate(ctx)
+	expectStatusError(t, err, http.StatusBadRequest)
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
@@ -46,6 +48,15 @@ func (mockRunner) Tokenize(_ context.Context, s string) (tokens []int, err error
 	return
 }
 
+func (mockRunner) Detokenize(_ context.Context, tokens []int) (string, error) {
+	var sb strings.Builder
+	for _, t := range tokens {
+		fmt.Fprintf(&sb, "<%d>", t)
+	}
+
+	return sb.String(), nil
+}
+
 func newMockServer(mock *mockRunner) func(discover.GpuInfoList, string, *ggml.GGML, []string, []string, api.Options, int) (llm.LlamaServer, error) {
 	return func(_ discover.GpuInfoList, _ string, _ *ggml.GGML, _, _ []string, _ api.Options, _ int) (llm.LlamaServer, error) {
 		return mock, nil
@@ -594,6 +605,177 @@ func TestGenerateChat(t *testing.T) {
 			t.Errorf("final tool call mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("messages with logprobs", func(t *testing.T) {
+		lps := []api.Logprob{
+			{TokenLogprob: api.TokenLogprob{Token: "Hi", Logprob: -0.5}},
+			{TokenLogprob: api.TokenLogprob{Token: "!", Logprob: -0.1}},
+		}
+
+		mock.CompletionFn = func(_ context.Context, _ llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: "Hi", Logprobs: lps[:1]})
+			fn(llm.CompletionResponse{Content: "!", Logprobs: lps[1:]})
+			fn(llm.CompletionResponse{Done: true, DoneReason: llm.DoneReasonStop})
+			return nil
+		}
+		t.Cleanup(func() { mock.CompletionFn = nil })
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model: "test",
+			Messages: []api.Message{
+				{Role: "user", Content: "Hello!"},
+			},
+			Stream:   &stream,
+			Logprobs: true,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if !mock.CompletionRequest.Logprobs {
+			t.Error("logprobs not passed to the runner")
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Message.Content != "Hi!" {
+			t.Errorf("expected content %q, got %q", "Hi!", resp.Message.Content)
+		}
+
+		if diff := cmp.Diff(lps, resp.Logprobs); diff != "" {
+			t.Errorf("logprobs mismatch (-want +got):\n%s", diff)
+		}
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:       "test",
+			Messages:    []api.Message{{Role: "user", Content: "Hello!"}},
+			TopLogprobs: 2,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("usage of an unfinished completion", func(t *testing.T) {
+		mock.CompletionFn = func(ctx context.Context, _ llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: "Hi", PromptEvalCount: 10, EvalCount: 1})
+			fn(llm.CompletionResponse{Content: "!", PromptEvalCount: 10, EvalCount: 2})
+			// the client went away before the completion was done
+			return context.Canceled
+		}
+		t.Cleanup(func() { mock.CompletionFn = nil })
+
+		limits := &rateLimits{clients: make(map[string]*usage)}
+		w := createRequest(t, func(c *gin.Context) {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), limitsKey{}, &limitedClient{limits, "ip:test", 1000}))
+			s.ChatHandler(c)
+		}, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if level := limits.clients["ip:test"].Tokens.Level; level != 1000-12 {
+			t.Errorf("expected 12 tokens to be charged, got %v", 1000-level)
+		}
+	})
+
+	t.Run("messages with tool_choice", func(t *testing.T) {
+		var tools []api.Tool
+		if err := json.Unmarshal([]byte(`[
+			{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object", "required": ["location"], "properties": {"location": {"type": "string"}}}}},
+			{"type": "function", "function": {"name": "get_time", "parameters": {"type": "object", "properties": {}}}}
+		]`), &tools); err != nil {
+			t.Fatal(err)
+		}
+
+		output := `{"name":"get_weather","arguments":{"location":"Seattle, WA"}}` + "\n" + `{"name":"get_weather","arguments":{"location":"Tokyo"}}`
+		mock.CompletionResponse = llm.CompletionResponse{
+			Content:    output,
+			Done:       true,
+			DoneReason: llm.DoneReasonStop,
+		}
+
+		chat := func(choice *api.ToolChoice, maxToolCalls int) (*httptest.ResponseRecorder, api.ChatResponse) {
+			t.Helper()
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:        "test-system",
+				Messages:     []api.Message{{Role: "user", Content: "What's the weather in Seattle?"}},
+				Tools:        tools,
+				ToolChoice:   choice,
+				MaxToolCalls: maxToolCalls,
+				Stream:       &stream,
+			})
+
+			var resp api.ChatResponse
+			if w.Code == http.StatusOK {
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return w, resp
+		}
+
+		w, resp := chat(&api.ToolChoice{Mode: "function", Name: "get_weather"}, 0)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if mock.CompletionRequest.Grammar == "" {
+			t.Error("expected the tool call to be enforced by a grammar")
+		}
+
+		if strings.Contains(mock.CompletionRequest.Prompt, "get_time") {
+			t.Error("expected only the chosen tool in the prompt")
+		}
+
+		if len(resp.Message.ToolCalls) != 2 {
+			t.Errorf("expected 2 tool calls, got %v", resp.Message.ToolCalls)
+		}
+
+		_, resp = chat(nil, 1)
+		if mock.CompletionRequest.Grammar != "" {
+			t.Error("expected no grammar without tool_choice")
+		}
+
+		if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Function.Arguments["location"] != "Seattle, WA" {
+			t.Errorf("expected the first tool call only, got %v", resp.Message.ToolCalls)
+		}
+
+		_, resp = chat(&api.ToolChoice{Mode: "none"}, 0)
+		if strings.Contains(mock.CompletionRequest.Prompt, "get_weather") {
+			t.Error("expected no tools in the prompt")
+		}
+
+		if len(resp.Message.ToolCalls) != 0 || resp.Message.Content != output {
+			t.Errorf("expected content only, got %q and %v", resp.Message.Content, resp.Message.ToolCalls)
+		}
+
+		if w, _ := chat(&api.ToolChoice{Mode: "function", Name: "get_stock_price"}, 0); w.Code != http.StatusBadRequest {
+			t.Errorf("unknown function: expected status 400, got %d", w.Code)
+		}
+
+		if w, _ := chat(nil, -1); w.Code != http.StatusBadRequest {
+			t.Errorf("max_tool_calls -1: expected status 400, got %d", w.Code)
+		}
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:      "test-system",
+			Messages:   []api.Message{{Role: "user", Content: "Hello!"}},
+			ToolChoice: &api.ToolChoice{Mode: "required"},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("required without tools: expected status 400, got %d", w.Code)
+		}
+	})
 }
 
 func TestGenerate(t *testing.T) {
@@ -967,4 +1149,70 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("logprobs", func(t *testing.T) {
+		lps := []api.Logprob{
+			{TokenLogprob: api.TokenLogprob{Token: "Hi", Logprob: -0.5}, TopLogprobs: []api.TokenLogprob{{Token: "Hi", Logprob: -0.5}}},
+			{TokenLogprob: api.TokenLogprob{Token: "!", Logprob: -0.1}, TopLogprobs: []api.TokenLogprob{{Token: "!", Logprob: -0.1}}},
+		}
+
+		mock.CompletionFn = func(_ context.Context, _ llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: "Hi", Logprobs: lps[:1]})
+			fn(llm.CompletionResponse{Content: "!", Logprobs: lps[1:]})
+			fn(llm.CompletionResponse{Done: true, DoneReason: llm.DoneReasonStop})
+			return nil
+		}
+		t.Cleanup(func() { mock.CompletionFn = nil })
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:       "test",
+			Prompt:      "Hello!",
+			Stream:      &stream,
+			Logprobs:    true,
+			TopLogprobs: 1,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if !mock.CompletionRequest.Logprobs || mock.CompletionRequest.TopLogprobs != 1 {
+			t.Errorf("logprobs not passed to the runner: %+v", mock.CompletionRequest)
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Response != "Hi!" {
+			t.Errorf("expected response %q, got %q", "Hi!", resp.Response)
+		}
+
+		if diff := cmp.Diff(lps, resp.Logprobs); diff != "" {
+			t.Errorf("logprobs mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid logprobs", func(t *testing.T) {
+		cases := []struct {
+			req api.GenerateRequest
+			err string
+		}{
+			{api.GenerateRequest{Model: "test", Prompt: "Hello!", TopLogprobs: 2}, "top_logprobs requires logprobs"},
+			{api.GenerateRequest{Model: "test", Prompt: "Hello!", Logprobs: true, TopLogprobs: 21}, "top_logprobs must be between 0 and 20"},
+			{api.GenerateRequest{Model: "test", Prompt: "Hello!", Logprobs: true, TopLogprobs: -1}, "top_logprobs must be between 0 and 20"},
+		}
+
+		for _, tt := range cases {
+			w := createRequest(t, s.GenerateHandler, tt.req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+
+			if diff := cmp.Diff(w.Body.String(), `{"error":"`+tt.err+`"}`); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		}
+	})
 }
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llama"
+	enginemodel "github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/types/model"
+)
+
+// tokenizer converts between text and the tokens of a model. Loaded runners
+// are tokenizers, and so is the vocabulary of a model on its own.
+type tokenizer interface {
+	Tokenize(context.Context, string) ([]int, error)
+	Detokenize(context.Context, []int) (string, error)
+}
+
+// vocabs caches the vocabulary tokenizers by model path
+var vocabs sync.Map
+
+var errInvalidToken = errors.New("invalid token")
+
+// checkTokens rejects tokens outside a vocabulary of n tokens before they
+// index into it
+func checkTokens(tokens []int, n int) error {
+	for _, t := range tokens {
+		if t < 0 || t >= n {
+			return fmt.Errorf("%w %d: vocabulary has %d tokens", errInvalidToken, t, n)
+		}
+	}
+	return nil
+}
+
+// textProcessorVocab tokenizes with the Ollama engine's tokenizer
+type textProcessorVocab struct {
+	tp enginemodel.TextProcessor
+}
+
+func (v textProcessorVocab) Tokenize(_ context.Context, content string) ([]int, error) {
+	tokens, err := v.tp.Encode(content, false)
+	if err != nil {
+		return nil, err
+	}
+
+	toks := make([]int, len(tokens))
+	for i, t := range tokens {
+		toks[i] = int(t)
+	}
+	return toks, nil
+}
+
+func (v textProcessorVocab) Detokenize(_ context.Context, tokens []int) (string, error) {
+	if err := checkTokens(tokens, len(v.tp.Vocabulary().Values)); err != nil {
+		return "", err
+	}
+
+	toks := make([]int32, len(tokens))
+	for i, t := range tokens {
+		toks[i] = int32(t)
+	}
+	return v.tp.Decode(toks)
+}
+
+// llamaVocab tokenizes with the vocabulary of a llama.cpp model loaded
+// without its weights, for architectures the Ollama engine does not support
+type llamaVocab struct {
+	mu    *sync.Mutex
+	model *llama.Model
+}
+
+func (v llamaVocab) Tokenize(_ context.Context, content string) ([]int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.model.Tokenize(content, false, true)
+}
+
+func (v llamaVocab) Detokenize(_ context.Context, tokens []int) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := checkTokens(tokens, v.model.NumVocab()); err != nil {
+		return "", err
+	}
+
+	var content string
+	for _, token := range tokens {
+		content += v.model.TokenToPiece(token)
+	}
+	return content, nil
+}
+
+// vocabTokenizer returns a tokenizer for the vocabulary of m, read from its
+// metadata without loading any weights
+func vocabTokenizer(m *Model) (tokenizer, error) {
+	if t, ok := vocabs.Load(m.ModelPath); ok {
+		return t.(tokenizer), nil
+	}
+
+	var t tokenizer
+	if tp, err := enginemodel.NewTextProcessor(m.ModelPath); err == nil {
+		t = textProcessorVocab{tp: tp}
+	} else {
+		slog.Debug("model not supported by Ollama engine, loading vocabulary in compatibility mode", "model", m.ModelPath, "error", err)
+		lm, err := llama.LoadModelFromFile(m.ModelPath, llama.ModelParams{VocabOnly: true})
+		if err != nil {
+			return nil, err
+		}
+		t = llamaVocab{mu: &sync.Mutex{}, model: lm}
+	}
+
+	actual, loaded := vocabs.LoadOrStore(m.ModelPath, t)
+	if lv, ok := t.(llamaVocab); ok && loaded {
+		llama.FreeModel(lv.model)
+	}
+
+	return actual.(tokenizer), nil
+}
+
+// tokenizer returns the vocabulary of the named model when vocabOnly is set
+// and schedules a runner for it otherwise
+func (s *Server) tokenizer(ctx context.Context, name string, vocabOnly bool, requestOpts map[string]any, keepAlive *api.Duration) (tokenizer, error) {
+	n := model.ParseName(name)
+	if !n.IsValid() {
+		return nil, fmt.Errorf("model %w", errRequired)
+	}
+
+	n, err := getExistingName(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkModel(ctx, n); err != nil {
+		return nil, err
+	}
+
+	if !vocabOnly {
+		r, _, _, err := s.scheduleRunner(ctx, n.String(), nil, requestOpts, keepAlive)
+		return r, err
+	}
+
+	m, err := GetModel(n.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return vocabTokenizer(m)
+}
+
+func (s *Server) TokenizeHandler(c *gin.Context) {
+	var req api.TokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	t, err := s.tokenizer(c.Request.Context(), req.Model, req.VocabOnly, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	tokens, err := t.Tokenize(c.Request.Context(), req.Content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if tokens == nil {
+		tokens = []int{}
+	}
+
+	c.JSON(http.StatusOK, api.TokenizeResponse{Model: req.Model, Tokens: tokens})
+}
+
+func (s *Server) DetokenizeHandler(c *gin.Context) {
+	var req api.DetokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	t, err := s.tokenizer(c.Request.Context(), req.Model, req.VocabOnly, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	content, err := t.Detokenize(c.Request.Context(), req.Tokens)
+	if errors.Is(err, errInvalidToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.DetokenizeResponse{Model: req.Model, Content: content})
+}
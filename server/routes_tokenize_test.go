@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	_ "github.com/ollama/ollama/model/models/llama"
+)
+
+func TestTokenize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var loads atomic.Int32
+	mock := mockRunner{}
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				loads.Add(1)
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.model":          "gpt2",
+		"tokenizer.ggml.tokens":         []string{"h", "i", "Ġ", "hi", "Ġhi"},
+		"tokenizer.ggml.token_type":     []int32{1, 1, 1, 1, 1},
+		"tokenizer.ggml.merges":         []string{"h i", "Ġ hi"},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"file.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	t.Run("runner", func(t *testing.T) {
+		w := createRequest(t, s.TokenizeHandler, api.TokenizeRequest{Model: "test", Content: "hi hi hi"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.TokenizeResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(api.TokenizeResponse{Model: "test", Tokens: []int{0, 1, 2}}, resp); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		w = createRequest(t, s.DetokenizeHandler, api.DetokenizeRequest{Model: "test", Tokens: []int{4, 2}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var dresp api.DetokenizeResponse
+		if err := json.NewDecoder(w.Body).Decode(&dresp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(api.DetokenizeResponse{Model: "test", Content: "<4><2>"}, dresp); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("vocab only", func(t *testing.T) {
+		loads.Store(0)
+
+		w := createRequest(t, s.TokenizeHandler, api.TokenizeRequest{Model: "test", Content: "hi hi", VocabOnly: true})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.TokenizeResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff([]int{3, 4}, resp.Tokens); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		w = createRequest(t, s.DetokenizeHandler, api.DetokenizeRequest{Model: "test", Tokens: resp.Tokens, VocabOnly: true})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var dresp api.DetokenizeResponse
+		if err := json.NewDecoder(w.Body).Decode(&dresp); err != nil {
+			t.Fatal(err)
+		}
+
+		if dresp.Content != "hi hi" {
+			t.Errorf("expected %q, got %q", "hi hi", dresp.Content)
+		}
+
+		if n := loads.Load(); n != 0 {
+			t.Errorf("expected no model to be loaded, got %d loads", n)
+		}
+
+		for _, tokens := range [][]int{{3, 5}, {-1}} {
+			w = createRequest(t, s.DetokenizeHandler, api.DetokenizeRequest{Model: "test", Tokens: tokens, VocabOnly: true})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%v: expected status 400, got %d: %s", tokens, w.Code, w.Body)
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		cases := []struct {
+			req  any
+			code int
+			err  string
+		}{
+			{api.TokenizeRequest{}, http.StatusBadRequest, `{"error":"model is required"}`},
+			{api.TokenizeRequest{Model: "missing", VocabOnly: true}, http.StatusNotFound, `{"error":"model \"missing\" not found, try pulling it first"}`},
+			{api.TokenizeRequest{Model: "missing"}, http.StatusNotFound, `{"error":"model \"missing\" not found, try pulling it first"}`},
+		}
+
+		for _, tt := range cases {
+			w := createRequest(t, s.TokenizeHandler, tt.req)
+			if w.Code != tt.code {
+				t.Errorf("%v: expected status %d, got %d", tt.req, tt.code, w.Code)
+			}
+
+			if diff := cmp.Diff(tt.err, w.Body.String()); diff != "" {
+				t.Errorf("%v: mismatch (-want +got):\n%s", tt.req, diff)
+			}
+		}
+	})
+}
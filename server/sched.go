@@ -32,9 +32,17 @@ type LlmRequest struct {
 	successCh       chan *runnerRef
 	errCh           chan error
 	schedAttempts   uint
+
+	// flow, start and seq place the request in the pending queue
+	flow  flow
+	start float64
+	seq   uint64
 }
 
 type Scheduler struct {
+	// queue holds requests waiting for a runner, which are handed to the
+	// pending loop through pendingReqCh in the order of their priority
+	queue         pendingQueue
 	pendingReqCh  chan *LlmRequest
 	finishedReqCh chan *LlmRequest
 	expiredCh     chan *runnerRef
@@ -65,7 +73,9 @@ var ErrMaxQueue = errors.New("server busy, please try again.  maximum pending re
 func InitScheduler(ctx context.Context) *Scheduler {
 	maxQueue := envconfig.MaxQueue()
 	sched := &Scheduler{
-		pendingReqCh:  make(chan *LlmRequest, maxQueue),
+		// the queue orders pending requests, so hand over only one at a
+		// time to let later requests of a higher class go first
+		pendingReqCh:  make(chan *LlmRequest, 1),
 		finishedReqCh: make(chan *LlmRequest, maxQueue),
 		expiredCh:     make(chan *runnerRef, maxQueue),
 		unloadedCh:    make(chan any, maxQueue),
@@ -92,12 +102,11 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 		sessionDuration: sessionDuration,
 		successCh:       make(chan *runnerRef),
 		errCh:           make(chan error, 1),
+		flow:            flowFrom(c),
 	}
 
-	select {
-	case s.pendingReqCh <- req:
-	default:
-		req.errCh <- ErrMaxQueue
+	if err := s.queue.push(req); err != nil {
+		req.errCh <- err
 	}
 	return req.successCh, req.errCh
 }
@@ -105,6 +114,10 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 // Returns immediately, spawns go routines for the scheduler which will shutdown when ctx is done
 func (s *Scheduler) Run(ctx context.Context) {
 	slog.Debug("starting llm scheduler")
+	go func() {
+		s.dispatchPending(ctx)
+	}()
+
 	go func() {
 		s.processPending(ctx)
 	}()
@@ -114,6 +127,30 @@ func (s *Scheduler) Run(ctx context.Context) {
 	}()
 }
 
+// dispatchPending hands the queued request that should go next to the
+// pending loop, looking again whenever another request is queued
+func (s *Scheduler) dispatchPending(ctx context.Context) {
+	for {
+		next := s.queue.peek()
+
+		// a nil channel blocks, so an empty queue waits for a request
+		var pendingReqCh chan *LlmRequest
+		if next != nil {
+			pendingReqCh = s.pendingReqCh
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Debug("shutting down scheduler dispatch loop")
+			return
+		case pendingReqCh <- next:
+			s.queue.remove(next)
+			slog.Debug("dispatching pending request", "class", next.flow.class, "model", next.model.ModelPath, "queued", s.queue.Len())
+		case <-s.queue.wait():
+		}
+	}
+}
+
 func (s *Scheduler) processPending(ctx context.Context) {
 	for {
 		select {
@@ -263,11 +300,9 @@ func (s *Scheduler) processPending(ctx context.Context) {
 							// queue so that we might satisfy other pending
 							// requests that aren't blocked
 							go func() {
-								// Process in a go routine to avoid deadlocking
-								// the scheduler if our queue is full
 								slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
 								time.Sleep(s.reschedDelay)
-								s.pendingReqCh <- pending
+								s.queue.requeue(pending)
 							}()
 							break
 						}
@@ -375,6 +410,7 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 
 			s.loadedMu.Lock()
 			slog.Debug("got lock to unload", "modelPath", runner.modelPath)
+			start, name := time.Now(), modelLabel(runner.model)
 			finished := runner.waitForVRAMRecovery()
 			runner.unload()
 			delete(s.loaded, runner.modelPath)
@@ -383,6 +419,8 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			runner.refMu.Unlock()
 
 			<-finished
+			runnerUnloadsTotal.add(1, name)
+			runnerUnloadDuration.observe(time.Since(start).Seconds(), name)
 			slog.Debug("sending an unloaded event", "modelPath", runner.modelPath)
 			s.unloadedCh <- struct{}{}
 		}
@@ -419,6 +457,7 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 	if req.sessionDuration != nil {
 		sessionDuration = req.sessionDuration.Duration
 	}
+	start := time.Now()
 	llama, err := s.newServerFn(gpus, req.model.ModelPath, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, numParallel)
 	if err != nil {
 		// some older models are not compatible with newer versions of llama.cpp
@@ -428,6 +467,7 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 			err = fmt.Errorf("%v: this model may be incompatible with your version of Ollama. If you previously pulled this model, try updating it by running `ollama pull %s`", err, req.model.ShortName)
 		}
 		slog.Info("NewLlamaServer failed", "model", req.model.ModelPath, "error", err)
+		runnerLoadsTotal.add(1, modelLabel(req.model), "error")
 		req.errCh <- err
 		return
 	}
@@ -455,6 +495,7 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 		defer runner.refMu.Unlock()
 		if err = llama.WaitUntilRunning(req.ctx); err != nil {
 			slog.Error("error loading llama server", "error", err)
+			runnerLoadsTotal.add(1, modelLabel(req.model), "error")
 			runner.refCount--
 			req.errCh <- err
 			slog.Debug("triggering expiration for failed load", "model", runner.modelPath)
@@ -462,6 +503,8 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 			return
 		}
 		slog.Debug("finished setting up runner", "model", req.model.ModelPath)
+		runnerLoadsTotal.add(1, modelLabel(req.model), "success")
+		runnerLoadDuration.observe(time.Since(start).Seconds(), modelLabel(req.model))
 		runner.loading = false
 		go func() {
 			<-req.ctx.Done()
@@ -3,12 +3,19 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
 
 	"github.com/ollama/ollama/api"
@@ -368,10 +375,10 @@ func TestGetRunner(t *testing.T) {
 	s.newServerFn = a.newServer
 	slog.Info("a")
 	successCh1a, errCh1a := s.GetRunner(a.ctx, a.req.model, a.req.opts, a.req.sessionDuration)
-	require.Len(t, s.pendingReqCh, 1)
+	require.Equal(t, 1, s.queue.Len())
 	slog.Info("b")
 	successCh1b, errCh1b := s.GetRunner(b.ctx, b.req.model, b.req.opts, b.req.sessionDuration)
-	require.Len(t, s.pendingReqCh, 1)
+	require.Equal(t, 1, s.queue.Len())
 	require.Empty(t, successCh1b)
 	require.Len(t, errCh1b, 1)
 	err := <-errCh1b
@@ -469,7 +476,7 @@ func TestPrematureExpired(t *testing.T) {
 	}
 	s.newServerFn = scenario1a.newServer
 	successCh1a, errCh1a := s.GetRunner(scenario1a.ctx, scenario1a.req.model, scenario1a.req.opts, scenario1a.req.sessionDuration)
-	require.Len(t, s.pendingReqCh, 1)
+	require.Equal(t, 1, s.queue.Len())
 	s.Run(ctx)
 	select {
 	case resp := <-successCh1a:
@@ -750,6 +757,226 @@ func TestHomogeneousGPUs(t *testing.T) {
 	}
 }
 
+func TestPendingQueue(t *testing.T) {
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+
+	newReq := func(class requestClass, client string) *LlmRequest {
+		return &LlmRequest{ctx: ctx, flow: flow{class: class, client: client}}
+	}
+
+	pop := func(q *pendingQueue) *LlmRequest {
+		next := q.peek()
+		if next != nil {
+			q.remove(next)
+		}
+		return next
+	}
+
+	t.Run("interactive ahead of batch backlog", func(t *testing.T) {
+		var q pendingQueue
+		for range 50 {
+			require.NoError(t, q.push(newReq(classBatch, "job")))
+		}
+
+		// part of the backlog has been scheduled when a user arrives
+		for range 10 {
+			require.Equal(t, "job", pop(&q).flow.client)
+		}
+
+		require.NoError(t, q.push(newReq(classInteractive, "user")))
+		require.NoError(t, q.push(newReq(classBatch, "other-job")))
+		require.Equal(t, "user", pop(&q).flow.client)
+		require.Equal(t, "other-job", pop(&q).flow.client)
+	})
+
+	t.Run("no class starves", func(t *testing.T) {
+		var q pendingQueue
+		for range 100 {
+			require.NoError(t, q.push(newReq(classInteractive, "user")))
+		}
+		for range 4 {
+			require.NoError(t, q.push(newReq(classBackground, "cron")))
+		}
+
+		// background gets one turn for every 16 interactive ones
+		var last int
+		for i := 0; q.Len() > 0; i++ {
+			if pop(&q).flow.class == classBackground {
+				require.LessOrEqual(t, i-last, 17, "background request waited behind %d others", i-last)
+				last = i
+			}
+		}
+		require.Greater(t, last, 0)
+	})
+
+	t.Run("clients share a class", func(t *testing.T) {
+		var q pendingQueue
+		for range 20 {
+			require.NoError(t, q.push(newReq(classBatch, "a")))
+		}
+		for range 5 {
+			require.NoError(t, q.push(newReq(classBatch, "b")))
+		}
+
+		var order []string
+		for range 10 {
+			order = append(order, pop(&q).flow.client)
+		}
+		require.Equal(t, []string{"a", "b", "a", "b", "a", "b", "a", "b", "a", "b"}, order)
+	})
+
+	t.Run("limits per class", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_QUEUE_BATCH", "2")
+
+		var q pendingQueue
+		require.NoError(t, q.push(newReq(classBatch, "a")))
+
+		canceled, cancel := context.WithCancel(ctx)
+		require.NoError(t, q.push(&LlmRequest{ctx: canceled, flow: flow{class: classBatch, client: "b"}}))
+		cancel()
+
+		// the canceled request no longer counts
+		require.NoError(t, q.push(newReq(classBatch, "b")))
+		require.ErrorIs(t, q.push(newReq(classBatch, "c")), ErrMaxQueue)
+		require.NoError(t, q.push(newReq(classInteractive, "c")))
+		require.Equal(t, 3, q.Len())
+	})
+}
+
+func TestFlowMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys := apiKeys{sha256.Sum256([]byte("secret")): {ID: "jobs", Scopes: []apiScope{scopeInference}}}
+
+	cases := []struct {
+		header, auth string
+		keys         apiKeys
+		code         int
+		flow         flow
+	}{
+		{"", "", nil, http.StatusOK, flow{class: classInteractive, client: "ip:192.0.2.1"}},
+		{"Batch", "Bearer secret", keys, http.StatusOK, flow{class: classBatch, client: "key:jobs"}},
+		// a token is not a client unless it is a key
+		{"batch", "Bearer made-up", nil, http.StatusOK, flow{class: classBatch, client: "ip:192.0.2.1"}},
+		{"background", "Basic abc", nil, http.StatusOK, flow{class: classBackground, client: "ip:192.0.2.1"}},
+		{"urgent", "", nil, http.StatusBadRequest, flow{}},
+	}
+
+	for _, tt := range cases {
+		var got flow
+		s := Server{keys: tt.keys}
+		r := gin.New()
+		r.Use(s.requireScope(scopeInference), flowMiddleware)
+		r.GET("/", func(c *gin.Context) { got = flowFrom(c.Request.Context()) })
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set(priorityHeader, tt.header)
+		req.Header.Set("Authorization", tt.auth)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		require.Equal(t, tt.code, w.Code, tt.header)
+		require.Equal(t, tt.flow, got, tt.header)
+	}
+}
+
+func TestRequestPriority(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		header   string
+		priority string
+		code     int
+		class    requestClass
+	}{
+		{"", "", http.StatusOK, classInteractive},
+		{"", "background", http.StatusOK, classBackground},
+		// the header takes precedence over the field
+		{"batch", "background", http.StatusOK, classBatch},
+		{"", "urgent", http.StatusBadRequest, classInteractive},
+	}
+
+	for _, tt := range cases {
+		var got flow
+		r := gin.New()
+		r.Use(flowMiddleware)
+		r.POST("/", func(c *gin.Context) {
+			var req api.GenerateRequest
+			require.NoError(t, c.ShouldBindJSON(&req))
+			if err := setRequestPriority(c, req.Priority); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			got = flowFrom(c.Request.Context())
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"priority": %q}`, tt.priority)))
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set(priorityHeader, tt.header)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		require.Equal(t, tt.code, w.Code, tt.priority)
+		require.Equal(t, tt.class, got.class, tt.priority)
+		if tt.code == http.StatusOK {
+			require.Equal(t, "ip:192.0.2.1", got.client, tt.priority)
+		}
+	}
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 2*time.Second)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, &api.Duration{Duration: time.Second})
+	s.newServerFn = a.newServer
+
+	// each request blocks the scheduler until its runner is received, so
+	// receiving from all of them in one place sees the order they are
+	// scheduled in
+	var names []string
+	var cases []reflect.SelectCase
+	get := func(class requestClass, client, name string) {
+		successCh, errCh := s.GetRunner(withFlow(a.ctx, flow{class: class, client: client}), a.req.model, a.req.opts, a.req.sessionDuration)
+		require.Empty(t, errCh)
+		names = append(names, name)
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(successCh)})
+	}
+
+	// a batch job queues a backlog before the scheduler gets to it, then
+	// others arrive
+	for i := range 4 {
+		get(classBatch, "job", fmt.Sprintf("job%d", i))
+	}
+	get(classBackground, "cron", "cron")
+	get(classBatch, "other-job", "other-job")
+	get(classInteractive, "user", "user")
+	require.Equal(t, 7, s.queue.Len())
+
+	s.Run(ctx)
+
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	var order []string
+	for range names {
+		i, _, _ := reflect.Select(cases)
+		if i == len(cases)-1 {
+			t.Fatal("timeout")
+		}
+
+		order = append(order, names[i])
+		cases[i].Chan = reflect.Value{}
+	}
+
+	require.Equal(t, []string{"user", "job0", "other-job", "cron", "job1", "job2", "job3"}, order)
+	// the dispatch loop removes a request from the queue after handing it
+	// off, which can be after its runner is received
+	require.Eventually(t, func() bool { return s.queue.Len() == 0 }, time.Second, time.Millisecond)
+	a.ctxDone()
+}
+
 type mockLlm struct {
 	pingResp           error
 	waitResp           error
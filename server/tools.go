@@ -0,0 +1,417 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template/parse"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/sample"
+	"github.com/ollama/ollama/template"
+)
+
+// toolParser separates the tool calls in a model's output from its content
+// as the output streams in. Tool calls are JSON values holding the name and
+// arguments keys the model's template writes for them, and may follow the
+// tag the template writes before tool calls, such as [TOOL_CALLS] or
+// <tool_call>.
+type toolParser struct {
+	// name and arguments are the keys of a tool call's name and arguments
+	name, arguments string
+	// prefix and suffix are the tags the template writes around tool calls,
+	// empty if it writes none
+	prefix, suffix string
+	// array is set if the template writes tool calls in a JSON array
+	array bool
+
+	// buf holds output that has not been returned yet
+	buf string
+	// inCalls is set after a prefix or a tool call, where more tool calls
+	// may follow without a prefix and whitespace is not content
+	inCalls bool
+	// tag is a prefix not yet followed by a tool call, returned as content
+	// if none follows
+	tag string
+}
+
+// toolParser returns a parser for the tool calls of the model's template,
+// or nil if the template does not write tool calls
+func (m *Model) toolParser() *toolParser {
+	// create a subtree from the node that ranges over .ToolCalls
+	tmpl := m.Template.Subtree(func(n parse.Node) bool {
+		if t, ok := n.(*parse.RangeNode); ok {
+			return slices.Contains(template.Identifiers(t.Pipe), "ToolCalls")
+		}
+
+		return false
+	})
+
+	if tmpl == nil {
+		return nil
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, map[string][]api.ToolCall{
+		"ToolCalls": {placeholderToolCall},
+	}); err != nil {
+		return nil
+	}
+
+	templateObjects := parseObjects(b.String())
+	if len(templateObjects) == 0 {
+		return nil
+	}
+
+	p := toolParser{}
+
+	// find the keys that correspond to the name and arguments fields
+	for k, v := range templateObjects[0] {
+		switch v.(type) {
+		case string:
+			p.name = k
+		case map[string]any:
+			p.arguments = k
+		}
+	}
+
+	if p.name == "" || p.arguments == "" {
+		return nil
+	}
+
+	p.prefix, p.suffix, p.array = m.toolTags()
+	return &p
+}
+
+var placeholderToolCall = api.ToolCall{
+	Function: api.ToolCallFunction{
+		Name: "@@name@@",
+		Arguments: api.ToolCallFunctionArguments{
+			"@@argument@@": 1,
+		},
+	},
+}
+
+// toolTags returns the text the template writes before and after the JSON
+// of tool calls, and whether that JSON is an array. It compares an assistant
+// message with content to one with a tool call and finds the tool call's
+// JSON in the part that differs.
+func (m *Model) toolTags() (prefix, suffix string, array bool) {
+	render := func(msg api.Message) string {
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Messages: []api.Message{
+			{Role: "user", Content: "@@user@@"},
+			msg,
+			{Role: "user", Content: "@@user@@"},
+		}}); err != nil {
+			slog.Debug("failed to render tool calls", "error", err)
+			return ""
+		}
+
+		return b.String()
+	}
+
+	content := render(api.Message{Role: "assistant", Content: "@@content@@"})
+	calls := render(api.Message{Role: "assistant", ToolCalls: []api.ToolCall{placeholderToolCall}})
+
+	var start int
+	for start < min(len(content), len(calls)) && content[start] == calls[start] {
+		start++
+	}
+
+	end := len(calls)
+	for end > start && len(content)-(len(calls)-end) > start && content[len(content)-(len(calls)-end)-1] == calls[end-1] {
+		end--
+	}
+
+	diff := calls[start:end]
+	name := strings.Index(diff, `"@@name@@"`)
+	if name < 0 {
+		return "", "", false
+	}
+
+	// the tool calls are the first JSON value around the name
+	for i := range name {
+		if diff[i] != '{' && diff[i] != '[' {
+			continue
+		}
+
+		dec := json.NewDecoder(strings.NewReader(diff[i:]))
+		var v any
+		if err := dec.Decode(&v); err == nil && i+int(dec.InputOffset()) > name {
+			return strings.TrimSpace(diff[:i]), strings.TrimSpace(diff[i+int(dec.InputOffset()):]), diff[i] == '['
+		}
+	}
+
+	return "", "", false
+}
+
+// format returns a Format for output that is only tool calls to tools, at
+// least one and at most max of them, or without limit if max is negative.
+// The calls are written as the template writes them, so the model is
+// constrained to what it was trained on.
+func (p *toolParser) format(tools []api.Tool, max int) (*sample.Format, error) {
+	key := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+
+	calls := make([]string, len(tools))
+	for i, tool := range tools {
+		params, err := json.Marshal(tool.Function.Parameters)
+		if err == nil {
+			_, err = sample.CompileJSONSchema(params)
+		}
+		if err != nil {
+			// still require a call to the tool, with any arguments
+			slog.Warn("tool parameters cannot be enforced", "tool", tool.Function.Name, "error", err)
+			params = []byte(`{"type":"object"}`)
+		}
+
+		calls[i] = fmt.Sprintf(`{"type":"object","properties":{%s:{"const":%s},%s:%s},"required":[%s,%s]}`,
+			key(p.name), key(tool.Function.Name), key(p.arguments), params, key(p.name), key(p.arguments))
+	}
+
+	call := fmt.Sprintf(`{"anyOf":[%s]}`, strings.Join(calls, ","))
+
+	var body *sample.Format
+	if p.array {
+		limit := ""
+		if max >= 0 {
+			limit = fmt.Sprintf(`,"maxItems":%d`, max)
+		}
+
+		f, err := sample.CompileJSONSchema([]byte(fmt.Sprintf(`{"type":"array","items":%s,"minItems":1%s}`, call, limit)))
+		if err != nil {
+			return nil, err
+		}
+		body = f
+	} else {
+		f, err := sample.CompileJSONSchema([]byte(call))
+		if err != nil {
+			return nil, err
+		}
+
+		// calls follow each other directly, after a comma, or each in its
+		// own tags
+		sep := `,?`
+		if p.prefix != "" || p.suffix != "" {
+			sep += `|` + regexp.QuoteMeta(p.suffix) + `\s{0,2}` + regexp.QuoteMeta(p.prefix)
+		}
+
+		s, err := sample.CompileRegex(sep)
+		if err != nil {
+			return nil, err
+		}
+		body = sample.Repeat(f, 1, max, s)
+	}
+
+	prefix, err := sample.CompileChoice([]string{p.prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	suffix, err := sample.CompileChoice([]string{p.suffix})
+	if err != nil {
+		return nil, err
+	}
+
+	return sample.Sequence(prefix, body, suffix), nil
+}
+
+// Add parses more of the output, returning the content and tool calls
+// found. Text that may still become part of a tool call is held back until
+// more output shows what it is.
+func (p *toolParser) Add(s string) (string, []api.ToolCall) {
+	p.buf += s
+	return p.parse(false)
+}
+
+// Done returns what is left of the output once it is complete
+func (p *toolParser) Done() (string, []api.ToolCall) {
+	return p.parse(true)
+}
+
+func (p *toolParser) parse(done bool) (string, []api.ToolCall) {
+	var sb strings.Builder
+	var calls []api.ToolCall
+
+	for len(p.buf) > 0 {
+		if p.inCalls {
+			// skip what separates tool calls, including any brackets left
+			// over from a model closing them too often, and the tags
+			// around them
+			rest := strings.TrimLeft(p.buf, " \t\r\n,;]}")
+			for _, tag := range []string{p.suffix, p.prefix} {
+				if tag != "" && strings.HasPrefix(rest, tag) {
+					rest = rest[len(tag):]
+				}
+			}
+
+			if len(rest) < len(p.buf) {
+				if p.tag != "" {
+					p.tag += p.buf[:len(p.buf)-len(rest)]
+				}
+				p.buf = rest
+				continue
+			}
+
+			if !done && (isPartial(p.buf, p.suffix) || isPartial(p.buf, p.prefix)) {
+				break
+			}
+
+			if p.buf[0] != '{' && p.buf[0] != '[' {
+				p.leaveCalls(&sb)
+				continue
+			}
+		} else {
+			i := strings.IndexAny(p.buf, "{[")
+			if p.prefix != "" {
+				if j := strings.Index(p.buf, p.prefix); j >= 0 && (i < 0 || j <= i) {
+					sb.WriteString(p.buf[:j])
+					p.tag = p.prefix
+					p.buf = p.buf[j+len(p.prefix):]
+					p.inCalls = true
+					continue
+				}
+			}
+
+			if i < 0 {
+				keep := 0
+				if !done {
+					keep = partialSuffix(p.buf, p.prefix)
+				}
+
+				sb.WriteString(p.buf[:len(p.buf)-keep])
+				p.buf = p.buf[len(p.buf)-keep:]
+				break
+			}
+
+			sb.WriteString(p.buf[:i])
+			p.buf = p.buf[i:]
+
+			// a prefix may start with a bracket, as [TOOL_CALLS] does
+			if !done && isPartial(p.buf, p.prefix) {
+				break
+			}
+		}
+
+		// p.buf starts with what may be a JSON value holding tool calls
+		found, n, err := p.decode(p.buf)
+		switch {
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			if !done {
+				return sb.String(), calls
+			}
+
+			// output that ends partway through JSON is content
+			p.leaveCalls(&sb)
+			sb.WriteString(p.buf)
+			p.buf = ""
+		case err != nil || len(found) == 0:
+			if !p.inCalls {
+				// not JSON, or JSON that is not a tool call, is content
+				n = max(n, 1)
+				sb.WriteString(p.buf[:n])
+				p.buf = p.buf[n:]
+				continue
+			}
+
+			p.leaveCalls(&sb)
+		default:
+			calls = append(calls, found...)
+			p.buf = p.buf[n:]
+			p.inCalls = true
+			p.tag = ""
+		}
+	}
+
+	if done {
+		p.leaveCalls(&sb)
+	}
+
+	return sb.String(), calls
+}
+
+// leaveCalls returns to parsing content, which includes a prefix that no
+// tool call followed
+func (p *toolParser) leaveCalls(sb *strings.Builder) {
+	sb.WriteString(p.tag)
+	p.tag = ""
+	p.inCalls = false
+}
+
+// decode reads the JSON value at the start of s and returns the tool calls
+// in it and its length. A value that is not complete yet returns
+// io.ErrUnexpectedEOF.
+func (p *toolParser) decode(s string) ([]api.ToolCall, int, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	var v any
+	if err := dec.Decode(&v); errors.Is(err, io.EOF) {
+		return nil, 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	// collect all nested objects
+	var collect func(any) []map[string]any
+	collect = func(obj any) (all []map[string]any) {
+		switch o := obj.(type) {
+		case map[string]any:
+			all = append(all, o)
+			for _, v := range o {
+				all = append(all, collect(v)...)
+			}
+		case []any:
+			for _, v := range o {
+				all = append(all, collect(v)...)
+			}
+		}
+
+		return all
+	}
+
+	var calls []api.ToolCall
+	for _, kv := range collect(v) {
+		n, nok := kv[p.name].(string)
+		a, aok := kv[p.arguments].(map[string]any)
+		if s, ok := kv[p.arguments].(string); ok && !aok {
+			// some models write the arguments as a JSON string
+			aok = json.Unmarshal([]byte(s), &a) == nil
+		}
+
+		if nok && aok {
+			calls = append(calls, api.ToolCall{
+				Function: api.ToolCallFunction{
+					Name:      n,
+					Arguments: a,
+				},
+			})
+		}
+	}
+
+	return calls, int(dec.InputOffset()), nil
+}
+
+// isPartial reports whether s is the start of tag, but not all of it
+func isPartial(s, tag string) bool {
+	return len(s) < len(tag) && strings.HasPrefix(tag, s)
+}
+
+// partialSuffix returns the length of the longest end of s that is the
+// start of tag
+func partialSuffix(s, tag string) int {
+	for n := min(len(s), len(tag)-1); n > 0; n-- {
+		if strings.HasPrefix(tag, s[len(s)-n:]) {
+			return n
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/template"
+)
+
+func TestToolParser(t *testing.T) {
+	p := filepath.Join("testdata", "tools")
+
+	call := func(format, location string) api.ToolCall {
+		return api.ToolCall{
+			Function: api.ToolCallFunction{
+				Name: "get_current_weather",
+				Arguments: api.ToolCallFunctionArguments{
+					"format":   format,
+					"location": location,
+				},
+			},
+		}
+	}
+	sf, toronto := call("fahrenheit", "San Francisco, CA"), call("celsius", "Toronto, Canada")
+
+	cases := []struct {
+		name    string
+		model   string
+		output  string
+		content string
+		calls   []api.ToolCall
+	}{
+		{
+			name:    "tag and content after",
+			model:   "mistral",
+			output:  `[TOOL_CALLS]  [{"name": "get_current_weather", "arguments": {"format":"fahrenheit","location":"San Francisco, CA"}}]` + "\n\nIt is 70°F.",
+			content: "It is 70°F.",
+			calls:   []api.ToolCall{sf},
+		},
+		{
+			name:    "content before",
+			model:   "mistral",
+			output:  `Let me check. {"name": "get_current_weather", "arguments": {"format":"fahrenheit","location":"San Francisco, CA"}}`,
+			content: "Let me check. ",
+			calls:   []api.ToolCall{sf},
+		},
+		{
+			name:    "tag without calls",
+			model:   "mistral",
+			output:  `[TOOL_CALLS] none needed`,
+			content: `[TOOL_CALLS] none needed`,
+		},
+		{
+			name:    "json that is not a call",
+			model:   "mistral",
+			output:  `Use [1, 2] or {"a": "b"} and [see docs](url) {`,
+			content: `Use [1, 2] or {"a": "b"} and [see docs](url) {`,
+		},
+		{
+			name:    "truncated",
+			model:   "mistral",
+			output:  `[TOOL_CALLS] [{"name": "get_current_weather", "arguments": {"format":"fahrenheit","location":"San Francisco, CA"}},{"name": "get_cur`,
+			content: `[TOOL_CALLS] [{"name": "get_current_weather", "arguments": {"format":"fahrenheit","location":"San Francisco, CA"}},{"name": "get_cur`,
+		},
+		{
+			name:    "tags around each call",
+			model:   "llama3-groq-tool-use",
+			output:  "<tool_call>\n" + `{"name": "get_current_weather", "arguments": {"format":"fahrenheit","location":"San Francisco, CA"}}` + "\n</tool_call>\n<tool_call>\n" + `{"name": "get_current_weather", "arguments": "{\"format\":\"celsius\",\"location\":\"Toronto, Canada\"}"}` + "\n</tool_call>\nDone.",
+			content: "Done.",
+			calls:   []api.ToolCall{sf, toronto},
+		},
+		{
+			name:   "code fence",
+			model:  "command-r-plus",
+			output: "Action: ```json\n" + `[{"tool_name": "get_current_weather", "parameters": {"format":"celsius","location":"Toronto, Canada"}}]` + "\n```",
+			calls:  []api.ToolCall{toronto},
+		},
+		{
+			name:   "nested",
+			model:  "xlam",
+			output: `{"tool_calls": [{"name": "get_current_weather", "arguments": {"format":"fahrenheit","location":"San Francisco, CA"}},{"name": "get_current_weather", "arguments": {"format":"celsius","location":"Toronto, Canada"}}]}`,
+			calls:  []api.ToolCall{sf, toronto},
+		},
+		{
+			name:    "content only",
+			model:   "llama3-groq-tool-use",
+			output:  "The weather is <b>nice</b>, < 30°C.",
+			content: "The weather is <b>nice</b>, < 30°C.",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := template.Parse(readFile(t, p, tt.model+".gotmpl").String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &Model{Template: tmpl}
+
+			// the result must not depend on how the output is split
+			for _, size := range []int{1, 2, 3, 7, len(tt.output)} {
+				parser := m.toolParser()
+				if parser == nil {
+					t.Fatal("expected a tool parser")
+				}
+
+				var content string
+				var calls []api.ToolCall
+				for i := 0; i < len(tt.output); i += size {
+					c, tc := parser.Add(tt.output[i:min(i+size, len(tt.output))])
+					content += c
+					calls = append(calls, tc...)
+				}
+
+				c, tc := parser.Done()
+				content += c
+				calls = append(calls, tc...)
+
+				if content != tt.content {
+					t.Errorf("size %d: expected content %q, got %q", size, tt.content, content)
+				}
+
+				if diff := cmp.Diff(calls, tt.calls); diff != "" {
+					t.Errorf("size %d: tool calls mismatch (-got +want):\n%s", size, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestToolParserStreams(t *testing.T) {
+	tmpl, err := template.Parse(readFile(t, filepath.Join("testdata", "tools"), "llama3-groq-tool-use.gotmpl").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parser := (&Model{Template: tmpl}).toolParser()
+
+	steps := []struct {
+		add     string
+		content string
+		calls   int
+	}{
+		{"Checking", "Checking", 0},
+		{" now <tool", " now ", 0},
+		{"_call>\n{\"name\": \"a\",", "", 0},
+		{" \"arguments\": {}}\n", "", 1},
+		{"</tool_call>\nok", "ok", 0},
+	}
+
+	for _, step := range steps {
+		content, calls := parser.Add(step.add)
+		if content != step.content || len(calls) != step.calls {
+			t.Errorf("after %q: expected %q and %d calls, got %q and %v", step.add, step.content, step.calls, content, calls)
+		}
+	}
+}
+
+func TestToolParserFormat(t *testing.T) {
+	p := filepath.Join("testdata", "tools")
+
+	var tools []api.Tool
+	if err := json.Unmarshal(readFile(t, p, "tools.json").Bytes(), &tools); err != nil {
+		t.Fatal(err)
+	}
+
+	// arguments follow the order of the parameters' properties, which is
+	// sorted as the parameters are a map
+	sf := `{"name": "get_current_weather", "arguments": {"format": "fahrenheit", "location": "San Francisco, CA"}}`
+	toronto := `{"name": "get_current_weather", "arguments": {"format": "celsius", "location": "Toronto, Canada"}}`
+
+	cases := []struct {
+		model   string
+		valid   []string
+		invalid []string
+	}{
+		{
+			model: "mistral",
+			valid: []string{`[TOOL_CALLS] [` + sf + `]`, `[TOOL_CALLS] [` + sf + `, ` + toronto + `]`},
+			invalid: []string{
+				sf,
+				`[TOOL_CALLS] []`,
+				`[TOOL_CALLS] [` + sf + `, ` + toronto + `, ` + sf + `]`,
+				`[TOOL_CALLS] [{"name": "get_current_weather", "arguments": {"location": "Paris"}}]`,
+				`[TOOL_CALLS] [{"name": "get_time", "arguments": {}}]`,
+				"It is 70°F.",
+			},
+		},
+		{
+			model:   "llama3-groq-tool-use",
+			valid:   []string{"<tool_call>\n" + sf + "\n</tool_call>", "<tool_call>\n" + sf + "\n</tool_call>\n<tool_call>\n" + toronto + "\n</tool_call>", "<tool_call>\n" + sf + "\n" + toronto + "\n</tool_call>"},
+			invalid: []string{sf, "<tool_call>\n</tool_call>", "<tool_call>\n" + sf},
+		},
+		{
+			model:   "command-r-plus",
+			valid:   []string{"Action: ```json\n" + `[{"tool_name": "get_current_weather", "parameters": {"format": "celsius", "location": "Toronto, Canada"}}]` + "\n```"},
+			invalid: []string{"Action: ```json\n[" + sf + "]\n```"},
+		},
+		{
+			model:   "xlam",
+			valid:   []string{sf, sf + ", " + toronto},
+			invalid: []string{`{"tool_calls": [` + sf + `]}`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.model, func(t *testing.T) {
+			tmpl, err := template.Parse(readFile(t, p, tt.model+".gotmpl").String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := (&Model{Template: tmpl}).toolParser().format(tools, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.valid {
+				if !f.Match(s) {
+					t.Errorf("expected %q to match", s)
+				}
+			}
+
+			for _, s := range tt.invalid {
+				if f.Match(s) {
+					t.Errorf("expected %q not to match", s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package updateservice
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultChannel is used when no channel is configured
+const DefaultChannel = "stable"
+
+// machineIDFile holds the ID that places this machine in staged rollouts
+const machineIDFile = "machine_id"
+
+// Release is a single release published on a channel
+type Release struct {
+	Version      string     `json:"version"`
+	ReleaseDate  time.Time  `json:"release_date"`
+	ReleaseNotes string     `json:"release_notes"`
+	Artifacts    []Artifact `json:"artifacts"`
+
+	// RolloutPercentage offers the release to only this share of machines.
+	// Unset means every machine.
+	RolloutPercentage *int `json:"rollout_percentage,omitempty"`
+	// MinimumSupportedVersion makes the update required for machines
+	// running an older version, bypassing the rollout
+	MinimumSupportedVersion string `json:"minimum_supported_version,omitempty"`
+}
+
+// ReleaseManifest lists the current release of each channel. A manifest
+// without channels describes a single release on the stable channel.
+type ReleaseManifest struct {
+	Release
+	Channels map[string]Release `json:"channels,omitempty"`
+}
+
+// channel returns the release published on the named channel
+func (m *ReleaseManifest) channel(name string) (*Release, bool) {
+	if r, ok := m.Channels[name]; ok {
+		return &r, true
+	}
+
+	if name == DefaultChannel && m.Version != "" {
+		return &m.Release, true
+	}
+
+	return nil, false
+}
+
+// artifact returns the artifact built for goos/goarch
+func (r *Release) artifact(goos, goarch string) *Artifact {
+	for i := range r.Artifacts {
+		if r.Artifacts[i].OS == goos && r.Artifacts[i].Arch == goarch {
+			return &r.Artifacts[i]
+		}
+	}
+	return nil
+}
+
+// decision is the outcome of applying the update policy to a release
+type decision struct {
+	available bool
+	required  bool
+	artifact  *Artifact
+	reason    string
+}
+
+// evaluate decides whether release should be offered to this machine
+func (um *UpdateManager) evaluate(release *Release) (decision, error) {
+	var d decision
+
+	cmp, err := CompareVersions(release.Version, um.currentVersion)
+	if err != nil {
+		return d, fmt.Errorf("failed to compare versions: %w", err)
+	}
+	if cmp <= 0 {
+		d.reason = fmt.Sprintf("up to date: %s is not newer than the current version %s", release.Version, um.currentVersion)
+		return d, nil
+	}
+
+	if floor := release.MinimumSupportedVersion; floor != "" {
+		below, err := CompareVersions(um.currentVersion, floor)
+		if err != nil {
+			return d, fmt.Errorf("invalid minimum supported version: %w", err)
+		}
+		d.required = below < 0
+	}
+
+	var pinned bool
+	if pin := um.config.PinnedVersion; pin != "" {
+		cmp, err := CompareVersions(release.Version, pin)
+		if err != nil {
+			return d, fmt.Errorf("invalid pinned version: %w", err)
+		}
+		if cmp > 0 {
+			d.reason = fmt.Sprintf("held back: %s is newer than the pinned version %s", release.Version, pin)
+			if d.required {
+				d.reason += fmt.Sprintf(", although %s is below the minimum supported version %s", um.currentVersion, release.MinimumSupportedVersion)
+			}
+			return d, nil
+		}
+		pinned = cmp == 0
+	}
+
+	if pct := release.RolloutPercentage; pct != nil && !d.required && !pinned {
+		machineID, err := um.machineID()
+		if err != nil {
+			return d, err
+		}
+
+		if bucket := rolloutBucket(machineID, release.Version); bucket >= *pct {
+			d.reason = fmt.Sprintf("not yet rolled out to this machine: %s is at %d%%, this machine is in bucket %d", release.Version, *pct, bucket)
+			return d, nil
+		}
+	}
+
+	d.artifact = release.artifact(runtime.GOOS, runtime.GOARCH)
+	if d.artifact == nil {
+		d.reason = fmt.Sprintf("%s has no artifact for %s/%s", release.Version, runtime.GOOS, runtime.GOARCH)
+		return d, nil
+	}
+
+	d.available = true
+	switch {
+	case d.required:
+		d.reason = fmt.Sprintf("update required: %s is below the minimum supported version %s", um.currentVersion, release.MinimumSupportedVersion)
+	case pinned:
+		d.reason = fmt.Sprintf("%s matches the pinned version", release.Version)
+	case release.RolloutPercentage != nil:
+		d.reason = fmt.Sprintf("%s is rolled out to %d%% of machines, including this one", release.Version, *release.RolloutPercentage)
+	default:
+		d.reason = fmt.Sprintf("%s is newer than the current version %s", release.Version, um.currentVersion)
+	}
+	return d, nil
+}
+
+// rolloutBucket deterministically places a machine in [0, 100) for a given
+// release. Mixing in the version means the same machines aren't always the
+// first to get an update.
+func rolloutBucket(machineID, version string) int {
+	sum := sha256.Sum256([]byte(machineID + ":" + version))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// machineID returns the ID stored in the data dir, creating one on first use
+func (um *UpdateManager) machineID() (string, error) {
+	p := filepath.Join(um.dataDir, machineIDFile)
+
+	bts, err := os.ReadFile(p)
+	if err == nil {
+		if id := strings.TrimSpace(string(bts)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read machine ID: %w", err)
+	}
+
+	id := uuid.NewString()
+	if err := os.WriteFile(p, []byte(id+"\n"), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write machine ID: %w", err)
+	}
+	return id, nil
+}
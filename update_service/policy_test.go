@@ -0,0 +1,207 @@
+package updateservice
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func percent(n int) *int {
+	return &n
+}
+
+func TestUpdatePolicy(t *testing.T) {
+	pub := newSigningKey(t)
+
+	cases := []struct {
+		name      string
+		channel   string
+		pin       string
+		modify    func(rs *releaseServer)
+		available bool
+		required  bool
+		version   string
+		reason    string
+	}{
+		{
+			name:      "stable without channels",
+			available: true,
+			version:   "0.2.0",
+			reason:    "newer than the current version",
+		},
+		{
+			name:    "beta channel",
+			channel: "beta",
+			modify: func(rs *releaseServer) {
+				beta := rs.manifest.Release
+				beta.Version = "0.3.0-beta.1"
+				rs.manifest.Channels = map[string]Release{"stable": rs.manifest.Release, "beta": beta}
+			},
+			available: true,
+			version:   "0.3.0-beta.1",
+		},
+		{
+			name:    "unknown channel",
+			channel: "nightly",
+			reason:  `channel "nightly" is not in the release manifest`,
+			version: "0.1.0",
+		},
+		{
+			name:    "held back by pin",
+			pin:     "0.1.5",
+			reason:  "held back: 0.2.0 is newer than the pinned version 0.1.5",
+			version: "0.2.0",
+		},
+		{
+			name: "pinned version skips rollout",
+			pin:  "0.2.0",
+			modify: func(rs *releaseServer) {
+				rs.manifest.RolloutPercentage = percent(0)
+			},
+			available: true,
+			version:   "0.2.0",
+			reason:    "matches the pinned version",
+		},
+		{
+			name: "no rollout",
+			modify: func(rs *releaseServer) {
+				rs.manifest.RolloutPercentage = percent(0)
+			},
+			version: "0.2.0",
+			reason:  "not yet rolled out to this machine",
+		},
+		{
+			name: "full rollout",
+			modify: func(rs *releaseServer) {
+				rs.manifest.RolloutPercentage = percent(100)
+			},
+			available: true,
+			version:   "0.2.0",
+			reason:    "rolled out to 100% of machines",
+		},
+		{
+			name: "below minimum bypasses rollout",
+			modify: func(rs *releaseServer) {
+				rs.manifest.RolloutPercentage = percent(0)
+				rs.manifest.MinimumSupportedVersion = "0.2.0"
+			},
+			available: true,
+			required:  true,
+			version:   "0.2.0",
+			reason:    "update required",
+		},
+		{
+			name: "above minimum respects rollout",
+			modify: func(rs *releaseServer) {
+				rs.manifest.RolloutPercentage = percent(0)
+				rs.manifest.MinimumSupportedVersion = "0.1.0"
+			},
+			version: "0.2.0",
+			reason:  "not yet rolled out",
+		},
+		{
+			name: "no artifact for platform",
+			modify: func(rs *releaseServer) {
+				rs.manifest.Artifacts = rs.manifest.Artifacts[:1]
+			},
+			version: "0.2.0",
+			reason:  "has no artifact for",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := newReleaseServer(t, "0.2.0", []byte("new"))
+			if tt.modify != nil {
+				tt.modify(rs)
+			}
+
+			um, _ := newTestUpdater(t, rs, pub, nil)
+			if tt.channel != "" {
+				um.SetChannel(tt.channel)
+			}
+			if err := um.SetPinnedVersion(tt.pin); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := um.CheckForUpdates(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			info := um.GetLastUpdateInfo()
+			if info.Available != tt.available || info.Required != tt.required {
+				t.Fatalf("expected available=%t required=%t, got %+v", tt.available, tt.required, info)
+			}
+			if info.NewVersion != tt.version {
+				t.Fatalf("expected version %s, got %s", tt.version, info.NewVersion)
+			}
+			if !strings.Contains(info.Reason, tt.reason) {
+				t.Fatalf("expected reason containing %q, got %q", tt.reason, info.Reason)
+			}
+			if want := tt.channel; want != "" && info.Channel != want {
+				t.Fatalf("expected channel %s, got %s", want, info.Channel)
+			}
+		})
+	}
+}
+
+func TestSetPinnedVersionInvalid(t *testing.T) {
+	um, err := New(t.TempDir(), "0.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := um.SetPinnedVersion("latest"); err == nil {
+		t.Fatal("expected error for invalid pinned version")
+	}
+}
+
+func TestMachineID(t *testing.T) {
+	dir := t.TempDir()
+
+	um, err := New(dir, "0.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := um.machineID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" {
+		t.Fatal("expected a machine ID")
+	}
+
+	// A new manager over the same data dir lands in the same bucket
+	again, err := New(dir, "0.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id2, err := again.machineID(); err != nil || id2 != id {
+		t.Fatalf("expected stored machine ID %s, got %s (%v)", id, id2, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, machineIDFile)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRolloutBucket(t *testing.T) {
+	if rolloutBucket("machine", "0.2.0") != rolloutBucket("machine", "0.2.0") {
+		t.Fatal("expected buckets to be deterministic")
+	}
+
+	// Roughly a quarter of machines fall under a 25% rollout
+	var in int
+	for i := range 10000 {
+		if rolloutBucket(fmt.Sprintf("machine-%d", i), "0.2.0") < 25 {
+			in++
+		}
+	}
+	if in < 2300 || in > 2700 {
+		t.Fatalf("expected about 2500 machines in a 25%% rollout, got %d", in)
+	}
+}
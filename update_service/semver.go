@@ -0,0 +1,119 @@
+package updateservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version. Build metadata is dropped since it
+// does not affect precedence.
+type semver struct {
+	major, minor, patch int
+	prerelease          []string
+}
+
+// parseSemver parses versions such as "1.2.3", "v1.2.3" and "1.2.3-rc.1".
+// Missing minor and patch components are treated as zero.
+func parseSemver(s string) (semver, error) {
+	var v semver
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s, _, _ = strings.Cut(s, "+")
+
+	core, pre, hasPre := strings.Cut(s, "-")
+	if hasPre {
+		if pre == "" {
+			return v, fmt.Errorf("invalid version %q: empty prerelease", s)
+		}
+		v.prerelease = strings.Split(pre, ".")
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[i] = n
+	}
+
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 as v is lower than, equal to or higher than w
+func (v semver) compare(w semver) int {
+	for _, c := range [][2]int{{v.major, w.major}, {v.minor, w.minor}, {v.patch, w.patch}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A release has higher precedence than any of its prereleases
+	switch {
+	case len(v.prerelease) == 0 && len(w.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(w.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(w.prerelease); i++ {
+		a, b := v.prerelease[i], w.prerelease[i]
+		if a == b {
+			continue
+		}
+
+		an, aerr := strconv.Atoi(a)
+		bn, berr := strconv.Atoi(b)
+		switch {
+		case aerr == nil && berr == nil:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aerr == nil:
+			// Numeric identifiers sort before alphanumeric ones
+			return -1
+		case berr == nil:
+			return 1
+		case a < b:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	switch {
+	case len(v.prerelease) < len(w.prerelease):
+		return -1
+	case len(v.prerelease) > len(w.prerelease):
+		return 1
+	}
+	return 0
+}
+
+// CompareVersions compares two semantic version strings, returning -1, 0 or
+// 1 as a is lower than, equal to or higher than b
+func CompareVersions(a, b string) (int, error) {
+	va, err := parseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+
+	vb, err := parseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return va.compare(vb), nil
+}
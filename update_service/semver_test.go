@@ -0,0 +1,41 @@
+package updateservice
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"0.2.0", "0.1.0", 1},
+		{"0.1.0", "0.2.0", -1},
+		{"v1.0.0", "1.0.0", 0},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0", "1.0.0", 0},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"1.0.0+build.5", "1.0.0", 0},
+		{"0.0.0", "0.6.5", -1},
+	}
+
+	for _, tt := range cases {
+		got, err := CompareVersions(tt.a, tt.b)
+		if err != nil {
+			t.Fatalf("%s vs %s: %v", tt.a, tt.b, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s vs %s: expected %d, got %d", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
+
+func TestCompareVersionsInvalid(t *testing.T) {
+	for _, v := range []string{"", "a.b.c", "1.2.3.4", "1.-2.3", "1.2.3-"} {
+		if _, err := CompareVersions(v, "1.0.0"); err == nil {
+			t.Errorf("expected error parsing %q", v)
+		}
+	}
+}
@@ -1,12 +1,26 @@
 package updateservice
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/ollama/ollama/auth"
+	"github.com/ollama/ollama/version"
 )
 
 // UpdateInfo represents information about an available update
@@ -17,54 +31,224 @@ type UpdateInfo struct {
 	ReleaseDate    time.Time `json:"release_date"`
 	ReleaseNotes   string    `json:"release_notes"`
 	DownloadURL    string    `json:"download_url,omitempty"`
+
+	// Channel is the release channel that was checked
+	Channel string `json:"channel,omitempty"`
+	// Reason explains why the update was or was not offered
+	Reason string `json:"reason,omitempty"`
+	// Required is set when the current version is below the release's
+	// minimum supported version
+	Required bool `json:"required,omitempty"`
+
+	// Artifact is the release binary for this platform
+	Artifact *Artifact `json:"artifact,omitempty"`
+	// PreviousVersion is the version Rollback restores
+	PreviousVersion string `json:"previous_version,omitempty"`
+}
+
+// Artifact is a release binary for a single platform
+type Artifact struct {
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	// Signature is an auth.Sign signature over "sha256:<hex>"
+	Signature string `json:"signature"`
+}
+
+// Config configures where updates come from and how they are installed
+type Config struct {
+	// ManifestURL serves the ReleaseManifest
+	ManifestURL string
+	// Channel selects the release channel, DefaultChannel if empty
+	Channel string
+	// PinnedVersion holds back any release newer than this version
+	PinnedVersion string
+	// PublicKeys are the ed25519 keys trusted to sign artifacts
+	PublicKeys []string
+	// ExecutablePath is the binary ApplyUpdate replaces; it defaults to the
+	// running executable
+	ExecutablePath string
+	// HealthCheck is run against the new binary after it is swapped in. An
+	// error rolls the update back. It defaults to running "<binary> --version".
+	HealthCheck func(ctx context.Context, executable string) error
 }
 
+var (
+	ErrNoManifestURL = errors.New("no update manifest URL configured")
+	ErrNoUpdate      = errors.New("no update available to apply")
+)
+
 // UpdateManager handles checking for and applying updates
 type UpdateManager struct {
+	// mu guards the fields below. It is not held while manifests and
+	// artifacts are downloaded or new binaries are checked, so the last
+	// update info can be read during an update.
+	mu sync.Mutex
+	// installing serializes ApplyUpdate and Rollback, which replace the
+	// executable
+	installing sync.Mutex
+
 	dataDir        string
 	currentVersion string
+	config         Config
+	client         *http.Client
 	lastCheck      time.Time
 	updateInfo     *UpdateInfo
 }
 
-// New creates a new UpdateManager instance
+// New creates a new UpdateManager instance without an update source
 func New(dataDir string, currentVersion string) (*UpdateManager, error) {
+	return NewWithConfig(dataDir, currentVersion, Config{})
+}
+
+// NewWithConfig creates a new UpdateManager instance. An empty
+// currentVersion means version.Version.
+func NewWithConfig(dataDir string, currentVersion string, config Config) (*UpdateManager, error) {
 	// Create data directory if it doesn't exist
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
+	if currentVersion == "" {
+		currentVersion = version.Version
+	}
+
+	if config.HealthCheck == nil {
+		config.HealthCheck = defaultHealthCheck
+	}
+
+	if config.Channel == "" {
+		config.Channel = DefaultChannel
+	}
+
 	return &UpdateManager{
 		dataDir:        dataDir,
 		currentVersion: currentVersion,
+		config:         config,
+		client:         http.DefaultClient,
 	}, nil
 }
 
-// CheckForUpdates checks if an update is available
-// In a real implementation, this would contact a server to check for updates
-func (um *UpdateManager) CheckForUpdates() (*UpdateInfo, error) {
-	// In a real implementation, we would make a network request to check for updates
-	// For simulation purposes, we'll return a static response
+// CurrentVersion returns the version currently installed
+func (um *UpdateManager) CurrentVersion() string {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	return um.currentVersion
+}
+
+// SetChannel switches the release channel used by CheckForUpdates
+func (um *UpdateManager) SetChannel(channel string) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
 
-	// Pretend to do a network request
-	time.Sleep(500 * time.Millisecond)
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	um.config.Channel = channel
+}
 
-	// Simulate an available update
-	um.updateInfo = &UpdateInfo{
-		Available:      true,
+// SetPinnedVersion holds back releases newer than version. An empty version
+// removes the pin.
+func (um *UpdateManager) SetPinnedVersion(version string) error {
+	if version != "" {
+		if _, err := parseSemver(version); err != nil {
+			return err
+		}
+	}
+
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	um.config.PinnedVersion = version
+	return nil
+}
+
+// CheckForUpdates fetches the release manifest and applies the channel,
+// pin, minimum version and rollout policy to decide whether to offer the
+// release on the configured channel
+func (um *UpdateManager) CheckForUpdates(ctx context.Context) (*UpdateInfo, error) {
+	um.mu.Lock()
+	manifestURL := um.config.ManifestURL
+	um.mu.Unlock()
+
+	manifest, err := um.fetchManifest(ctx, manifestURL)
+	if err != nil {
+		return nil, err
+	}
+
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	info := &UpdateInfo{
 		CurrentVersion: um.currentVersion,
-		NewVersion:     "0.2.0", // Always one version ahead
-		ReleaseDate:    time.Now(),
-		ReleaseNotes:   "Bug fixes and performance improvements",
-		DownloadURL:    "https://example.com/kcriff/update/0.2.0",
+		Channel:        um.config.Channel,
+	}
+	if um.updateInfo != nil {
+		info.PreviousVersion = um.updateInfo.PreviousVersion
+	}
+
+	release, ok := manifest.channel(um.config.Channel)
+	if !ok {
+		info.NewVersion = um.currentVersion
+		info.Reason = fmt.Sprintf("channel %q is not in the release manifest", um.config.Channel)
+	} else {
+		d, err := um.evaluate(release)
+		if err != nil {
+			return nil, err
+		}
+
+		info.NewVersion = release.Version
+		info.ReleaseDate = release.ReleaseDate
+		info.ReleaseNotes = release.ReleaseNotes
+		info.Available = d.available
+		info.Required = d.required
+		info.Reason = d.reason
+		if d.artifact != nil {
+			info.Artifact = d.artifact
+			info.DownloadURL = d.artifact.URL
+		}
 	}
 
+	um.updateInfo = info
 	um.lastCheck = time.Now()
 
 	// Save update info to disk
-	um.saveUpdateInfo()
+	if err := um.saveUpdateInfo(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	infoCopy := *info
+	return &infoCopy, nil
+}
+
+// fetchManifest downloads and decodes the release manifest at manifestURL
+func (um *UpdateManager) fetchManifest(ctx context.Context, manifestURL string) (*ReleaseManifest, error) {
+	if manifestURL == "" {
+		return nil, ErrNoManifestURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	return um.updateInfo, nil
+	resp, err := um.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch release manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch release manifest: unexpected status code %d", resp.StatusCode)
+	}
+
+	var manifest ReleaseManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse release manifest: %w", err)
+	}
+
+	return &manifest, nil
 }
 
 // saveUpdateInfo saves the current update info to disk
@@ -109,44 +293,323 @@ func (um *UpdateManager) loadUpdateInfo() error {
 
 // GetLastUpdateInfo returns the latest update info without checking for new updates
 func (um *UpdateManager) GetLastUpdateInfo() *UpdateInfo {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
 	if um.updateInfo == nil {
 		// Try to load from disk
 		if err := um.loadUpdateInfo(); err != nil {
 			log.Printf("Warning: Failed to load update info: %v", err)
 		}
 	}
-	return um.updateInfo
+
+	if um.updateInfo == nil {
+		return nil
+	}
+
+	infoCopy := *um.updateInfo
+	return &infoCopy
 }
 
-// ApplyUpdate applies the available update
-// In a real implementation, this would download and install the update
-func (um *UpdateManager) ApplyUpdate() error {
-	if um.updateInfo == nil || !um.updateInfo.Available {
-		return fmt.Errorf("no update available to apply")
+// ApplyUpdate downloads and verifies the available update, swaps it in for
+// the current executable and rolls back if the new binary fails its health
+// check
+func (um *UpdateManager) ApplyUpdate(ctx context.Context) error {
+	um.installing.Lock()
+	defer um.installing.Unlock()
+
+	um.mu.Lock()
+	if um.updateInfo == nil {
+		if err := um.loadUpdateInfo(); err != nil {
+			um.mu.Unlock()
+			return err
+		}
+	}
+
+	if um.updateInfo == nil || !um.updateInfo.Available || um.updateInfo.Artifact == nil {
+		um.mu.Unlock()
+		return ErrNoUpdate
 	}
 
-	// Simulate downloading and applying the update
-	log.Printf("Downloading update from %s...", um.updateInfo.DownloadURL)
-	time.Sleep(2 * time.Second)
-	
-	log.Printf("Installing update %s...", um.updateInfo.NewVersion)
-	time.Sleep(1 * time.Second)
-	
-	// Update the current version
-	um.currentVersion = um.updateInfo.NewVersion
-	
+	info := *um.updateInfo
+	um.mu.Unlock()
+
+	log.Printf("Downloading update from %s...", info.Artifact.URL)
+	staged, err := um.stageArtifact(ctx, info.NewVersion, info.Artifact)
+	if err != nil {
+		return err
+	}
+
+	executable, err := um.executablePath()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Installing update %s...", info.NewVersion)
+	if err := swapExecutable(staged, executable); err != nil {
+		return err
+	}
+
+	if err := um.config.HealthCheck(ctx, executable); err != nil {
+		if rerr := restoreExecutable(executable); rerr != nil {
+			return fmt.Errorf("update %s failed health check (%w) and could not be rolled back: %w", info.NewVersion, err, rerr)
+		}
+		return fmt.Errorf("update %s failed health check, rolled back: %w", info.NewVersion, err)
+	}
+
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	previousVersion := um.currentVersion
+	um.currentVersion = info.NewVersion
+
 	// Create a new update info that shows no more updates available
 	um.updateInfo = &UpdateInfo{
-		Available:      false,
-		CurrentVersion: um.currentVersion,
-		NewVersion:     um.currentVersion,
-		ReleaseDate:    time.Now(),
-		ReleaseNotes:   "",
+		Available:       false,
+		CurrentVersion:  um.currentVersion,
+		NewVersion:      um.currentVersion,
+		ReleaseDate:     info.ReleaseDate,
+		ReleaseNotes:    info.ReleaseNotes,
+		Channel:         info.Channel,
+		Reason:          fmt.Sprintf("updated from %s", previousVersion),
+		PreviousVersion: previousVersion,
 	}
-	
+
 	// Save the new update info
-	um.saveUpdateInfo()
-	
+	if err := um.saveUpdateInfo(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
 	log.Printf("Update to version %s completed successfully!", um.currentVersion)
 	return nil
-}
\ No newline at end of file
+}
+
+// Rollback restores the executable that was replaced by the last update
+func (um *UpdateManager) Rollback() error {
+	um.installing.Lock()
+	defer um.installing.Unlock()
+
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	if um.updateInfo == nil {
+		if err := um.loadUpdateInfo(); err != nil {
+			return err
+		}
+	}
+
+	if um.updateInfo == nil || um.updateInfo.PreviousVersion == "" {
+		return errors.New("no previous version to roll back to")
+	}
+
+	executable, err := um.executablePath()
+	if err != nil {
+		return err
+	}
+
+	if err := restoreExecutable(executable); err != nil {
+		return err
+	}
+
+	rolledBack := um.currentVersion
+	um.currentVersion = um.updateInfo.PreviousVersion
+	um.updateInfo = &UpdateInfo{
+		CurrentVersion: um.currentVersion,
+		NewVersion:     um.currentVersion,
+		Channel:        um.config.Channel,
+		Reason:         fmt.Sprintf("rolled back from %s", rolledBack),
+	}
+	return um.saveUpdateInfo()
+}
+
+func (um *UpdateManager) executablePath() (string, error) {
+	if um.config.ExecutablePath != "" {
+		return um.config.ExecutablePath, nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate executable: %w", err)
+	}
+	return filepath.EvalSymlinks(executable)
+}
+
+// stageArtifact downloads the artifact into the data dir and verifies its
+// digest and signature. A previously staged copy is reused if it verifies.
+func (um *UpdateManager) stageArtifact(ctx context.Context, newVersion string, artifact *Artifact) (string, error) {
+	u, err := url.Parse(artifact.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid artifact URL: %w", err)
+	}
+
+	name := path.Base(u.Path)
+	if name == "" || name == "/" || name == "." {
+		name = "update"
+	}
+
+	stageDir := filepath.Join(um.dataDir, "updates", newVersion)
+	if err := os.MkdirAll(stageDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	staged := filepath.Join(stageDir, name)
+	if err := um.verifyArtifact(staged, artifact); err == nil {
+		log.Printf("Update %s already downloaded", newVersion)
+		return staged, nil
+	}
+
+	partial := staged + ".partial"
+	if err := um.downloadArtifact(ctx, artifact.URL, partial); err != nil {
+		return "", err
+	}
+
+	if err := um.verifyArtifact(partial, artifact); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+
+	if err := os.Rename(partial, staged); err != nil {
+		return "", fmt.Errorf("failed to stage update: %w", err)
+	}
+
+	return staged, nil
+}
+
+func (um *UpdateManager) downloadArtifact(ctx context.Context, artifactURL, dest string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, artifactURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := um.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download update: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download update: unexpected status code %d", resp.StatusCode)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create update file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("failed to download update: %w", err)
+	}
+
+	return f.Close()
+}
+
+// verifyArtifact checks the file's SHA-256 against the artifact and the
+// artifact's signature over that digest
+func (um *UpdateManager) verifyArtifact(p string, artifact *Artifact) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash update: %w", err)
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	if want := strings.TrimPrefix(artifact.SHA256, "sha256:"); !strings.EqualFold(digest, want) {
+		return fmt.Errorf("update digest mismatch: expected sha256:%s, got sha256:%s", want, digest)
+	}
+
+	if err := auth.Verify(artifact.Signature, []byte("sha256:"+digest), um.config.PublicKeys); err != nil {
+		return fmt.Errorf("update signature verification failed: %w", err)
+	}
+
+	return nil
+}
+
+// swapExecutable replaces executable with staged, keeping the replaced
+// binary next to it with a ".previous" suffix
+func swapExecutable(staged, executable string) error {
+	// Copy next to the executable first so the final rename stays on one
+	// filesystem and is atomic
+	next := executable + ".new"
+	if err := copyFile(staged, next, 0o755); err != nil {
+		return fmt.Errorf("failed to prepare update: %w", err)
+	}
+
+	previous := executable + ".previous"
+	if err := os.Remove(previous); err != nil && !os.IsNotExist(err) {
+		os.Remove(next)
+		return fmt.Errorf("failed to remove old backup: %w", err)
+	}
+
+	if err := os.Rename(executable, previous); err != nil {
+		os.Remove(next)
+		return fmt.Errorf("failed to back up current executable: %w", err)
+	}
+
+	if err := os.Rename(next, executable); err != nil {
+		if rerr := os.Rename(previous, executable); rerr != nil {
+			return fmt.Errorf("failed to install update (%w) and to restore the previous executable: %w", err, rerr)
+		}
+		return fmt.Errorf("failed to install update: %w", err)
+	}
+
+	return nil
+}
+
+// restoreExecutable puts the ".previous" backup back in place
+func restoreExecutable(executable string) error {
+	previous := executable + ".previous"
+	if _, err := os.Stat(previous); err != nil {
+		return fmt.Errorf("no previous executable to restore: %w", err)
+	}
+
+	if err := os.Rename(previous, executable); err != nil {
+		return fmt.Errorf("failed to restore previous executable: %w", err)
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
+
+// defaultHealthCheck runs the new binary with --version
+func defaultHealthCheck(ctx context.Context, executable string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, executable, "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package updateservice
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ollama/ollama/auth"
+)
+
+// newSigningKey writes a fresh ed25519 key where auth.Sign looks for it and
+// returns its public half in authorized_keys format
+func newSigningKey(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(home, ".ollama"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".ollama", "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+}
+
+// releaseServer serves a release manifest at /manifest.json and the
+// artifact at /ollama
+type releaseServer struct {
+	*httptest.Server
+	manifest ReleaseManifest
+	artifact []byte
+}
+
+func newReleaseServer(t *testing.T, newVersion string, artifact []byte) *releaseServer {
+	t.Helper()
+
+	rs := &releaseServer{artifact: artifact}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.json":
+			json.NewEncoder(w).Encode(rs.manifest)
+		case "/ollama":
+			w.Write(rs.artifact)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(rs.Close)
+
+	sum := sha256.Sum256(artifact)
+	digest := hex.EncodeToString(sum[:])
+	sig, err := auth.Sign(context.Background(), []byte("sha256:"+digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs.manifest = ReleaseManifest{
+		Release: Release{
+			Version:      newVersion,
+			ReleaseNotes: "notes",
+			Artifacts: []Artifact{
+				{OS: "plan9", Arch: "mips", URL: rs.URL + "/other"},
+				{OS: runtime.GOOS, Arch: runtime.GOARCH, URL: rs.URL + "/ollama", SHA256: digest, Signature: sig},
+			},
+		},
+	}
+	return rs
+}
+
+// newTestUpdater returns an updater for a fake executable containing "old"
+func newTestUpdater(t *testing.T, rs *releaseServer, pub string, healthCheck func(context.Context, string) error) (*UpdateManager, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "ollama")
+	if err := os.WriteFile(executable, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if healthCheck == nil {
+		healthCheck = func(context.Context, string) error { return nil }
+	}
+
+	um, err := NewWithConfig(filepath.Join(dir, "data"), "0.1.0", Config{
+		ManifestURL:    rs.URL + "/manifest.json",
+		PublicKeys:     []string{pub},
+		ExecutablePath: executable,
+		HealthCheck:    healthCheck,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return um, executable
+}
+
+func readFile(t *testing.T, p string) string {
+	t.Helper()
+
+	bts, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bts)
+}
+
+func TestCheckForUpdates(t *testing.T) {
+	pub := newSigningKey(t)
+
+	cases := []struct {
+		version   string
+		available bool
+	}{
+		{"0.2.0", true},
+		{"0.1.0", false},
+		{"0.0.9", false},
+		{"0.1.1-rc.1", true},
+		{"0.1.0-rc.1", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.version, func(t *testing.T) {
+			rs := newReleaseServer(t, tt.version, []byte("new"))
+			um, _ := newTestUpdater(t, rs, pub, nil)
+
+			info, err := um.CheckForUpdates(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Available != tt.available {
+				t.Fatalf("expected available=%t, got %t", tt.available, info.Available)
+			}
+			if info.CurrentVersion != "0.1.0" || info.NewVersion != tt.version {
+				t.Fatalf("unexpected versions %+v", info)
+			}
+			if tt.available && info.DownloadURL != rs.URL+"/ollama" {
+				t.Fatalf("expected artifact for this platform, got %s", info.DownloadURL)
+			}
+
+			if last := um.GetLastUpdateInfo(); last == nil || last.Available != tt.available {
+				t.Fatalf("expected saved update info, got %+v", last)
+			}
+		})
+	}
+}
+
+func TestCheckForUpdatesWithoutSource(t *testing.T) {
+	um, err := New(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := um.CheckForUpdates(context.Background()); !errors.Is(err, ErrNoManifestURL) {
+		t.Fatalf("expected %v, got %v", ErrNoManifestURL, err)
+	}
+}
+
+func TestApplyUpdate(t *testing.T) {
+	pub := newSigningKey(t)
+	rs := newReleaseServer(t, "0.2.0", []byte("new"))
+
+	var um *UpdateManager
+	var checked string
+	var during *UpdateInfo
+	um, executable := newTestUpdater(t, rs, pub, func(_ context.Context, p string) error {
+		checked = readFile(t, p)
+		// the update info can be read while an update is applied
+		during = um.GetLastUpdateInfo()
+		return nil
+	})
+
+	if err := um.ApplyUpdate(context.Background()); !errors.Is(err, ErrNoUpdate) {
+		t.Fatalf("expected %v before checking, got %v", ErrNoUpdate, err)
+	}
+
+	if _, err := um.CheckForUpdates(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := um.ApplyUpdate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if checked != "new" {
+		t.Fatalf("expected health check to run against the new binary, got %q", checked)
+	}
+	if during == nil || !during.Available {
+		t.Fatalf("expected the available update during the health check, got %+v", during)
+	}
+	if got := readFile(t, executable); got != "new" {
+		t.Fatalf("expected new executable, got %q", got)
+	}
+	if got := readFile(t, executable+".previous"); got != "old" {
+		t.Fatalf("expected previous executable to be kept, got %q", got)
+	}
+	if um.CurrentVersion() != "0.2.0" {
+		t.Fatalf("expected version 0.2.0, got %s", um.CurrentVersion())
+	}
+	if info := um.GetLastUpdateInfo(); info.Available || info.PreviousVersion != "0.1.0" {
+		t.Fatalf("unexpected update info after apply %+v", info)
+	}
+
+	if err := um.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, executable); got != "old" {
+		t.Fatalf("expected rolled back executable, got %q", got)
+	}
+	if um.CurrentVersion() != "0.1.0" {
+		t.Fatalf("expected version 0.1.0 after rollback, got %s", um.CurrentVersion())
+	}
+}
+
+func TestApplyUpdateRejected(t *testing.T) {
+	pub := newSigningKey(t)
+
+	cases := []struct {
+		name        string
+		modify      func(t *testing.T, rs *releaseServer)
+		healthCheck func(context.Context, string) error
+		err         string
+	}{
+		{
+			name:   "digest mismatch",
+			modify: func(t *testing.T, rs *releaseServer) { rs.artifact = []byte("evil") },
+			err:    "digest mismatch",
+		},
+		{
+			name: "untrusted signature",
+			modify: func(t *testing.T, rs *releaseServer) {
+				newSigningKey(t)
+				sig, err := auth.Sign(context.Background(), []byte("sha256:"+rs.manifest.Artifacts[1].SHA256))
+				if err != nil {
+					t.Fatal(err)
+				}
+				rs.manifest.Artifacts[1].Signature = sig
+			},
+			err: "untrusted key",
+		},
+		{
+			name:   "unsigned",
+			modify: func(t *testing.T, rs *releaseServer) { rs.manifest.Artifacts[1].Signature = "" },
+			err:    "not signed",
+		},
+		{
+			name: "failed health check",
+			healthCheck: func(context.Context, string) error {
+				return errors.New("crashed on startup")
+			},
+			err: "rolled back",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := newReleaseServer(t, "0.2.0", []byte("new"))
+			um, executable := newTestUpdater(t, rs, pub, tt.healthCheck)
+			if tt.modify != nil {
+				tt.modify(t, rs)
+			}
+
+			if _, err := um.CheckForUpdates(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			err := um.ApplyUpdate(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+
+			if got := readFile(t, executable); got != "old" {
+				t.Fatalf("expected executable to be unchanged, got %q", got)
+			}
+			if um.CurrentVersion() != "0.1.0" {
+				t.Fatalf("expected version to be unchanged, got %s", um.CurrentVersion())
+			}
+		})
+	}
+}